@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key: a signing key identified by its kid, the algorithm is pinned to the key type
+type Key struct {
+	ID        string
+	Algorithm string
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// Public: check if the key can be published in the JWKS
+func (k *Key) Public() bool {
+	return k.Algorithm != jwt.SigningMethodHS256.Alg()
+}
+
+// KeySet: the keys to verify tokens and the active key to sign new tokens,
+// retired keys stay in the set until the tokens signed with them expire
+type KeySet struct {
+	mu     sync.RWMutex
+	keys   map[string]*Key
+	active *Key
+}
+
+// Active: get the key used to sign new tokens
+func (s *KeySet) Active() *Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.active
+}
+
+// Lookup: get the key by its kid
+func (s *KeySet) Lookup(kid string) (*Key, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// Keys: get all keys of the set
+func (s *KeySet) Keys() []*Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]*Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Algorithms: get the algorithms of the keys in the set, the only ones accepted when parsing tokens
+func (s *KeySet) Algorithms() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen := make(map[string]bool)
+	var algs []string
+	for _, key := range s.keys {
+		if !seen[key.Algorithm] {
+			seen[key.Algorithm] = true
+			algs = append(algs, key.Algorithm)
+		}
+	}
+	return algs
+}
+
+// replace: swap the keys of the set
+func (s *KeySet) replace(other *KeySet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = other.keys
+	s.active = other.active
+}
+
+// NewHMACKeySet: create a key set with a single HS256 secret
+func NewHMACKeySet(kid string, secret string) (*KeySet, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("the HS256 secret is empty")
+	}
+	key := &Key{ID: kid, Algorithm: jwt.SigningMethodHS256.Alg(), signKey: []byte(secret), verifyKey: []byte(secret)}
+	return &KeySet{keys: map[string]*Key{kid: key}, active: key}, nil
+}
+
+// LoadKeySet: load the PEM private keys named <kid>.pem from the directory,
+// the active key is generated with the algorithm if it does not exist yet
+func LoadKeySet(dir string, activeKID string, alg string) (*KeySet, error) {
+	if activeKID == "" || strings.ContainsAny(activeKID, `/\`) {
+		return nil, fmt.Errorf("invalid active key id %q", activeKID)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create the key directory: %v", err)
+	}
+
+	// generate the active key on the first start and after a rotation
+	activePath := filepath.Join(dir, activeKID+".pem")
+	if _, err := os.Stat(activePath); os.IsNotExist(err) {
+		if err := generateKey(activePath, alg); err != nil {
+			return nil, err
+		}
+	}
+
+	// the directory is listed rather than globbed, its path may contain the meta characters of the patterns
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the keys: %v", err)
+	}
+	set := &KeySet{keys: make(map[string]*Key)}
+	for _, entry := range entries {
+		kid, ok := strings.CutSuffix(entry.Name(), ".pem")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		key, err := loadKey(filepath.Join(dir, entry.Name()), kid)
+		if err != nil {
+			return nil, err
+		}
+		set.keys[kid] = key
+	}
+
+	set.active = set.keys[activeKID]
+	if set.active == nil {
+		return nil, fmt.Errorf("the active key %s is not found in %s", activeKID, dir)
+	}
+	if set.active.Algorithm != alg {
+		return nil, fmt.Errorf("the active key %s is %s, not %s", activeKID, set.active.Algorithm, alg)
+	}
+	return set, nil
+}
+
+// loadKey: load the PKCS#8 private key from the PEM file
+func loadKey(path string, kid string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the key %s: %v", kid, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode the key %s", kid)
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the key %s: %v", kid, err)
+	}
+
+	switch k := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return &Key{ID: kid, Algorithm: jwt.SigningMethodRS256.Alg(), signKey: k, verifyKey: &k.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &Key{ID: kid, Algorithm: jwt.SigningMethodEdDSA.Alg(), signKey: k, verifyKey: k.Public()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T of the key %s", privateKey, kid)
+	}
+}
+
+// generateKey: generate a private key for the algorithm and save it to the PEM file
+func generateKey(path string, alg string) error {
+	var privateKey crypto.Signer
+	var err error
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to generate the key: %v", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the key: %v", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to save the key: %v", err)
+	}
+	return nil
+}
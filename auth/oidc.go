@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// ErrOIDCDisabled: the single sign-on is not configured
+var ErrOIDCDisabled = errors.New("single sign-on is not configured")
+
+// OIDCIdentity: the identity asserted by the ID token of the identity provider
+type OIDCIdentity struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+// OIDCProvider: the OpenID Connect relying party of an identity provider,
+// using the authorization code flow with PKCE
+type OIDCProvider struct {
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDCProvider: discover the endpoints and the keys of the issuer
+func NewOIDCProvider(ctx context.Context, issuer string, clientID string, clientSecret string, redirectURL string,
+	scopes []string) (*OIDCProvider, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover the provider: %v", err)
+	}
+
+	// the openid scope is required to get an ID token
+	hasOpenID := false
+	for _, scope := range scopes {
+		if scope == oidc.ScopeOpenID {
+			hasOpenID = true
+		}
+	}
+	if !hasOpenID {
+		scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+	}
+
+	return &OIDCProvider{
+		oauth2: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+	}, nil
+}
+
+// NewPKCEVerifier: generate the PKCE code verifier of a login
+func NewPKCEVerifier() string {
+	return oauth2.GenerateVerifier()
+}
+
+// AuthCodeURL: get the authorization url of the identity provider, the state, the nonce and the PKCE challenge
+// bind the response to this login
+func (p *OIDCProvider) AuthCodeURL(state string, nonce string, verifier string) string {
+	return p.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+// Exchange: exchange the authorization code for the tokens, then verify the signature, the issuer,
+// the audience, the expiry and the nonce of the ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, verifier string, nonce string) (*OIDCIdentity, error) {
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange the code: %v", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, fmt.Errorf("the token response has no id_token")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify the id token: %v", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("the nonce of the id token does not match")
+	}
+	if idToken.AccessTokenHash != "" {
+		if err := idToken.VerifyAccessToken(token.AccessToken); err != nil {
+			return nil, fmt.Errorf("failed to verify the access token hash: %v", err)
+		}
+	}
+
+	var claims struct {
+		Email             string      `json:"email"`
+		EmailVerified     interface{} `json:"email_verified"` // some providers send it as a string
+		PreferredUsername string      `json:"preferred_username"`
+		Name              string      `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to parse the id token claims: %v", err)
+	}
+
+	return &OIDCIdentity{
+		Issuer:            idToken.Issuer,
+		Subject:           idToken.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified == true || claims.EmailVerified == "true",
+		PreferredUsername: claims.PreferredUsername,
+		Name:              claims.Name,
+	}, nil
+}
+
+var (
+	oidcMu       sync.Mutex
+	oidcConfig   config.OIDCConfig
+	oidcProvider *OIDCProvider
+)
+
+// GetOIDCProvider: get the provider of the configured issuer, the discovery runs on the first login
+// and is retried until it succeeds, so the server starts while the identity provider is down
+func GetOIDCProvider(ctx context.Context) (*OIDCProvider, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if !oidcConfig.Enabled() {
+		return nil, ErrOIDCDisabled
+	}
+	if oidcProvider == nil {
+		provider, err := NewOIDCProvider(ctx, oidcConfig.IssuerURL, oidcConfig.ClientID, oidcConfig.ClientSecret,
+			oidcConfig.RedirectURL, oidcConfig.Scopes)
+		if err != nil {
+			return nil, err
+		}
+		oidcProvider = provider
+	}
+	return oidcProvider, nil
+}
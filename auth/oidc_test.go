@@ -0,0 +1,172 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/auth"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIdP: a minimal OpenID Connect provider, the authorization page is skipped by authorize
+type mockIdP struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+
+	mu    sync.Mutex
+	codes map[string]url.Values // the authorization requests by their code
+}
+
+func newMockIdP(t *testing.T, clientID string) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate the key: %v", err)
+	}
+	idp := &mockIdP{key: key, clientID: clientID, codes: make(map[string]url.Values)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(auth.JWKSet{Keys: []auth.JWK{{
+			KeyType:   "RSA",
+			KeyID:     "idp",
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// authorize: approve the authorization request as if the user logged in, returns the code
+func (idp *mockIdP) authorize(t *testing.T, authURL string) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("Failed to parse the authorization url: %v", err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("Expected a S256 PKCE challenge in %s", authURL)
+	}
+
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	code := "code-" + query.Get("state")
+	idp.codes[code] = query
+	return code
+}
+
+// token: exchange the code for the tokens if the code verifier matches the challenge
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	query, ok := idp.codes[r.FormValue("code")]
+	delete(idp.codes, r.FormValue("code"))
+	idp.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != query.Get("code_challenge") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                idp.server.URL,
+		"sub":                "alice-subject",
+		"aud":                idp.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Minute).Unix(),
+		"nonce":              query.Get("nonce"),
+		"email":              "alice@example.com",
+		"email_verified":     true,
+		"preferred_username": "alice",
+	})
+	idToken.Header["kid"] = "idp"
+	signed, err := idToken.SignedString(idp.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     signed,
+	})
+}
+
+func TestOIDCProviderExchange(t *testing.T) {
+	idp := newMockIdP(t, "file-store")
+	ctx := context.Background()
+	provider, err := auth.NewOIDCProvider(ctx, idp.server.URL, "file-store", "secret", "https://localhost/callback", []string{"email"})
+	if err != nil {
+		t.Fatalf("Failed to discover the provider: %v", err)
+	}
+
+	verifier := auth.NewPKCEVerifier()
+	code := idp.authorize(t, provider.AuthCodeURL("state-1", "nonce-1", verifier))
+	identity, err := provider.Exchange(ctx, code, verifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("Failed to exchange the code: %v", err)
+	}
+	if identity.Issuer != idp.server.URL || identity.Subject != "alice-subject" {
+		t.Errorf("Unexpected identity %s of %s", identity.Subject, identity.Issuer)
+	}
+	if identity.Email != "alice@example.com" || !identity.EmailVerified || identity.PreferredUsername != "alice" {
+		t.Errorf("Unexpected claims of the identity: %+v", identity)
+	}
+
+	// the code can not be exchanged without the verifier of the login
+	code = idp.authorize(t, provider.AuthCodeURL("state-2", "nonce-2", verifier))
+	if _, err := provider.Exchange(ctx, code, auth.NewPKCEVerifier(), "nonce-2"); err == nil {
+		t.Errorf("Expected the exchange with another verifier to fail")
+	}
+
+	// the ID token of another login is rejected by its nonce
+	code = idp.authorize(t, provider.AuthCodeURL("state-3", "nonce-3", verifier))
+	if _, err := provider.Exchange(ctx, code, verifier, "nonce-other"); err == nil {
+		t.Errorf("Expected the ID token with another nonce to be rejected")
+	}
+}
+
+func TestOIDCProviderAudience(t *testing.T) {
+	idp := newMockIdP(t, "another-client")
+	ctx := context.Background()
+	provider, err := auth.NewOIDCProvider(ctx, idp.server.URL, "file-store", "secret", "https://localhost/callback", nil)
+	if err != nil {
+		t.Fatalf("Failed to discover the provider: %v", err)
+	}
+
+	verifier := auth.NewPKCEVerifier()
+	code := idp.authorize(t, provider.AuthCodeURL("state", "nonce", verifier))
+	if _, err := provider.Exchange(ctx, code, verifier, "nonce"); err == nil {
+		t.Errorf("Expected the ID token issued to another client to be rejected")
+	}
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// keySet: the keys of the access tokens
+	keySet = &KeySet{}
+	// jwtConfig: the signing keys, the issuer and the audience of the access tokens
+	jwtConfig config.JWTConfig
+)
+
+// Claims: the claims of the access token
+type Claims struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Version  int    `json:"ver"` // the token version of the user, bumped when all sessions are revoked
+	jwt.RegisteredClaims
+}
+
+// loadKeySet: load the configured keys
+func loadKeySet() (*KeySet, error) {
+	if jwtConfig.SigningAlg == jwt.SigningMethodHS256.Alg() {
+		kid := jwtConfig.ActiveKeyID
+		if kid == "" {
+			kid = "hs256"
+		}
+		return NewHMACKeySet(kid, jwtConfig.SecretKey)
+	}
+	return LoadKeySet(jwtConfig.KeysDir, jwtConfig.ActiveKeyID, jwtConfig.SigningAlg)
+}
+
+// Init: load the keys of the access tokens and keep the settings of the single sign-on
+func Init(jwtCfg config.JWTConfig, oidcCfg config.OIDCConfig) error {
+	jwtConfig = jwtCfg
+	oidcMu.Lock()
+	oidcConfig = oidcCfg
+	oidcProvider = nil
+	oidcMu.Unlock()
+	if err := Reload(); err != nil {
+		return fmt.Errorf("failed to load the JWT keys: %v", err)
+	}
+	return nil
+}
+
+// Reload: reload the keys, e.g. after a new active key is configured or a retired key is removed
+func Reload() error {
+	set, err := loadKeySet()
+	if err != nil {
+		return err
+	}
+	keySet.replace(set)
+	return nil
+}
+
+// GetKeySet: get the keys of the access tokens
+func GetKeySet() *KeySet {
+	return keySet
+}
+
+// IssueAccessToken: sign a new access token for the user with the active key
+func IssueAccessToken(userID int, username string, version int) (string, *Claims, error) {
+	tokenID, err := utils.GenerateToken(16)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	claims := &Claims{
+		UserID:   userID,
+		Username: username,
+		Version:  version,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Issuer:    jwtConfig.Issuer,
+			Subject:   fmt.Sprintf("%d", userID),
+			Audience:  jwt.ClaimStrings{jwtConfig.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(config.JWTExpirationTime)),
+		},
+	}
+
+	key := keySet.Active()
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+	tokenStr, err := token.SignedString(key.signKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to sign the token: %v", err)
+	}
+	return tokenStr, claims, nil
+}
+
+// ParseAccessToken: verify the signature, the algorithm of the kid and the iss, aud, exp and nbf claims of the token
+func ParseAccessToken(tokenStr string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keySet.Lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		// the algorithm is pinned to the key, a token can not pick another one
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method %s for the key %s", token.Method.Alg(), kid)
+		}
+		return key.verifyKey, nil
+	},
+		jwt.WithValidMethods(keySet.Algorithms()),
+		jwt.WithIssuer(jwtConfig.Issuer),
+		jwt.WithAudience(jwtConfig.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
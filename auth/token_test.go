@@ -0,0 +1,135 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/auth"
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// loadTestKey: load the Ed25519 private key generated by the key set
+func loadTestKey(t *testing.T, path string) ed25519.PrivateKey {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read the key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatalf("Failed to decode the key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse the key: %v", err)
+	}
+	return key.(ed25519.PrivateKey)
+}
+
+func TestParseAccessToken(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.JWTConfig{SigningAlg: "EdDSA", KeysDir: dir, ActiveKeyID: "k1", Issuer: "file-store", Audience: "file-store-api"}
+	if err := auth.Init(cfg, config.OIDCConfig{}); err != nil {
+		t.Fatalf("Failed to init the keys: %v", err)
+	}
+	signKey := loadTestKey(t, filepath.Join(dir, "k1.pem"))
+
+	issued, _, err := auth.IssueAccessToken(7, "alice", 1)
+	if err != nil {
+		t.Fatalf("Failed to issue the token: %v", err)
+	}
+	if claims, err := auth.ParseAccessToken(issued); err != nil || claims.UserID != 7 || claims.Version != 1 {
+		t.Fatalf("Expected the issued token to be valid, got %+v, %v", claims, err)
+	}
+
+	now := time.Now()
+	claims := func(change func(c *jwt.RegisteredClaims)) *auth.Claims {
+		c := &auth.Claims{UserID: 7, Username: "alice", RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    cfg.Issuer,
+			Audience:  jwt.ClaimStrings{cfg.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		}}
+		change(&c.RegisteredClaims)
+		return c
+	}
+	unchanged := func(c *jwt.RegisteredClaims) {}
+
+	cases := []struct {
+		name   string
+		method jwt.SigningMethod
+		key    interface{}
+		kid    string
+		claims *auth.Claims
+		valid  bool
+	}{
+		{"signed by the active key", jwt.SigningMethodEdDSA, signKey, "k1", claims(unchanged), true},
+		{"alg none", jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "k1", claims(unchanged), false},
+		{"alg not pinned to the key", jwt.SigningMethodHS256, []byte(signKey.Public().(ed25519.PublicKey)), "k1", claims(unchanged), false},
+		{"unknown kid", jwt.SigningMethodEdDSA, signKey, "k2", claims(unchanged), false},
+		{"no kid", jwt.SigningMethodEdDSA, signKey, "", claims(unchanged), false},
+		{"wrong issuer", jwt.SigningMethodEdDSA, signKey, "k1", claims(func(c *jwt.RegisteredClaims) { c.Issuer = "other" }), false},
+		{"wrong audience", jwt.SigningMethodEdDSA, signKey, "k1", claims(func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"other"} }), false},
+		{"expired", jwt.SigningMethodEdDSA, signKey, "k1", claims(func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute)) }), false},
+		{"no expiry", jwt.SigningMethodEdDSA, signKey, "k1", claims(func(c *jwt.RegisteredClaims) { c.ExpiresAt = nil }), false},
+		{"not valid yet", jwt.SigningMethodEdDSA, signKey, "k1", claims(func(c *jwt.RegisteredClaims) { c.NotBefore = jwt.NewNumericDate(now.Add(time.Hour)) }), false},
+	}
+	for _, c := range cases {
+		token := jwt.NewWithClaims(c.method, c.claims)
+		if c.kid != "" {
+			token.Header["kid"] = c.kid
+		}
+		tokenStr, err := token.SignedString(c.key)
+		if err != nil {
+			t.Fatalf("%s: failed to sign the token: %v", c.name, err)
+		}
+
+		_, err = auth.ParseAccessToken(tokenStr)
+		if c.valid && err != nil {
+			t.Errorf("%s: expected the token to be valid, got %v", c.name, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%s: expected the token to be rejected", c.name)
+		}
+	}
+}
+
+func TestLoadKeySet(t *testing.T) {
+	// the meta characters of the glob patterns in the path are taken literally
+	dir := filepath.Join(t.TempDir(), "keys[1]")
+	set, err := auth.LoadKeySet(dir, "k1", "EdDSA")
+	if err != nil || set.Active() == nil || set.Active().ID != "k1" {
+		t.Fatalf("Expected the active key k1, got %v", err)
+	}
+
+	// the retired keys stay in the set after a rotation
+	set, err = auth.LoadKeySet(dir, "k2", "EdDSA")
+	if err != nil || set.Active().ID != "k2" {
+		t.Fatalf("Expected the active key k2, got %v", err)
+	}
+	if _, ok := set.Lookup("k1"); !ok {
+		t.Errorf("Expected the retired key k1 to be kept")
+	}
+
+	cases := []struct {
+		name      string
+		activeKID string
+		alg       string
+	}{
+		{"empty kid", "", "EdDSA"},
+		{"kid outside the directory", "../k1", "EdDSA"},
+		{"algorithm of the active key changed", "k1", "RS256"},
+		{"unsupported algorithm", "k3", "HS512"},
+	}
+	for _, c := range cases {
+		if _, err := auth.LoadKeySet(dir, c.activeKID, c.alg); err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+	}
+}
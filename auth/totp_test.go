@@ -0,0 +1,50 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/auth"
+	"github.com/pquerna/otp/totp"
+)
+
+func TestValidateTOTP(t *testing.T) {
+	enrollment, err := auth.GenerateTOTP("FileStore", "alice")
+	if err != nil {
+		t.Fatalf("Failed to generate the TOTP secret: %v", err)
+	}
+
+	code, err := totp.GenerateCode(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to generate the code: %v", err)
+	}
+	step, ok := auth.ValidateTOTP(enrollment.Secret, code, 0)
+	if !ok {
+		t.Fatalf("Expected the code %s to be valid", code)
+	}
+
+	// the same code can not be used twice
+	if _, ok := auth.ValidateTOTP(enrollment.Secret, code, step); ok {
+		t.Errorf("Expected the code %s to be rejected after its step was used", code)
+	}
+	if _, ok := auth.ValidateTOTP(enrollment.Secret, "000000x", 0); ok {
+		t.Errorf("Expected an invalid code to be rejected")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := auth.GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("Failed to generate the recovery codes: %v", err)
+	}
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 11 || seen[code] {
+			t.Errorf("Unexpected recovery code %q", code)
+		}
+		seen[code] = true
+		if normalized := auth.NormalizeRecoveryCode(" " + code[:5] + code[6:] + " "); normalized != code {
+			t.Errorf("Expected %q to be normalized to %q", normalized, code)
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Config: the settings of the server, loaded by Load from the defaults, the config file,
+// the environment variables and the command line flags, the later ones take precedence
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Storage  StorageConfig  `yaml:"storage"`
+	Database DatabaseConfig `yaml:"database"`
+	MySQL    MySQLConfig    `yaml:"mysql"`
+	Postgres PostgresConfig `yaml:"postgres"`
+	SQLite   SQLiteConfig   `yaml:"sqlite"`
+	Redis    RedisConfig    `yaml:"redis"`
+	RabbitMQ RabbitMQConfig `yaml:"rabbitmq"`
+	OSS      OSSConfig      `yaml:"oss"`
+	JWT      JWTConfig      `yaml:"jwt"`
+	Mail     MailConfig     `yaml:"mail"`
+	OIDC     OIDCConfig     `yaml:"oidc"`
+	Health   HealthConfig   `yaml:"health"`
+	Metrics  MetricsConfig  `yaml:"metrics"`
+	Log      LogConfig      `yaml:"log"`
+	Tracing  TracingConfig  `yaml:"tracing"`
+}
+
+// ServerConfig: the listener of the server
+type ServerConfig struct {
+	Addr     string `yaml:"addr"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// PublicBaseURL: the base url of the links in the mails, e.g. https://bladewaltz.cn:8080
+	PublicBaseURL string `yaml:"public_base_url"`
+
+	// ShutdownTimeout: how long the in-flight requests and the MQ messages are drained on SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// StorageConfig: the local storage of the uploaded files
+type StorageConfig struct {
+	FileStoreDir  string `yaml:"file_store_dir"`
+	FileChunkDir  string `yaml:"file_chunk_dir"`
+	MaxUploadSize int64  `yaml:"max_upload_size"` // the memory of a multipart form, the rest is buffered on disk
+}
+
+// DatabaseConfig: the metadata database, the connection is configured by the section of the driver
+type DatabaseConfig struct {
+	Driver string `yaml:"driver"` // mysql, postgres or sqlite
+
+	// AutoMigrate: apply the pending migrations on startup, otherwise the server refuses
+	// to start until they are applied by the migrate command
+	AutoMigrate bool `yaml:"auto_migrate"`
+}
+
+// MySQLConfig: the connection and the connection pool of the metadata database
+type MySQLConfig struct {
+	Host            string        `yaml:"host"`
+	Port            int           `yaml:"port"`
+	User            string        `yaml:"user"`
+	Password        string        `yaml:"password"`
+	Database        string        `yaml:"database"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+}
+
+// PostgresConfig: the connection and the connection pool of the metadata database on PostgreSQL
+type PostgresConfig struct {
+	Host            string        `yaml:"host"`
+	Port            int           `yaml:"port"`
+	User            string        `yaml:"user"`
+	Password        string        `yaml:"password"`
+	Database        string        `yaml:"database"`
+	SSLMode         string        `yaml:"sslmode"` // disable, require, verify-ca or verify-full
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+}
+
+// SQLiteConfig: the metadata database embedded in a file, for the deployments on a single machine
+type SQLiteConfig struct {
+	Path string `yaml:"path"`
+}
+
+// RedisConfig: the connection of the redis
+type RedisConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+}
+
+// RabbitMQConfig: the connection of the RabbitMQ and the queues of the transfer and the index messages
+type RabbitMQConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	VHost    string `yaml:"vhost"`
+
+	Exchange        string `yaml:"exchange"`
+	OSSQueue        string `yaml:"oss_queue"`
+	OSSRoutingKey   string `yaml:"oss_routing_key"`
+	IndexQueue      string `yaml:"index_queue"`
+	IndexRoutingKey string `yaml:"index_routing_key"`
+}
+
+// OSSConfig: the object storage the files are transferred to
+type OSSConfig struct {
+	Endpoint        string `yaml:"endpoint"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	AccessKeySecret string `yaml:"access_key_secret"`
+	Bucket          string `yaml:"bucket"`
+}
+
+// JWTConfig: the signing keys of the access tokens, HS256 uses SecretKey,
+// RS256 and EdDSA use the PEM keys in KeysDir named <kid>.pem
+type JWTConfig struct {
+	SecretKey   string `yaml:"secret_key"`
+	SigningAlg  string `yaml:"signing_alg"`
+	KeysDir     string `yaml:"keys_dir"`
+	ActiveKeyID string `yaml:"active_key_id"`
+	Issuer      string `yaml:"issuer"`
+	Audience    string `yaml:"audience"`
+}
+
+// MailConfig: the mailer of the account mails
+type MailConfig struct {
+	// Driver: "smtp" sends the mails, "log" writes them to LogFile or the log for development and tests
+	Driver       string `yaml:"driver"`
+	From         string `yaml:"from"`
+	LogFile      string `yaml:"log_file"`
+	SMTPHost     string `yaml:"smtp_host"`
+	SMTPPort     int    `yaml:"smtp_port"`
+	SMTPUsername string `yaml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password"`
+
+	// RequireVerification: restrict the unverified accounts to read-only access
+	RequireVerification bool `yaml:"require_verification"`
+}
+
+// OIDCConfig: the single sign-on with an OpenID Connect provider, disabled if IssuerURL is empty
+type OIDCConfig struct {
+	IssuerURL    string   `yaml:"issuer_url"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url"` // defaults to the callback under PublicBaseURL
+	Scopes       []string `yaml:"scopes"`
+	ProviderName string   `yaml:"provider_name"` // shown on the login button
+
+	// AutoProvision: create the accounts of the unknown identities on their first login
+	AutoProvision bool `yaml:"auto_provision"`
+	// LinkByEmail: link the identities to the existing accounts with the same verified email
+	LinkByEmail bool `yaml:"link_by_email"`
+}
+
+// HealthConfig: the readiness probe of the dependencies
+type HealthConfig struct {
+	Timeout      time.Duration `yaml:"timeout"`        // of every dependency check
+	MinFreeBytes int64         `yaml:"min_free_bytes"` // on the filesystems of the file store and the chunks
+
+	// ShutdownDelay: how long the readiness probe fails on SIGTERM before the server stops
+	// accepting the connections, for the load balancers to stop routing to it
+	ShutdownDelay time.Duration `yaml:"shutdown_delay"`
+}
+
+// MetricsConfig: the Prometheus metrics served at /metrics
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Token   string `yaml:"token"` // the bearer token of the scrapers, the metrics are public if empty
+}
+
+// LogConfig: the structured logs of the server, written to stderr
+type LogConfig struct {
+	Level  string `yaml:"level"`  // debug, info, warn or error
+	Format string `yaml:"format"` // text or json
+}
+
+// TracingConfig: the OpenTelemetry traces of the requests and the messages
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter"`     // none, otlp or stdout
+	Endpoint    string  `yaml:"endpoint"`     // host:port of the OTLP/HTTP collector, defaults to OTEL_EXPORTER_OTLP_ENDPOINT
+	Insecure    bool    `yaml:"insecure"`     // send to the collector over plain http
+	SampleRatio float64 `yaml:"sample_ratio"` // of the new traces, the traces of the callers follow their decision
+	ServiceName string  `yaml:"service_name"`
+}
+
+// Default: the settings for the development on a single machine
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			CertFile:        "config/ssl/server.crt",
+			KeyFile:         "config/ssl/server.key",
+			PublicBaseURL:   "https://localhost:8080",
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Storage: StorageConfig{
+			FileStoreDir:  "data/files",
+			FileChunkDir:  "data/chunks",
+			MaxUploadSize: 32 << 20, // 32MB
+		},
+		Database: DatabaseConfig{
+			Driver:      "mysql",
+			AutoMigrate: true,
+		},
+		MySQL: MySQLConfig{
+			Host:            "127.0.0.1",
+			Port:            3306,
+			User:            "root",
+			Database:        "file_store",
+			MaxOpenConns:    100,
+			MaxIdleConns:    30,
+			ConnMaxLifetime: time.Hour,
+		},
+		Postgres: PostgresConfig{
+			Host:            "127.0.0.1",
+			Port:            5432,
+			User:            "postgres",
+			Database:        "file_store",
+			SSLMode:         "disable",
+			MaxOpenConns:    100,
+			MaxIdleConns:    30,
+			ConnMaxLifetime: time.Hour,
+		},
+		SQLite: SQLiteConfig{
+			Path: "data/file_store.db",
+		},
+		Redis: RedisConfig{
+			Host: "127.0.0.1",
+			Port: 6379,
+		},
+		RabbitMQ: RabbitMQConfig{
+			Host:            "127.0.0.1",
+			Port:            5672,
+			User:            "guest",
+			Password:        "guest",
+			Exchange:        "file_store.trans",
+			OSSQueue:        "file_store.trans.oss",
+			OSSRoutingKey:   "oss",
+			IndexQueue:      "file_store.trans.index",
+			IndexRoutingKey: "index",
+		},
+		JWT: JWTConfig{
+			SigningAlg: "HS256",
+			KeysDir:    "config/jwt_keys",
+			Issuer:     "file-store-server",
+			Audience:   "file-store-server",
+		},
+		Mail: MailConfig{
+			Driver:   "log",
+			SMTPPort: 587,
+		},
+		OIDC: OIDCConfig{
+			Scopes:       []string{"openid", "profile", "email"},
+			ProviderName: "SSO",
+		},
+		Health: HealthConfig{
+			Timeout:      2 * time.Second,
+			MinFreeBytes: 1 << 30, // 1GB
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			SampleRatio: 1,
+			ServiceName: "file-store-server",
+		},
+	}
+}
+
+// DSN: the data source name of the mysql driver
+func (c MySQLConfig) DSN() string {
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8&parseTime=true", c.User, c.Password,
+		net.JoinHostPort(c.Host, strconv.Itoa(c.Port)), c.Database)
+}
+
+// DSN: the connection url of the pgx driver
+func (c PostgresConfig) DSN() string {
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:     "/" + c.Database,
+		RawQuery: url.Values{"sslmode": {c.SSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+// Addr: the address of the redis server
+func (c RedisConfig) Addr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+// URL: the amqp url of the RabbitMQ server
+func (c RabbitMQConfig) URL() string {
+	u := url.URL{
+		Scheme: "amqp",
+		User:   url.UserPassword(c.User, c.Password),
+		Host:   net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:   "/" + c.VHost,
+	}
+	return u.String()
+}
+
+// Enabled: check if the single sign-on is configured
+func (c OIDCConfig) Enabled() bool {
+	return c.IssuerURL != "" && c.ClientID != ""
+}
+
+// Validate: check the settings, all problems are reported together
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key string, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+		}
+	}
+	validPort := func(port int) bool { return port > 0 && port <= 65535 }
+
+	check(c.Server.Addr != "", "server.addr", "is required")
+	check(c.Server.CertFile != "", "server.cert_file", "is required")
+	check(c.Server.KeyFile != "", "server.key_file", "is required")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout", "must be positive")
+	if u, err := url.Parse(c.Server.PublicBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("server.public_base_url: %q is not an http(s) url", c.Server.PublicBaseURL))
+	}
+
+	check(c.Storage.FileStoreDir != "", "storage.file_store_dir", "is required")
+	check(c.Storage.FileChunkDir != "", "storage.file_chunk_dir", "is required")
+	check(c.Storage.MaxUploadSize > 0, "storage.max_upload_size", "must be positive")
+
+	// only the section of the driver is used
+	switch c.Database.Driver {
+	case "mysql":
+		check(c.MySQL.Host != "", "mysql.host", "is required")
+		check(validPort(c.MySQL.Port), "mysql.port", "%d is not a valid port", c.MySQL.Port)
+		check(c.MySQL.User != "", "mysql.user", "is required")
+		check(c.MySQL.Database != "", "mysql.database", "is required")
+		check(c.MySQL.MaxOpenConns > 0, "mysql.max_open_conns", "must be positive")
+		check(c.MySQL.MaxIdleConns >= 0 && c.MySQL.MaxIdleConns <= c.MySQL.MaxOpenConns, "mysql.max_idle_conns",
+			"must be between 0 and max_open_conns")
+		check(c.MySQL.ConnMaxLifetime >= 0, "mysql.conn_max_lifetime", "must not be negative")
+	case "postgres":
+		check(c.Postgres.Host != "", "postgres.host", "is required")
+		check(validPort(c.Postgres.Port), "postgres.port", "%d is not a valid port", c.Postgres.Port)
+		check(c.Postgres.User != "", "postgres.user", "is required")
+		check(c.Postgres.Database != "", "postgres.database", "is required")
+		check(c.Postgres.SSLMode != "", "postgres.sslmode", "is required")
+		check(c.Postgres.MaxOpenConns > 0, "postgres.max_open_conns", "must be positive")
+		check(c.Postgres.MaxIdleConns >= 0 && c.Postgres.MaxIdleConns <= c.Postgres.MaxOpenConns, "postgres.max_idle_conns",
+			"must be between 0 and max_open_conns")
+		check(c.Postgres.ConnMaxLifetime >= 0, "postgres.conn_max_lifetime", "must not be negative")
+	case "sqlite":
+		check(c.SQLite.Path != "", "sqlite.path", "is required")
+	default:
+		errs = append(errs, fmt.Errorf("database.driver: unsupported driver %q, use mysql, postgres or sqlite", c.Database.Driver))
+	}
+
+	check(c.Health.Timeout > 0, "health.timeout", "must be positive")
+	check(c.Health.MinFreeBytes >= 0, "health.min_free_bytes", "must not be negative")
+	check(c.Health.ShutdownDelay >= 0, "health.shutdown_delay", "must not be negative")
+
+	check(c.Redis.Host != "", "redis.host", "is required")
+	check(validPort(c.Redis.Port), "redis.port", "%d is not a valid port", c.Redis.Port)
+	check(c.Redis.DB >= 0, "redis.db", "must not be negative")
+
+	check(c.RabbitMQ.Host != "", "rabbitmq.host", "is required")
+	check(validPort(c.RabbitMQ.Port), "rabbitmq.port", "%d is not a valid port", c.RabbitMQ.Port)
+	check(c.RabbitMQ.Exchange != "", "rabbitmq.exchange", "is required")
+	check(c.RabbitMQ.OSSQueue != "" && c.RabbitMQ.OSSRoutingKey != "", "rabbitmq.oss_queue", "the queue and its routing key are required")
+	check(c.RabbitMQ.IndexQueue != "" && c.RabbitMQ.IndexRoutingKey != "", "rabbitmq.index_queue", "the queue and its routing key are required")
+
+	check(c.OSS.Endpoint == "" || c.OSS.Bucket != "", "oss.bucket", "is required with the endpoint")
+
+	switch c.JWT.SigningAlg {
+	case "HS256":
+		check(c.JWT.SecretKey != "", "jwt.secret_key", "is required for HS256")
+	case "RS256", "EdDSA":
+		check(c.JWT.KeysDir != "", "jwt.keys_dir", "is required for %s", c.JWT.SigningAlg)
+		check(c.JWT.ActiveKeyID != "", "jwt.active_key_id", "is required for %s", c.JWT.SigningAlg)
+	default:
+		errs = append(errs, fmt.Errorf("jwt.signing_alg: unsupported algorithm %q, use HS256, RS256 or EdDSA", c.JWT.SigningAlg))
+	}
+	check(c.JWT.Issuer != "", "jwt.issuer", "is required")
+	check(c.JWT.Audience != "", "jwt.audience", "is required")
+
+	switch c.Mail.Driver {
+	case "log":
+	case "smtp":
+		check(c.Mail.SMTPHost != "", "mail.smtp_host", "is required for the smtp driver")
+		check(validPort(c.Mail.SMTPPort), "mail.smtp_port", "%d is not a valid port", c.Mail.SMTPPort)
+		check(c.Mail.From != "", "mail.from", "is required for the smtp driver")
+	default:
+		errs = append(errs, fmt.Errorf("mail.driver: unsupported driver %q, use smtp or log", c.Mail.Driver))
+	}
+
+	if c.OIDC.IssuerURL != "" || c.OIDC.ClientID != "" {
+		check(c.OIDC.IssuerURL != "", "oidc.issuer_url", "is required with the client id")
+		check(c.OIDC.ClientID != "", "oidc.client_id", "is required with the issuer url")
+		check(c.OIDC.RedirectURL != "", "oidc.redirect_url", "is required")
+	}
+
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log.level: unsupported level %q, use debug, info, warn or error", c.Log.Level))
+	}
+	switch c.Log.Format {
+	case "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("log.format: unsupported format %q, use text or json", c.Log.Format))
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "otlp", "stdout":
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter: unsupported exporter %q, use none, otlp or stdout", c.Tracing.Exporter))
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio", "%v is not between 0 and 1", c.Tracing.SampleRatio)
+	check(c.Tracing.ServiceName != "", "tracing.service_name", "is required")
+
+	return errors.Join(errs...)
+}
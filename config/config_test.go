@@ -0,0 +1,88 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/config"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+server:
+  addr: ":9000"
+  public_base_url: "https://files.example.com"
+storage:
+  file_store_dir: /srv/files
+mysql:
+  host: db.internal
+  conn_max_lifetime: 30m
+jwt:
+  secret_key: file-secret
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write the config file: %v", err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("MYSQL_HOST", "db.env")
+	t.Setenv("SERVER_ADDR", ":9001")
+
+	cfg, err := config.Load([]string{"-addr", ":9002"})
+	if err != nil {
+		t.Fatalf("Failed to load the config: %v", err)
+	}
+	if cfg.Server.Addr != ":9002" {
+		t.Errorf("Expected the flag to override the env and the file, got %s", cfg.Server.Addr)
+	}
+	if cfg.MySQL.Host != "db.env" {
+		t.Errorf("Expected the env to override the file, got %s", cfg.MySQL.Host)
+	}
+	if cfg.Storage.FileStoreDir != "/srv/files" || cfg.MySQL.ConnMaxLifetime != 30*time.Minute {
+		t.Errorf("Expected the file to override the defaults, got %s and %v", cfg.Storage.FileStoreDir, cfg.MySQL.ConnMaxLifetime)
+	}
+	if cfg.MySQL.Port != 3306 || cfg.Storage.FileChunkDir == "" {
+		t.Errorf("Expected the defaults of the unset fields")
+	}
+	if cfg.OIDC.RedirectURL != "https://files.example.com/user/login/oidc/callback" {
+		t.Errorf("Expected the redirect url under the public url, got %s", cfg.OIDC.RedirectURL)
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("mysql:\n  max_idle_conns: 500\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write the config file: %v", err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("JWT_SECRET_KEY", "")
+	t.Setenv("MAIL_DRIVER", "pigeon")
+	t.Setenv("REDIS_PORT", "six")
+
+	// the malformed values are reported before the validation
+	if _, err := config.Load(nil); err == nil || !strings.Contains(err.Error(), "$REDIS_PORT") {
+		t.Errorf("Expected the malformed REDIS_PORT to be reported, got %v", err)
+	}
+
+	t.Setenv("REDIS_PORT", "")
+	_, err := config.Load(nil)
+	if err == nil {
+		t.Fatalf("Expected the invalid config to be rejected")
+	}
+	for _, key := range []string{"mysql.max_idle_conns", "jwt.secret_key", "mail.driver"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("Expected %s to be reported in %v", key, err)
+		}
+	}
+
+	// a misspelled key is not silently ignored
+	if err := os.WriteFile(path, []byte("mysql:\n  hots: db\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write the config file: %v", err)
+	}
+	if _, err := config.Load(nil); err == nil || !strings.Contains(err.Error(), "hots") {
+		t.Errorf("Expected the unknown key to be rejected, got %v", err)
+	}
+}
@@ -1,13 +1,48 @@
 package config
 
+import "time"
+
 const (
-	// Directory path
-	FileStoreDir = "/home/bladewaltz/data/files/"
-	FileChunkDir = "/home/bladewaltz/data/chunks/"
+	// Full-text search
+	MaxIndexFileSize    = 32 << 20 // the larger files are not indexed
+	MaxIndexContentSize = 1 << 20  // 1MB of extracted text per file
+	SearchResultLimit   = 20
+	SearchSnippetLength = 160
+
+	// Tags and metadata of the user files
+	MaxTagsPerFile     = 32
+	MaxTagLength       = 64
+	MaxMetadataKeys    = 32
+	MaxMetaKeyLength   = 64
+	MaxMetaValueLength = 1024
+
+	// Public share links
+	ShareTokenBytes    = 24
+	ShareMaxExpireTime = time.Hour * 24 * 365 // 1 year
+	ShareLogLimit      = 100
+
+	// password guesses per share link and client ip in the window
+	SharePasswordMaxAttempts = 20
+	SharePasswordWindow      = time.Minute * 15
+
+	// Personal API keys
+	APIKeyPrefix        = "fsk_" // tells the API keys apart from the JWTs
+	APIKeyBytes         = 32
+	APIKeyDisplayLength = 12 // the prefix of the key shown in the key list
+	APIKeyMaxPerUser    = 20
+	APIKeyMaxNameLength = 64
+	APIKeyMaxExpireTime = time.Hour * 24 * 365 // 1 year
+	APIKeyTouchInterval = time.Minute          // the granularity of the last used time
 
-	MaxUploadSize = 32 << 20 // 32MB
+	// User profile
+	MaxPhoneLength       = 20
+	MaxDisplayNameLength = 64
+	MaxBioLength         = 512
+	MaxAvatarURLLength   = 512
+	MaxLocaleLength      = 16
 
-	// SSL cert and key
-	CertFile = "/etc/apache2/ssl/bladewaltz.cn.crt"
-	KeyFile  = "/etc/apache2/ssl/bladewaltz.cn.key"
+	// Administration
+	AdminUserListLimit    = 50
+	AdminUserListMaxLimit = 200
+	AdminMaxLockTime      = time.Hour * 24 * 365
 )
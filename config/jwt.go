@@ -1,27 +1,24 @@
 package config
 
-import (
-	"log"
-	"os"
-	"time"
-
-	"github.com/bladewaltz9/file-store-server/utils"
-)
-
-var (
-	JWTSecretKey string
-)
+import "time"
 
 const (
-	JWTExpirationTime = time.Hour * 1 // 1 hour
-)
+	JWTExpirationTime          = time.Minute * 15   // 15 minutes, the lifetime of the access token
+	RefreshTokenExpirationTime = time.Hour * 24 * 7 // 7 days
+	RefreshTokenBytes          = 32
 
-func init() {
-	// Load the environment variables
-	if err := utils.LoadEnv(); err != nil {
-		log.Fatalf("Failed to load the .env file: %v", err)
-	}
+	// TOTP second factor
+	TOTPIssuer         = "FileStore"
+	RecoveryCodeCount  = 10
+	MFATokenBytes      = 32
+	MFATokenExpireTime = time.Minute * 5
+	MFAMaxAttempts     = 5 // code attempts per login
 
-	// JWT
-	JWTSecretKey = os.Getenv("JWT_SECRET_KEY")
-}
+	// Login brute-force protection
+	LoginFailureWindow    = time.Minute * 15
+	LoginMaxFailures      = 5  // failures of a username before the account is locked
+	LoginMaxFailuresPerIP = 20 // failures of an ip before it is blocked for the window
+	LoginLockoutTime      = time.Minute * 15
+	LoginBaseDelay        = time.Second * 1 // doubled with every failure
+	LoginMaxDelay         = time.Second * 30
+)
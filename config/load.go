@@ -0,0 +1,269 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// setting: a field of the config that can be set by an environment variable and optionally a flag
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	set   func(value string) error
+}
+
+func stringSetting(env, flag, usage string, dst *string) setting {
+	return setting{env, flag, usage, func(value string) error {
+		*dst = value
+		return nil
+	}}
+}
+
+func intSetting(env, flag, usage string, dst *int) setting {
+	return setting{env, flag, usage, func(value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*dst = n
+		return nil
+	}}
+}
+
+func int64Setting(env, flag, usage string, dst *int64) setting {
+	return setting{env, flag, usage, func(value string) error {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*dst = n
+		return nil
+	}}
+}
+
+func boolSetting(env, flag, usage string, dst *bool) setting {
+	return setting{env, flag, usage, func(value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		*dst = b
+		return nil
+	}}
+}
+
+func float64Setting(env, flag, usage string, dst *float64) setting {
+	return setting{env, flag, usage, func(value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*dst = f
+		return nil
+	}}
+}
+
+func durationSetting(env, flag, usage string, dst *time.Duration) setting {
+	return setting{env, flag, usage, func(value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration, e.g. 30s or 1h", value)
+		}
+		*dst = d
+		return nil
+	}}
+}
+
+func listSetting(env, flag, usage string, dst *[]string) setting {
+	return setting{env, flag, usage, func(value string) error {
+		*dst = strings.Fields(value)
+		return nil
+	}}
+}
+
+// settings: the environment variables and the flags of the config fields
+func (c *Config) settings() []setting {
+	return []setting{
+		stringSetting("SERVER_ADDR", "addr", "the address to listen on", &c.Server.Addr),
+		stringSetting("TLS_CERT_FILE", "tls-cert", "the TLS certificate file", &c.Server.CertFile),
+		stringSetting("TLS_KEY_FILE", "tls-key", "the TLS key file", &c.Server.KeyFile),
+		stringSetting("PUBLIC_BASE_URL", "public-url", "the base url of the links in the mails", &c.Server.PublicBaseURL),
+		durationSetting("SHUTDOWN_TIMEOUT", "shutdown-timeout", "how long the in-flight work is drained on shutdown", &c.Server.ShutdownTimeout),
+
+		stringSetting("FILE_STORE_DIR", "store-dir", "the directory of the uploaded files", &c.Storage.FileStoreDir),
+		stringSetting("FILE_CHUNK_DIR", "chunk-dir", "the directory of the uploaded chunks", &c.Storage.FileChunkDir),
+		int64Setting("MAX_UPLOAD_SIZE", "max-upload-size", "the memory of a multipart form in bytes", &c.Storage.MaxUploadSize),
+
+		stringSetting("DB_DRIVER", "db-driver", "the metadata database, mysql, postgres or sqlite", &c.Database.Driver),
+		boolSetting("DB_AUTO_MIGRATE", "db-auto-migrate", "apply the pending schema migrations on startup", &c.Database.AutoMigrate),
+
+		stringSetting("MYSQL_HOST", "mysql-host", "the mysql host", &c.MySQL.Host),
+		intSetting("MYSQL_PORT", "mysql-port", "the mysql port", &c.MySQL.Port),
+		stringSetting("MYSQL_USER", "", "", &c.MySQL.User),
+		stringSetting("MYSQL_PASSWORD", "", "", &c.MySQL.Password),
+		stringSetting("MYSQL_DATABASE", "", "", &c.MySQL.Database),
+		intSetting("MYSQL_MAX_OPEN_CONNS", "", "", &c.MySQL.MaxOpenConns),
+		intSetting("MYSQL_MAX_IDLE_CONNS", "", "", &c.MySQL.MaxIdleConns),
+		durationSetting("MYSQL_CONN_MAX_LIFETIME", "", "", &c.MySQL.ConnMaxLifetime),
+
+		stringSetting("POSTGRES_HOST", "postgres-host", "the PostgreSQL host", &c.Postgres.Host),
+		intSetting("POSTGRES_PORT", "postgres-port", "the PostgreSQL port", &c.Postgres.Port),
+		stringSetting("POSTGRES_USER", "", "", &c.Postgres.User),
+		stringSetting("POSTGRES_PASSWORD", "", "", &c.Postgres.Password),
+		stringSetting("POSTGRES_DATABASE", "", "", &c.Postgres.Database),
+		stringSetting("POSTGRES_SSLMODE", "", "", &c.Postgres.SSLMode),
+		intSetting("POSTGRES_MAX_OPEN_CONNS", "", "", &c.Postgres.MaxOpenConns),
+		intSetting("POSTGRES_MAX_IDLE_CONNS", "", "", &c.Postgres.MaxIdleConns),
+		durationSetting("POSTGRES_CONN_MAX_LIFETIME", "", "", &c.Postgres.ConnMaxLifetime),
+
+		stringSetting("SQLITE_PATH", "sqlite-path", "the SQLite database file", &c.SQLite.Path),
+
+		stringSetting("REDIS_HOST", "redis-host", "the redis host", &c.Redis.Host),
+		intSetting("REDIS_PORT", "redis-port", "the redis port", &c.Redis.Port),
+		stringSetting("REDIS_PASSWORD", "", "", &c.Redis.Password),
+		intSetting("REDIS_DB", "", "", &c.Redis.DB),
+
+		stringSetting("RABBITMQ_HOST", "rabbitmq-host", "the RabbitMQ host", &c.RabbitMQ.Host),
+		intSetting("RABBITMQ_PORT", "rabbitmq-port", "the RabbitMQ port", &c.RabbitMQ.Port),
+		stringSetting("RABBITMQ_USER", "", "", &c.RabbitMQ.User),
+		stringSetting("RABBITMQ_PASSWORD", "", "", &c.RabbitMQ.Password),
+		stringSetting("RABBITMQ_VHOST", "", "", &c.RabbitMQ.VHost),
+		stringSetting("TRANS_EXCHANGE_NAME", "", "", &c.RabbitMQ.Exchange),
+		stringSetting("TRANS_OSS_QUEUE_NAME", "", "", &c.RabbitMQ.OSSQueue),
+		stringSetting("TRANS_OSS_ROUTING_KEY", "", "", &c.RabbitMQ.OSSRoutingKey),
+		stringSetting("TRANS_INDEX_QUEUE_NAME", "", "", &c.RabbitMQ.IndexQueue),
+		stringSetting("TRANS_INDEX_ROUTING_KEY", "", "", &c.RabbitMQ.IndexRoutingKey),
+
+		stringSetting("OSS_ENDPOINT", "", "", &c.OSS.Endpoint),
+		stringSetting("OSS_ACCESS_KEY_ID", "", "", &c.OSS.AccessKeyID),
+		stringSetting("OSS_ACCESS_KEY_SECRET", "", "", &c.OSS.AccessKeySecret),
+		stringSetting("OSS_BUCKET_NAME", "", "", &c.OSS.Bucket),
+
+		stringSetting("JWT_SECRET_KEY", "", "", &c.JWT.SecretKey),
+		stringSetting("JWT_SIGNING_ALG", "", "", &c.JWT.SigningAlg),
+		stringSetting("JWT_KEYS_DIR", "", "", &c.JWT.KeysDir),
+		stringSetting("JWT_ACTIVE_KEY_ID", "", "", &c.JWT.ActiveKeyID),
+		stringSetting("JWT_ISSUER", "", "", &c.JWT.Issuer),
+		stringSetting("JWT_AUDIENCE", "", "", &c.JWT.Audience),
+
+		stringSetting("MAIL_DRIVER", "mail-driver", "the mail driver, smtp or log", &c.Mail.Driver),
+		stringSetting("MAIL_FROM", "", "", &c.Mail.From),
+		stringSetting("MAIL_LOG_FILE", "", "", &c.Mail.LogFile),
+		stringSetting("SMTP_HOST", "", "", &c.Mail.SMTPHost),
+		intSetting("SMTP_PORT", "", "", &c.Mail.SMTPPort),
+		stringSetting("SMTP_USERNAME", "", "", &c.Mail.SMTPUsername),
+		stringSetting("SMTP_PASSWORD", "", "", &c.Mail.SMTPPassword),
+		boolSetting("REQUIRE_EMAIL_VERIFICATION", "", "", &c.Mail.RequireVerification),
+
+		stringSetting("OIDC_ISSUER_URL", "", "", &c.OIDC.IssuerURL),
+		stringSetting("OIDC_CLIENT_ID", "", "", &c.OIDC.ClientID),
+		stringSetting("OIDC_CLIENT_SECRET", "", "", &c.OIDC.ClientSecret),
+		stringSetting("OIDC_REDIRECT_URL", "", "", &c.OIDC.RedirectURL),
+		listSetting("OIDC_SCOPES", "", "", &c.OIDC.Scopes),
+		stringSetting("OIDC_PROVIDER_NAME", "", "", &c.OIDC.ProviderName),
+		boolSetting("OIDC_AUTO_PROVISION", "", "", &c.OIDC.AutoProvision),
+		boolSetting("OIDC_LINK_BY_EMAIL", "", "", &c.OIDC.LinkByEmail),
+
+		durationSetting("HEALTH_TIMEOUT", "", "", &c.Health.Timeout),
+		int64Setting("HEALTH_MIN_FREE_BYTES", "", "", &c.Health.MinFreeBytes),
+		durationSetting("HEALTH_SHUTDOWN_DELAY", "", "", &c.Health.ShutdownDelay),
+
+		boolSetting("METRICS_ENABLED", "", "", &c.Metrics.Enabled),
+		stringSetting("METRICS_TOKEN", "", "", &c.Metrics.Token),
+
+		stringSetting("LOG_LEVEL", "log-level", "the level of the logs, debug, info, warn or error", &c.Log.Level),
+		stringSetting("LOG_FORMAT", "log-format", "the format of the logs, text or json", &c.Log.Format),
+
+		stringSetting("TRACING_EXPORTER", "tracing-exporter", "the exporter of the traces, none, otlp or stdout", &c.Tracing.Exporter),
+		stringSetting("TRACING_ENDPOINT", "", "", &c.Tracing.Endpoint),
+		boolSetting("TRACING_INSECURE", "", "", &c.Tracing.Insecure),
+		float64Setting("TRACING_SAMPLE_RATIO", "", "", &c.Tracing.SampleRatio),
+		stringSetting("TRACING_SERVICE_NAME", "", "", &c.Tracing.ServiceName),
+	}
+}
+
+// Load: load the config from the defaults, the YAML file given by -config or CONFIG_FILE,
+// the environment variables (and config/.env in development) and the flags in args, then validate it
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet("file-store-server", flag.ContinueOnError)
+	configFile := fs.String("config", "", "the YAML config file, defaults to $CONFIG_FILE")
+	byFlag := make(map[string]setting)
+	for _, s := range settings {
+		if s.flag != "" {
+			fs.String(s.flag, "", s.usage+" ($"+s.env+")")
+			byFlag[s.flag] = s
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// the .env file is optional, the variables already set take precedence over it
+	_ = utils.LoadEnv()
+
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, s := range settings {
+		if value := os.Getenv(s.env); value != "" {
+			if err := s.set(value); err != nil {
+				errs = append(errs, fmt.Errorf("$%s: %v", s.env, err))
+			}
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if s, ok := byFlag[f.Name]; ok {
+			if err := s.set(f.Value.String()); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %v", f.Name, err))
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+
+	if cfg.OIDC.RedirectURL == "" {
+		cfg.OIDC.RedirectURL = strings.TrimSuffix(cfg.Server.PublicBaseURL, "/") + "/user/login/oidc/callback"
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+// loadFile: override the config with the fields present in the YAML file
+func (c *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open the config file: %v", err.Error())
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true) // a misspelled key is an error instead of a silently ignored setting
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to parse the config file %s: %v", path, err.Error())
+	}
+	return nil
+}
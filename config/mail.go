@@ -0,0 +1,13 @@
+package config
+
+import "time"
+
+const (
+	EmailTokenBytes         = 32
+	EmailVerifyExpireTime   = time.Hour * 24 // 24 hours
+	PasswordResetExpireTime = time.Minute * 30
+	MailRateLimit           = 3 // mails of each kind per user in the window
+	MailRateLimitPerIP      = 10
+	MailRateLimitWindow     = time.Hour * 1
+	MinPasswordLength       = 8
+)
@@ -0,0 +1,8 @@
+package config
+
+import "time"
+
+const (
+	OIDCStateBytes      = 32
+	OIDCStateExpireTime = time.Minute * 10
+)
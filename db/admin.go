@@ -0,0 +1,147 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/models"
+)
+
+// ListUsers: list the users whose username or email contains the search, optionally with the status,
+// returns the page and the total number of the matching users
+func (d *DB) ListUsers(search string, status string, limit int, offset int) ([]models.AdminUser, int, error) {
+	condition := "1 = 1"
+	var args []interface{}
+	if search != "" {
+		// escape the LIKE wildcards of the search
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(search) + "%"
+		condition += " AND (" + d.db.dialect.like("u.username") + " OR " + d.db.dialect.like("u.email") + ")"
+		args = append(args, pattern, pattern)
+	}
+	if status != "" {
+		condition += " AND u.status = ?"
+		args = append(args, status)
+	}
+
+	var total int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM tbl_user u WHERE "+condition, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+
+	query := `SELECT u.id, u.username, COALESCE(u.email, ''), u.email_validated, u.role, u.status, u.locked_until,
+	u.signup_at, u.last_active, COALESCE(q.used_bytes, 0), COALESCE(q.file_count, 0)
+	FROM tbl_user u
+	LEFT JOIN tbl_user_quota q ON q.user_id = u.id
+	WHERE ` + condition + `
+	ORDER BY u.id
+	LIMIT ? OFFSET ?`
+	rows, err := d.db.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	defer rows.Close()
+
+	users := []models.AdminUser{}
+	for rows.Next() {
+		var user models.AdminUser
+		var lockedUntil sql.NullTime
+		err := rows.Scan(&user.UserID, &user.Username, &user.Email, &user.EmailValidated, &user.Role, &user.Status,
+			&lockedUntil, &user.SignupAt, &user.LastActive, &user.UsedBytes, &user.FileCount)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan the row: %v", err.Error())
+		}
+		if lockedUntil.Valid {
+			user.LockedUntil = &lockedUntil.Time
+		}
+		users = append(users, user)
+	}
+	return users, total, nil
+}
+
+// SetUserStatus: set the status of the account, the lockout time only applies to the locked status
+func (d *DB) SetUserStatus(userID int, status string, lockedUntil *time.Time) error {
+	if status != models.UserStatusLocked {
+		lockedUntil = nil
+	}
+	if _, err := d.db.Exec("UPDATE tbl_user SET status = ?, locked_until = ? WHERE id = ?", status, lockedUntil, userID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
+// SetUserRole: set the system role of the user
+func (d *DB) SetUserRole(userID int, role string) error {
+	if _, err := d.db.Exec("UPDATE tbl_user SET role = ? WHERE id = ?", role, userID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
+// ForceDeleteFile: delete the file from all users and teams regardless of its reference count,
+// returns false if the file does not exist and the path of the deleted file otherwise
+func (d *DB) ForceDeleteFile(fileID int) (bool, string, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return false, "", fmt.Errorf("failed to begin the transaction: %v", err.Error())
+	}
+	defer tx.Rollback() // no-op after the commit
+
+	var filePath string
+	query := "SELECT file_path FROM tbl_file WHERE id = ?" + tx.dialect.forUpdate("tbl_file")
+	if err := tx.QueryRow(query, fileID).Scan(&filePath); err != nil {
+		if err == sql.ErrNoRows {
+			return false, "", nil
+		}
+		return false, "", fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+
+	// Remove the file from the quota usage of everyone who is charged for it
+	if err := releaseFileQuota(tx, "uf.file_id = ?", fileID); err != nil {
+		return false, "", err
+	}
+
+	// the user files, share links and the search index are deleted by cascade
+	if _, err := tx.Exec("DELETE FROM tbl_file WHERE id = ?", fileID); err != nil {
+		return false, "", fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, "", fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+	return true, filePath, nil
+}
+
+// GetSystemStats: get the system-wide storage statistics
+func (d *DB) GetSystemStats() (*models.SystemStats, error) {
+	stats := &models.SystemStats{Users: make(map[string]int)}
+
+	rows, err := d.db.Query("SELECT status, COUNT(*) FROM tbl_user GROUP BY status")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan the row: %v", err.Error())
+		}
+		stats.Users[status] = count
+	}
+
+	query := `SELECT
+	(SELECT COUNT(*) FROM tbl_file),
+	(SELECT COALESCE(SUM(file_size), 0) FROM tbl_file),
+	(SELECT COUNT(*) FROM tbl_user_file),
+	(SELECT COALESCE(SUM(used_bytes), 0) FROM tbl_user_quota),
+	(SELECT COUNT(*) FROM tbl_team),
+	(SELECT COUNT(*) FROM tbl_share_link WHERE status = 'active')`
+	err = d.db.QueryRow(query).Scan(&stats.Files, &stats.StoredBytes, &stats.UserFiles, &stats.LogicalBytes,
+		&stats.Teams, &stats.ShareLinks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return stats, nil
+}
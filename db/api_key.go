@@ -0,0 +1,129 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/models"
+)
+
+// SaveAPIKey: save the API key by the hash of the key
+func (d *DB) SaveAPIKey(key *models.APIKey, keyHash string) (int, error) {
+	query := "INSERT INTO tbl_api_key (user_id, name, prefix, key_hash, scopes, expire_at) VALUES (?, ?, ?, ?, ?, ?)"
+
+	keyID, err := d.db.insert(query, key.UserID, key.Name, key.Prefix, keyHash, strings.Join(key.Scopes, ","), key.ExpireAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return int(keyID), nil
+}
+
+const apiKeyColumns = `k.id, k.user_id, u.username, k.name, k.prefix, k.scopes, k.expire_at, k.last_used_at, k.last_used_ip, k.create_at`
+
+// scanAPIKey: scan the API key from the row
+func scanAPIKey(scanner interface{ Scan(...interface{}) error }) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	var scopes string
+	var expireAt, lastUsedAt sql.NullTime
+	err := scanner.Scan(&key.KeyID, &key.UserID, &key.Username, &key.Name, &key.Prefix, &scopes,
+		&expireAt, &lastUsedAt, &key.LastUsedIP, &key.CreateAt)
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = []string{}
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	if expireAt.Valid {
+		key.ExpireAt = &expireAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	return key, nil
+}
+
+// GetAPIKeyByHash: get the API key by the hash of the key, nil if it does not exist or the user is disabled,
+// the keys of the users locked out for a while by failed logins keep working, not those locked with no end
+func (d *DB) GetAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + `
+	FROM tbl_api_key k
+	JOIN tbl_user u ON u.id = k.user_id
+	WHERE k.key_hash = ? AND (u.status = 'active' OR (u.status = 'locked' AND u.locked_until > ?))`
+
+	key, err := scanAPIKey(d.db.QueryRow(query, keyHash, time.Now()))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return key, nil
+}
+
+// GetUserAPIKeys: get the API keys of the user
+func (d *DB) GetUserAPIKeys(userID int) ([]models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + `
+	FROM tbl_api_key k
+	JOIN tbl_user u ON u.id = k.user_id
+	WHERE k.user_id = ?
+	ORDER BY k.create_at DESC`
+
+	rows, err := d.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan the row: %v", err.Error())
+		}
+		keys = append(keys, *key)
+	}
+	return keys, nil
+}
+
+// CountUserAPIKeys: count the API keys of the user
+func (d *DB) CountUserAPIKeys(userID int) (int, error) {
+	var count int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM tbl_api_key WHERE user_id = ?", userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return count, nil
+}
+
+// DeleteAPIKey: delete the API key of the user, returns false if it does not exist
+func (d *DB) DeleteAPIKey(userID int, keyID int) (bool, error) {
+	result, err := d.db.Exec("DELETE FROM tbl_api_key WHERE id = ? AND user_id = ?", keyID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get the affected rows: %v", err.Error())
+	}
+	return affected > 0, nil
+}
+
+// DeleteUserAPIKeys: delete all API keys of the user, e.g. once an admin locks or disables the account
+func (d *DB) DeleteUserAPIKeys(userID int) error {
+	if _, err := d.db.Exec("DELETE FROM tbl_api_key WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
+// TouchAPIKey: record the last use of the API key, at most once per interval to spare the writes
+func (d *DB) TouchAPIKey(keyID int, ip string, interval time.Duration) error {
+	query := "UPDATE tbl_api_key SET last_used_at = ?, last_used_ip = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)"
+	now := time.Now()
+	if _, err := d.db.Exec(query, now, ip, keyID, now.Add(-interval)); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/XSAM/otelsql"
+	"github.com/bladewaltz9/file-store-server/config"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	_ "modernc.org/sqlite"
+)
+
+// DB: the metadata database
+type DB struct {
+	db *conn
+}
+
+// Open: open the connection pool of the configured database driver and check the connection
+func Open(cfg *config.Config) (*DB, error) {
+	var pool *sql.DB
+	var err error
+	switch dialect(cfg.Database.Driver) {
+	case dialectMySQL:
+		pool, err = openPool("mysql", cfg.MySQL.DSN(), semconv.DBSystemNameMySQL)
+		if err == nil {
+			pool.SetMaxIdleConns(cfg.MySQL.MaxIdleConns)
+			pool.SetMaxOpenConns(cfg.MySQL.MaxOpenConns)
+			pool.SetConnMaxLifetime(cfg.MySQL.ConnMaxLifetime)
+		}
+	case dialectPostgres:
+		pool, err = openPool("pgx", cfg.Postgres.DSN(), semconv.DBSystemNamePostgreSQL)
+		if err == nil {
+			pool.SetMaxIdleConns(cfg.Postgres.MaxIdleConns)
+			pool.SetMaxOpenConns(cfg.Postgres.MaxOpenConns)
+			pool.SetConnMaxLifetime(cfg.Postgres.ConnMaxLifetime)
+		}
+	case dialectSQLite:
+		if err := os.MkdirAll(filepath.Dir(cfg.SQLite.Path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create the sqlite directory: %v", err.Error())
+		}
+		pool, err = openPool("sqlite", sqliteDSN(cfg.SQLite.Path), semconv.DBSystemNameSQLite)
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", cfg.Database.Driver)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pool.Ping(); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to ping the %s: %v", cfg.Database.Driver, err.Error())
+	}
+	return &DB{db: &conn{DB: pool, dialect: dialect(cfg.Database.Driver), ctx: context.Background()}}, nil
+}
+
+// openPool: open the connection pool of the driver, the queries are traced in the spans of their contexts
+func openPool(driverName string, dsn string, system attribute.KeyValue) (*sql.DB, error) {
+	pool, err := otelsql.Open(driverName, dsn,
+		otelsql.WithAttributes(system),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+			// only the queries of the traced requests and messages, not the migrations and the probes
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsValid()
+			},
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the %s: %v", driverName, err.Error())
+	}
+	return pool, nil
+}
+
+// sqliteDSN: the data source name of the sqlite file, the foreign keys are enforced as in the other databases,
+// the transactions take the write lock when they begin and wait for each other instead of failing
+func sqliteDSN(path string) string {
+	params := url.Values{
+		"_pragma":      {"foreign_keys(1)", "busy_timeout(10000)", "journal_mode(WAL)"},
+		"_txlock":      {"immediate"},
+		"_time_format": {"sqlite"},
+	}
+	return "file:" + path + "?" + params.Encode()
+}
+
+// WithContext: the database running its queries with ctx, they are traced as the children of its span,
+// the queries are not canceled with ctx, e.g. the work left running after the response
+func (d *DB) WithContext(ctx context.Context) *DB {
+	return &DB{db: &conn{DB: d.db.DB, dialect: d.db.dialect, ctx: context.WithoutCancel(ctx)}}
+}
+
+// Ping: check the connection to the database
+func (d *DB) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+// Close: close the connection pool
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Stats: the statistics of the connection pool
+func (d *DB) Stats() sql.DBStats {
+	return d.db.Stats()
+}
+
+// conn: the connection pool, the queries and their arguments are adapted to the dialect
+type conn struct {
+	*sql.DB
+	dialect dialect
+	ctx     context.Context // of the queries, see WithContext
+}
+
+func (c *conn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.DB.ExecContext(c.ctx, c.dialect.rebind(query), c.dialect.args(args)...)
+}
+
+func (c *conn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.DB.QueryContext(c.ctx, c.dialect.rebind(query), c.dialect.args(args)...)
+}
+
+func (c *conn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.DB.QueryRowContext(c.ctx, c.dialect.rebind(query), c.dialect.args(args)...)
+}
+
+func (c *conn) Prepare(query string) (*stmt, error) {
+	s, err := c.DB.PrepareContext(c.ctx, c.dialect.rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: s, dialect: c.dialect, ctx: c.ctx}, nil
+}
+
+func (c *conn) Begin() (*txConn, error) {
+	tx, err := c.DB.BeginTx(c.ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &txConn{Tx: tx, dialect: c.dialect, ctx: c.ctx}, nil
+}
+
+// insert: execute the INSERT and get the id of the new row
+func (c *conn) insert(query string, args ...interface{}) (int64, error) {
+	return insertID(c, c.dialect, query, args)
+}
+
+// txConn: the transaction, the queries and their arguments are adapted to the dialect
+type txConn struct {
+	*sql.Tx
+	dialect dialect
+	ctx     context.Context
+}
+
+func (t *txConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.Tx.ExecContext(t.ctx, t.dialect.rebind(query), t.dialect.args(args)...)
+}
+
+func (t *txConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return t.Tx.QueryContext(t.ctx, t.dialect.rebind(query), t.dialect.args(args)...)
+}
+
+func (t *txConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return t.Tx.QueryRowContext(t.ctx, t.dialect.rebind(query), t.dialect.args(args)...)
+}
+
+func (t *txConn) Prepare(query string) (*stmt, error) {
+	s, err := t.Tx.PrepareContext(t.ctx, t.dialect.rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: s, dialect: t.dialect, ctx: t.ctx}, nil
+}
+
+// insert: execute the INSERT in the transaction and get the id of the new row
+func (t *txConn) insert(query string, args ...interface{}) (int64, error) {
+	return insertID(t, t.dialect, query, args)
+}
+
+// stmt: the prepared statement, its arguments are adapted to the dialect
+type stmt struct {
+	*sql.Stmt
+	dialect dialect
+	ctx     context.Context
+}
+
+func (s *stmt) Exec(args ...interface{}) (sql.Result, error) {
+	return s.Stmt.ExecContext(s.ctx, s.dialect.args(args)...)
+}
+
+func (s *stmt) Query(args ...interface{}) (*sql.Rows, error) {
+	return s.Stmt.QueryContext(s.ctx, s.dialect.args(args)...)
+}
+
+func (s *stmt) QueryRow(args ...interface{}) *sql.Row {
+	return s.Stmt.QueryRowContext(s.ctx, s.dialect.args(args)...)
+}
+
+// execer: the common methods of conn and txConn
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// insertID: execute the INSERT and get the id of the new row, PostgreSQL has no LastInsertId and returns it instead
+func insertID(e execer, d dialect, query string, args []interface{}) (int64, error) {
+	if d == dialectPostgres {
+		var id int64
+		err := e.QueryRow(query+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+	result, err := e.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
@@ -0,0 +1,343 @@
+package db_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/db"
+	"github.com/bladewaltz9/file-store-server/db/dbtest"
+	"github.com/bladewaltz9/file-store-server/models"
+)
+
+// openTestDB: open the database described by cfg and close it when the test ends
+func openTestDB(t *testing.T, cfg *config.Config) *db.DB {
+	store, err := db.Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open the database: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// loadTestConfig: load the config from the environment for the given driver
+func loadTestConfig(t *testing.T, driver string) *config.Config {
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatalf("Failed to load the config: %v", err)
+	}
+	cfg.Database.Driver = driver
+	return cfg
+}
+
+// TestContractMySQL: run the contract against the MySQL database named by MYSQL_TEST_DATABASE,
+// migrated to the latest schema, the test is skipped if it is not set
+func TestContractMySQL(t *testing.T) {
+	database := os.Getenv("MYSQL_TEST_DATABASE")
+	if database == "" {
+		t.Skip("MYSQL_TEST_DATABASE is not set")
+	}
+	cfg := loadTestConfig(t, "mysql")
+	cfg.MySQL.Database = database
+
+	store := openTestDB(t, cfg)
+	if _, err := store.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate the database: %v", err)
+	}
+	dbtest.Run(t, func(t *testing.T) dbtest.Store { return store })
+}
+
+// TestContractPostgres: run the contract against the PostgreSQL database named by POSTGRES_TEST_DATABASE,
+// migrated to the latest schema, the test is skipped if it is not set
+func TestContractPostgres(t *testing.T) {
+	database := os.Getenv("POSTGRES_TEST_DATABASE")
+	if database == "" {
+		t.Skip("POSTGRES_TEST_DATABASE is not set")
+	}
+	cfg := loadTestConfig(t, "postgres")
+	cfg.Postgres.Database = database
+
+	store := openTestDB(t, cfg)
+	if _, err := store.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate the database: %v", err)
+	}
+	dbtest.Run(t, func(t *testing.T) dbtest.Store { return store })
+}
+
+// openSQLite: open an empty SQLite database in the temporary directory of the test
+func openSQLite(t *testing.T) *db.DB {
+	cfg := config.Default()
+	cfg.Database.Driver = "sqlite"
+	cfg.SQLite.Path = filepath.Join(t.TempDir(), "file_store.db")
+	return openTestDB(t, cfg)
+}
+
+// TestContractSQLite: run the contract against a temporary SQLite database
+func TestContractSQLite(t *testing.T) {
+	store := openSQLite(t)
+	if _, err := store.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate the database: %v", err)
+	}
+	dbtest.Run(t, func(t *testing.T) dbtest.Store { return store })
+}
+
+func TestMigrate(t *testing.T) {
+	store := openSQLite(t)
+	applied, err := store.Migrate()
+	if err != nil || len(applied) == 0 || applied[0] != "0001_init" {
+		t.Fatalf("Expected the migrations to be applied, got %v, %v", applied, err)
+	}
+	current, latest, err := store.SchemaVersion()
+	if err != nil || current != latest || store.CheckSchema() != nil {
+		t.Fatalf("Expected the latest version, got %d of %d, %v", current, latest, err)
+	}
+	if applied, err := store.Migrate(); err != nil || len(applied) != 0 {
+		t.Errorf("Expected no pending migrations, got %v, %v", applied, err)
+	}
+
+	reverted, err := store.MigrateDown(latest)
+	if err != nil || len(reverted) != latest || reverted[len(reverted)-1] != "0001_init" {
+		t.Fatalf("Expected the migrations to be reverted, got %v, %v", reverted, err)
+	}
+	if err := store.CheckSchema(); !errors.Is(err, db.ErrSchemaOutdated) {
+		t.Errorf("Expected ErrSchemaOutdated, got %v", err)
+	}
+	if err := store.SaveUserInfo("alice", "encoded", "alice@example.com"); err == nil {
+		t.Errorf("Expected the tables to be dropped")
+	}
+
+	if _, err := store.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate the database again: %v", err)
+	}
+	if err := store.ExecScript("INSERT INTO schema_migrations (version, name) VALUES (9999, 'future')"); err != nil {
+		t.Fatalf("Failed to record the future migration: %v", err)
+	}
+	if _, err := store.Migrate(); !errors.Is(err, db.ErrSchemaTooNew) {
+		t.Errorf("Expected ErrSchemaTooNew from Migrate, got %v", err)
+	}
+	if err := store.CheckSchema(); !errors.Is(err, db.ErrSchemaTooNew) {
+		t.Errorf("Expected ErrSchemaTooNew from CheckSchema, got %v", err)
+	}
+}
+
+// TestMigrateBaseline: the databases created by hand from the original doc/table.sql are recorded at
+// version 1 and migrated with their data
+func TestMigrateBaseline(t *testing.T) {
+	// the SQLite form of the original doc/table.sql
+	schema, err := os.ReadFile("migrations/sqlite/0001_init.up.sql")
+	if err != nil {
+		t.Fatalf("Failed to read the schema: %v", err)
+	}
+	store := openSQLite(t)
+	if err := store.ExecScript(string(schema)); err != nil {
+		t.Fatalf("Failed to create the schema: %v", err)
+	}
+	if err := store.ExecScript(`INSERT INTO tbl_user (id, username, password, email) VALUES (1, 'alice', 'encoded', 'alice@example.com');
+		INSERT INTO tbl_file (id, file_hash, file_name, file_size, file_path, reference_count) VALUES (1, 'hash', 'a.txt', 11, '/data/hash', 1);
+		INSERT INTO tbl_user_file (user_id, file_id, file_name) VALUES (1, 1, 'a.txt')`); err != nil {
+		t.Fatalf("Failed to save the data: %v", err)
+	}
+
+	if current, _, err := store.SchemaVersion(); err != nil || current != 1 {
+		t.Fatalf("Expected version 1, got %d, %v", current, err)
+	}
+	applied, err := store.Migrate()
+	if err != nil {
+		t.Fatalf("Failed to migrate the database: %v", err)
+	}
+	if len(applied) == 0 || applied[0] == "0001_init" {
+		t.Errorf("Expected the migrations after the first one, got %v", applied)
+	}
+	if err := store.CheckSchema(); err != nil {
+		t.Errorf("Expected the latest version, got %v", err)
+	}
+
+	user, err := store.GetUserInfoByUsername("alice")
+	if err != nil || user.Role != models.UserRoleUser {
+		t.Errorf("Expected alice to be a user, got %+v, %v", user, err)
+	}
+	if files, err := store.GetUserFiles(1); err != nil || len(files) != 1 || files[0].FileName != "a.txt" {
+		t.Errorf("Expected the file of alice to be kept, got %+v, %v", files, err)
+	}
+	if quota, err := store.GetUserQuota(1); err != nil || quota.FileCount != 1 || quota.UsedBytes != 11 {
+		t.Errorf("Expected the existing file to be charged, got %+v, %v", quota, err)
+	}
+}
+
+// TestMigrateBaselineFeatures: the databases created by hand from doc/table.sql with the tables added
+// before the migrations are recorded at version 2
+func TestMigrateBaselineFeatures(t *testing.T) {
+	store := openSQLite(t)
+	for _, name := range []string{"0001_init", "0002_features"} {
+		schema, err := os.ReadFile("migrations/sqlite/" + name + ".up.sql")
+		if err != nil {
+			t.Fatalf("Failed to read the schema: %v", err)
+		}
+		if err := store.ExecScript(string(schema)); err != nil {
+			t.Fatalf("Failed to create the schema: %v", err)
+		}
+	}
+
+	if current, _, err := store.SchemaVersion(); err != nil || current != 2 {
+		t.Fatalf("Expected version 2, got %d, %v", current, err)
+	}
+	applied, err := store.Migrate()
+	if err != nil {
+		t.Fatalf("Failed to migrate the database: %v", err)
+	}
+	for _, name := range applied {
+		if name == "0001_init" || name == "0002_features" {
+			t.Errorf("Expected the first migrations to be skipped, got %v", applied)
+		}
+	}
+	if err := store.CheckSchema(); err != nil {
+		t.Errorf("Expected the latest version, got %v", err)
+	}
+}
+
+// saveTestUser: save the user and return its id
+func saveTestUser(t *testing.T, store *db.DB, username string) int {
+	t.Helper()
+	if err := store.SaveUserInfo(username, "encoded", username+"@example.com"); err != nil {
+		t.Fatalf("Failed to save the user: %v", err)
+	}
+	user, err := store.GetUserInfoByUsername(username)
+	if err != nil {
+		t.Fatalf("Failed to get the user: %v", err)
+	}
+	return user.UserID
+}
+
+// saveTestFile: save the file with the hash and give it to the users as a.txt
+func saveTestFile(t *testing.T, store *db.DB, hash string, userIDs ...int) int {
+	t.Helper()
+	fileID, err := store.SaveFileMeta(hash, "a.txt", 1, "/data/"+hash)
+	if err != nil {
+		t.Fatalf("Failed to save the file: %v", err)
+	}
+	for _, userID := range userIDs {
+		if err := store.SaveUserFile(userID, fileID, "a.txt"); err != nil {
+			t.Fatalf("Failed to save the user file: %v", err)
+		}
+	}
+	return fileID
+}
+
+// TestBulkEditTags: the tags added in bulk are capped per file
+func TestBulkEditTags(t *testing.T) {
+	store := openSQLite(t)
+	if _, err := store.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate the database: %v", err)
+	}
+	userID := saveTestUser(t, store, "alice")
+	fileID := saveTestFile(t, store, "hash", userID)
+
+	tags := make([]string, config.MaxTagsPerFile)
+	for i := range tags {
+		tags[i] = fmt.Sprintf("tag%d", i)
+	}
+	if err := store.BulkEditTags(userID, []int{fileID}, tags, nil); err != nil {
+		t.Fatalf("Failed to add the tags: %v", err)
+	}
+	if err := store.BulkEditTags(userID, []int{fileID}, []string{"tag0"}, nil); err != nil {
+		t.Errorf("Expected the existing tags to be accepted, got %v", err)
+	}
+	if err := store.BulkEditTags(userID, []int{fileID}, []string{"extra"}, nil); !errors.Is(err, db.ErrTooManyTags) {
+		t.Errorf("Expected ErrTooManyTags, got %v", err)
+	}
+	if err := store.BulkEditTags(userID, []int{fileID}, []string{"extra"}, []string{"tag0"}); err != nil {
+		t.Errorf("Expected the tag to replace a removed one, got %v", err)
+	}
+}
+
+// TestUpdateUserFileMeta: the users renaming a stored file rename only their own copy,
+// or the copy shared with them for writing
+func TestUpdateUserFileMeta(t *testing.T) {
+	store := openSQLite(t)
+	if _, err := store.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate the database: %v", err)
+	}
+	alice, bob, carol := saveTestUser(t, store, "alice"), saveTestUser(t, store, "bob"), saveTestUser(t, store, "carol")
+	fileID := saveTestFile(t, store, "hash", alice, bob)
+	share := &models.UserShare{OwnerID: alice, GranteeID: carol, FileID: fileID, Permission: models.PermissionWrite}
+	if err := store.SaveUserShare(share); err != nil {
+		t.Fatalf("Failed to share the file: %v", err)
+	}
+
+	fileName := func(userID int) string {
+		files, err := store.GetUserFiles(userID)
+		if err != nil || len(files) != 1 {
+			t.Fatalf("Expected one file, got %v, %v", files, err)
+		}
+		return files[0].FileName
+	}
+	rename := func(userID int, name string) {
+		if err := store.UpdateUserFileMeta(userID, fileID, models.UpdateFileMetaRequest{FileName: name, Status: "active"}); err != nil {
+			t.Fatalf("Failed to rename the file: %v", err)
+		}
+	}
+
+	rename(bob, "bob.txt")
+	if fileName(alice) != "a.txt" || fileName(bob) != "bob.txt" {
+		t.Errorf("Expected only the file of bob to be renamed, got %q and %q", fileName(alice), fileName(bob))
+	}
+	rename(carol, "carol.txt")
+	if fileName(alice) != "carol.txt" || fileName(bob) != "bob.txt" {
+		t.Errorf("Expected only the file shared with carol to be renamed, got %q and %q", fileName(alice), fileName(bob))
+	}
+	if meta, err := store.GetFileMeta(fileID); err != nil || meta.FileName != "a.txt" {
+		t.Errorf("Expected the stored file to keep its name, got %+v, %v", meta, err)
+	}
+}
+
+// TestGetAPIKeyByHash: the API keys work while the user is active or locked out for a while
+func TestGetAPIKeyByHash(t *testing.T) {
+	store := openSQLite(t)
+	if _, err := store.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate the database: %v", err)
+	}
+	userID := saveTestUser(t, store, "alice")
+	if _, err := store.SaveAPIKey(&models.APIKey{UserID: userID, Name: "ci", Prefix: "fsk_test", Scopes: []string{"read"}}, "hash"); err != nil {
+		t.Fatalf("Failed to save the API key: %v", err)
+	}
+
+	future, past := time.Now().Add(time.Hour), time.Now().Add(-time.Hour)
+	cases := []struct {
+		name        string
+		status      string
+		lockedUntil *time.Time
+		valid       bool
+	}{
+		{"active", models.UserStatusActive, nil, true},
+		{"locked for a while", models.UserStatusLocked, &future, true},
+		{"lock expired", models.UserStatusLocked, &past, false},
+		{"locked with no end", models.UserStatusLocked, nil, false},
+		{"disabled", models.UserStatusDisabled, nil, false},
+	}
+	for _, c := range cases {
+		if err := store.SetUserStatus(userID, c.status, c.lockedUntil); err != nil {
+			t.Fatalf("%s: failed to set the status: %v", c.name, err)
+		}
+		key, err := store.GetAPIKeyByHash("hash")
+		if err != nil {
+			t.Fatalf("%s: failed to get the API key: %v", c.name, err)
+		}
+		if (key != nil) != c.valid {
+			t.Errorf("%s: expected the key valid %v, got %+v", c.name, c.valid, key)
+		}
+	}
+
+	if err := store.SetUserStatus(userID, models.UserStatusActive, nil); err != nil {
+		t.Fatalf("Failed to set the status: %v", err)
+	}
+	if err := store.DeleteUserAPIKeys(userID); err != nil {
+		t.Fatalf("Failed to delete the API keys: %v", err)
+	}
+	if key, err := store.GetAPIKeyByHash("hash"); err != nil || key != nil {
+		t.Errorf("Expected the API key to be deleted, got %+v, %v", key, err)
+	}
+}
@@ -0,0 +1,284 @@
+// Package dbtest is the contract of the repositories of the db package, run against
+// every implementation so the in-memory store keeps the semantics of MySQL.
+package dbtest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/db"
+	"github.com/bladewaltz9/file-store-server/models"
+)
+
+// Store: the repositories under test
+type Store interface {
+	db.UserRepository
+	db.FileRepository
+}
+
+// Run: run the contract against the stores created by newStore, the names and the hashes
+// are unique to the run so the contract can run against a shared database
+func Run(t *testing.T, newStore func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s Store, unique func(string) string)
+	}{
+		{"UniqueUsername", testUniqueUsername},
+		{"UserUpdates", testUserUpdates},
+		{"UniqueHash", testUniqueHash},
+		{"ReferenceCount", testReferenceCount},
+		{"Quota", testQuota},
+		{"DeleteUserCascade", testDeleteUserCascade},
+	}
+	run := time.Now().UnixNano()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unique := func(name string) string {
+				return fmt.Sprintf("%s_%s_%d", tt.name, name, run)
+			}
+			tt.test(t, newStore(t), unique)
+		})
+	}
+}
+
+// newUser: save a user and return it
+func newUser(t *testing.T, s Store, username string) *models.UserInfo {
+	t.Helper()
+	if err := s.SaveUserInfo(username, "encoded", username+"@example.com"); err != nil {
+		t.Fatalf("Failed to save the user: %v", err)
+	}
+	user, err := s.GetUserInfoByUsername(username)
+	if err != nil {
+		t.Fatalf("Failed to get the user: %v", err)
+	}
+	t.Cleanup(func() { _, _ = s.DeleteUser(user.UserID) })
+	return user
+}
+
+// newFile: save a file and return its id
+func newFile(t *testing.T, s Store, hash string, size int64) int {
+	t.Helper()
+	fileID, err := s.SaveFileMeta(hash, hash+".txt", size, "/data/"+hash)
+	if err != nil {
+		t.Fatalf("Failed to save the file: %v", err)
+	}
+	return fileID
+}
+
+func testUniqueUsername(t *testing.T, s Store, unique func(string) string) {
+	user := newUser(t, s, unique("alice"))
+	if user.Role != models.UserRoleUser || user.Status != models.UserStatusActive || user.EmailValidated {
+		t.Errorf("Expected an active user with an unverified email, got %+v", user)
+	}
+
+	if err := s.SaveUserInfo(user.Username, "other", "other@example.com"); !errors.Is(err, db.ErrUserExists) {
+		t.Errorf("Expected ErrUserExists, got %v", err)
+	}
+	if _, err := s.GetUserInfoByUsername(unique("nobody")); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	if byID, err := s.GetUserInfoByID(user.UserID); err != nil || byID.Username != user.Username {
+		t.Errorf("Expected the user by id, got %+v, %v", byID, err)
+	}
+	if byEmail, err := s.GetUserInfoByEmail(user.Email); err != nil || byEmail.UserID != user.UserID {
+		t.Errorf("Expected the user by email, got %+v, %v", byEmail, err)
+	}
+}
+
+func testUserUpdates(t *testing.T, s Store, unique func(string) string) {
+	user := newUser(t, s, unique("bob"))
+
+	// the token of an old email does not verify the new one
+	if err := s.SetEmailValidated(user.UserID, "old@example.com"); err != nil {
+		t.Fatalf("Failed to validate the email: %v", err)
+	}
+	if got, _ := s.GetUserInfoByID(user.UserID); got.EmailValidated {
+		t.Errorf("Expected the email to stay unverified")
+	}
+	if err := s.SetEmailValidated(user.UserID, user.Email); err != nil {
+		t.Fatalf("Failed to validate the email: %v", err)
+	}
+	if got, _ := s.GetUserInfoByID(user.UserID); !got.EmailValidated {
+		t.Errorf("Expected the email to be verified")
+	}
+
+	other := newUser(t, s, unique("carol"))
+	if inUse, err := s.EmailInUse(other.Email, user.UserID); err != nil || !inUse {
+		t.Errorf("Expected the email of another user to be in use, got %v, %v", inUse, err)
+	}
+	if inUse, err := s.EmailInUse(user.Email, user.UserID); err != nil || inUse {
+		t.Errorf("Expected the own email not to be in use, got %v, %v", inUse, err)
+	}
+
+	phone := "+8613800000000"
+	profile := &models.UserProfile{DisplayName: "Bob"}
+	if err := s.UpdateUserProfile(user.UserID, &phone, profile); err != nil {
+		t.Fatalf("Failed to update the profile: %v", err)
+	}
+	if err := s.UpdateUserProfile(user.UserID, nil, nil); err != nil {
+		t.Fatalf("Failed to update the profile: %v", err)
+	}
+	if got, _ := s.GetUserInfoByID(user.UserID); got.Phone != phone || got.Profile.DisplayName != "Bob" {
+		t.Errorf("Expected the nil fields to be unchanged, got %+v", got)
+	}
+
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := s.LockUser(user.UserID, &until); err != nil {
+		t.Fatalf("Failed to lock the user: %v", err)
+	}
+	if got, _ := s.GetUserInfoByID(user.UserID); got.Status != models.UserStatusLocked || got.LockedUntil == nil {
+		t.Errorf("Expected the user to be locked, got %+v", got)
+	}
+	if unlocked, err := s.UnlockUser(user.UserID); err != nil || !unlocked {
+		t.Errorf("Expected the user to be unlocked, got %v, %v", unlocked, err)
+	}
+	if unlocked, err := s.UnlockUser(user.UserID); err != nil || unlocked {
+		t.Errorf("Expected the active user not to be unlocked again, got %v, %v", unlocked, err)
+	}
+}
+
+func testUniqueHash(t *testing.T, s Store, unique func(string) string) {
+	hash := unique("hash")
+	fileID := newFile(t, s, hash, 10)
+	t.Cleanup(func() { _, _, _ = s.DeleteUserFile(0, fileID) })
+
+	if _, err := s.SaveFileMeta(hash, "copy.txt", 10, "/data/copy"); !errors.Is(err, db.ErrFileExists) {
+		t.Errorf("Expected ErrFileExists, got %v", err)
+	}
+	if exists, id, err := s.FileExists(hash); err != nil || !exists || id != fileID {
+		t.Errorf("Expected the file %d to exist, got %v, %d, %v", fileID, exists, id, err)
+	}
+	if exists, _, err := s.FileExists(unique("missing")); err != nil || exists {
+		t.Errorf("Expected the file not to exist, got %v, %v", exists, err)
+	}
+
+	if err := s.UpdateFileMeta(fileID, models.UpdateFileMetaRequest{FileName: "renamed.txt", Status: "disabled"}); err != nil {
+		t.Fatalf("Failed to update the file: %v", err)
+	}
+	meta, err := s.GetFileMeta(fileID)
+	if err != nil {
+		t.Fatalf("Failed to get the file: %v", err)
+	}
+	if meta.FileHash != hash || meta.FileName != "renamed.txt" || meta.Status != "disabled" || meta.FileSize != 10 {
+		t.Errorf("Unexpected file metadata %+v", meta)
+	}
+}
+
+func testReferenceCount(t *testing.T, s Store, unique func(string) string) {
+	alice := newUser(t, s, unique("alice"))
+	bob := newUser(t, s, unique("bob"))
+	fileID := newFile(t, s, unique("hash"), 10)
+
+	for _, user := range []*models.UserInfo{alice, bob} {
+		if err := s.SaveUserFile(user.UserID, fileID, user.Username+".txt"); err != nil {
+			t.Fatalf("Failed to save the user file: %v", err)
+		}
+	}
+	if err := s.SaveUserFile(alice.UserID, fileID, "again.txt"); !errors.Is(err, db.ErrUserFileExists) {
+		t.Errorf("Expected ErrUserFileExists, got %v", err)
+	}
+	if exists, err := s.UserFileExists(bob.UserID, fileID); err != nil || !exists {
+		t.Errorf("Expected bob to have the file, got %v, %v", exists, err)
+	}
+	files, err := s.GetUserFiles(alice.UserID)
+	if err != nil || len(files) != 1 || files[0].FileID != fileID || files[0].FileName != alice.Username+".txt" || files[0].FileSize != 10 {
+		t.Errorf("Expected the file of alice under the name given by alice, got %+v, %v", files, err)
+	}
+
+	// the file is kept while bob references it
+	if released, _, err := s.DeleteUserFile(alice.UserID, fileID); err != nil || released {
+		t.Errorf("Expected the file to be kept, got %v, %v", released, err)
+	}
+	if exists, err := s.UserFileExists(alice.UserID, fileID); err != nil || exists {
+		t.Errorf("Expected alice not to have the file, got %v, %v", exists, err)
+	}
+	if _, err := s.GetFileMeta(fileID); err != nil {
+		t.Errorf("Expected the file to be kept, got %v", err)
+	}
+
+	released, filePath, err := s.DeleteUserFile(bob.UserID, fileID)
+	if err != nil || !released || filePath != "/data/"+unique("hash") {
+		t.Errorf("Expected the file to be released, got %v, %q, %v", released, filePath, err)
+	}
+	if _, err := s.GetFileMeta(fileID); !errors.Is(err, db.ErrFileNotFound) {
+		t.Errorf("Expected ErrFileNotFound, got %v", err)
+	}
+}
+
+func testQuota(t *testing.T, s Store, unique func(string) string) {
+	user := newUser(t, s, unique("alice"))
+	fileID := newFile(t, s, unique("small"), 1000)
+
+	if err := s.SaveUserFile(user.UserID, fileID, "small.txt"); err != nil {
+		t.Fatalf("Failed to save the user file: %v", err)
+	}
+	quota, err := s.GetUserQuota(user.UserID)
+	if err != nil {
+		t.Fatalf("Failed to get the quota: %v", err)
+	}
+	if quota.Plan != "free" || quota.UsedBytes != 1000 || quota.FileCount != 1 {
+		t.Errorf("Expected the file to be charged on the free plan, got %+v", quota)
+	}
+
+	if err := s.CheckUserQuota(user.UserID, 11<<30); !errors.Is(err, db.ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+	}
+
+	// the uploaded file is not kept if it does not fit, more than the free plan
+	hugeHash := unique("huge")
+	if _, err := s.SaveNewUserFile(user.UserID, hugeHash, "huge.bin", 11<<30, "/data/"+hugeHash); !errors.Is(err, db.ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+	}
+	if exists, _, err := s.FileExists(hugeHash); err != nil || exists {
+		t.Errorf("Expected the rejected upload not to be saved, got %v, %v", exists, err)
+	}
+	newID, err := s.SaveNewUserFile(user.UserID, unique("upload"), "upload.txt", 10, "/data/"+unique("upload"))
+	if err != nil {
+		t.Fatalf("Failed to save the uploaded file: %v", err)
+	}
+	if exists, _ := s.UserFileExists(user.UserID, newID); !exists {
+		t.Errorf("Expected the uploaded file to be saved for the user")
+	}
+	if _, _, err := s.DeleteUserFile(user.UserID, newID); err != nil {
+		t.Fatalf("Failed to delete the user file: %v", err)
+	}
+
+	if _, _, err := s.DeleteUserFile(user.UserID, fileID); err != nil {
+		t.Fatalf("Failed to delete the user file: %v", err)
+	}
+	if quota, _ := s.GetUserQuota(user.UserID); quota.UsedBytes != 0 || quota.FileCount != 0 {
+		t.Errorf("Expected the quota to be released, got %+v", quota)
+	}
+}
+
+func testDeleteUserCascade(t *testing.T, s Store, unique func(string) string) {
+	alice := newUser(t, s, unique("alice"))
+	bob := newUser(t, s, unique("bob"))
+	ownID := newFile(t, s, unique("own"), 10)
+	sharedID := newFile(t, s, unique("shared"), 10)
+
+	for _, uf := range []struct{ userID, fileID int }{{alice.UserID, ownID}, {alice.UserID, sharedID}, {bob.UserID, sharedID}} {
+		if err := s.SaveUserFile(uf.userID, uf.fileID, "file.txt"); err != nil {
+			t.Fatalf("Failed to save the user file: %v", err)
+		}
+	}
+
+	removedPaths, err := s.DeleteUser(alice.UserID)
+	if err != nil {
+		t.Fatalf("Failed to delete the user: %v", err)
+	}
+	if len(removedPaths) != 1 || removedPaths[0] != "/data/"+unique("own") {
+		t.Errorf("Expected only the own file to be removed, got %v", removedPaths)
+	}
+	if _, err := s.GetUserInfoByID(alice.UserID); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	if _, err := s.GetFileMeta(ownID); !errors.Is(err, db.ErrFileNotFound) {
+		t.Errorf("Expected the own file to be deleted, got %v", err)
+	}
+	if exists, err := s.UserFileExists(bob.UserID, sharedID); err != nil || !exists {
+		t.Errorf("Expected bob to keep the shared file, got %v, %v", exists, err)
+	}
+}
@@ -0,0 +1,183 @@
+package db
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// dialect: the SQL dialect of the database, the queries are written for MySQL with ? placeholders
+// and the dialect provides the parts that differ
+type dialect string
+
+const (
+	dialectMySQL    dialect = "mysql"
+	dialectPostgres dialect = "postgres"
+	dialectSQLite   dialect = "sqlite"
+)
+
+// rebind: replace the ? placeholders with the numbered placeholders of PostgreSQL
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// args: convert the arguments the driver stores differently from MySQL, PostgreSQL stores the flags
+// as SMALLINT and SQLite compares the times as text so they are all stored in UTC
+func (d dialect) args(args []interface{}) []interface{} {
+	if d == dialectMySQL {
+		return args
+	}
+
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case bool:
+			if d == dialectPostgres {
+				arg = 0
+				if v {
+					arg = 1
+				}
+			}
+		case time.Time:
+			if d == dialectSQLite {
+				arg = v.UTC()
+			}
+		case *time.Time:
+			if d == dialectSQLite && v != nil {
+				arg = v.UTC()
+			}
+		}
+		converted[i] = arg
+	}
+	return converted
+}
+
+// greatest: the larger of the two expressions
+func (d dialect) greatest(a string, b string) string {
+	if d == dialectSQLite {
+		return "MAX(" + a + ", " + b + ")" // the scalar MAX with several arguments
+	}
+	return "GREATEST(" + a + ", " + b + ")"
+}
+
+// insertIgnore: the INSERT INTO statement skipping the rows violating a unique key
+func (d dialect) insertIgnore(query string) string {
+	if d == dialectMySQL {
+		return strings.Replace(query, "INSERT INTO", "INSERT IGNORE INTO", 1)
+	}
+	return query + " ON CONFLICT DO NOTHING"
+}
+
+// upsert: the clause updating the existing row with the unique key instead of inserting it,
+// a column alone takes the inserted value and the assignments like "enabled = 0" are kept
+func (d dialect) upsert(key string, columns ...string) string {
+	assignments := make([]string, len(columns))
+	for i, column := range columns {
+		switch {
+		case strings.Contains(column, "="):
+			assignments[i] = column
+		case d == dialectMySQL:
+			assignments[i] = column + " = VALUES(" + column + ")"
+		default:
+			assignments[i] = column + " = excluded." + column
+		}
+	}
+
+	if d == dialectMySQL {
+		return " ON DUPLICATE KEY UPDATE " + strings.Join(assignments, ", ")
+	}
+	return " ON CONFLICT (" + key + ") DO UPDATE SET " + strings.Join(assignments, ", ")
+}
+
+// forUpdate: the clause locking the selected rows of the table until the end of the transaction,
+// SQLite has no row locks, its transactions take the write lock when they begin
+func (d dialect) forUpdate(table string) string {
+	switch d {
+	case dialectSQLite:
+		return ""
+	case dialectPostgres:
+		return " FOR UPDATE OF " + table
+	default:
+		return " FOR UPDATE"
+	}
+}
+
+// nullSafeEqual: the condition comparing the column to the placeholder, true if both are NULL
+func (d dialect) nullSafeEqual(column string) string {
+	switch d {
+	case dialectSQLite:
+		return column + " IS ?"
+	case dialectPostgres:
+		return column + " IS NOT DISTINCT FROM ?"
+	default:
+		return column + " <=> ?"
+	}
+}
+
+// like: the case-insensitive LIKE condition of the column with the pattern escaped by backslashes
+func (d dialect) like(column string) string {
+	switch d {
+	case dialectSQLite:
+		return column + ` LIKE ? ESCAPE '\'`
+	case dialectPostgres:
+		return column + " ILIKE ?"
+	default:
+		return column + " LIKE ?"
+	}
+}
+
+// fullTextMatch: the relevance score and the condition of the full-text search of the column, each takes the keyword,
+// PostgreSQL splits the words without a language and SQLite falls back to a substring search scored 1
+func (d dialect) fullTextMatch(column string) (string, string) {
+	switch d {
+	case dialectSQLite:
+		match := "instr(lower(" + column + "), lower(?)) > 0"
+		return "(" + match + ")", match
+	case dialectPostgres:
+		vector := "to_tsvector('simple', " + column + ")"
+		return "ts_rank(" + vector + ", plainto_tsquery('simple', ?))", vector + " @@ plainto_tsquery('simple', ?)"
+	default:
+		match := "MATCH(" + column + ") AGAINST(? IN NATURAL LANGUAGE MODE)"
+		return match, match
+	}
+}
+
+// isDuplicateKey: check if the error is the violation of a unique key
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	var pgErr *pgconn.PgError
+	var sqliteErr *sqlite.Error
+	switch {
+	case errors.As(err, &mysqlErr):
+		return mysqlErr.Number == 1062
+	case errors.As(err, &pgErr):
+		return pgErr.Code == "23505" // unique_violation
+	case errors.As(err, &sqliteErr):
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+	}
+	return false
+}
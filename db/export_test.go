@@ -0,0 +1,7 @@
+package db
+
+// ExecScript: run a multi-statement sql script, used by the tests to change the database behind the migrations
+func (d *DB) ExecScript(script string) error {
+	_, err := d.db.DB.Exec(script)
+	return err
+}
@@ -8,33 +8,31 @@ import (
 )
 
 // SaveFileMeta: save the file metadata to the database
-func SaveFileMeta(fileHash string, fileName string, fileSize int64, filePath string) (int, error) {
+func (d *DB) SaveFileMeta(fileHash string, fileName string, fileSize int64, filePath string) (int, error) {
+	return saveFileMeta(d.db, d.db.dialect, fileHash, fileName, fileSize, filePath)
+}
+
+// saveFileMeta: save the file metadata with the connection or in the transaction,
+// returns ErrFileExists if a file with the same hash is stored
+func saveFileMeta(e execer, d dialect, fileHash string, fileName string, fileSize int64, filePath string) (int, error) {
 	query := "INSERT INTO tbl_file (file_hash, file_name, file_size, file_path) VALUES (?, ?, ?, ?)"
 
-	stmt, err := db.Prepare(query)
-	if err != nil {
-		return 0, fmt.Errorf("failed to prepare the query: %v", err.Error())
+	fileID, err := insertID(e, d, query, []interface{}{fileHash, fileName, fileSize, filePath})
+	if isDuplicateKey(err) {
+		return 0, ErrFileExists
 	}
-	defer stmt.Close()
-
-	result, err := stmt.Exec(fileHash, fileName, fileSize, filePath)
 	if err != nil {
 		return 0, fmt.Errorf("failed to execute the query: %v", err.Error())
 	}
 
-	fileID, err := result.LastInsertId()
-	if err != nil {
-		return 0, fmt.Errorf("failed to get the last insert id: %v", err.Error())
-	}
-
 	return int(fileID), nil
 }
 
 // GetFileMeta: get the file metadata from the database
-func GetFileMeta(fileID int) (*models.FileMeta, error) {
+func (d *DB) GetFileMeta(fileID int) (*models.FileMeta, error) {
 	query := "SELECT file_hash, file_name, file_size, file_path, create_at, update_at, status FROM tbl_file WHERE id = ?"
 
-	stmt, err := db.Prepare(query)
+	stmt, err := d.db.Prepare(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare the query: %v", err.Error())
 	}
@@ -44,6 +42,9 @@ func GetFileMeta(fileID int) (*models.FileMeta, error) {
 		FileID: fileID,
 	}
 	err = stmt.QueryRow(fileID).Scan(&fileMeta.FileHash, &fileMeta.FileName, &fileMeta.FileSize, &fileMeta.FilePath, &fileMeta.CreateAt, &fileMeta.UpdateAt, &fileMeta.Status)
+	if err == sql.ErrNoRows {
+		return nil, ErrFileNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
 	}
@@ -51,10 +52,10 @@ func GetFileMeta(fileID int) (*models.FileMeta, error) {
 }
 
 // UpdateFileMeta: update the file metadata in the database
-func UpdateFileMeta(fileID int, updateReq models.UpdateFileMetaRequest) error {
+func (d *DB) UpdateFileMeta(fileID int, updateReq models.UpdateFileMetaRequest) error {
 	query := "UPDATE tbl_file SET file_name = ?, status = ? WHERE id = ?"
 
-	stmt, err := db.Prepare(query)
+	stmt, err := d.db.Prepare(query)
 	if err != nil {
 		return fmt.Errorf("failed to prepare the query: %v", err.Error())
 	}
@@ -69,10 +70,10 @@ func UpdateFileMeta(fileID int, updateReq models.UpdateFileMetaRequest) error {
 }
 
 // FileExists: check if the file exists in the tbl_file
-func FileExists(fileHash string) (bool, int, error) {
+func (d *DB) FileExists(fileHash string) (bool, int, error) {
 	query := "SELECT id FROM tbl_file WHERE file_hash = ?"
 
-	stmt, err := db.Prepare(query)
+	stmt, err := d.db.Prepare(query)
 	if err != nil {
 		return false, 0, fmt.Errorf("failed to prepare the query: %v", err.Error())
 	}
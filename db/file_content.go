@@ -0,0 +1,51 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/bladewaltz9/file-store-server/models"
+)
+
+// SaveFileContent: save the extracted text of the file to the full-text index
+func (d *DB) SaveFileContent(fileID int, content string) error {
+	query := "INSERT INTO tbl_file_content (file_id, content) VALUES (?, ?)" + d.db.dialect.upsert("file_id", "content")
+
+	stmt, err := d.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("failed to prepare the query: %v", err.Error())
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(fileID, content); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
+// SearchUserFiles: search the content of the files owned by the user, ordered by relevance
+func (d *DB) SearchUserFiles(userID int, keyword string, limit int) ([]models.SearchHit, error) {
+	score, match := d.db.dialect.fullTextMatch("c.content")
+	query := `SELECT f.id, uf.file_name, f.file_size, c.content, ` + score + ` AS score
+	FROM tbl_file_content c
+	JOIN tbl_user_file uf ON uf.file_id = c.file_id
+	JOIN tbl_file f ON f.id = c.file_id
+	WHERE uf.user_id = ? AND uf.status = 'active' AND ` + match + `
+	ORDER BY score DESC
+	LIMIT ?`
+
+	rows, err := d.db.Query(query, keyword, userID, keyword, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	defer rows.Close()
+
+	var hits []models.SearchHit
+	for rows.Next() {
+		hit := models.SearchHit{}
+		if err := rows.Scan(&hit.FileID, &hit.FileName, &hit.FileSize, &hit.Content, &hit.Score); err != nil {
+			return nil, fmt.Errorf("failed to scan the row: %v", err.Error())
+		}
+		hits = append(hits, hit)
+	}
+	return hits, nil
+}
@@ -0,0 +1,275 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/models"
+)
+
+// ErrTooManyTags: the user file would have more than config.MaxTagsPerFile tags
+var ErrTooManyTags = errors.New("too many tags")
+
+// getUserFileID: get the id of the user file relationship in the transaction
+func getUserFileID(tx *txConn, userID int, fileID int) (int, error) {
+	var userFileID int
+	err := tx.QueryRow("SELECT id FROM tbl_user_file WHERE user_id = ? AND file_id = ?", userID, fileID).Scan(&userFileID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("file %d does not belong to the user", fileID)
+		}
+		return 0, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return userFileID, nil
+}
+
+// SetUserFileTags: replace the tags of the user file
+func (d *DB) SetUserFileTags(userID int, fileID int, tags []string) error {
+	// Begin the transaction
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin the transaction: %v", err.Error())
+	}
+	defer tx.Rollback()
+
+	userFileID, err := getUserFileID(tx, userID, fileID)
+	if err != nil {
+		return err
+	}
+
+	// Delete the old tags
+	if _, err := tx.Exec("DELETE FROM tbl_user_file_tag WHERE user_file_id = ?", userFileID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+
+	// Insert the new tags
+	if err := insertTags(tx, userFileID, userID, tags); err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+	return nil
+}
+
+// BulkEditTags: add and remove tags on several user files at once, fails with ErrTooManyTags
+// if a file would end up with more than config.MaxTagsPerFile tags
+func (d *DB) BulkEditTags(userID int, fileIDs []int, add []string, remove []string) error {
+	// Begin the transaction
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin the transaction: %v", err.Error())
+	}
+	defer tx.Rollback()
+
+	for _, fileID := range fileIDs {
+		userFileID, err := getUserFileID(tx, userID, fileID)
+		if err != nil {
+			return err
+		}
+
+		// Remove the tags
+		for _, tag := range remove {
+			if _, err := tx.Exec("DELETE FROM tbl_user_file_tag WHERE user_file_id = ? AND tag = ?", userFileID, tag); err != nil {
+				return fmt.Errorf("failed to execute the query: %v", err.Error())
+			}
+		}
+
+		// Add the tags
+		if err := insertTags(tx, userFileID, userID, add); err != nil {
+			return err
+		}
+		if len(add) > 0 {
+			var count int
+			if err := tx.QueryRow("SELECT COUNT(*) FROM tbl_user_file_tag WHERE user_file_id = ?", userFileID).Scan(&count); err != nil {
+				return fmt.Errorf("failed to execute the query: %v", err.Error())
+			}
+			if count > config.MaxTagsPerFile {
+				return fmt.Errorf("%w: file %d would have %d tags, at most %d", ErrTooManyTags, fileID, count, config.MaxTagsPerFile)
+			}
+		}
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+	return nil
+}
+
+// insertTags: insert the tags of the user file, existing tags are ignored
+func insertTags(tx *txConn, userFileID int, userID int, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.Prepare(tx.dialect.insertIgnore("INSERT INTO tbl_user_file_tag (user_file_id, user_id, tag) VALUES (?, ?, ?)"))
+	if err != nil {
+		return fmt.Errorf("failed to prepare the query: %v", err.Error())
+	}
+	defer stmt.Close()
+
+	for _, tag := range tags {
+		if _, err := stmt.Exec(userFileID, userID, tag); err != nil {
+			return fmt.Errorf("failed to execute the query: %v", err.Error())
+		}
+	}
+	return nil
+}
+
+// SetUserFileMetadata: replace the key-value metadata of the user file
+func (d *DB) SetUserFileMetadata(userID int, fileID int, metadata map[string]string) error {
+	// Begin the transaction
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin the transaction: %v", err.Error())
+	}
+	defer tx.Rollback()
+
+	userFileID, err := getUserFileID(tx, userID, fileID)
+	if err != nil {
+		return err
+	}
+
+	// Delete the old metadata
+	if _, err := tx.Exec("DELETE FROM tbl_user_file_meta WHERE user_file_id = ?", userFileID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+
+	// Insert the new metadata
+	if len(metadata) > 0 {
+		stmt, err := tx.Prepare("INSERT INTO tbl_user_file_meta (user_file_id, meta_key, meta_value) VALUES (?, ?, ?)")
+		if err != nil {
+			return fmt.Errorf("failed to prepare the query: %v", err.Error())
+		}
+		defer stmt.Close()
+
+		for key, value := range metadata {
+			if _, err := stmt.Exec(userFileID, key, value); err != nil {
+				return fmt.Errorf("failed to execute the query: %v", err.Error())
+			}
+		}
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+	return nil
+}
+
+// GetUserFileLabels: get the tags and metadata of the user file
+func (d *DB) GetUserFileLabels(userID int, fileID int) ([]string, map[string]string, error) {
+	files := []models.FileInfo{{FileID: fileID}}
+	if err := d.LoadUserFileLabels(userID, files); err != nil {
+		return nil, nil, err
+	}
+	return files[0].Tags, files[0].Metadata, nil
+}
+
+// LoadUserFileLabels: fill the tags and metadata of the user files
+func (d *DB) LoadUserFileLabels(userID int, files []models.FileInfo) error {
+	if len(files) == 0 {
+		return nil
+	}
+	index := make(map[int]*models.FileInfo, len(files))
+	for i := range files {
+		index[files[i].FileID] = &files[i]
+	}
+
+	// Load the tags
+	queryTags := `SELECT uf.file_id, t.tag
+	FROM tbl_user_file_tag t
+	JOIN tbl_user_file uf ON uf.id = t.user_file_id
+	WHERE uf.user_id = ?
+	ORDER BY t.tag`
+	rows, err := d.db.Query(queryTags, userID)
+	if err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var fileID int
+		var tag string
+		if err := rows.Scan(&fileID, &tag); err != nil {
+			return fmt.Errorf("failed to scan the row: %v", err.Error())
+		}
+		if file, ok := index[fileID]; ok {
+			file.Tags = append(file.Tags, tag)
+		}
+	}
+
+	// Load the metadata
+	queryMeta := `SELECT uf.file_id, m.meta_key, m.meta_value
+	FROM tbl_user_file_meta m
+	JOIN tbl_user_file uf ON uf.id = m.user_file_id
+	WHERE uf.user_id = ?`
+	metaRows, err := d.db.Query(queryMeta, userID)
+	if err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	defer metaRows.Close()
+
+	for metaRows.Next() {
+		var fileID int
+		var key, value string
+		if err := metaRows.Scan(&fileID, &key, &value); err != nil {
+			return fmt.Errorf("failed to scan the row: %v", err.Error())
+		}
+		if file, ok := index[fileID]; ok {
+			if file.Metadata == nil {
+				file.Metadata = make(map[string]string)
+			}
+			file.Metadata[key] = value
+		}
+	}
+	return nil
+}
+
+// QueryUserFiles: get the user files having all the tags and matching all the metadata
+func (d *DB) QueryUserFiles(userID int, tags []string, metadata map[string]string) ([]models.FileInfo, error) {
+	var conditions []string
+	args := []interface{}{userID}
+	for _, tag := range tags {
+		conditions = append(conditions, "uf.id IN (SELECT user_file_id FROM tbl_user_file_tag WHERE user_id = ? AND tag = ?)")
+		args = append(args, userID, tag)
+	}
+	for key, value := range metadata {
+		conditions = append(conditions, "uf.id IN (SELECT user_file_id FROM tbl_user_file_meta WHERE meta_key = ? AND meta_value = ?)")
+		args = append(args, key, value)
+	}
+
+	query := `SELECT f.id, uf.file_name, f.file_size, uf.upload_at, uf.status
+	FROM tbl_user_file uf
+	JOIN tbl_file f ON uf.file_id = f.id
+	WHERE uf.user_id = ?`
+	if len(conditions) > 0 {
+		query += " AND " + strings.Join(conditions, " AND ")
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	defer rows.Close()
+
+	var userFiles []models.FileInfo
+	for rows.Next() {
+		file, err := scanFileInfo(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan the row: %v", err.Error())
+		}
+		userFiles = append(userFiles, file)
+	}
+
+	if err := d.LoadUserFileLabels(userID, userFiles); err != nil {
+		return nil, err
+	}
+	return userFiles, nil
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/bladewaltz9/file-store-server/models"
+)
+
+// ownerCondition: the condition to select the rows owned by the team if teamID is set, by the user otherwise
+func ownerCondition(alias string, userID int, teamID int) (string, interface{}) {
+	if teamID > 0 {
+		return alias + ".team_id = ?", teamID
+	}
+	return alias + ".user_id = ?", userID
+}
+
+// nullableID: convert the id to NULL if it is 0
+func nullableID(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// CreateFolder: create the folder owned by the user or the team
+func (d *DB) CreateFolder(folder *models.Folder) (int, error) {
+	query := "INSERT INTO tbl_folder (name, parent_id, user_id, team_id) VALUES (?, ?, ?, ?)"
+
+	folderID, err := d.db.insert(query, folder.Name, nullableID(folder.ParentID), nullableID(folder.UserID), nullableID(folder.TeamID))
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return int(folderID), nil
+}
+
+// GetFolder: get the folder by its id
+func (d *DB) GetFolder(folderID int) (*models.Folder, error) {
+	query := "SELECT id, name, parent_id, user_id, team_id, create_at FROM tbl_folder WHERE id = ?"
+
+	folder := &models.Folder{}
+	var parentID, userID, teamID sql.NullInt64
+	err := d.db.QueryRow(query, folderID).Scan(&folder.FolderID, &folder.Name, &parentID, &userID, &teamID, &folder.CreateAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	folder.ParentID = int(parentID.Int64)
+	folder.UserID = int(userID.Int64)
+	folder.TeamID = int(teamID.Int64)
+	return folder, nil
+}
+
+// GetFolderContent: get the sub folders and files of the folder owned by the user or the team, folder 0 is the root
+func (d *DB) GetFolderContent(userID int, teamID int, folderID int) (*models.FolderContent, error) {
+	content := &models.FolderContent{
+		Folders: []models.Folder{},
+		Files:   []models.FileInfo{},
+	}
+
+	// Get the sub folders
+	condition, ownerID := ownerCondition("f", userID, teamID)
+	queryFolders := "SELECT f.id, f.name, f.create_at FROM tbl_folder f WHERE " + condition + " AND " +
+		d.db.dialect.nullSafeEqual("f.parent_id") + " ORDER BY f.name"
+	rows, err := d.db.Query(queryFolders, ownerID, nullableID(folderID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		folder := models.Folder{ParentID: folderID, TeamID: teamID}
+		if teamID == 0 {
+			folder.UserID = userID
+		}
+		if err := rows.Scan(&folder.FolderID, &folder.Name, &folder.CreateAt); err != nil {
+			return nil, fmt.Errorf("failed to scan the row: %v", err.Error())
+		}
+		content.Folders = append(content.Folders, folder)
+	}
+
+	// Get the files
+	condition, ownerID = ownerCondition("uf", userID, teamID)
+	queryFiles := `SELECT f.id, uf.file_name, f.file_size, uf.upload_at, uf.status
+	FROM tbl_user_file uf
+	JOIN tbl_file f ON uf.file_id = f.id
+	WHERE ` + condition + ` AND ` + d.db.dialect.nullSafeEqual("uf.folder_id") + `
+	ORDER BY uf.file_name`
+	fileRows, err := d.db.Query(queryFiles, ownerID, nullableID(folderID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	defer fileRows.Close()
+
+	for fileRows.Next() {
+		file, err := scanFileInfo(fileRows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan the row: %v", err.Error())
+		}
+		content.Files = append(content.Files, file)
+	}
+	return content, nil
+}
+
+// DeleteFolder: delete the folder with its sub folders, the files inside are moved to the root
+func (d *DB) DeleteFolder(folderID int) error {
+	if _, err := d.db.Exec("DELETE FROM tbl_folder WHERE id = ?", folderID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
+// MoveFile: move the file of the user or the team into the folder, folder 0 is the root
+func (d *DB) MoveFile(userID int, teamID int, fileID int, folderID int) error {
+	condition, ownerID := ownerCondition("tbl_user_file", userID, teamID)
+	query := "UPDATE tbl_user_file SET folder_id = ? WHERE " + condition + " AND file_id = ?"
+
+	if _, err := d.db.Exec(query, nullableID(folderID), ownerID, fileID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,458 @@
+// Package memory implements the repositories of the db package in memory, for the tests
+// of the handlers and the services that do not need a database.
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/db"
+	"github.com/bladewaltz9/file-store-server/models"
+)
+
+// plan: the limits of the default plan of the new users, the same as the free plan of tbl_plan
+var plan = struct {
+	name     string
+	maxBytes int64
+	maxFiles int
+}{"free", 10 << 30, 10000}
+
+// userFile: a file of a user, a reference to the stored file
+type userFile struct {
+	fileID   int
+	fileName string
+	uploadAt time.Time
+	status   string
+}
+
+// file: a stored file with the count of its references
+type file struct {
+	meta           models.FileMeta
+	referenceCount int
+}
+
+// Store: the users and the files in memory, safe for concurrent use
+type Store struct {
+	mu        sync.Mutex
+	nextUser  int
+	nextFile  int
+	users     map[int]*models.UserInfo
+	files     map[int]*file
+	userFiles map[int][]*userFile // by the user id, in the upload order
+	quotas    map[int]*models.UserQuota
+}
+
+var (
+	_ db.UserRepository = (*Store)(nil)
+	_ db.FileRepository = (*Store)(nil)
+)
+
+// New: create an empty store
+func New() *Store {
+	return &Store{
+		users:     make(map[int]*models.UserInfo),
+		files:     make(map[int]*file),
+		userFiles: make(map[int][]*userFile),
+		quotas:    make(map[int]*models.UserQuota),
+	}
+}
+
+// SaveUserInfo: save the user information, returns db.ErrUserExists if the username is taken
+func (s *Store) SaveUserInfo(username string, password string, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Username == username {
+			return db.ErrUserExists
+		}
+	}
+	s.nextUser++
+	now := time.Now()
+	s.users[s.nextUser] = &models.UserInfo{
+		UserID:     s.nextUser,
+		Username:   username,
+		Password:   password,
+		Email:      email,
+		Role:       models.UserRoleUser,
+		Status:     models.UserStatusActive,
+		SignupAt:   now,
+		LastActive: now,
+	}
+	s.quotas[s.nextUser] = &models.UserQuota{Plan: plan.name, MaxBytes: plan.maxBytes, MaxFiles: plan.maxFiles}
+	return nil
+}
+
+// GetUserInfoByUsername: get the user information by the username
+func (s *Store) GetUserInfoByUsername(username string) (*models.UserInfo, error) {
+	return s.findUser(func(user *models.UserInfo) bool { return user.Username == username })
+}
+
+// GetUserInfoByID: get the user information by the user id
+func (s *Store) GetUserInfoByID(userID int) (*models.UserInfo, error) {
+	return s.findUser(func(user *models.UserInfo) bool { return user.UserID == userID })
+}
+
+// GetUserInfoByEmail: get the oldest user with the email
+func (s *Store) GetUserInfoByEmail(email string) (*models.UserInfo, error) {
+	return s.findUser(func(user *models.UserInfo) bool { return user.Email == email })
+}
+
+// findUser: get a copy of the user with the lowest id matching the condition
+func (s *Store) findUser(match func(user *models.UserInfo) bool) (*models.UserInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found *models.UserInfo
+	for _, user := range s.users {
+		if match(user) && (found == nil || user.UserID < found.UserID) {
+			found = user
+		}
+	}
+	if found == nil {
+		return nil, db.ErrUserNotFound
+	}
+	user := *found
+	if found.LockedUntil != nil {
+		until := *found.LockedUntil
+		user.LockedUntil = &until
+	}
+	return &user, nil
+}
+
+// updateUser: apply the update to the user if it exists, like an UPDATE matching no row
+func (s *Store) updateUser(userID int, update func(user *models.UserInfo)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user, ok := s.users[userID]; ok {
+		update(user)
+	}
+}
+
+// SetEmailValidated: mark the email of the user as verified if it is still the email the token was mailed to
+func (s *Store) SetEmailValidated(userID int, email string) error {
+	s.updateUser(userID, func(user *models.UserInfo) {
+		if user.Email == email {
+			user.EmailValidated = true
+		}
+	})
+	return nil
+}
+
+// UpdateUserEmail: set the verified new email of the user
+func (s *Store) UpdateUserEmail(userID int, email string) error {
+	s.updateUser(userID, func(user *models.UserInfo) {
+		user.Email = email
+		user.EmailValidated = true
+	})
+	return nil
+}
+
+// EmailInUse: check if another user already has the email
+func (s *Store) EmailInUse(email string, userID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Email == email && user.UserID != userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// UpdateUserProfile: update the phone and the profile of the user, the nil fields are unchanged,
+// the phone needs to be verified again once it changes
+func (s *Store) UpdateUserProfile(userID int, phone *string, profile *models.UserProfile) error {
+	s.updateUser(userID, func(user *models.UserInfo) {
+		if phone != nil {
+			if user.Phone != *phone {
+				user.PhoneValidated = false
+			}
+			user.Phone = *phone
+		}
+		if profile != nil {
+			user.Profile = *profile
+		}
+	})
+	return nil
+}
+
+// UpdateUserPassword: update the encoded password of the user
+func (s *Store) UpdateUserPassword(userID int, encodedPwd string) error {
+	s.updateUser(userID, func(user *models.UserInfo) { user.Password = encodedPwd })
+	return nil
+}
+
+// LockUser: lock the account until the time, or until an admin unlocks it if until is nil
+func (s *Store) LockUser(userID int, until *time.Time) error {
+	s.updateUser(userID, func(user *models.UserInfo) {
+		user.Status = models.UserStatusLocked
+		user.LockedUntil = nil
+		if until != nil {
+			lockedUntil := *until
+			user.LockedUntil = &lockedUntil
+		}
+	})
+	return nil
+}
+
+// UnlockUser: unlock the locked account, returns false if the account is not locked
+func (s *Store) UnlockUser(userID int) (bool, error) {
+	unlocked := false
+	s.updateUser(userID, func(user *models.UserInfo) {
+		if user.Status == models.UserStatusLocked {
+			user.Status = models.UserStatusActive
+			user.LockedUntil = nil
+			unlocked = true
+		}
+	})
+	return unlocked, nil
+}
+
+// DeleteUser: delete the account with its files, returns the paths of the files no one references anymore
+func (s *Store) DeleteUser(userID int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removedPaths []string
+	for _, uf := range s.userFiles[userID] {
+		if released, filePath := s.releaseFile(uf.fileID); released {
+			removedPaths = append(removedPaths, filePath)
+		}
+	}
+	delete(s.userFiles, userID)
+	delete(s.quotas, userID)
+	delete(s.users, userID)
+	return removedPaths, nil
+}
+
+// SaveFileMeta: save the file metadata, returns db.ErrFileExists if a file with the same hash is stored
+func (s *Store) SaveFileMeta(fileHash string, fileName string, fileSize int64, filePath string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, f := range s.files {
+		if f.meta.FileHash == fileHash {
+			return 0, db.ErrFileExists
+		}
+	}
+	s.nextFile++
+	now := time.Now()
+	s.files[s.nextFile] = &file{meta: models.FileMeta{
+		FileID:   s.nextFile,
+		FileHash: fileHash,
+		FileName: fileName,
+		FileSize: fileSize,
+		FilePath: filePath,
+		CreateAt: now,
+		UpdateAt: now,
+		Status:   "active",
+	}}
+	return s.nextFile, nil
+}
+
+// GetFileMeta: get the file metadata, returns db.ErrFileNotFound if there is no such file
+func (s *Store) GetFileMeta(fileID int) (*models.FileMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.files[fileID]
+	if !ok {
+		return nil, db.ErrFileNotFound
+	}
+	meta := f.meta
+	return &meta, nil
+}
+
+// UpdateFileMeta: update the name and the status of the file
+func (s *Store) UpdateFileMeta(fileID int, updateReq models.UpdateFileMetaRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f, ok := s.files[fileID]; ok {
+		f.meta.FileName = updateReq.FileName
+		f.meta.Status = updateReq.Status
+		f.meta.UpdateAt = time.Now()
+	}
+	return nil
+}
+
+// FileExists: check if a file with the hash is stored
+func (s *Store) FileExists(fileHash string) (bool, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, f := range s.files {
+		if f.meta.FileHash == fileHash {
+			return true, id, nil
+		}
+	}
+	return false, 0, nil
+}
+
+// GetUserFiles: get the files of the user
+func (s *Store) GetUserFiles(userID int) ([]models.FileInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var userFiles []models.FileInfo
+	for _, uf := range s.userFiles[userID] {
+		f := s.files[uf.fileID]
+		userFiles = append(userFiles, models.FileInfo{
+			FileID:     uf.fileID,
+			FileName:   uf.fileName,
+			FileSize:   f.meta.FileSize,
+			UploadTime: uf.uploadAt.Format("2006-01-02 15:04"),
+			Status:     uf.status,
+		})
+	}
+	return userFiles, nil
+}
+
+// UserFileExists: check if the user has the file
+func (s *Store) UserFileExists(userID int, fileID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.findUserFile(userID, fileID) >= 0, nil
+}
+
+// findUserFile: the index of the file in the files of the user, -1 if the user does not have it
+func (s *Store) findUserFile(userID int, fileID int) int {
+	for i, uf := range s.userFiles[userID] {
+		if uf.fileID == fileID {
+			return i
+		}
+	}
+	return -1
+}
+
+// SaveUserFile: add the file to the files of the user and charge the quota of the user,
+// returns db.ErrQuotaExceeded if the file does not fit and db.ErrUserFileExists if the user has the file
+func (s *Store) SaveUserFile(userID int, fileID int, fileName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.files[fileID]
+	if !ok {
+		return db.ErrFileNotFound
+	}
+	quota, ok := s.quotas[userID]
+	if !ok {
+		return db.ErrUserNotFound
+	}
+	if s.findUserFile(userID, fileID) >= 0 {
+		return db.ErrUserFileExists
+	}
+	if !quota.Allows(f.meta.FileSize) {
+		return db.ErrQuotaExceeded
+	}
+
+	quota.UsedBytes += f.meta.FileSize
+	quota.FileCount++
+	f.referenceCount++
+	s.userFiles[userID] = append(s.userFiles[userID], &userFile{
+		fileID:   fileID,
+		fileName: fileName,
+		uploadAt: time.Now(),
+		status:   "active",
+	})
+	return nil
+}
+
+// SaveNewUserFile: save the uploaded file and add it to the files of the user, neither is saved
+// if the file does not fit the quota, returns db.ErrFileExists if a file with the same hash is stored
+func (s *Store) SaveNewUserFile(userID int, fileHash string, fileName string, fileSize int64, filePath string) (int, error) {
+	fileID, err := s.SaveFileMeta(fileHash, fileName, fileSize, filePath)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.SaveUserFile(userID, fileID, fileName); err != nil {
+		s.mu.Lock()
+		delete(s.files, fileID)
+		s.mu.Unlock()
+		return 0, err
+	}
+	return fileID, nil
+}
+
+// DeleteUserFile: remove the file from the files of the user and delete the file with its last reference,
+// returns true and the path of the file if it is deleted
+func (s *Store) DeleteUserFile(userID int, fileID int) (bool, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.findUserFile(userID, fileID)
+	if i < 0 {
+		// like the MySQL implementation, the reference count of the file is released anyway
+		released, filePath := s.releaseFile(fileID)
+		return released, filePath, nil
+	}
+	files := s.userFiles[userID]
+	s.userFiles[userID] = append(files[:i:i], files[i+1:]...)
+	s.releaseQuota(userID, s.files[fileID].meta.FileSize)
+
+	released, filePath := s.releaseFile(fileID)
+	return released, filePath, nil
+}
+
+// releaseFile: decrease the reference count of the file and delete it if the reference count is 0
+func (s *Store) releaseFile(fileID int) (bool, string) {
+	f, ok := s.files[fileID]
+	if !ok {
+		return false, ""
+	}
+	if f.referenceCount > 0 {
+		f.referenceCount--
+	}
+	if f.referenceCount > 0 {
+		return false, f.meta.FilePath
+	}
+	delete(s.files, fileID)
+	return true, f.meta.FilePath
+}
+
+// releaseQuota: remove a file of the size from the usage of the user
+func (s *Store) releaseQuota(userID int, size int64) {
+	if quota, ok := s.quotas[userID]; ok {
+		quota.UsedBytes = max(quota.UsedBytes-size, 0)
+		quota.FileCount = max(quota.FileCount-1, 0)
+	}
+}
+
+// GetUserQuota: get the storage quota and usage of the user
+func (s *Store) GetUserQuota(userID int) (*models.UserQuota, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	quota, ok := s.quotas[userID]
+	if !ok {
+		return nil, db.ErrUserNotFound
+	}
+	q := *quota
+	return &q, nil
+}
+
+// SetUserQuota: set the limits of the quota of the user, e.g. a quota the test files do not fit
+func (s *Store) SetUserQuota(userID int, maxBytes int64, maxFiles int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if quota, ok := s.quotas[userID]; ok {
+		quota.MaxBytes, quota.MaxFiles = maxBytes, maxFiles
+	}
+}
+
+// CheckUserQuota: check if the user can store another file of the size, returns db.ErrQuotaExceeded if not
+func (s *Store) CheckUserQuota(userID int, size int64) error {
+	quota, err := s.GetUserQuota(userID)
+	if err != nil {
+		return err
+	}
+	if !quota.Allows(size) {
+		return db.ErrQuotaExceeded
+	}
+	return nil
+}
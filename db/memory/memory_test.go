@@ -0,0 +1,12 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/bladewaltz9/file-store-server/db/dbtest"
+	"github.com/bladewaltz9/file-store-server/db/memory"
+)
+
+func TestContract(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) dbtest.Store { return memory.New() })
+}
@@ -0,0 +1,393 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFS: the migrations of every dialect, named migrations/<dialect>/<version>_<name>.<up|down>.sql
+//
+//go:embed migrations
+var migrationFS embed.FS
+
+var (
+	// ErrSchemaTooNew: the database was migrated by a newer server, this one does not know its schema
+	ErrSchemaTooNew = errors.New("the database schema is newer than the server")
+	// ErrSchemaOutdated: the database has pending migrations and they are not applied automatically
+	ErrSchemaOutdated = errors.New("the database schema is outdated")
+)
+
+const (
+	// migrationLockName and migrationLockID: the MySQL named lock and the PostgreSQL advisory lock
+	// held while migrating, so the replicas starting together apply the migrations once
+	migrationLockName = "file_store_migrate"
+	migrationLockID   = 4658431926871
+
+	// migrationLockTimeout: how long a replica waits for the one migrating
+	migrationLockTimeout = time.Minute
+)
+
+var migrationFileRegexp = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migration: a numbered change of the schema and the script reverting it
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+func (m migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.version, m.name)
+}
+
+// loadMigrations: read the migrations of the dialect in the order of their versions,
+// every version has both scripts and the versions have no gaps
+func loadMigrations(d dialect) ([]migration, error) {
+	dir := "migrations/" + string(d)
+	entries, err := fs.ReadDir(migrationFS, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the migrations: %v", err.Error())
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		match := migrationFileRegexp.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		script, err := fs.ReadFile(migrationFS, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the migration: %v", err.Error())
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: match[2]}
+			byVersion[version] = m
+		} else if m.name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, m.name, match[2])
+		}
+		if match[3] == "up" {
+			m.up = string(script)
+		} else {
+			m.down = string(script)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %s needs both an up and a down script", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration %s is out of sequence, expected version %d", m, i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrate: apply the pending migrations and return the applied ones, returns ErrSchemaTooNew
+// if the database was migrated by a newer server
+func (d *DB) Migrate() ([]string, error) {
+	migrations, err := loadMigrations(d.db.dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []string
+	err = d.withMigrationLock(func(m *migrator) error {
+		current, err := m.baseline(migrations)
+		if err != nil {
+			return err
+		}
+		if current > len(migrations) {
+			return fmt.Errorf("%w: version %d, the server knows up to %d", ErrSchemaTooNew, current, len(migrations))
+		}
+		for _, next := range migrations[current:] {
+			if err := m.apply(next, true); err != nil {
+				return err
+			}
+			applied = append(applied, next.String())
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown: revert the last steps migrations and return the reverted ones
+func (d *DB) MigrateDown(steps int) ([]string, error) {
+	migrations, err := loadMigrations(d.db.dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []string
+	err = d.withMigrationLock(func(m *migrator) error {
+		current, err := m.baseline(migrations)
+		if err != nil {
+			return err
+		}
+		if current > len(migrations) {
+			return fmt.Errorf("%w: version %d, the server knows up to %d", ErrSchemaTooNew, current, len(migrations))
+		}
+		for i := current; i > 0 && i > current-steps; i-- {
+			if err := m.apply(migrations[i-1], false); err != nil {
+				return err
+			}
+			reverted = append(reverted, migrations[i-1].String())
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// SchemaVersion: the version of the database and the latest version known by the server
+func (d *DB) SchemaVersion() (int, int, error) {
+	migrations, err := loadMigrations(d.db.dialect)
+	if err != nil {
+		return 0, 0, err
+	}
+	m := &migrator{ctx: context.Background(), e: d.db.DB, dialect: d.db.dialect}
+	current, _, err := m.version()
+	return current, len(migrations), err
+}
+
+// CheckSchema: check the database is at the latest version without migrating it,
+// returns ErrSchemaTooNew or ErrSchemaOutdated otherwise
+func (d *DB) CheckSchema() error {
+	current, latest, err := d.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > latest {
+		return fmt.Errorf("%w: version %d, the server knows up to %d", ErrSchemaTooNew, current, latest)
+	}
+	if current < latest {
+		return fmt.Errorf("%w: version %d, the latest is %d", ErrSchemaOutdated, current, latest)
+	}
+	return nil
+}
+
+// withMigrationLock: run fn on a single connection holding the migration lock. PostgreSQL and SQLite
+// run the migrations in a transaction, which takes the lock, MySQL commits every DDL statement
+// and takes a named lock instead
+func (d *DB) withMigrationLock(fn func(m *migrator) error) error {
+	ctx := context.Background()
+	c, err := d.db.DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get a connection: %v", err.Error())
+	}
+	defer c.Close()
+
+	if d.db.dialect == dialectMySQL {
+		var locked sql.NullInt64
+		err := c.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLockName, int(migrationLockTimeout.Seconds())).Scan(&locked)
+		if err != nil {
+			return fmt.Errorf("failed to get the migration lock: %v", err.Error())
+		}
+		if locked.Int64 != 1 {
+			return errors.New("timed out waiting for the migration lock")
+		}
+		defer c.QueryRowContext(ctx, "SELECT RELEASE_LOCK(?)", migrationLockName).Scan(&locked)
+		return fn(&migrator{ctx: ctx, e: c, dialect: d.db.dialect})
+	}
+
+	tx, err := c.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err.Error())
+	}
+	defer tx.Rollback()
+	if d.db.dialect == dialectPostgres {
+		lockTimeout := fmt.Sprintf("SET LOCAL lock_timeout = '%ds'", int(migrationLockTimeout.Seconds()))
+		if _, err := tx.ExecContext(ctx, lockTimeout); err != nil {
+			return fmt.Errorf("failed to set the lock timeout: %v", err.Error())
+		}
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockID); err != nil {
+			return fmt.Errorf("failed to get the migration lock: %v", err.Error())
+		}
+	}
+	if err := fn(&migrator{ctx: ctx, e: tx, dialect: d.db.dialect}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit the migrations: %v", err.Error())
+	}
+	return nil
+}
+
+// migrator: runs the migrations on the connection or the transaction holding the migration lock
+type migrator struct {
+	ctx     context.Context
+	e       sqlExecer
+	dialect dialect
+}
+
+// sqlExecer: the common methods of *sql.DB, *sql.Conn and *sql.Tx
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// version: the latest applied migration and whether it is recorded, the databases created by hand
+// have no migrations table and are at version 1 with the original doc/table.sql, or at version 2
+// with the tables added to it before the migrations
+func (m *migrator) version() (int, bool, error) {
+	exists, err := m.tableExists("schema_migrations")
+	if err != nil {
+		return 0, false, err
+	}
+	if exists {
+		var version int
+		if err := m.e.QueryRowContext(m.ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+			return 0, false, fmt.Errorf("failed to get the schema version: %v", err.Error())
+		}
+		if version > 0 {
+			return version, true, nil
+		}
+	}
+
+	legacy, err := m.tableExists("tbl_user")
+	if err != nil || !legacy {
+		return 0, false, err
+	}
+	features, err := m.tableExists("tbl_user_quota")
+	if err != nil {
+		return 0, false, err
+	}
+	if features {
+		return 2, false, nil
+	}
+	return 1, false, nil
+}
+
+// baseline: create the migrations table and record the versions of the databases created by hand
+func (m *migrator) baseline(migrations []migration) (int, error) {
+	if _, err := m.e.ExecContext(m.ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER NOT NULL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)`); err != nil {
+		return 0, fmt.Errorf("failed to create the migrations table: %v", err.Error())
+	}
+	version, recorded, err := m.version()
+	if err != nil {
+		return 0, err
+	}
+	if !recorded {
+		for _, mig := range migrations {
+			if mig.version > version {
+				break
+			}
+			if err := m.record(mig, true); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return version, nil
+}
+
+// tableExists: check the table exists in the current database
+func (m *migrator) tableExists(table string) (bool, error) {
+	var query string
+	switch m.dialect {
+	case dialectSQLite:
+		query = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	case dialectPostgres:
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?"
+	default:
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+	}
+	var count int
+	if err := m.e.QueryRowContext(m.ctx, m.dialect.rebind(query), table).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check the table %s: %v", table, err.Error())
+	}
+	return count > 0, nil
+}
+
+// apply: run the up or the down script of the migration and record it
+func (m *migrator) apply(mig migration, up bool) error {
+	script := mig.down
+	if up {
+		script = mig.up
+	}
+	for _, statement := range m.statements(script) {
+		if _, err := m.e.ExecContext(m.ctx, statement); err != nil {
+			if m.dialect == dialectMySQL {
+				// the statements before the failed one are committed
+				return fmt.Errorf("failed to migrate %s, the schema may be partially migrated: %v", mig, err.Error())
+			}
+			return fmt.Errorf("failed to migrate %s: %v", mig, err.Error())
+		}
+	}
+	return m.record(mig, up)
+}
+
+// record: add the applied migration to the migrations table, or remove the reverted one
+func (m *migrator) record(mig migration, up bool) error {
+	var err error
+	if up {
+		_, err = m.e.ExecContext(m.ctx, m.dialect.rebind("INSERT INTO schema_migrations (version, name) VALUES (?, ?)"), mig.version, mig.name)
+	} else {
+		_, err = m.e.ExecContext(m.ctx, m.dialect.rebind("DELETE FROM schema_migrations WHERE version = ?"), mig.version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record the migration %s: %v", mig, err.Error())
+	}
+	return nil
+}
+
+// statements: split the script into the statements run one by one, the MySQL driver
+// runs a single statement per call, the others run the whole script
+func (m *migrator) statements(script string) []string {
+	if m.dialect != dialectMySQL {
+		return []string{script}
+	}
+
+	var statements []string
+	var current strings.Builder
+	var quote byte
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case strings.HasPrefix(script[i:], "-- "):
+			// skip the comment up to the end of the line
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+			i += end - 1
+			continue
+		case c == ';':
+			if statement := strings.TrimSpace(current.String()); statement != "" {
+				statements = append(statements, statement)
+			}
+			current.Reset()
+			continue
+		}
+		current.WriteByte(c)
+	}
+	if statement := strings.TrimSpace(current.String()); statement != "" {
+		statements = append(statements, statement)
+	}
+	return statements
+}
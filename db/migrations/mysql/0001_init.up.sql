@@ -1,4 +1,4 @@
-
+-- 最初的表结构 (doc/table.sql), 手动创建的数据库从这里开始迁移
 CREATE TABLE `tbl_file` (
   `id` INT AUTO_INCREMENT PRIMARY KEY,
   `file_hash` CHAR(64) NOT NULL DEFAULT '' COMMENT '文件hash', -- SHA-256
@@ -41,4 +41,4 @@ CREATE TABLE `tbl_user_file` (
   FOREIGN KEY (`user_id`) REFERENCES `tbl_user`(`id`) ON DELETE CASCADE,
   FOREIGN KEY (`file_id`) REFERENCES `tbl_file`(`id`) ON DELETE CASCADE,
   UNIQUE KEY `idx_user_file` (`user_id`, `file_id`)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
\ No newline at end of file
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
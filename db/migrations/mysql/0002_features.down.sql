@@ -0,0 +1,38 @@
+DROP TABLE IF EXISTS `tbl_user_identity`;
+DROP TABLE IF EXISTS `tbl_api_key`;
+DROP TABLE IF EXISTS `tbl_user_share`;
+DROP TABLE IF EXISTS `tbl_share_access_log`;
+DROP TABLE IF EXISTS `tbl_share_link`;
+DROP TABLE IF EXISTS `tbl_user_file_meta`;
+DROP TABLE IF EXISTS `tbl_user_file_tag`;
+DROP TABLE IF EXISTS `tbl_file_content`;
+
+-- 团队文件没有用户, 不能保留
+DELETE FROM `tbl_user_file` WHERE `user_id` IS NULL;
+ALTER TABLE `tbl_user_file`
+  DROP FOREIGN KEY `fk_user_file_team`,
+  DROP FOREIGN KEY `fk_user_file_uploader`,
+  DROP FOREIGN KEY `fk_user_file_folder`;
+ALTER TABLE `tbl_user_file`
+  DROP INDEX `idx_team_file`,
+  DROP COLUMN `team_id`,
+  DROP COLUMN `uploader_id`,
+  DROP COLUMN `folder_id`,
+  MODIFY `user_id` INT NOT NULL COMMENT '用户ID';
+UPDATE `tbl_file` f SET `reference_count` = (SELECT COUNT(*) FROM `tbl_user_file` uf WHERE uf.`file_id` = f.`id`);
+
+DROP TABLE IF EXISTS `tbl_folder`;
+DROP TABLE IF EXISTS `tbl_team_invite`;
+DROP TABLE IF EXISTS `tbl_team_member`;
+DROP TABLE IF EXISTS `tbl_team`;
+DROP TABLE IF EXISTS `tbl_user_recovery_code`;
+DROP TABLE IF EXISTS `tbl_user_totp`;
+DROP TABLE IF EXISTS `tbl_user_token`;
+DROP TABLE IF EXISTS `tbl_user_quota`;
+
+ALTER TABLE `tbl_user` DROP FOREIGN KEY `fk_user_plan`;
+ALTER TABLE `tbl_user`
+  DROP COLUMN `locked_until`,
+  DROP COLUMN `plan_id`,
+  DROP COLUMN `role`;
+DROP TABLE IF EXISTS `tbl_plan`;
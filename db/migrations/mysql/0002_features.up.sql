@@ -0,0 +1,224 @@
+-- 在最初的表结构上添加的功能: 套餐和配额, 邮件令牌, 两步验证, 团队, 文件夹,
+-- 全文检索, 标签, 分享链接, 用户间分享, API 密钥和单点登录
+CREATE TABLE `tbl_plan` (
+  `id` INT AUTO_INCREMENT PRIMARY KEY,
+  `name` VARCHAR(32) NOT NULL UNIQUE COMMENT '套餐名',
+  `max_bytes` BIGINT NOT NULL DEFAULT 0 COMMENT '存储空间上限, 0表示不限',
+  `max_files` INT NOT NULL DEFAULT 0 COMMENT '文件数量上限, 0表示不限'
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT INTO `tbl_plan` (`id`, `name`, `max_bytes`, `max_files`) VALUES
+  (1, 'free', 10737418240, 10000), -- 10GB
+  (2, 'pro', 1099511627776, 0); -- 1TB
+
+ALTER TABLE `tbl_user`
+  ADD COLUMN `locked_until` TIMESTAMP NULL DEFAULT NULL COMMENT '锁定截止时间, 空表示由管理员解锁',
+  ADD COLUMN `plan_id` INT NOT NULL DEFAULT 1 COMMENT '套餐ID',
+  ADD COLUMN `role` ENUM('user', 'admin') NOT NULL DEFAULT 'user' COMMENT '系统角色',
+  ADD CONSTRAINT `fk_user_plan` FOREIGN KEY (`plan_id`) REFERENCES `tbl_plan`(`id`);
+
+CREATE TABLE `tbl_user_quota` (
+  `user_id` INT PRIMARY KEY COMMENT '用户ID',
+  `max_bytes` BIGINT NULL DEFAULT NULL COMMENT '用户存储空间上限, 空表示使用套餐上限',
+  `max_files` INT NULL DEFAULT NULL COMMENT '用户文件数量上限, 空表示使用套餐上限',
+  `used_bytes` BIGINT NOT NULL DEFAULT 0 COMMENT '已用存储空间',
+  `file_count` INT NOT NULL DEFAULT 0 COMMENT '文件数量',
+  `update_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新日期',
+  FOREIGN KEY (`user_id`) REFERENCES `tbl_user`(`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `tbl_user_token` (
+  `id` INT AUTO_INCREMENT PRIMARY KEY,
+  `user_id` INT NOT NULL COMMENT '用户ID',
+  `purpose` ENUM('verify_email', 'reset_password', 'change_email') NOT NULL COMMENT '用途',
+  `email` VARCHAR(64) NOT NULL DEFAULT '' COMMENT '令牌发送到的邮箱',
+  `token_hash` CHAR(64) NOT NULL COMMENT '令牌SHA-256',
+  `expire_at` TIMESTAMP NOT NULL COMMENT '过期时间',
+  `used_at` TIMESTAMP NULL DEFAULT NULL COMMENT '使用时间, 空表示未使用',
+  `create_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '创建日期',
+  UNIQUE KEY `idx_token_hash` (`token_hash`),
+  KEY `idx_user_purpose` (`user_id`, `purpose`),
+  FOREIGN KEY (`user_id`) REFERENCES `tbl_user`(`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `tbl_user_totp` (
+  `user_id` INT PRIMARY KEY COMMENT '用户ID',
+  `secret` VARCHAR(64) NOT NULL COMMENT 'TOTP密钥(base32)',
+  `enabled` TINYINT(1) NOT NULL DEFAULT 0 COMMENT '是否已启用, 确认验证码后启用',
+  `last_step` BIGINT NOT NULL DEFAULT 0 COMMENT '最后使用的时间步, 防止验证码重放',
+  `create_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '创建日期',
+  FOREIGN KEY (`user_id`) REFERENCES `tbl_user`(`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `tbl_user_recovery_code` (
+  `id` INT AUTO_INCREMENT PRIMARY KEY,
+  `user_id` INT NOT NULL COMMENT '用户ID',
+  `code_hash` CHAR(64) NOT NULL COMMENT '恢复码SHA-256',
+  `used_at` TIMESTAMP NULL DEFAULT NULL COMMENT '使用时间, 空表示未使用',
+  UNIQUE KEY `idx_user_code` (`user_id`, `code_hash`),
+  FOREIGN KEY (`user_id`) REFERENCES `tbl_user`(`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `tbl_team` (
+  `id` INT AUTO_INCREMENT PRIMARY KEY,
+  `name` VARCHAR(64) NOT NULL COMMENT '团队名',
+  `owner_id` INT NOT NULL COMMENT '创建者ID',
+  `create_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '创建日期',
+  FOREIGN KEY (`owner_id`) REFERENCES `tbl_user`(`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `tbl_team_member` (
+  `team_id` INT NOT NULL COMMENT '团队ID',
+  `user_id` INT NOT NULL COMMENT '用户ID',
+  `role` ENUM('owner', 'admin', 'editor', 'viewer') NOT NULL DEFAULT 'viewer' COMMENT '角色',
+  `join_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '加入日期',
+  PRIMARY KEY (`team_id`, `user_id`),
+  FOREIGN KEY (`team_id`) REFERENCES `tbl_team`(`id`) ON DELETE CASCADE,
+  FOREIGN KEY (`user_id`) REFERENCES `tbl_user`(`id`) ON DELETE CASCADE,
+  KEY `idx_user` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `tbl_team_invite` (
+  `id` INT AUTO_INCREMENT PRIMARY KEY,
+  `team_id` INT NOT NULL COMMENT '团队ID',
+  `inviter_id` INT NOT NULL COMMENT '邀请者ID',
+  `invitee_id` INT NOT NULL COMMENT '被邀请者ID',
+  `role` ENUM('admin', 'editor', 'viewer') NOT NULL DEFAULT 'viewer' COMMENT '邀请角色',
+  `status` ENUM('pending', 'accepted', 'declined', 'canceled') NOT NULL DEFAULT 'pending' COMMENT '状态',
+  `create_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '创建日期',
+  FOREIGN KEY (`team_id`) REFERENCES `tbl_team`(`id`) ON DELETE CASCADE,
+  FOREIGN KEY (`inviter_id`) REFERENCES `tbl_user`(`id`) ON DELETE CASCADE,
+  FOREIGN KEY (`invitee_id`) REFERENCES `tbl_user`(`id`) ON DELETE CASCADE,
+  KEY `idx_invitee_status` (`invitee_id`, `status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `tbl_folder` (
+  `id` INT AUTO_INCREMENT PRIMARY KEY,
+  `name` VARCHAR(256) NOT NULL COMMENT '文件夹名',
+  `parent_id` INT NULL DEFAULT NULL COMMENT '父文件夹ID, 空表示根目录',
+  `user_id` INT NULL DEFAULT NULL COMMENT '所属用户ID, 团队文件夹为空',
+  `team_id` INT NULL DEFAULT NULL COMMENT '所属团队ID, 个人文件夹为空',
+  `create_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '创建日期',
+  FOREIGN KEY (`parent_id`) REFERENCES `tbl_folder`(`id`) ON DELETE CASCADE,
+  FOREIGN KEY (`user_id`) REFERENCES `tbl_user`(`id`) ON DELETE CASCADE,
+  FOREIGN KEY (`team_id`) REFERENCES `tbl_team`(`id`) ON DELETE CASCADE,
+  KEY `idx_user_parent` (`user_id`, `parent_id`),
+  KEY `idx_team_parent` (`team_id`, `parent_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- 团队文件没有用户, 已有的文件由其用户上传
+ALTER TABLE `tbl_user_file`
+  MODIFY `user_id` INT NULL DEFAULT NULL COMMENT '用户ID, 团队文件为空',
+  ADD COLUMN `team_id` INT NULL DEFAULT NULL COMMENT '团队ID, 个人文件为空' AFTER `user_id`,
+  ADD COLUMN `uploader_id` INT NULL DEFAULT NULL COMMENT '上传者ID' AFTER `team_id`,
+  ADD COLUMN `folder_id` INT NULL DEFAULT NULL COMMENT '文件夹ID, 空表示根目录' AFTER `uploader_id`,
+  ADD CONSTRAINT `fk_user_file_team` FOREIGN KEY (`team_id`) REFERENCES `tbl_team`(`id`) ON DELETE CASCADE,
+  ADD CONSTRAINT `fk_user_file_uploader` FOREIGN KEY (`uploader_id`) REFERENCES `tbl_user`(`id`) ON DELETE SET NULL,
+  ADD CONSTRAINT `fk_user_file_folder` FOREIGN KEY (`folder_id`) REFERENCES `tbl_folder`(`id`) ON DELETE SET NULL,
+  ADD UNIQUE KEY `idx_team_file` (`team_id`, `file_id`);
+UPDATE `tbl_user_file` SET `uploader_id` = `user_id`;
+
+-- 已有的文件计入其用户的配额
+INSERT INTO `tbl_user_quota` (`user_id`, `used_bytes`, `file_count`)
+  SELECT uf.`user_id`, COALESCE(SUM(f.`file_size`), 0), COUNT(*)
+  FROM `tbl_user_file` uf JOIN `tbl_file` f ON f.`id` = uf.`file_id`
+  GROUP BY uf.`user_id`;
+
+CREATE TABLE `tbl_file_content` (
+  `file_id` INT PRIMARY KEY COMMENT '文件ID',
+  `content` MEDIUMTEXT COMMENT '文件文本内容',
+  `update_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新日期',
+  FOREIGN KEY (`file_id`) REFERENCES `tbl_file`(`id`) ON DELETE CASCADE,
+  FULLTEXT KEY `idx_content` (`content`) WITH PARSER ngram
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `tbl_user_file_tag` (
+  `id` INT AUTO_INCREMENT PRIMARY KEY,
+  `user_file_id` INT NOT NULL COMMENT '用户文件ID',
+  `user_id` INT NOT NULL COMMENT '用户ID',
+  `tag` VARCHAR(64) NOT NULL COMMENT '标签',
+  FOREIGN KEY (`user_file_id`) REFERENCES `tbl_user_file`(`id`) ON DELETE CASCADE,
+  UNIQUE KEY `idx_user_file_tag` (`user_file_id`, `tag`),
+  KEY `idx_user_tag` (`user_id`, `tag`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `tbl_user_file_meta` (
+  `id` INT AUTO_INCREMENT PRIMARY KEY,
+  `user_file_id` INT NOT NULL COMMENT '用户文件ID',
+  `meta_key` VARCHAR(64) NOT NULL COMMENT '属性名',
+  `meta_value` VARCHAR(1024) NOT NULL DEFAULT '' COMMENT '属性值',
+  FOREIGN KEY (`user_file_id`) REFERENCES `tbl_user_file`(`id`) ON DELETE CASCADE,
+  UNIQUE KEY `idx_user_file_meta` (`user_file_id`, `meta_key`),
+  KEY `idx_meta` (`meta_key`, `meta_value`(191))
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `tbl_share_link` (
+  `id` INT AUTO_INCREMENT PRIMARY KEY,
+  `user_id` INT NOT NULL COMMENT '分享者ID',
+  `file_id` INT NOT NULL COMMENT '文件ID',
+  `token` VARCHAR(64) NOT NULL COMMENT '分享链接token',
+  `password` VARCHAR(60) NOT NULL DEFAULT '' COMMENT '访问密码encoded, 空表示无密码',
+  `expire_at` TIMESTAMP NULL DEFAULT NULL COMMENT '过期时间, 空表示永不过期',
+  `max_downloads` INT NOT NULL DEFAULT 0 COMMENT '最大下载次数, 0表示不限制',
+  `download_count` INT NOT NULL DEFAULT 0 COMMENT '已下载次数',
+  `status` ENUM('active', 'revoked') NOT NULL DEFAULT 'active' COMMENT '状态',
+  `create_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '创建日期',
+  FOREIGN KEY (`user_id`, `file_id`) REFERENCES `tbl_user_file`(`user_id`, `file_id`) ON DELETE CASCADE,
+  UNIQUE KEY `idx_token` (`token`),
+  KEY `idx_user` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `tbl_share_access_log` (
+  `id` BIGINT AUTO_INCREMENT PRIMARY KEY,
+  `link_id` INT NOT NULL COMMENT '分享链接ID',
+  `ip` VARCHAR(45) NOT NULL DEFAULT '' COMMENT '访问者IP',
+  `user_agent` VARCHAR(256) NOT NULL DEFAULT '' COMMENT '访问者UA',
+  `action` ENUM('view', 'download', 'denied') NOT NULL COMMENT '访问类型',
+  `result` VARCHAR(64) NOT NULL DEFAULT '' COMMENT '访问结果',
+  `access_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '访问时间',
+  FOREIGN KEY (`link_id`) REFERENCES `tbl_share_link`(`id`) ON DELETE CASCADE,
+  KEY `idx_link` (`link_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `tbl_user_share` (
+  `id` INT AUTO_INCREMENT PRIMARY KEY,
+  `owner_id` INT NOT NULL COMMENT '文件所有者ID',
+  `grantee_id` INT NOT NULL COMMENT '被分享用户ID',
+  `file_id` INT NOT NULL COMMENT '文件ID',
+  `permission` ENUM('read', 'write') NOT NULL DEFAULT 'read' COMMENT '权限',
+  `expire_at` TIMESTAMP NULL DEFAULT NULL COMMENT '过期时间, 空表示永不过期',
+  `create_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '创建日期',
+  FOREIGN KEY (`owner_id`, `file_id`) REFERENCES `tbl_user_file`(`user_id`, `file_id`) ON DELETE CASCADE,
+  FOREIGN KEY (`grantee_id`) REFERENCES `tbl_user`(`id`) ON DELETE CASCADE,
+  UNIQUE KEY `idx_owner_grantee_file` (`owner_id`, `grantee_id`, `file_id`),
+  KEY `idx_grantee_file` (`grantee_id`, `file_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `tbl_api_key` (
+  `id` INT AUTO_INCREMENT PRIMARY KEY,
+  `user_id` INT NOT NULL COMMENT '用户ID',
+  `name` VARCHAR(64) NOT NULL COMMENT '密钥名称',
+  `prefix` VARCHAR(16) NOT NULL COMMENT '密钥前缀, 用于识别密钥',
+  `key_hash` CHAR(64) NOT NULL COMMENT '密钥SHA-256哈希',
+  `scopes` SET('read', 'upload', 'delete') NOT NULL COMMENT '权限范围',
+  `expire_at` TIMESTAMP NULL DEFAULT NULL COMMENT '过期时间, 空表示永不过期',
+  `last_used_at` TIMESTAMP NULL DEFAULT NULL COMMENT '最后使用时间',
+  `last_used_ip` VARCHAR(45) NOT NULL DEFAULT '' COMMENT '最后使用IP',
+  `create_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '创建日期',
+  FOREIGN KEY (`user_id`) REFERENCES `tbl_user`(`id`) ON DELETE CASCADE,
+  UNIQUE KEY `idx_key_hash` (`key_hash`),
+  KEY `idx_user` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `tbl_user_identity` (
+  `id` INT AUTO_INCREMENT PRIMARY KEY,
+  `user_id` INT NOT NULL COMMENT '用户ID',
+  `issuer` VARCHAR(255) NOT NULL COMMENT 'OIDC身份提供方',
+  `subject` VARCHAR(255) NOT NULL COMMENT 'OIDC用户标识',
+  `email` VARCHAR(64) NOT NULL DEFAULT '' COMMENT '身份提供方的邮箱',
+  `create_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP COMMENT '关联日期',
+  `last_login_at` TIMESTAMP NULL DEFAULT NULL COMMENT '最后登录时间',
+  FOREIGN KEY (`user_id`) REFERENCES `tbl_user`(`id`) ON DELETE CASCADE,
+  UNIQUE KEY `idx_issuer_subject` (`issuer`, `subject`),
+  UNIQUE KEY `idx_user_issuer` (`user_id`, `issuer`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
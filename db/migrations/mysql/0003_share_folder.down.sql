@@ -0,0 +1,5 @@
+DELETE FROM `tbl_share_link` WHERE `folder_id` IS NOT NULL;
+ALTER TABLE `tbl_share_link` DROP FOREIGN KEY `fk_share_link_folder`;
+ALTER TABLE `tbl_share_link`
+  DROP COLUMN `folder_id`,
+  MODIFY `file_id` INT NOT NULL COMMENT '文件ID';
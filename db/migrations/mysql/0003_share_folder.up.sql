@@ -0,0 +1,5 @@
+-- 文件夹分享链接: 分享链接指向文件或文件夹之一
+ALTER TABLE `tbl_share_link`
+  MODIFY `file_id` INT NULL DEFAULT NULL COMMENT '文件ID, 文件夹分享为空',
+  ADD COLUMN `folder_id` INT NULL DEFAULT NULL COMMENT '文件夹ID, 文件分享为空' AFTER `file_id`,
+  ADD CONSTRAINT `fk_share_link_folder` FOREIGN KEY (`folder_id`) REFERENCES `tbl_folder`(`id`) ON DELETE CASCADE;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS tbl_user_file;
+DROP TABLE IF EXISTS tbl_user;
+DROP TABLE IF EXISTS tbl_file;
+
+DROP FUNCTION IF EXISTS touch_last_active();
+DROP FUNCTION IF EXISTS touch_update_at();
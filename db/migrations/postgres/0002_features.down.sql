@@ -0,0 +1,30 @@
+DROP TABLE IF EXISTS tbl_user_identity;
+DROP TABLE IF EXISTS tbl_api_key;
+DROP TABLE IF EXISTS tbl_user_share;
+DROP TABLE IF EXISTS tbl_share_access_log;
+DROP TABLE IF EXISTS tbl_share_link;
+DROP TABLE IF EXISTS tbl_user_file_meta;
+DROP TABLE IF EXISTS tbl_user_file_tag;
+DROP TABLE IF EXISTS tbl_file_content;
+
+-- 团队文件没有用户, 不能保留, 删除列时一并删除其约束
+DELETE FROM tbl_user_file WHERE user_id IS NULL;
+ALTER TABLE tbl_user_file DROP COLUMN team_id;
+ALTER TABLE tbl_user_file DROP COLUMN uploader_id;
+ALTER TABLE tbl_user_file DROP COLUMN folder_id;
+ALTER TABLE tbl_user_file ALTER COLUMN user_id SET NOT NULL;
+UPDATE tbl_file SET reference_count = (SELECT COUNT(*) FROM tbl_user_file uf WHERE uf.file_id = tbl_file.id);
+
+DROP TABLE IF EXISTS tbl_folder;
+DROP TABLE IF EXISTS tbl_team_invite;
+DROP TABLE IF EXISTS tbl_team_member;
+DROP TABLE IF EXISTS tbl_team;
+DROP TABLE IF EXISTS tbl_user_recovery_code;
+DROP TABLE IF EXISTS tbl_user_totp;
+DROP TABLE IF EXISTS tbl_user_token;
+DROP TABLE IF EXISTS tbl_user_quota;
+
+ALTER TABLE tbl_user DROP COLUMN locked_until;
+ALTER TABLE tbl_user DROP COLUMN plan_id;
+ALTER TABLE tbl_user DROP COLUMN role;
+DROP TABLE IF EXISTS tbl_plan;
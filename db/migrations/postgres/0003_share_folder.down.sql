@@ -0,0 +1,4 @@
+DELETE FROM tbl_share_link WHERE folder_id IS NOT NULL;
+ALTER TABLE tbl_share_link DROP CONSTRAINT tbl_share_link_target;
+ALTER TABLE tbl_share_link DROP COLUMN folder_id;
+ALTER TABLE tbl_share_link ALTER COLUMN file_id SET NOT NULL;
@@ -0,0 +1,4 @@
+-- 文件夹分享链接: 分享链接指向文件或文件夹之一
+ALTER TABLE tbl_share_link ALTER COLUMN file_id DROP NOT NULL;
+ALTER TABLE tbl_share_link ADD COLUMN folder_id INTEGER NULL DEFAULT NULL REFERENCES tbl_folder(id) ON DELETE CASCADE; -- 文件夹ID, 文件分享为空
+ALTER TABLE tbl_share_link ADD CONSTRAINT tbl_share_link_target CHECK ((file_id IS NULL) <> (folder_id IS NULL));
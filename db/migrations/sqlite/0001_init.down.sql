@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS tbl_user_file;
+DROP TABLE IF EXISTS tbl_user;
+DROP TABLE IF EXISTS tbl_file;
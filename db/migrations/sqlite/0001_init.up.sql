@@ -0,0 +1,57 @@
+-- SQLite 版本的最初的表结构, 与 MySQL 的迁移保持一致
+-- update_at / last_active 的自动更新由触发器实现, 全文索引由 instr 查询代替
+
+CREATE TABLE tbl_file (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  file_hash CHAR(64) NOT NULL DEFAULT '', -- 文件hash, SHA-256
+  file_name VARCHAR(256) NOT NULL DEFAULT '', -- 文件名
+  file_size BIGINT DEFAULT 0, -- 文件大小
+  file_path VARCHAR(512) NOT NULL DEFAULT '', -- 文件存储位置
+  create_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 创建日期
+  update_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 更新日期
+  status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'disabled', 'deleted')), -- 状态
+  reference_count INTEGER DEFAULT 0, -- 文件引用计数
+  ext1 INTEGER DEFAULT 0, -- 备用字段1
+  ext2 TEXT, -- 备用字段2
+  UNIQUE (file_hash)
+);
+CREATE INDEX tbl_file_idx_status ON tbl_file (status);
+
+CREATE TRIGGER tbl_file_touch_update_at AFTER UPDATE ON tbl_file
+  FOR EACH ROW WHEN NEW.update_at = OLD.update_at
+BEGIN
+  UPDATE tbl_file SET update_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TABLE tbl_user (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  username VARCHAR(64) NOT NULL UNIQUE, -- 用户名
+  password VARCHAR(60) NOT NULL DEFAULT '', -- 用户encoded密码
+  email VARCHAR(64) DEFAULT '', -- 邮箱
+  phone VARCHAR(20) DEFAULT '', -- 手机号
+  email_validated INTEGER DEFAULT 0, -- 邮箱是否已验证
+  phone_validated INTEGER DEFAULT 0, -- 手机号是否已验证
+  signup_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 注册日期
+  last_active TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 最后活跃时间戳
+  profile TEXT, -- 用户属性, 使用 JSON 数据类型
+  status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'disabled', 'locked', 'deleted')) -- 账户状态
+);
+CREATE INDEX tbl_user_idx_status ON tbl_user (status);
+
+CREATE TRIGGER tbl_user_touch_last_active AFTER UPDATE ON tbl_user
+  FOR EACH ROW WHEN NEW.last_active = OLD.last_active
+BEGIN
+  UPDATE tbl_user SET last_active = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TABLE tbl_user_file (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL, -- 用户ID
+  file_id INTEGER NOT NULL, -- 文件ID
+  file_name VARCHAR(256) NOT NULL DEFAULT '', -- 文件名
+  upload_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 上传时间
+  status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'disabled', 'deleted')), -- 文件状态
+  FOREIGN KEY (user_id) REFERENCES tbl_user(id) ON DELETE CASCADE,
+  FOREIGN KEY (file_id) REFERENCES tbl_file(id) ON DELETE CASCADE,
+  UNIQUE (user_id, file_id)
+);
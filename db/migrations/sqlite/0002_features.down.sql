@@ -0,0 +1,64 @@
+DROP TABLE IF EXISTS tbl_user_identity;
+DROP TABLE IF EXISTS tbl_api_key;
+DROP TABLE IF EXISTS tbl_user_share;
+DROP TABLE IF EXISTS tbl_share_access_log;
+DROP TABLE IF EXISTS tbl_share_link;
+DROP TABLE IF EXISTS tbl_user_file_meta;
+DROP TABLE IF EXISTS tbl_user_file_tag;
+DROP TABLE IF EXISTS tbl_file_content;
+
+-- 团队文件没有用户, 不能保留, 其余的用户文件备份后随 tbl_user 重建
+CREATE TEMP TABLE tbl_user_file_backup AS SELECT * FROM tbl_user_file WHERE user_id IS NOT NULL;
+DROP TABLE tbl_user_file;
+
+DROP TABLE IF EXISTS tbl_folder;
+DROP TABLE IF EXISTS tbl_team_invite;
+DROP TABLE IF EXISTS tbl_team_member;
+DROP TABLE IF EXISTS tbl_team;
+DROP TABLE IF EXISTS tbl_user_recovery_code;
+DROP TABLE IF EXISTS tbl_user_totp;
+DROP TABLE IF EXISTS tbl_user_token;
+DROP TABLE IF EXISTS tbl_user_quota;
+
+CREATE TABLE tbl_user_old (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  username VARCHAR(64) NOT NULL UNIQUE, -- 用户名
+  password VARCHAR(60) NOT NULL DEFAULT '', -- 用户encoded密码
+  email VARCHAR(64) DEFAULT '', -- 邮箱
+  phone VARCHAR(20) DEFAULT '', -- 手机号
+  email_validated INTEGER DEFAULT 0, -- 邮箱是否已验证
+  phone_validated INTEGER DEFAULT 0, -- 手机号是否已验证
+  signup_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 注册日期
+  last_active TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 最后活跃时间戳
+  profile TEXT, -- 用户属性, 使用 JSON 数据类型
+  status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'disabled', 'locked', 'deleted')) -- 账户状态
+);
+INSERT INTO tbl_user_old (id, username, password, email, phone, email_validated, phone_validated, signup_at, last_active, profile, status)
+  SELECT id, username, password, email, phone, email_validated, phone_validated, signup_at, last_active, profile, status FROM tbl_user;
+DROP TABLE tbl_user;
+ALTER TABLE tbl_user_old RENAME TO tbl_user;
+CREATE INDEX tbl_user_idx_status ON tbl_user (status);
+
+CREATE TRIGGER tbl_user_touch_last_active AFTER UPDATE ON tbl_user
+  FOR EACH ROW WHEN NEW.last_active = OLD.last_active
+BEGIN
+  UPDATE tbl_user SET last_active = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+DROP TABLE IF EXISTS tbl_plan;
+
+CREATE TABLE tbl_user_file (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL, -- 用户ID
+  file_id INTEGER NOT NULL, -- 文件ID
+  file_name VARCHAR(256) NOT NULL DEFAULT '', -- 文件名
+  upload_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 上传时间
+  status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'disabled', 'deleted')), -- 文件状态
+  FOREIGN KEY (user_id) REFERENCES tbl_user(id) ON DELETE CASCADE,
+  FOREIGN KEY (file_id) REFERENCES tbl_file(id) ON DELETE CASCADE,
+  UNIQUE (user_id, file_id)
+);
+INSERT INTO tbl_user_file (id, user_id, file_id, file_name, upload_at, status)
+  SELECT id, user_id, file_id, file_name, upload_at, status FROM tbl_user_file_backup;
+DROP TABLE tbl_user_file_backup;
+UPDATE tbl_file SET reference_count = (SELECT COUNT(*) FROM tbl_user_file uf WHERE uf.file_id = tbl_file.id);
@@ -0,0 +1,274 @@
+-- 在最初的表结构上添加的功能: 套餐和配额, 邮件令牌, 两步验证, 团队, 文件夹,
+-- 全文检索, 标签, 分享链接, 用户间分享, API 密钥和单点登录
+-- SQLite 不能修改列约束, 需要重建 tbl_user 和 tbl_user_file, 删除 tbl_user 会级联删除用户文件, 所以先备份再恢复
+CREATE TEMP TABLE tbl_user_file_backup AS SELECT * FROM tbl_user_file;
+DROP TABLE tbl_user_file;
+
+CREATE TABLE tbl_plan (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name VARCHAR(32) NOT NULL UNIQUE, -- 套餐名
+  max_bytes BIGINT NOT NULL DEFAULT 0, -- 存储空间上限, 0表示不限
+  max_files INTEGER NOT NULL DEFAULT 0 -- 文件数量上限, 0表示不限
+);
+
+INSERT INTO tbl_plan (id, name, max_bytes, max_files) VALUES
+  (1, 'free', 10737418240, 10000), -- 10GB
+  (2, 'pro', 1099511627776, 0); -- 1TB
+
+CREATE TABLE tbl_user_new (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  username VARCHAR(64) NOT NULL UNIQUE, -- 用户名
+  password VARCHAR(60) NOT NULL DEFAULT '', -- 用户encoded密码
+  email VARCHAR(64) DEFAULT '', -- 邮箱
+  phone VARCHAR(20) DEFAULT '', -- 手机号
+  email_validated INTEGER DEFAULT 0, -- 邮箱是否已验证
+  phone_validated INTEGER DEFAULT 0, -- 手机号是否已验证
+  signup_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 注册日期
+  last_active TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 最后活跃时间戳
+  profile TEXT, -- 用户属性, 使用 JSON 数据类型
+  status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'disabled', 'locked', 'deleted')), -- 账户状态
+  locked_until TIMESTAMP NULL DEFAULT NULL, -- 锁定截止时间, 空表示由管理员解锁
+  plan_id INTEGER NOT NULL DEFAULT 1, -- 套餐ID
+  role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'admin')), -- 系统角色
+  FOREIGN KEY (plan_id) REFERENCES tbl_plan(id)
+);
+INSERT INTO tbl_user_new (id, username, password, email, phone, email_validated, phone_validated, signup_at, last_active, profile, status)
+  SELECT id, username, password, email, phone, email_validated, phone_validated, signup_at, last_active, profile, status FROM tbl_user;
+DROP TABLE tbl_user;
+ALTER TABLE tbl_user_new RENAME TO tbl_user;
+CREATE INDEX tbl_user_idx_status ON tbl_user (status);
+
+CREATE TRIGGER tbl_user_touch_last_active AFTER UPDATE ON tbl_user
+  FOR EACH ROW WHEN NEW.last_active = OLD.last_active
+BEGIN
+  UPDATE tbl_user SET last_active = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TABLE tbl_user_quota (
+  user_id INTEGER PRIMARY KEY, -- 用户ID
+  max_bytes BIGINT NULL DEFAULT NULL, -- 用户存储空间上限, 空表示使用套餐上限
+  max_files INTEGER NULL DEFAULT NULL, -- 用户文件数量上限, 空表示使用套餐上限
+  used_bytes BIGINT NOT NULL DEFAULT 0, -- 已用存储空间
+  file_count INTEGER NOT NULL DEFAULT 0, -- 文件数量
+  update_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 更新日期
+  FOREIGN KEY (user_id) REFERENCES tbl_user(id) ON DELETE CASCADE
+);
+
+CREATE TRIGGER tbl_user_quota_touch_update_at AFTER UPDATE ON tbl_user_quota
+  FOR EACH ROW WHEN NEW.update_at = OLD.update_at
+BEGIN
+  UPDATE tbl_user_quota SET update_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TABLE tbl_user_token (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL, -- 用户ID
+  purpose TEXT NOT NULL CHECK (purpose IN ('verify_email', 'reset_password', 'change_email')), -- 用途
+  email VARCHAR(64) NOT NULL DEFAULT '', -- 令牌发送到的邮箱
+  token_hash CHAR(64) NOT NULL, -- 令牌SHA-256
+  expire_at TIMESTAMP NOT NULL, -- 过期时间
+  used_at TIMESTAMP NULL DEFAULT NULL, -- 使用时间, 空表示未使用
+  create_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 创建日期
+  UNIQUE (token_hash),
+  FOREIGN KEY (user_id) REFERENCES tbl_user(id) ON DELETE CASCADE
+);
+CREATE INDEX tbl_user_token_idx_user_purpose ON tbl_user_token (user_id, purpose);
+
+CREATE TABLE tbl_user_totp (
+  user_id INTEGER PRIMARY KEY, -- 用户ID
+  secret VARCHAR(64) NOT NULL, -- TOTP密钥(base32)
+  enabled INTEGER NOT NULL DEFAULT 0, -- 是否已启用, 确认验证码后启用
+  last_step BIGINT NOT NULL DEFAULT 0, -- 最后使用的时间步, 防止验证码重放
+  create_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 创建日期
+  FOREIGN KEY (user_id) REFERENCES tbl_user(id) ON DELETE CASCADE
+);
+
+CREATE TABLE tbl_user_recovery_code (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL, -- 用户ID
+  code_hash CHAR(64) NOT NULL, -- 恢复码SHA-256
+  used_at TIMESTAMP NULL DEFAULT NULL, -- 使用时间, 空表示未使用
+  UNIQUE (user_id, code_hash),
+  FOREIGN KEY (user_id) REFERENCES tbl_user(id) ON DELETE CASCADE
+);
+
+CREATE TABLE tbl_team (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name VARCHAR(64) NOT NULL, -- 团队名
+  owner_id INTEGER NOT NULL, -- 创建者ID
+  create_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 创建日期
+  FOREIGN KEY (owner_id) REFERENCES tbl_user(id) ON DELETE CASCADE
+);
+
+CREATE TABLE tbl_team_member (
+  team_id INTEGER NOT NULL, -- 团队ID
+  user_id INTEGER NOT NULL, -- 用户ID
+  role TEXT NOT NULL DEFAULT 'viewer' CHECK (role IN ('owner', 'admin', 'editor', 'viewer')), -- 角色
+  join_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 加入日期
+  PRIMARY KEY (team_id, user_id),
+  FOREIGN KEY (team_id) REFERENCES tbl_team(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES tbl_user(id) ON DELETE CASCADE
+);
+CREATE INDEX tbl_team_member_idx_user ON tbl_team_member (user_id);
+
+CREATE TABLE tbl_team_invite (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  team_id INTEGER NOT NULL, -- 团队ID
+  inviter_id INTEGER NOT NULL, -- 邀请者ID
+  invitee_id INTEGER NOT NULL, -- 被邀请者ID
+  role TEXT NOT NULL DEFAULT 'viewer' CHECK (role IN ('admin', 'editor', 'viewer')), -- 邀请角色
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'declined', 'canceled')), -- 状态
+  create_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 创建日期
+  FOREIGN KEY (team_id) REFERENCES tbl_team(id) ON DELETE CASCADE,
+  FOREIGN KEY (inviter_id) REFERENCES tbl_user(id) ON DELETE CASCADE,
+  FOREIGN KEY (invitee_id) REFERENCES tbl_user(id) ON DELETE CASCADE
+);
+CREATE INDEX tbl_team_invite_idx_invitee_status ON tbl_team_invite (invitee_id, status);
+
+CREATE TABLE tbl_folder (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name VARCHAR(256) NOT NULL, -- 文件夹名
+  parent_id INTEGER NULL DEFAULT NULL, -- 父文件夹ID, 空表示根目录
+  user_id INTEGER NULL DEFAULT NULL, -- 所属用户ID, 团队文件夹为空
+  team_id INTEGER NULL DEFAULT NULL, -- 所属团队ID, 个人文件夹为空
+  create_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 创建日期
+  FOREIGN KEY (parent_id) REFERENCES tbl_folder(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES tbl_user(id) ON DELETE CASCADE,
+  FOREIGN KEY (team_id) REFERENCES tbl_team(id) ON DELETE CASCADE
+);
+CREATE INDEX tbl_folder_idx_user_parent ON tbl_folder (user_id, parent_id);
+CREATE INDEX tbl_folder_idx_team_parent ON tbl_folder (team_id, parent_id);
+
+CREATE TABLE tbl_user_file (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NULL DEFAULT NULL, -- 用户ID, 团队文件为空
+  team_id INTEGER NULL DEFAULT NULL, -- 团队ID, 个人文件为空
+  uploader_id INTEGER NULL DEFAULT NULL, -- 上传者ID
+  folder_id INTEGER NULL DEFAULT NULL, -- 文件夹ID, 空表示根目录
+  file_id INTEGER NOT NULL, -- 文件ID
+  file_name VARCHAR(256) NOT NULL DEFAULT '', -- 文件名
+  upload_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 上传时间
+  status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'disabled', 'deleted')), -- 文件状态
+  FOREIGN KEY (user_id) REFERENCES tbl_user(id) ON DELETE CASCADE,
+  FOREIGN KEY (team_id) REFERENCES tbl_team(id) ON DELETE CASCADE,
+  FOREIGN KEY (uploader_id) REFERENCES tbl_user(id) ON DELETE SET NULL,
+  FOREIGN KEY (folder_id) REFERENCES tbl_folder(id) ON DELETE SET NULL,
+  FOREIGN KEY (file_id) REFERENCES tbl_file(id) ON DELETE CASCADE,
+  UNIQUE (user_id, file_id),
+  UNIQUE (team_id, file_id)
+);
+
+-- 已有的文件由其用户上传
+INSERT INTO tbl_user_file (id, user_id, uploader_id, file_id, file_name, upload_at, status)
+  SELECT id, user_id, user_id, file_id, file_name, upload_at, status FROM tbl_user_file_backup;
+DROP TABLE tbl_user_file_backup;
+
+-- 已有的文件计入其用户的配额
+INSERT INTO tbl_user_quota (user_id, used_bytes, file_count)
+  SELECT uf.user_id, COALESCE(SUM(f.file_size), 0), COUNT(*)
+  FROM tbl_user_file uf JOIN tbl_file f ON f.id = uf.file_id
+  GROUP BY uf.user_id;
+
+CREATE TABLE tbl_file_content (
+  file_id INTEGER PRIMARY KEY, -- 文件ID
+  content TEXT, -- 文件文本内容
+  update_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 更新日期
+  FOREIGN KEY (file_id) REFERENCES tbl_file(id) ON DELETE CASCADE
+);
+
+CREATE TRIGGER tbl_file_content_touch_update_at AFTER UPDATE ON tbl_file_content
+  FOR EACH ROW WHEN NEW.update_at = OLD.update_at
+BEGIN
+  UPDATE tbl_file_content SET update_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TABLE tbl_user_file_tag (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_file_id INTEGER NOT NULL, -- 用户文件ID
+  user_id INTEGER NOT NULL, -- 用户ID
+  tag VARCHAR(64) NOT NULL, -- 标签
+  FOREIGN KEY (user_file_id) REFERENCES tbl_user_file(id) ON DELETE CASCADE,
+  UNIQUE (user_file_id, tag)
+);
+CREATE INDEX tbl_user_file_tag_idx_user_tag ON tbl_user_file_tag (user_id, tag);
+
+CREATE TABLE tbl_user_file_meta (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_file_id INTEGER NOT NULL, -- 用户文件ID
+  meta_key VARCHAR(64) NOT NULL, -- 属性名
+  meta_value VARCHAR(1024) NOT NULL DEFAULT '', -- 属性值
+  FOREIGN KEY (user_file_id) REFERENCES tbl_user_file(id) ON DELETE CASCADE,
+  UNIQUE (user_file_id, meta_key)
+);
+CREATE INDEX tbl_user_file_meta_idx_meta ON tbl_user_file_meta (meta_key, meta_value);
+
+CREATE TABLE tbl_share_link (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL, -- 分享者ID
+  file_id INTEGER NOT NULL, -- 文件ID
+  token VARCHAR(64) NOT NULL, -- 分享链接token
+  password VARCHAR(60) NOT NULL DEFAULT '', -- 访问密码encoded, 空表示无密码
+  expire_at TIMESTAMP NULL DEFAULT NULL, -- 过期时间, 空表示永不过期
+  max_downloads INTEGER NOT NULL DEFAULT 0, -- 最大下载次数, 0表示不限制
+  download_count INTEGER NOT NULL DEFAULT 0, -- 已下载次数
+  status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'revoked')), -- 状态
+  create_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 创建日期
+  FOREIGN KEY (user_id, file_id) REFERENCES tbl_user_file(user_id, file_id) ON DELETE CASCADE,
+  UNIQUE (token)
+);
+CREATE INDEX tbl_share_link_idx_user ON tbl_share_link (user_id);
+
+CREATE TABLE tbl_share_access_log (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  link_id INTEGER NOT NULL, -- 分享链接ID
+  ip VARCHAR(45) NOT NULL DEFAULT '', -- 访问者IP
+  user_agent VARCHAR(256) NOT NULL DEFAULT '', -- 访问者UA
+  action TEXT NOT NULL CHECK (action IN ('view', 'download', 'denied')), -- 访问类型
+  result VARCHAR(64) NOT NULL DEFAULT '', -- 访问结果
+  access_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 访问时间
+  FOREIGN KEY (link_id) REFERENCES tbl_share_link(id) ON DELETE CASCADE
+);
+CREATE INDEX tbl_share_access_log_idx_link ON tbl_share_access_log (link_id);
+
+CREATE TABLE tbl_user_share (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  owner_id INTEGER NOT NULL, -- 文件所有者ID
+  grantee_id INTEGER NOT NULL, -- 被分享用户ID
+  file_id INTEGER NOT NULL, -- 文件ID
+  permission TEXT NOT NULL DEFAULT 'read' CHECK (permission IN ('read', 'write')), -- 权限
+  expire_at TIMESTAMP NULL DEFAULT NULL, -- 过期时间, 空表示永不过期
+  create_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 创建日期
+  FOREIGN KEY (owner_id, file_id) REFERENCES tbl_user_file(user_id, file_id) ON DELETE CASCADE,
+  FOREIGN KEY (grantee_id) REFERENCES tbl_user(id) ON DELETE CASCADE,
+  UNIQUE (owner_id, grantee_id, file_id)
+);
+CREATE INDEX tbl_user_share_idx_grantee_file ON tbl_user_share (grantee_id, file_id);
+
+CREATE TABLE tbl_api_key (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL, -- 用户ID
+  name VARCHAR(64) NOT NULL, -- 密钥名称
+  prefix VARCHAR(16) NOT NULL, -- 密钥前缀, 用于识别密钥
+  key_hash CHAR(64) NOT NULL, -- 密钥SHA-256哈希
+  scopes TEXT NOT NULL, -- 权限范围
+  expire_at TIMESTAMP NULL DEFAULT NULL, -- 过期时间, 空表示永不过期
+  last_used_at TIMESTAMP NULL DEFAULT NULL, -- 最后使用时间
+  last_used_ip VARCHAR(45) NOT NULL DEFAULT '', -- 最后使用IP
+  create_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 创建日期
+  FOREIGN KEY (user_id) REFERENCES tbl_user(id) ON DELETE CASCADE,
+  UNIQUE (key_hash)
+);
+CREATE INDEX tbl_api_key_idx_user ON tbl_api_key (user_id);
+
+CREATE TABLE tbl_user_identity (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL, -- 用户ID
+  issuer VARCHAR(255) NOT NULL, -- OIDC身份提供方
+  subject VARCHAR(255) NOT NULL, -- OIDC用户标识
+  email VARCHAR(64) NOT NULL DEFAULT '', -- 身份提供方的邮箱
+  create_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 关联日期
+  last_login_at TIMESTAMP NULL DEFAULT NULL, -- 最后登录时间
+  FOREIGN KEY (user_id) REFERENCES tbl_user(id) ON DELETE CASCADE,
+  UNIQUE (issuer, subject),
+  UNIQUE (user_id, issuer)
+);
@@ -0,0 +1,25 @@
+DELETE FROM tbl_share_link WHERE folder_id IS NOT NULL;
+CREATE TEMP TABLE tbl_share_access_log_backup AS SELECT * FROM tbl_share_access_log;
+
+CREATE TABLE tbl_share_link_old (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL, -- 分享者ID
+  file_id INTEGER NOT NULL, -- 文件ID
+  token VARCHAR(64) NOT NULL, -- 分享链接token
+  password VARCHAR(60) NOT NULL DEFAULT '', -- 访问密码encoded, 空表示无密码
+  expire_at TIMESTAMP NULL DEFAULT NULL, -- 过期时间, 空表示永不过期
+  max_downloads INTEGER NOT NULL DEFAULT 0, -- 最大下载次数, 0表示不限制
+  download_count INTEGER NOT NULL DEFAULT 0, -- 已下载次数
+  status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'revoked')), -- 状态
+  create_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- 创建日期
+  FOREIGN KEY (user_id, file_id) REFERENCES tbl_user_file(user_id, file_id) ON DELETE CASCADE,
+  UNIQUE (token)
+);
+INSERT INTO tbl_share_link_old (id, user_id, file_id, token, password, expire_at, max_downloads, download_count, status, create_at)
+  SELECT id, user_id, file_id, token, password, expire_at, max_downloads, download_count, status, create_at FROM tbl_share_link;
+DROP TABLE tbl_share_link;
+ALTER TABLE tbl_share_link_old RENAME TO tbl_share_link;
+CREATE INDEX tbl_share_link_idx_user ON tbl_share_link (user_id);
+
+INSERT INTO tbl_share_access_log SELECT * FROM tbl_share_access_log_backup;
+DROP TABLE tbl_share_access_log_backup;
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bladewaltz9/file-store-server/models"
+)
+
+// ErrQuotaExceeded: the user has no storage space or file count left
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// the user overrides take precedence over the plan limits
+const userQuotaQuery = `SELECT p.name, COALESCE(q.max_bytes, p.max_bytes), COALESCE(q.max_files, p.max_files),
+	COALESCE(q.used_bytes, 0), COALESCE(q.file_count, 0)
+	FROM tbl_user u
+	JOIN tbl_plan p ON p.id = u.plan_id
+	LEFT JOIN tbl_user_quota q ON q.user_id = u.id
+	WHERE u.id = ?`
+
+// the usage row exists when it is locked, PostgreSQL can not lock the nullable side of an outer join
+var lockUserQuotaQuery = strings.Replace(userQuotaQuery, "LEFT JOIN", "JOIN", 1)
+
+// queryer: the common query method of *sql.DB and *sql.Tx
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// scanUserQuota: query the quota of the user
+func scanUserQuota(q queryer, query string, userID int) (*models.UserQuota, error) {
+	quota := &models.UserQuota{}
+	err := q.QueryRow(query, userID).Scan(&quota.Plan, &quota.MaxBytes, &quota.MaxFiles, &quota.UsedBytes, &quota.FileCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return quota, nil
+}
+
+// GetUserQuota: get the storage quota and usage of the user
+func (d *DB) GetUserQuota(userID int) (*models.UserQuota, error) {
+	return scanUserQuota(d.db, userQuotaQuery, userID)
+}
+
+// CheckUserQuota: check if the user can store another file of the size, returns ErrQuotaExceeded if not
+func (d *DB) CheckUserQuota(userID int, size int64) error {
+	quota, err := d.GetUserQuota(userID)
+	if err != nil {
+		return err
+	}
+	if !quota.Allows(size) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// reserveQuota: add the file to the usage of the user in the transaction, returns ErrQuotaExceeded if it does not fit
+func reserveQuota(tx *txConn, userID int, fileID int) error {
+	var size int64
+	if err := tx.QueryRow("SELECT file_size FROM tbl_file WHERE id = ?", fileID).Scan(&size); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+
+	// lock the usage row of the user so concurrent uploads are counted one by one
+	if _, err := tx.Exec(tx.dialect.insertIgnore("INSERT INTO tbl_user_quota (user_id) VALUES (?)"), userID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	quota, err := scanUserQuota(tx, lockUserQuotaQuery+tx.dialect.forUpdate("q"), userID)
+	if err != nil {
+		return err
+	}
+	if !quota.Allows(size) {
+		return ErrQuotaExceeded
+	}
+
+	query := "UPDATE tbl_user_quota SET used_bytes = used_bytes + ?, file_count = file_count + 1 WHERE user_id = ?"
+	if _, err := tx.Exec(query, size, userID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
+// releaseQuota: remove the files from the usage of the user in the transaction
+func releaseQuota(tx *txConn, userID int, size int64, count int) error {
+	query := `UPDATE tbl_user_quota SET used_bytes = ` + tx.dialect.greatest("used_bytes - ?", "0") +
+		`, file_count = ` + tx.dialect.greatest("file_count - ?", "0") + ` WHERE user_id = ?`
+	if _, err := tx.Exec(query, size, count, userID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
+// releaseFileQuota: remove the file from the usage of the user who is charged for it,
+// must be called before the user file relationship is deleted
+func releaseFileQuota(tx *txConn, userFileCondition string, args ...interface{}) error {
+	query := `SELECT uf.uploader_id, SUM(f.file_size), COUNT(*)
+	FROM tbl_user_file uf
+	JOIN tbl_file f ON f.id = uf.file_id
+	WHERE uf.uploader_id IS NOT NULL AND ` + userFileCondition + `
+	GROUP BY uf.uploader_id`
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+
+	type usage struct {
+		userID int
+		size   int64
+		count  int
+	}
+	var usages []usage
+	for rows.Next() {
+		var u usage
+		if err := rows.Scan(&u.userID, &u.size, &u.count); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan the row: %v", err.Error())
+		}
+		usages = append(usages, u)
+	}
+	rows.Close()
+
+	for _, u := range usages {
+		if err := releaseQuota(tx, u.userID, u.size, u.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/models"
+)
+
+var (
+	// ErrUserExists: the username is taken
+	ErrUserExists = errors.New("user already exists")
+	// ErrFileExists: a file with the same hash is already stored, it is shared through its reference count instead
+	ErrFileExists = errors.New("file already exists")
+	// ErrFileNotFound: the file does not exist
+	ErrFileNotFound = errors.New("file not found")
+	// ErrUserFileExists: the user already has the file
+	ErrUserFileExists = errors.New("user file already exists")
+)
+
+// UserRepository: the accounts of the users, implemented by DB and the in-memory store of the tests
+type UserRepository interface {
+	// SaveUserInfo: returns ErrUserExists if the username is taken
+	SaveUserInfo(username string, password string, email string) error
+	// GetUserInfoByUsername, GetUserInfoByID and GetUserInfoByEmail: return ErrUserNotFound if there is no such user
+	GetUserInfoByUsername(username string) (*models.UserInfo, error)
+	GetUserInfoByID(userID int) (*models.UserInfo, error)
+	GetUserInfoByEmail(email string) (*models.UserInfo, error)
+	SetEmailValidated(userID int, email string) error
+	UpdateUserEmail(userID int, email string) error
+	EmailInUse(email string, userID int) (bool, error)
+	UpdateUserProfile(userID int, phone *string, profile *models.UserProfile) error
+	UpdateUserPassword(userID int, encodedPwd string) error
+	LockUser(userID int, until *time.Time) error
+	UnlockUser(userID int) (bool, error)
+	// DeleteUser: deletes the files of the user by cascade, returns the paths of the files no one references anymore
+	DeleteUser(userID int) ([]string, error)
+}
+
+// FileRepository: the stored files, deduplicated by their hash, and the files of the users referencing them
+type FileRepository interface {
+	// SaveFileMeta: returns ErrFileExists if a file with the same hash is stored
+	SaveFileMeta(fileHash string, fileName string, fileSize int64, filePath string) (int, error)
+	// GetFileMeta: returns ErrFileNotFound if there is no such file
+	GetFileMeta(fileID int) (*models.FileMeta, error)
+	UpdateFileMeta(fileID int, updateReq models.UpdateFileMetaRequest) error
+	FileExists(fileHash string) (bool, int, error)
+
+	GetUserFiles(userID int) ([]models.FileInfo, error)
+	UserFileExists(userID int, fileID int) (bool, error)
+	// SaveUserFile: adds a reference to the file and charges the quota of the user,
+	// returns ErrQuotaExceeded if the file does not fit and ErrUserFileExists if the user has the file
+	SaveUserFile(userID int, fileID int, fileName string) error
+	// SaveNewUserFile: saves the uploaded file with the reference of the user, neither is saved if the file
+	// does not fit the quota, returns ErrFileExists if a file with the same hash is stored
+	SaveNewUserFile(userID int, fileHash string, fileName string, fileSize int64, filePath string) (int, error)
+	// DeleteUserFile: removes a reference to the file, the file is deleted with its last reference,
+	// returns true and the path of the file if it is deleted
+	DeleteUserFile(userID int, fileID int) (bool, string, error)
+
+	GetUserQuota(userID int) (*models.UserQuota, error)
+	CheckUserQuota(userID int, size int64) error
+}
+
+var (
+	_ UserRepository = (*DB)(nil)
+	_ FileRepository = (*DB)(nil)
+)
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/models"
+)
+
+// SaveShareLink: save the share link to the database
+func (d *DB) SaveShareLink(link *models.ShareLink) (int, error) {
+	query := "INSERT INTO tbl_share_link (user_id, file_id, folder_id, token, password, expire_at, max_downloads) VALUES (?, ?, ?, ?, ?, ?, ?)"
+
+	linkID, err := d.db.insert(query, link.UserID, nullableID(link.FileID), nullableID(link.FolderID), link.Token, link.Password, link.ExpireAt, link.MaxDownloads)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return int(linkID), nil
+}
+
+// the share links of files are named by the user files, the ones of folders by the folders
+const shareLinkColumns = `s.id, s.user_id, s.file_id, uf.file_name, s.folder_id, fo.name, s.token, s.password, s.expire_at,
+	s.max_downloads, s.download_count, s.status, s.create_at
+	FROM tbl_share_link s
+	LEFT JOIN tbl_user_file uf ON uf.user_id = s.user_id AND uf.file_id = s.file_id
+	LEFT JOIN tbl_folder fo ON fo.id = s.folder_id`
+
+// scanShareLink: scan the share link from the row
+func scanShareLink(scanner interface{ Scan(...interface{}) error }) (*models.ShareLink, error) {
+	link := &models.ShareLink{}
+	var fileID, folderID sql.NullInt64
+	var fileName, folderName sql.NullString
+	var expireAt sql.NullTime
+	err := scanner.Scan(&link.LinkID, &link.UserID, &fileID, &fileName, &folderID, &folderName, &link.Token, &link.Password,
+		&expireAt, &link.MaxDownloads, &link.DownloadCount, &link.Status, &link.CreateAt)
+	if err != nil {
+		return nil, err
+	}
+	link.FileID, link.FileName = int(fileID.Int64), fileName.String
+	link.FolderID, link.FolderName = int(folderID.Int64), folderName.String
+	if expireAt.Valid {
+		link.ExpireAt = &expireAt.Time
+	}
+	link.HasPassword = link.Password != ""
+	return link, nil
+}
+
+// GetShareLinkByToken: get the share link by its token
+func (d *DB) GetShareLinkByToken(token string) (*models.ShareLink, error) {
+	query := `SELECT ` + shareLinkColumns + `
+	WHERE s.token = ?`
+
+	link, err := scanShareLink(d.db.QueryRow(query, token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return link, nil
+}
+
+// GetUserShareLinks: get the share links created by the user
+func (d *DB) GetUserShareLinks(userID int) ([]models.ShareLink, error) {
+	query := `SELECT ` + shareLinkColumns + `
+	WHERE s.user_id = ?
+	ORDER BY s.create_at DESC`
+
+	rows, err := d.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	defer rows.Close()
+
+	var links []models.ShareLink
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan the row: %v", err.Error())
+		}
+		links = append(links, *link)
+	}
+	return links, nil
+}
+
+// RevokeShareLink: revoke the share link of the user
+func (d *DB) RevokeShareLink(userID int, linkID int) (bool, error) {
+	query := "UPDATE tbl_share_link SET status = 'revoked' WHERE id = ? AND user_id = ?"
+
+	result, err := d.db.Exec(query, linkID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get the affected rows: %v", err.Error())
+	}
+	return affected > 0, nil
+}
+
+// ConsumeShareDownload: count a download of the share link if it is still usable
+func (d *DB) ConsumeShareDownload(linkID int) (bool, error) {
+	query := `UPDATE tbl_share_link SET download_count = download_count + 1
+	WHERE id = ? AND status = 'active'
+	AND (max_downloads = 0 OR download_count < max_downloads)
+	AND (expire_at IS NULL OR expire_at > ?)`
+
+	result, err := d.db.Exec(query, linkID, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get the affected rows: %v", err.Error())
+	}
+	return affected > 0, nil
+}
+
+// SaveShareAccessLog: save the access log of the share link
+func (d *DB) SaveShareAccessLog(accessLog *models.ShareAccessLog) error {
+	query := "INSERT INTO tbl_share_access_log (link_id, ip, user_agent, action, result) VALUES (?, ?, ?, ?, ?)"
+
+	if _, err := d.db.Exec(query, accessLog.LinkID, accessLog.IP, accessLog.Agent, accessLog.Action, accessLog.Result); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
+// GetShareAccessLogs: get the latest access logs of the share link owned by the user
+func (d *DB) GetShareAccessLogs(userID int, linkID int, limit int) ([]models.ShareAccessLog, error) {
+	query := `SELECT l.link_id, l.ip, l.user_agent, l.action, l.result, l.access_at
+	FROM tbl_share_access_log l
+	JOIN tbl_share_link s ON s.id = l.link_id
+	WHERE l.link_id = ? AND s.user_id = ?
+	ORDER BY l.id DESC
+	LIMIT ?`
+
+	rows, err := d.db.Query(query, linkID, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	defer rows.Close()
+
+	var logs []models.ShareAccessLog
+	for rows.Next() {
+		accessLog := models.ShareAccessLog{}
+		if err := rows.Scan(&accessLog.LinkID, &accessLog.IP, &accessLog.Agent, &accessLog.Action, &accessLog.Result, &accessLog.AccessAt); err != nil {
+			return nil, fmt.Errorf("failed to scan the row: %v", err.Error())
+		}
+		logs = append(logs, accessLog)
+	}
+	return logs, nil
+}
@@ -0,0 +1,402 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/bladewaltz9/file-store-server/models"
+)
+
+// CreateTeam: create the team and add the creator as the owner
+func (d *DB) CreateTeam(name string, ownerID int) (int, error) {
+	// Begin the transaction
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin the transaction: %v", err.Error())
+	}
+	defer tx.Rollback()
+
+	teamID, err := tx.insert("INSERT INTO tbl_team (name, owner_id) VALUES (?, ?)", name, ownerID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+
+	if _, err := tx.Exec("INSERT INTO tbl_team_member (team_id, user_id, role) VALUES (?, ?, ?)", teamID, ownerID, models.RoleOwner); err != nil {
+		return 0, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+	return int(teamID), nil
+}
+
+// GetUserTeams: get the teams the user belongs to, with the role of the user
+func (d *DB) GetUserTeams(userID int) ([]models.TeamInfo, error) {
+	query := `SELECT t.id, t.name, t.owner_id, m.role, t.create_at
+	FROM tbl_team t
+	JOIN tbl_team_member m ON m.team_id = t.id
+	WHERE m.user_id = ?
+	ORDER BY t.name`
+
+	rows, err := d.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	defer rows.Close()
+
+	var teams []models.TeamInfo
+	for rows.Next() {
+		team := models.TeamInfo{}
+		if err := rows.Scan(&team.TeamID, &team.Name, &team.OwnerID, &team.Role, &team.CreateAt); err != nil {
+			return nil, fmt.Errorf("failed to scan the row: %v", err.Error())
+		}
+		teams = append(teams, team)
+	}
+	return teams, nil
+}
+
+// GetTeamRole: get the role of the user in the team, empty if the user is not a member
+func (d *DB) GetTeamRole(teamID int, userID int) (string, error) {
+	var role string
+	err := d.db.QueryRow("SELECT role FROM tbl_team_member WHERE team_id = ? AND user_id = ?", teamID, userID).Scan(&role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return role, nil
+}
+
+// GetTeamMembers: get the members of the team
+func (d *DB) GetTeamMembers(teamID int) ([]models.TeamMember, error) {
+	query := `SELECT m.team_id, m.user_id, u.username, m.role, m.join_at
+	FROM tbl_team_member m
+	JOIN tbl_user u ON u.id = m.user_id
+	WHERE m.team_id = ?
+	ORDER BY m.join_at`
+
+	rows, err := d.db.Query(query, teamID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	defer rows.Close()
+
+	var members []models.TeamMember
+	for rows.Next() {
+		member := models.TeamMember{}
+		if err := rows.Scan(&member.TeamID, &member.UserID, &member.Username, &member.Role, &member.JoinAt); err != nil {
+			return nil, fmt.Errorf("failed to scan the row: %v", err.Error())
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// UpdateTeamMemberRole: change the role of the team member
+func (d *DB) UpdateTeamMemberRole(teamID int, userID int, role string) error {
+	if _, err := d.db.Exec("UPDATE tbl_team_member SET role = ? WHERE team_id = ? AND user_id = ?", role, teamID, userID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
+// RemoveTeamMember: remove the member from the team, the files uploaded by the member stay in the team
+func (d *DB) RemoveTeamMember(teamID int, userID int) error {
+	if _, err := d.db.Exec("DELETE FROM tbl_team_member WHERE team_id = ? AND user_id = ?", teamID, userID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
+// SaveTeamInvite: save the invitation of the user to the team
+func (d *DB) SaveTeamInvite(teamID int, inviterID int, inviteeID int, role string) (int, error) {
+	query := "INSERT INTO tbl_team_invite (team_id, inviter_id, invitee_id, role) VALUES (?, ?, ?, ?)"
+
+	inviteID, err := d.db.insert(query, teamID, inviterID, inviteeID, role)
+	if err != nil {
+		return 0, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return int(inviteID), nil
+}
+
+// GetPendingInvites: get the pending invitations of the user
+func (d *DB) GetPendingInvites(userID int) ([]models.TeamInvite, error) {
+	query := `SELECT i.id, i.team_id, t.name, i.inviter_id, u.username, i.invitee_id, i.role, i.status, i.create_at
+	FROM tbl_team_invite i
+	JOIN tbl_team t ON t.id = i.team_id
+	JOIN tbl_user u ON u.id = i.inviter_id
+	WHERE i.invitee_id = ? AND i.status = 'pending'
+	ORDER BY i.create_at DESC`
+
+	rows, err := d.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	defer rows.Close()
+
+	var invites []models.TeamInvite
+	for rows.Next() {
+		invite := models.TeamInvite{}
+		if err := rows.Scan(&invite.InviteID, &invite.TeamID, &invite.TeamName, &invite.InviterID, &invite.InviterName,
+			&invite.InviteeID, &invite.Role, &invite.Status, &invite.CreateAt); err != nil {
+			return nil, fmt.Errorf("failed to scan the row: %v", err.Error())
+		}
+		invites = append(invites, invite)
+	}
+	return invites, nil
+}
+
+// RespondTeamInvite: accept or decline the pending invitation of the user,
+// the user joins the team with the invited role if accepted
+func (d *DB) RespondTeamInvite(inviteID int, userID int, accept bool) (bool, error) {
+	// Begin the transaction
+	tx, err := d.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin the transaction: %v", err.Error())
+	}
+	defer tx.Rollback()
+
+	var teamID int
+	var role string
+	query := "SELECT team_id, role FROM tbl_team_invite WHERE id = ? AND invitee_id = ? AND status = 'pending'" + tx.dialect.forUpdate("tbl_team_invite")
+	err = tx.QueryRow(query, inviteID, userID).Scan(&teamID, &role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+
+	status := "declined"
+	if accept {
+		status = "accepted"
+		query := tx.dialect.insertIgnore("INSERT INTO tbl_team_member (team_id, user_id, role) VALUES (?, ?, ?)")
+		if _, err := tx.Exec(query, teamID, userID, role); err != nil {
+			return false, fmt.Errorf("failed to execute the query: %v", err.Error())
+		}
+	}
+	if _, err := tx.Exec("UPDATE tbl_team_invite SET status = ? WHERE id = ?", status, inviteID); err != nil {
+		return false, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+	return true, nil
+}
+
+// DeleteTeam: delete the team with its members, folders and files,
+// returns the paths of the files whose reference count drops to 0
+func (d *DB) DeleteTeam(teamID int) ([]string, error) {
+	// Begin the transaction
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin the transaction: %v", err.Error())
+	}
+	defer tx.Rollback()
+
+	removedPaths, err := deleteTeam(tx, teamID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+	return removedPaths, nil
+}
+
+// deleteTeam: delete the team and release its files in the transaction, returns the paths of the files to remove
+func deleteTeam(tx *txConn, teamID int) ([]string, error) {
+	fileIDs, err := queryIDs(tx, "SELECT file_id FROM tbl_user_file WHERE team_id = ?", teamID)
+	if err != nil {
+		return nil, err
+	}
+	if err := releaseFileQuota(tx, "uf.team_id = ?", teamID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM tbl_user_file WHERE team_id = ?", teamID); err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+
+	// Release the files of the team
+	var removedPaths []string
+	for _, fileID := range fileIDs {
+		released, filePath, err := releaseFile(tx, fileID)
+		if err != nil {
+			return nil, err
+		}
+		if released {
+			removedPaths = append(removedPaths, filePath)
+		}
+	}
+
+	// Delete the team, the members, invitations and folders are deleted by cascade
+	if _, err := tx.Exec("DELETE FROM tbl_team WHERE id = ?", teamID); err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return removedPaths, nil
+}
+
+// queryIDs: query a single int column in the transaction
+func queryIDs(tx *txConn, query string, args ...interface{}) ([]int, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan the row: %v", err.Error())
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// TeamFileExists: check if the file exists in the team
+func (d *DB) TeamFileExists(teamID int, fileID int) (bool, error) {
+	var id int
+	err := d.db.QueryRow("SELECT id FROM tbl_user_file WHERE team_id = ? AND file_id = ?", teamID, fileID).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return true, nil
+}
+
+// SaveTeamFile: save the team file relationship to the database, the file is counted against the quota of the uploader,
+// returns ErrQuotaExceeded if it does not fit
+func (d *DB) SaveTeamFile(teamID int, uploaderID int, fileID int, fileName string) error {
+	// Begin the transaction
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin the transaction: %v", err.Error())
+	}
+	defer tx.Rollback()
+
+	if err := saveTeamFile(tx, teamID, uploaderID, fileID, fileName); err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+	return nil
+}
+
+// SaveNewTeamFile: save the uploaded file and the team file relationship in one transaction, so the file
+// is not kept if it does not fit in the quota of the uploader, returns the id of the file
+func (d *DB) SaveNewTeamFile(teamID int, uploaderID int, fileHash string, fileName string, fileSize int64, filePath string) (int, error) {
+	// Begin the transaction
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin the transaction: %v", err.Error())
+	}
+	defer tx.Rollback()
+
+	fileID, err := saveFileMeta(tx, tx.dialect, fileHash, fileName, fileSize, filePath)
+	if err != nil {
+		return 0, err
+	}
+	if err := saveTeamFile(tx, teamID, uploaderID, fileID, fileName); err != nil {
+		return 0, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+	return fileID, nil
+}
+
+// saveTeamFile: save the team file relationship in the transaction, charge the quota of the uploader
+// and count the reference to the file
+func saveTeamFile(tx *txConn, teamID int, uploaderID int, fileID int, fileName string) error {
+	// Save the team file relationship
+	queryInsert := "INSERT INTO tbl_user_file (team_id, uploader_id, file_id, file_name) VALUES (?, ?, ?, ?)"
+	if _, err := tx.Exec(queryInsert, teamID, uploaderID, fileID, fileName); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+
+	// Count the file against the quota of the uploader
+	if err := reserveQuota(tx, uploaderID, fileID); err != nil {
+		return err
+	}
+
+	// Update the reference count
+	if _, err := tx.Exec("UPDATE tbl_file SET reference_count = reference_count + 1 WHERE id = ?", fileID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
+// DeleteTeamFile: delete the team file relationship and delete the file if the reference count is 0
+func (d *DB) DeleteTeamFile(teamID int, fileID int) (bool, string, error) {
+	// Begin the transaction
+	tx, err := d.db.Begin()
+	if err != nil {
+		return false, "", fmt.Errorf("failed to begin the transaction: %v", err.Error())
+	}
+	defer tx.Rollback()
+
+	// Remove the file from the quota usage of the uploader
+	if err := releaseFileQuota(tx, "uf.team_id = ? AND uf.file_id = ?", teamID, fileID); err != nil {
+		return false, "", err
+	}
+
+	result, err := tx.Exec("DELETE FROM tbl_user_file WHERE team_id = ? AND file_id = ?", teamID, fileID)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return false, "", fmt.Errorf("file %d does not belong to the team", fileID)
+	}
+
+	// Release the file
+	released, filePath, err := releaseFile(tx, fileID)
+	if err != nil {
+		return false, "", err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return false, "", fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+	return released, filePath, nil
+}
+
+// GetTeamFileRoles: get the roles of the user in the teams holding the file
+func (d *DB) GetTeamFileRoles(userID int, fileID int) ([]string, error) {
+	query := `SELECT m.role
+	FROM tbl_user_file uf
+	JOIN tbl_team_member m ON m.team_id = uf.team_id
+	WHERE uf.file_id = ? AND m.user_id = ?`
+
+	rows, err := d.db.Query(query, fileID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, fmt.Errorf("failed to scan the row: %v", err.Error())
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/models"
+)
+
+// SaveTOTPSecret: save the new secret of the user, not enabled until a code is confirmed
+func (d *DB) SaveTOTPSecret(userID int, secret string) error {
+	query := "INSERT INTO tbl_user_totp (user_id, secret) VALUES (?, ?)" + d.db.dialect.upsert("user_id", "secret", "enabled = 0", "last_step = 0")
+
+	if _, err := d.db.Exec(query, userID, secret); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
+// GetTOTP: get the TOTP second factor of the user, nil if the user has none
+func (d *DB) GetTOTP(userID int) (*models.UserTOTP, error) {
+	query := "SELECT user_id, secret, enabled, last_step FROM tbl_user_totp WHERE user_id = ?"
+
+	userTOTP := &models.UserTOTP{}
+	err := d.db.QueryRow(query, userID).Scan(&userTOTP.UserID, &userTOTP.Secret, &userTOTP.Enabled, &userTOTP.LastStep)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return userTOTP, nil
+}
+
+// UseTOTPStep: record the time step of the accepted code, returns false if a code of this or a later step was used,
+// so concurrent requests can not replay the same code
+func (d *DB) UseTOTPStep(userID int, step int64) (bool, error) {
+	result, err := d.db.Exec("UPDATE tbl_user_totp SET last_step = ? WHERE user_id = ? AND last_step < ?", step, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get the affected rows: %v", err.Error())
+	}
+	return affected > 0, nil
+}
+
+// EnableTOTP: enable the second factor of the user and replace the recovery codes
+func (d *DB) EnableTOTP(userID int, codeHashes []string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin the transaction: %v", err.Error())
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE tbl_user_totp SET enabled = 1 WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+	return nil
+}
+
+// SaveRecoveryCodes: replace the recovery codes of the user
+func (d *DB) SaveRecoveryCodes(userID int, codeHashes []string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin the transaction: %v", err.Error())
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+	return nil
+}
+
+// replaceRecoveryCodes: delete the old recovery codes of the user and save the new ones
+func replaceRecoveryCodes(tx *txConn, userID int, codeHashes []string) error {
+	if _, err := tx.Exec("DELETE FROM tbl_user_recovery_code WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	for _, codeHash := range codeHashes {
+		if _, err := tx.Exec("INSERT INTO tbl_user_recovery_code (user_id, code_hash) VALUES (?, ?)", userID, codeHash); err != nil {
+			return fmt.Errorf("failed to execute the query: %v", err.Error())
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode: mark the unused recovery code as used, returns false if it is unknown or used
+func (d *DB) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	query := "UPDATE tbl_user_recovery_code SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL"
+
+	result, err := d.db.Exec(query, time.Now(), userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get the affected rows: %v", err.Error())
+	}
+	return affected > 0, nil
+}
+
+// DeleteTOTP: remove the second factor and the recovery codes of the user
+func (d *DB) DeleteTOTP(userID int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin the transaction: %v", err.Error())
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM tbl_user_recovery_code WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	if _, err := tx.Exec("DELETE FROM tbl_user_totp WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+	return nil
+}
@@ -2,54 +2,170 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/bladewaltz9/file-store-server/models"
 )
 
 // SaveUserInfo: save the user information to the database
-func SaveUserInfo(username string, password string, email string) error {
+func (d *DB) SaveUserInfo(username string, password string, email string) error {
 	query := "INSERT INTO tbl_user (username, password, email) VALUES (?, ?, ?)"
 
-	stmt, err := db.Prepare(query)
+	stmt, err := d.db.Prepare(query)
 	if err != nil {
 		return fmt.Errorf("failed to prepare the query: %v", err.Error())
 	}
 	defer stmt.Close()
 
 	_, err = stmt.Exec(username, password, email)
+	if isDuplicateKey(err) {
+		return ErrUserExists
+	}
 	if err != nil {
 		return fmt.Errorf("failed to execute the query: %v", err.Error())
 	}
 	return nil
 }
 
+// ErrUserNotFound: the user does not exist
+var ErrUserNotFound = errors.New("user not found")
+
 // GetUserInfo: get the user information from the database
-func GetUserInfoByUsername(username string) (*models.UserInfo, error) {
-	query := "SELECT id, username, password, email FROM tbl_user WHERE username = ?"
+func (d *DB) GetUserInfoByUsername(username string) (*models.UserInfo, error) {
+	return d.queryUserInfo("username = ?", username)
+}
+
+// GetUserInfoByID: get the user information by the user id
+func (d *DB) GetUserInfoByID(userID int) (*models.UserInfo, error) {
+	return d.queryUserInfo("id = ?", userID)
+}
 
-	stmt, err := db.Prepare(query)
+// GetUserInfoByEmail: get the user information by the email
+func (d *DB) GetUserInfoByEmail(email string) (*models.UserInfo, error) {
+	return d.queryUserInfo("email = ? ORDER BY id LIMIT 1", email)
+}
+
+// queryUserInfo: get the user information matching the condition
+func (d *DB) queryUserInfo(condition string, args ...interface{}) (*models.UserInfo, error) {
+	query := `SELECT id, username, password, email, email_validated, COALESCE(phone, ''), COALESCE(phone_validated, 0),
+	role, status, locked_until, profile, signup_at, last_active FROM tbl_user WHERE ` + condition
+
+	stmt, err := d.db.Prepare(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare the query: %v", err.Error())
 	}
 	defer stmt.Close()
 
 	user := &models.UserInfo{}
-	err = stmt.QueryRow(username).Scan(&user.UserID, &user.Username, &user.Password, &user.Email)
+	var email sql.NullString
+	var lockedUntil sql.NullTime
+	var profile []byte
+	err = stmt.QueryRow(args...).Scan(&user.UserID, &user.Username, &user.Password, &email, &user.EmailValidated,
+		&user.Phone, &user.PhoneValidated, &user.Role, &user.Status, &lockedUntil, &profile, &user.SignupAt, &user.LastActive)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
 		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
 	}
+	user.Email = email.String
+	if lockedUntil.Valid {
+		user.LockedUntil = &lockedUntil.Time
+	}
+	if len(profile) > 0 {
+		if err := json.Unmarshal(profile, &user.Profile); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal the profile: %v", err.Error())
+		}
+	}
 	return user, nil
 }
 
+// SetEmailValidated: mark the email of the user as verified if it is still the email the token was mailed to
+func (d *DB) SetEmailValidated(userID int, email string) error {
+	if _, err := d.db.Exec("UPDATE tbl_user SET email_validated = 1 WHERE id = ? AND email = ?", userID, email); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
+// UpdateUserEmail: set the verified new email of the user
+func (d *DB) UpdateUserEmail(userID int, email string) error {
+	if _, err := d.db.Exec("UPDATE tbl_user SET email = ?, email_validated = 1 WHERE id = ?", email, userID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
+// EmailInUse: check if another user already has the email
+func (d *DB) EmailInUse(email string, userID int) (bool, error) {
+	var count int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM tbl_user WHERE email = ? AND id <> ?", email, userID).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return count > 0, nil
+}
+
+// UpdateUserProfile: update the phone and the profile of the user, the nil fields are unchanged,
+// the phone needs to be verified again once it changes
+func (d *DB) UpdateUserProfile(userID int, phone *string, profile *models.UserProfile) error {
+	if phone != nil {
+		query := "UPDATE tbl_user SET phone_validated = CASE WHEN phone = ? THEN phone_validated ELSE 0 END, phone = ? WHERE id = ?"
+		if _, err := d.db.Exec(query, *phone, *phone, userID); err != nil {
+			return fmt.Errorf("failed to execute the query: %v", err.Error())
+		}
+	}
+	if profile != nil {
+		data, err := json.Marshal(profile)
+		if err != nil {
+			return fmt.Errorf("failed to marshal the profile: %v", err.Error())
+		}
+		if _, err := d.db.Exec("UPDATE tbl_user SET profile = ? WHERE id = ?", data, userID); err != nil {
+			return fmt.Errorf("failed to execute the query: %v", err.Error())
+		}
+	}
+	return nil
+}
+
+// LockUser: lock the account until the time, or until an admin unlocks it if until is nil
+func (d *DB) LockUser(userID int, until *time.Time) error {
+	if _, err := d.db.Exec("UPDATE tbl_user SET status = 'locked', locked_until = ? WHERE id = ?", until, userID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
+// UnlockUser: unlock the locked account, returns false if the account is not locked
+func (d *DB) UnlockUser(userID int) (bool, error) {
+	result, err := d.db.Exec("UPDATE tbl_user SET status = 'active', locked_until = NULL WHERE id = ? AND status = 'locked'", userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get the affected rows: %v", err.Error())
+	}
+	return affected > 0, nil
+}
+
+// UpdateUserPassword: update the encoded password of the user
+func (d *DB) UpdateUserPassword(userID int, encodedPwd string) error {
+	if _, err := d.db.Exec("UPDATE tbl_user SET password = ? WHERE id = ?", encodedPwd, userID); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
 // GetUserFiles: get the user files from the database
-func GetUserFiles(user_id int) ([]models.FileInfo, error) {
-	query := `SELECT f.id, f.file_name, f.file_size, DATE_FORMAT(uf.upload_at, '%Y-%m-%d %H:%i'), uf.status 
+func (d *DB) GetUserFiles(user_id int) ([]models.FileInfo, error) {
+	query := `SELECT f.id, uf.file_name, f.file_size, uf.upload_at, uf.status 
 	FROM tbl_user_file uf
 	JOIN tbl_file f ON uf.file_id = f.id 
 	WHERE uf.user_id = ?;`
 
-	rows, err := db.Query(query, user_id)
+	rows, err := d.db.Query(query, user_id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
 	}
@@ -57,8 +173,8 @@ func GetUserFiles(user_id int) ([]models.FileInfo, error) {
 
 	var userFiles []models.FileInfo
 	for rows.Next() {
-		file := models.FileInfo{}
-		if err := rows.Scan(&file.FileID, &file.FileName, &file.FileSize, &file.UploadTime, &file.Status); err != nil {
+		file, err := scanFileInfo(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan the row: %v", err.Error())
 		}
 		userFiles = append(userFiles, file)
@@ -66,11 +182,22 @@ func GetUserFiles(user_id int) ([]models.FileInfo, error) {
 	return userFiles, nil
 }
 
+// scanFileInfo: scan the user file from the row of the id, name, size, upload time and status
+func scanFileInfo(scanner interface{ Scan(...interface{}) error }) (models.FileInfo, error) {
+	file := models.FileInfo{}
+	var uploadAt time.Time
+	if err := scanner.Scan(&file.FileID, &file.FileName, &file.FileSize, &uploadAt, &file.Status); err != nil {
+		return file, err
+	}
+	file.UploadTime = uploadAt.Format("2006-01-02 15:04")
+	return file, nil
+}
+
 // UserFileExists: check if the file exists in the tbl_user_file
-func UserFileExists(userID int, fileID int) (bool, error) {
+func (d *DB) UserFileExists(userID int, fileID int) (bool, error) {
 	query := "SELECT id FROM tbl_user_file WHERE user_id = ? AND file_id = ?"
 
-	stmt, err := db.Prepare(query)
+	stmt, err := d.db.Prepare(query)
 	if err != nil {
 		return false, fmt.Errorf("failed to prepare the query: %v", err.Error())
 	}
@@ -88,31 +215,74 @@ func UserFileExists(userID int, fileID int) (bool, error) {
 	return true, nil
 }
 
-// SaveUserFile: save the user file relationship to the database
-func SaveUserFile(userID int, fileID int, fileName string) error {
+// SaveUserFile: save the user file relationship to the database, returns ErrQuotaExceeded if the file does not fit the quota
+func (d *DB) SaveUserFile(userID int, fileID int, fileName string) error {
 	// Begin the transaction
-	tx, err := db.Begin()
+	tx, err := d.db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin the transaction: %v", err.Error())
 	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		}
-	}()
+	defer tx.Rollback() // no-op after the commit
+
+	if err := saveUserFile(tx, userID, fileID, fileName); err != nil {
+		return err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+
+	return nil
+}
 
+// SaveNewUserFile: save the uploaded file and the user file relationship in one transaction, so the file
+// is not kept if it does not fit in the quota, returns the id of the file
+func (d *DB) SaveNewUserFile(userID int, fileHash string, fileName string, fileSize int64, filePath string) (int, error) {
+	// Begin the transaction
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin the transaction: %v", err.Error())
+	}
+	defer tx.Rollback() // no-op after the commit
+
+	fileID, err := saveFileMeta(tx, tx.dialect, fileHash, fileName, fileSize, filePath)
+	if err != nil {
+		return 0, err
+	}
+	if err := saveUserFile(tx, userID, fileID, fileName); err != nil {
+		return 0, err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+	return fileID, nil
+}
+
+// saveUserFile: save the user file relationship in the transaction, charge the quota of the user
+// and count the reference to the file
+func saveUserFile(tx *txConn, userID int, fileID int, fileName string) error {
 	// Save the user file relationship
-	queryInsert := "INSERT INTO tbl_user_file (user_id, file_id, file_name) VALUES (?, ?, ?)"
+	queryInsert := "INSERT INTO tbl_user_file (user_id, uploader_id, file_id, file_name) VALUES (?, ?, ?, ?)"
 	stmtInsert, err := tx.Prepare(queryInsert)
 	if err != nil {
 		return fmt.Errorf("failed to prepare the query: %v", err.Error())
 	}
 	defer stmtInsert.Close()
 
-	if _, err := stmtInsert.Exec(userID, fileID, fileName); err != nil {
+	if _, err := stmtInsert.Exec(userID, userID, fileID, fileName); isDuplicateKey(err) {
+		return ErrUserFileExists
+	} else if err != nil {
 		return fmt.Errorf("failed to execute the query: %v", err.Error())
 	}
 
+	// Count the file against the quota of the user
+	if err := reserveQuota(tx, userID, fileID); err != nil {
+		return err
+	}
+
 	// Update the reference count
 	queryUpdate := "UPDATE tbl_file SET reference_count = reference_count + 1 WHERE id = ?"
 	stmtUpdate, err := tx.Prepare(queryUpdate)
@@ -124,27 +294,22 @@ func SaveUserFile(userID int, fileID int, fileName string) error {
 	if _, err := stmtUpdate.Exec(fileID); err != nil {
 		return fmt.Errorf("failed to execute the query: %v", err.Error())
 	}
-
-	// Commit the transaction
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit the transaction: %v", err.Error())
-	}
-
 	return nil
 }
 
 // DeleteUserFile: delete the user file relationship from the database and delete the file if the reference count is 0
-func DeleteUserFile(userID int, fileID int) (bool, string, error) {
+func (d *DB) DeleteUserFile(userID int, fileID int) (bool, string, error) {
 	// Begin the transaction
-	tx, err := db.Begin()
+	tx, err := d.db.Begin()
 	if err != nil {
 		return false, "", fmt.Errorf("failed to begin the transaction: %v", err.Error())
 	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		}
-	}()
+	defer tx.Rollback() // no-op after the commit
+
+	// Remove the file from the quota usage
+	if err := releaseFileQuota(tx, "uf.user_id = ? AND uf.file_id = ?", userID, fileID); err != nil {
+		return false, "", err
+	}
 
 	// Delete the user file relationship
 	queryDelete := "DELETE FROM tbl_user_file WHERE user_id = ? AND file_id = ?"
@@ -158,8 +323,77 @@ func DeleteUserFile(userID int, fileID int) (bool, string, error) {
 		return false, "", fmt.Errorf("failed to execute the query: %v", err.Error())
 	}
 
+	// Release the file
+	released, filePath, err := releaseFile(tx, fileID)
+	if err != nil {
+		return false, "", err
+	}
+
+	// Commit the transaction
+	if err := tx.Commit(); err != nil {
+		return false, "", fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+
+	return released, filePath, nil
+}
+
+// DeleteUser: delete the account with its files and the teams it owns, returns the paths of the files
+// no one references anymore, the tokens, keys, shares and memberships are deleted by cascade
+func (d *DB) DeleteUser(userID int) ([]string, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin the transaction: %v", err.Error())
+	}
+	defer tx.Rollback() // no-op after the commit
+
+	var removedPaths []string
+	teamIDs, err := queryIDs(tx, "SELECT id FROM tbl_team WHERE owner_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, teamID := range teamIDs {
+		paths, err := deleteTeam(tx, teamID)
+		if err != nil {
+			return nil, err
+		}
+		removedPaths = append(removedPaths, paths...)
+	}
+
+	// Release the personal files, one reference per user file
+	fileIDs, err := queryIDs(tx, "SELECT file_id FROM tbl_user_file WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := releaseFileQuota(tx, "uf.user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM tbl_user_file WHERE user_id = ?", userID); err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	for _, fileID := range fileIDs {
+		released, filePath, err := releaseFile(tx, fileID)
+		if err != nil {
+			return nil, err
+		}
+		if released {
+			removedPaths = append(removedPaths, filePath)
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM tbl_user WHERE id = ?", userID); err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+	return removedPaths, nil
+}
+
+// releaseFile: decrease the reference count of the file and delete it if the reference count is 0
+func releaseFile(tx *txConn, fileID int) (bool, string, error) {
 	// Update the reference count
-	queryUpdate := "UPDATE tbl_file SET reference_count = GREATEST(reference_count - 1, 0) WHERE id = ?"
+	queryUpdate := "UPDATE tbl_file SET reference_count = " + tx.dialect.greatest("reference_count - 1", "0") + " WHERE id = ?"
 	stmtUpdate, err := tx.Prepare(queryUpdate)
 	if err != nil {
 		return false, "", fmt.Errorf("failed to prepare the query: %v", err.Error())
@@ -192,10 +426,5 @@ func DeleteUserFile(userID int, fileID int) (bool, string, error) {
 		}
 	}
 
-	// Commit the transaction
-	if err := tx.Commit(); err != nil {
-		return false, "", fmt.Errorf("failed to commit the transaction: %v", err.Error())
-	}
-
 	return referenceCount == 0, filePath, nil
 }
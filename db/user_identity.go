@@ -0,0 +1,60 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/models"
+)
+
+// GetUserByIdentity: get the user linked to the identity of the provider, nil if it is not linked
+func (d *DB) GetUserByIdentity(issuer string, subject string) (*models.UserInfo, error) {
+	user, err := d.queryUserInfo("id = (SELECT user_id FROM tbl_user_identity WHERE issuer = ? AND subject = ?)", issuer, subject)
+	if err == ErrUserNotFound {
+		return nil, nil
+	}
+	return user, err
+}
+
+// LinkUserIdentity: link the identity of the provider to the user
+func (d *DB) LinkUserIdentity(userID int, issuer string, subject string, email string) error {
+	query := "INSERT INTO tbl_user_identity (user_id, issuer, subject, email, last_login_at) VALUES (?, ?, ?, ?, ?)"
+	if _, err := d.db.Exec(query, userID, issuer, subject, email, time.Now()); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
+// TouchUserIdentity: record the login with the identity and keep its email in sync with the provider
+func (d *DB) TouchUserIdentity(issuer string, subject string, email string) error {
+	query := "UPDATE tbl_user_identity SET email = ?, last_login_at = ? WHERE issuer = ? AND subject = ?"
+	if _, err := d.db.Exec(query, email, time.Now(), issuer, subject); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
+// ProvisionIdentityUser: create the account of the identity on its first login, the account has no password
+// until the user resets it, the email is verified if the provider verified it
+func (d *DB) ProvisionIdentityUser(username string, email string, emailVerified bool, issuer string, subject string) (int, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin the transaction: %v", err.Error())
+	}
+	defer tx.Rollback()
+
+	userID, err := tx.insert("INSERT INTO tbl_user (username, email, email_validated) VALUES (?, ?, ?)", username, email, emailVerified)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert the user: %v", err.Error())
+	}
+
+	query := "INSERT INTO tbl_user_identity (user_id, issuer, subject, email, last_login_at) VALUES (?, ?, ?, ?, ?)"
+	if _, err := tx.Exec(query, userID, issuer, subject, email, time.Now()); err != nil {
+		return 0, fmt.Errorf("failed to insert the identity: %v", err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+	return int(userID), nil
+}
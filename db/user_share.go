@@ -0,0 +1,167 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/models"
+)
+
+// SaveUserShare: share the user file with the grantee, an existing share is updated
+func (d *DB) SaveUserShare(share *models.UserShare) error {
+	query := "INSERT INTO tbl_user_share (owner_id, grantee_id, file_id, permission, expire_at) VALUES (?, ?, ?, ?, ?)" +
+		d.db.dialect.upsert("owner_id, grantee_id, file_id", "permission", "expire_at")
+
+	stmt, err := d.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("failed to prepare the query: %v", err.Error())
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(share.OwnerID, share.GranteeID, share.FileID, share.Permission, share.ExpireAt); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
+
+// DeleteUserShare: delete the share, both the owner and the grantee can delete it
+func (d *DB) DeleteUserShare(userID int, shareID int) (bool, error) {
+	query := "DELETE FROM tbl_user_share WHERE id = ? AND (owner_id = ? OR grantee_id = ?)"
+
+	result, err := d.db.Exec(query, shareID, userID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get the affected rows: %v", err.Error())
+	}
+	return affected > 0, nil
+}
+
+const userShareQuery = `SELECT s.id, s.owner_id, o.username, s.grantee_id, g.username, s.file_id, uf.file_name, f.file_size, s.permission, s.expire_at, s.create_at
+	FROM tbl_user_share s
+	JOIN tbl_user o ON o.id = s.owner_id
+	JOIN tbl_user g ON g.id = s.grantee_id
+	JOIN tbl_user_file uf ON uf.user_id = s.owner_id AND uf.file_id = s.file_id
+	JOIN tbl_file f ON f.id = s.file_id`
+
+// GetSharedWithUser: get the unexpired files shared with the user
+func (d *DB) GetSharedWithUser(userID int) ([]models.UserShare, error) {
+	return d.queryUserShares(userShareQuery+` WHERE s.grantee_id = ? AND (s.expire_at IS NULL OR s.expire_at > ?) ORDER BY s.create_at DESC`, userID, time.Now())
+}
+
+// GetSharedByUser: get the files the user shared with others
+func (d *DB) GetSharedByUser(userID int) ([]models.UserShare, error) {
+	return d.queryUserShares(userShareQuery+` WHERE s.owner_id = ? ORDER BY s.create_at DESC`, userID)
+}
+
+// queryUserShares: query the shares with the arguments
+func (d *DB) queryUserShares(query string, args ...interface{}) ([]models.UserShare, error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	defer rows.Close()
+
+	var shares []models.UserShare
+	for rows.Next() {
+		share := models.UserShare{}
+		var expireAt sql.NullTime
+		if err := rows.Scan(&share.ShareID, &share.OwnerID, &share.OwnerName, &share.GranteeID, &share.GranteeName,
+			&share.FileID, &share.FileName, &share.FileSize, &share.Permission, &expireAt, &share.CreateAt); err != nil {
+			return nil, fmt.Errorf("failed to scan the row: %v", err.Error())
+		}
+		if expireAt.Valid {
+			share.ExpireAt = &expireAt.Time
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+// GetSharePermission: get the unexpired permission the owner granted to the grantee on the file,
+// empty if nothing is shared
+func (d *DB) GetSharePermission(ownerID int, granteeID int, fileID int) (string, error) {
+	query := `SELECT permission FROM tbl_user_share
+	WHERE owner_id = ? AND grantee_id = ? AND file_id = ? AND (expire_at IS NULL OR expire_at > ?)`
+
+	var permission string
+	err := d.db.QueryRow(query, ownerID, granteeID, fileID, time.Now()).Scan(&permission)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return permission, nil
+}
+
+// GetFileAccess: get the highest permission of the user on the file,
+// "owner" if the user owns it, the permission from the shares and the team roles otherwise, empty if none
+func (d *DB) GetFileAccess(userID int, fileID int) (string, error) {
+	owned, err := d.UserFileExists(userID, fileID)
+	if err != nil {
+		return "", err
+	}
+	if owned {
+		return models.PermissionOwner, nil
+	}
+
+	// the team editors and above can write the team files, the viewers can read them
+	roles, err := d.GetTeamFileRoles(userID, fileID)
+	if err != nil {
+		return "", err
+	}
+	permission := ""
+	for _, role := range roles {
+		if role == models.RoleViewer {
+			permission = models.PermissionRead
+		} else {
+			return models.PermissionWrite, nil
+		}
+	}
+
+	query := `SELECT permission FROM tbl_user_share
+	WHERE grantee_id = ? AND file_id = ? AND (expire_at IS NULL OR expire_at > ?)
+	ORDER BY permission = 'write' DESC
+	LIMIT 1`
+
+	var shared string
+	err = d.db.QueryRow(query, userID, fileID, time.Now()).Scan(&shared)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return permission, nil
+		}
+		return "", fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return shared, nil
+}
+
+// UpdateUserFileMeta: update the name and the status of the file as its holders see it, the stored
+// file shared by all of them is kept. The file of the user is updated if the user has it, otherwise
+// the team files the user can edit and the files shared with the user for writing
+func (d *DB) UpdateUserFileMeta(userID int, fileID int, updateReq models.UpdateFileMetaRequest) error {
+	owned, err := d.UserFileExists(userID, fileID)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE tbl_user_file SET file_name = ?, status = ? WHERE user_id = ? AND file_id = ?"
+	args := []interface{}{updateReq.FileName, updateReq.Status, userID, fileID}
+	if !owned {
+		query = `UPDATE tbl_user_file SET file_name = ?, status = ?
+		WHERE file_id = ? AND (
+			team_id IN (SELECT team_id FROM tbl_team_member WHERE user_id = ? AND role <> ?)
+			OR user_id IN (SELECT owner_id FROM tbl_user_share
+				WHERE grantee_id = ? AND file_id = ? AND permission = ? AND (expire_at IS NULL OR expire_at > ?)))`
+		args = []interface{}{updateReq.FileName, updateReq.Status, fileID,
+			userID, models.RoleViewer, userID, fileID, models.PermissionWrite, time.Now()}
+	}
+
+	if _, err := d.db.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SaveUserToken: save the hash of the single-use token mailed to the email, the unused tokens of the same purpose are invalidated
+func (d *DB) SaveUserToken(userID int, purpose string, email string, tokenHash string, expireAt time.Time) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin the transaction: %v", err.Error())
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM tbl_user_token WHERE user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	query := "INSERT INTO tbl_user_token (user_id, purpose, email, token_hash, expire_at) VALUES (?, ?, ?, ?, ?)"
+	if _, err := tx.Exec(query, userID, purpose, email, tokenHash, expireAt); err != nil {
+		return fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+	return nil
+}
+
+// ConsumeUserToken: mark the unexpired token as used and get its user and the email it was mailed to,
+// returns 0 if the token is invalid
+func (d *DB) ConsumeUserToken(purpose string, tokenHash string) (int, string, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to begin the transaction: %v", err.Error())
+	}
+	defer tx.Rollback()
+
+	query := `SELECT id, user_id, email FROM tbl_user_token
+	WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expire_at > ?` + tx.dialect.forUpdate("tbl_user_token")
+	var tokenID, userID int
+	var email string
+	if err := tx.QueryRow(query, tokenHash, purpose, time.Now()).Scan(&tokenID, &userID, &email); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", nil
+		}
+		return 0, "", fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+	if _, err := tx.Exec("UPDATE tbl_user_token SET used_at = ? WHERE id = ?", time.Now(), tokenID); err != nil {
+		return 0, "", fmt.Errorf("failed to execute the query: %v", err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, "", fmt.Errorf("failed to commit the transaction: %v", err.Error())
+	}
+	return userID, email, nil
+}
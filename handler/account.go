@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/db"
+	"github.com/bladewaltz9/file-store-server/mail"
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// errMailRateLimited: too many mails were sent to the user or from the client
+var errMailRateLimited = errors.New("too many requests, please try again later")
+
+// allowMail: count a mail of the purpose against the limit of the recipient key and the limit
+// of the client ip, returns errMailRateLimited once one of them is reached
+func (s *Server) allowMail(r *http.Request, purpose string, recipient string) error {
+	limits := []struct {
+		key   string
+		limit int
+	}{
+		{fmt.Sprintf("mail:%s:%s", purpose, recipient), config.MailRateLimit},
+		{"mail:ip:" + utils.ClientIP(r), config.MailRateLimitPerIP},
+	}
+	for _, l := range limits {
+		allowed, err := s.rdb(r.Context()).AllowRate(l.key, l.limit, config.MailRateLimitWindow)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return errMailRateLimited
+		}
+	}
+	return nil
+}
+
+// sendUserToken: generate a single-use token for the user and mail the link of the purpose,
+// rate limited per user and per client ip
+func (s *Server) sendUserToken(r *http.Request, user *models.UserInfo, purpose string) error {
+	if user.Email == "" {
+		return fmt.Errorf("the user %d has no email", user.UserID)
+	}
+	if err := s.allowMail(r, purpose, fmt.Sprintf("user:%d", user.UserID)); err != nil {
+		return err
+	}
+	return s.mailUserToken(r, user, purpose)
+}
+
+// mailUserToken: generate a single-use token for the user and mail the link of the purpose,
+// the caller applies the rate limits
+func (s *Server) mailUserToken(r *http.Request, user *models.UserInfo, purpose string) error {
+	token, err := utils.GenerateToken(config.EmailTokenBytes)
+	if err != nil {
+		return err
+	}
+
+	msg := &mail.Message{To: user.Email}
+	expireTime := config.EmailVerifyExpireTime
+	switch purpose {
+	case models.TokenVerifyEmail:
+		msg.Subject = "Verify your email"
+		msg.Body = fmt.Sprintf("Hi %s,\n\nPlease verify your email by opening the link below:\n\n%s/user/verify?token=%s\n\nThe link expires in %v.\n",
+			user.Username, s.cfg.Server.PublicBaseURL, url.QueryEscape(token), expireTime)
+	case models.TokenResetPassword:
+		expireTime = config.PasswordResetExpireTime
+		msg.Subject = "Reset your password"
+		msg.Body = fmt.Sprintf("Hi %s,\n\nOpen the link below to reset your password:\n\n%s/user/password/reset?token=%s\n\nThe link expires in %v. If you did not request it, you can ignore this mail.\n",
+			user.Username, s.cfg.Server.PublicBaseURL, url.QueryEscape(token), expireTime)
+	case models.TokenChangeEmail:
+		msg.Subject = "Confirm your new email"
+		msg.Body = fmt.Sprintf("Hi %s,\n\nPlease confirm your new email by opening the link below:\n\n%s/user/email/confirm?token=%s\n\nThe link expires in %v. If you did not request it, you can ignore this mail.\n",
+			user.Username, s.cfg.Server.PublicBaseURL, url.QueryEscape(token), expireTime)
+	default:
+		return fmt.Errorf("unknown token purpose: %s", purpose)
+	}
+
+	if err := s.store(r.Context()).SaveUserToken(user.UserID, purpose, user.Email, utils.HashToken(token), time.Now().Add(expireTime)); err != nil {
+		return err
+	}
+	return s.mailer.Send(msg)
+}
+
+// EmailVerifySendHandler: handles the request to send the verification mail again
+func (s *Server) EmailVerifySendHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	user, err := s.userStore(r.Context()).GetUserInfoByID(userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get user", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get user")
+		return
+	}
+	if user.EmailValidated {
+		utils.WriteJSONResponse(w, http.StatusOK, "success", "email already verified")
+		return
+	}
+	if user.Email == "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "no email to verify")
+		return
+	}
+
+	if err := s.sendUserToken(r, user, models.TokenVerifyEmail); err != nil {
+		if errors.Is(err, errMailRateLimited) {
+			utils.WriteJSONResponse(w, http.StatusTooManyRequests, "error", err.Error())
+			return
+		}
+		slog.ErrorContext(r.Context(), "failed to send verification mail", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to send verification mail")
+		return
+	}
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "verification mail sent")
+}
+
+// EmailVerifyHandler: handles the verification link, e.g. /user/verify?token=xxx
+func (s *Server) EmailVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, email, err := s.store(r.Context()).ConsumeUserToken(models.TokenVerifyEmail, utils.HashToken(r.FormValue("token")))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to verify token", "error", err)
+		http.Error(w, "failed to verify email", http.StatusInternalServerError)
+		return
+	}
+	if userID == 0 {
+		http.Error(w, "the link is invalid or expired", http.StatusBadRequest)
+		return
+	}
+	// the link only verifies the email it was mailed to
+	if err := s.userStore(r.Context()).SetEmailValidated(userID, email); err != nil {
+		slog.ErrorContext(r.Context(), "failed to verify email", "error", err)
+		http.Error(w, "failed to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard", http.StatusFound)
+}
+
+// PasswordForgotHandler: handles the request to mail a password reset link, the requests are
+// rate limited per email and per client ip before the account is looked up, and the mail is sent
+// in the background, so neither the response nor its timing tells if the account exists
+func (s *Server) PasswordForgotHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		http.ServeFile(w, r, "static/view/password_forgot.html")
+		return
+	}
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	if email == "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+	if err := s.allowMail(r, models.TokenResetPassword, "email:"+utils.HashToken(strings.ToLower(email))); err != nil {
+		if errors.Is(err, errMailRateLimited) {
+			utils.WriteJSONResponse(w, http.StatusTooManyRequests, "error", err.Error())
+			return
+		}
+		slog.ErrorContext(r.Context(), "failed to check the mail rate", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to send password reset mail")
+		return
+	}
+
+	// the request is done before the mail is sent, keep its log fields without its cancellation
+	r = r.WithContext(context.WithoutCancel(r.Context()))
+	s.goBackground(func() {
+		user, err := s.userStore(r.Context()).GetUserInfoByEmail(email)
+		if errors.Is(err, db.ErrUserNotFound) || (err == nil && user.Email == "") {
+			return
+		}
+		if err == nil {
+			err = s.mailUserToken(r, user, models.TokenResetPassword)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to send password reset mail", "error", err)
+		}
+	})
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "if the email is registered, a reset link has been sent")
+}
+
+// PasswordResetHandler: handles the request to set a new password with the reset token,
+// all sessions of the user are revoked
+func (s *Server) PasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		http.ServeFile(w, r, "static/view/password_reset.html")
+		return
+	}
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+
+	password := r.FormValue("password")
+	if len(password) < config.MinPasswordLength {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", fmt.Sprintf("the password must have at least %d characters", config.MinPasswordLength))
+		return
+	}
+
+	userID, email, err := s.store(r.Context()).ConsumeUserToken(models.TokenResetPassword, utils.HashToken(r.FormValue("token")))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to verify token", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to reset password")
+		return
+	}
+	if userID == 0 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "the link is invalid or expired")
+		return
+	}
+
+	encodedPwd, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode the password", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to reset password")
+		return
+	}
+	if err := s.userStore(r.Context()).UpdateUserPassword(userID, string(encodedPwd)); err != nil {
+		slog.ErrorContext(r.Context(), "failed to update the password", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to reset password")
+		return
+	}
+	// the mail proves the ownership of the email as well
+	if err := s.userStore(r.Context()).SetEmailValidated(userID, email); err != nil {
+		slog.ErrorContext(r.Context(), "failed to verify email", "error", err)
+	}
+	if err := s.rdb(r.Context()).RevokeUserSessions(userID); err != nil {
+		slog.ErrorContext(r.Context(), "failed to revoke sessions", "error", err)
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "password reset successfully")
+}
@@ -0,0 +1,298 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/logging"
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/utils"
+)
+
+// parseAdminTarget: get the id at the end of the admin request path, e.g. /admin/user/status/{user_id}
+func parseAdminTarget(w http.ResponseWriter, r *http.Request, prefix string) (int, bool) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, prefix))
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return 0, false
+	}
+	return id, true
+}
+
+// AdminUserListHandler: handles the request to list and search the users,
+// e.g. /admin/users?q=alice&status=locked&limit=50&offset=0
+func (s *Server) AdminUserListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+
+	query := r.URL.Query()
+	search := strings.TrimSpace(query.Get("q"))
+	status := query.Get("status")
+	switch status {
+	case "", models.UserStatusActive, models.UserStatusDisabled, models.UserStatusLocked, models.UserStatusDeleted:
+	default:
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid status")
+		return
+	}
+	limit := config.AdminUserListLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > config.AdminUserListMaxLimit {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid limit")
+			return
+		}
+	}
+	offset := 0
+	if value := query.Get("offset"); value != "" {
+		var err error
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid offset")
+			return
+		}
+	}
+
+	users, total, err := s.store(r.Context()).ListUsers(search, status, limit, offset)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list users", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to list users")
+		return
+	}
+	writeJSON(w, &models.AdminUserList{Users: users, Total: total})
+}
+
+// AdminUserStatusHandler: handles the request to activate, disable, lock or delete an account,
+// e.g. /admin/user/status/{user_id}, all sessions of the user are revoked unless it is activated
+func (s *Server) AdminUserStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	adminID, _ := getUserFromContext(r)
+
+	userID, ok := parseAdminTarget(w, r, "/admin/user/status/")
+	if !ok {
+		return
+	}
+	var statusReq models.UpdateUserStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&statusReq); err != nil {
+		slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to decode the request")
+		return
+	}
+	switch statusReq.Status {
+	case models.UserStatusActive, models.UserStatusDisabled, models.UserStatusLocked, models.UserStatusDeleted:
+	default:
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid status")
+		return
+	}
+	lockTime := time.Duration(statusReq.LockHours) * time.Hour
+	if statusReq.LockHours < 0 || lockTime > config.AdminMaxLockTime {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+
+	// the administrators can not lock themselves out
+	if userID == adminID {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "can not change your own status")
+		return
+	}
+	userInfo, err := s.userStore(r.Context()).GetUserInfoByID(userID)
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "user not found")
+		return
+	}
+
+	var lockedUntil *time.Time
+	if statusReq.LockHours > 0 {
+		until := time.Now().Add(lockTime)
+		lockedUntil = &until
+	}
+	if err := s.store(r.Context()).SetUserStatus(userID, statusReq.Status, lockedUntil); err != nil {
+		slog.ErrorContext(r.Context(), "failed to set user status", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to set user status")
+		return
+	}
+	if statusReq.Status == models.UserStatusActive {
+		s.resetLoginFailures(r.Context(), userInfo.Username)
+	} else {
+		// the API keys would outlive the lock, they are revoked with the sessions
+		if err := s.store(r.Context()).DeleteUserAPIKeys(userID); err != nil {
+			slog.ErrorContext(r.Context(), "failed to revoke api keys", "error", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to revoke api keys")
+			return
+		}
+		if err := s.rdb(r.Context()).RevokeUserSessions(userID); err != nil {
+			slog.ErrorContext(r.Context(), "failed to revoke sessions", "error", err)
+		}
+	}
+	slog.InfoContext(r.Context(), "admin set the status of user", "admin_id", adminID, "target_user_id", userID, "status", statusReq.Status)
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "user status updated successfully")
+}
+
+// AdminUserUnlockHandler: handles the request to unlock the account and clear its failed login attempts,
+// e.g. /admin/user/unlock/{user_id}
+func (s *Server) AdminUserUnlockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	adminID, _ := getUserFromContext(r)
+
+	userID, ok := parseAdminTarget(w, r, "/admin/user/unlock/")
+	if !ok {
+		return
+	}
+	userInfo, err := s.userStore(r.Context()).GetUserInfoByID(userID)
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "user not found")
+		return
+	}
+
+	unlocked, err := s.userStore(r.Context()).UnlockUser(userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to unlock user", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to unlock user")
+		return
+	}
+	if err := s.rdb(r.Context()).ResetLoginFailures(loginFailureKey(userInfo.Username)); err != nil {
+		slog.ErrorContext(r.Context(), "failed to reset login failures", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to unlock user")
+		return
+	}
+	slog.InfoContext(r.Context(), "admin unlocked user", "admin_id", adminID, "target_user_id", userID)
+
+	if !unlocked {
+		utils.WriteJSONResponse(w, http.StatusOK, "success", "user is not locked, failed login attempts cleared")
+		return
+	}
+	utils.WriteJSONResponse(w, http.StatusOK, "success", fmt.Sprintf("user %s unlocked successfully", userInfo.Username))
+}
+
+// AdminUserRoleHandler: handles the request to grant or revoke the administrator role, e.g. /admin/user/role/{user_id}
+func (s *Server) AdminUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	adminID, _ := getUserFromContext(r)
+
+	userID, ok := parseAdminTarget(w, r, "/admin/user/role/")
+	if !ok {
+		return
+	}
+	var roleReq models.UpdateUserRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&roleReq); err != nil {
+		slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to decode the request")
+		return
+	}
+	if roleReq.Role != models.UserRoleUser && roleReq.Role != models.UserRoleAdmin {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid role")
+		return
+	}
+
+	// there is always an administrator left
+	if userID == adminID {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "can not change your own role")
+		return
+	}
+	if _, err := s.userStore(r.Context()).GetUserInfoByID(userID); err != nil {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "user not found")
+		return
+	}
+
+	if err := s.store(r.Context()).SetUserRole(userID, roleReq.Role); err != nil {
+		slog.ErrorContext(r.Context(), "failed to set user role", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to set user role")
+		return
+	}
+	slog.InfoContext(r.Context(), "admin set the role of user", "admin_id", adminID, "target_user_id", userID, "role", roleReq.Role)
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "user role updated successfully")
+}
+
+// AdminUserUsageHandler: handles the request to get the storage usage of any user, e.g. /admin/user/usage/{user_id}
+func (s *Server) AdminUserUsageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+
+	userID, ok := parseAdminTarget(w, r, "/admin/user/usage/")
+	if !ok {
+		return
+	}
+	if _, err := s.userStore(r.Context()).GetUserInfoByID(userID); err != nil {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "user not found")
+		return
+	}
+
+	quota, err := s.fileStore(r.Context()).GetUserQuota(userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get the quota", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get the quota")
+		return
+	}
+	writeJSON(w, quota)
+}
+
+// AdminFileDeleteHandler: handles the request to delete a file from all users and teams,
+// e.g. /admin/file/delete/{file_id}
+func (s *Server) AdminFileDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	adminID, _ := getUserFromContext(r)
+
+	fileID, ok := parseAdminTarget(w, r, "/admin/file/delete/")
+	if !ok {
+		return
+	}
+
+	logging.AddAttrs(r.Context(), slog.Int("file_id", fileID))
+	found, filePath, err := s.store(r.Context()).ForceDeleteFile(fileID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to delete file", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to delete file")
+		return
+	}
+	if !found {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "file not found")
+		return
+	}
+	s.goBackground(func() {
+		if err := os.Remove(filePath); err != nil {
+			slog.ErrorContext(r.Context(), "failed to delete file", "error", err)
+		}
+	})
+	slog.InfoContext(r.Context(), "admin deleted file", "admin_id", adminID)
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "file deleted successfully")
+}
+
+// AdminStatsHandler: handles the request to get the system-wide storage statistics
+func (s *Server) AdminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+
+	stats, err := s.store(r.Context()).GetSystemStats()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get system stats", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get system stats")
+		return
+	}
+	writeJSON(w, stats)
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/utils"
+)
+
+// validAPIKeyScopes: check the requested scopes, at least one is required
+func validAPIKeyScopes(scopes []string) bool {
+	if len(scopes) == 0 {
+		return false
+	}
+	for _, scope := range scopes {
+		valid := false
+		for _, s := range models.APIKeyScopes {
+			if scope == s {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return false
+		}
+	}
+	return true
+}
+
+// APIKeyCreateHandler: handles the request to create a personal API key, the key is only returned once
+func (s *Server) APIKeyCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	// decode the request body
+	var keyReq models.CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&keyReq); err != nil {
+		slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to decode the request")
+		return
+	}
+	keyReq.Name = strings.TrimSpace(keyReq.Name)
+	expireTime := time.Duration(keyReq.ExpireDays) * time.Hour * 24
+	if keyReq.Name == "" || len(keyReq.Name) > config.APIKeyMaxNameLength || !validAPIKeyScopes(keyReq.Scopes) ||
+		keyReq.ExpireDays < 0 || expireTime > config.APIKeyMaxExpireTime {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+
+	count, err := s.store(r.Context()).CountUserAPIKeys(userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to count api keys", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to create api key")
+		return
+	}
+	if count >= config.APIKeyMaxPerUser {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "too many api keys")
+		return
+	}
+
+	// generate the random key
+	token, err := utils.GenerateToken(config.APIKeyBytes)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to generate token", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to generate token")
+		return
+	}
+	keyStr := config.APIKeyPrefix + token
+
+	key := models.APIKey{
+		UserID:   userID,
+		Name:     keyReq.Name,
+		Prefix:   keyStr[:config.APIKeyDisplayLength],
+		Scopes:   keyReq.Scopes,
+		CreateAt: time.Now(),
+	}
+	if keyReq.ExpireDays > 0 {
+		expireAt := time.Now().Add(expireTime)
+		key.ExpireAt = &expireAt
+	}
+
+	key.KeyID, err = s.store(r.Context()).SaveAPIKey(&key, utils.HashToken(keyStr))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to save api key", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to save api key")
+		return
+	}
+
+	writeJSON(w, &models.CreateAPIKeyResponse{Key: keyStr, APIKey: key})
+}
+
+// APIKeyListHandler: handles the request to list the API keys of the user
+func (s *Server) APIKeyListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	keys, err := s.store(r.Context()).GetUserAPIKeys(userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get api keys", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get api keys")
+		return
+	}
+	if keys == nil {
+		keys = []models.APIKey{}
+	}
+
+	writeJSON(w, keys)
+}
+
+// APIKeyRevokeHandler: handles the request to revoke an API key, e.g. /user/apikey/revoke/{key_id}
+func (s *Server) APIKeyRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	keyID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/user/apikey/revoke/"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to convert key_id to int", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+
+	ok, err := s.store(r.Context()).DeleteAPIKey(userID, keyID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to delete api key", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to revoke api key")
+		return
+	}
+	if !ok {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "api key not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "api key revoked successfully")
+}
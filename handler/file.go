@@ -4,65 +4,76 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bladewaltz9/file-store-server/config"
-	"github.com/bladewaltz9/file-store-server/db"
+	"github.com/bladewaltz9/file-store-server/logging"
+	"github.com/bladewaltz9/file-store-server/metrics"
 	"github.com/bladewaltz9/file-store-server/models"
 	"github.com/bladewaltz9/file-store-server/mq"
-	"github.com/bladewaltz9/file-store-server/oss"
+	"github.com/bladewaltz9/file-store-server/tracing"
 	"github.com/bladewaltz9/file-store-server/utils"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // FileUploadHandler: handles the upload request
-func FileUploadHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) FileUploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
 		return
 	}
+	start := time.Now()
 
 	// handle the upload file
-	if err := r.ParseMultipartForm(config.MaxUploadSize); err != nil {
+	_, span := tracing.Start(r.Context(), "http.ParseMultipartForm")
+	err := r.ParseMultipartForm(s.cfg.Storage.MaxUploadSize)
+	tracing.End(span, err)
+	if err != nil {
 		if err == http.ErrContentLength {
-			log.Printf("uploaded file is too large: %v", err)
+			slog.ErrorContext(r.Context(), "uploaded file is too large", "error", err)
 			utils.WriteJSONResponse(w, http.StatusRequestEntityTooLarge, "error", "uploaded file is too large")
 		} else {
-			log.Printf("failed to parse multipart form: %v", err)
+			slog.ErrorContext(r.Context(), "failed to parse multipart form", "error", err)
 			utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to parse form data")
 		}
 		return
 	}
 
-	// get the user_id, file_hash, and file from the form
-	userID, err := strconv.Atoi(r.FormValue("user_id"))
-	if err != nil {
-		log.Printf("failed to convert user_id to int: %v", err.Error())
-		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+	// the file is uploaded by the authenticated user, get the file_hash and the file from the form
+	userID, _ := getUserFromContext(r)
+	fileHash := r.FormValue("file_hash")
+	teamID, folderID, ok := s.checkUploadTarget(w, r, userID)
+	if !ok {
 		return
 	}
-	fileHash := r.FormValue("file_hash")
 	file, header, err := r.FormFile("file")
 	if err != nil {
-		log.Printf("failed to get data from form: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to get data from form", "error", err)
 		http.Error(w, "failed to get data from form", http.StatusInternalServerError)
 		return
 	}
 	defer file.Close()
 
+	// check the quota before storing the file
+	if !s.checkQuota(w, r, userID, header.Size) {
+		return
+	}
+
 	fileMetas := &models.FileMeta{}
 	fileMetas.FileName = header.Filename
-	fileMetas.FilePath = config.FileStoreDir + uuid.New().String() + "_" + header.Filename
+	fileMetas.FilePath = filepath.Join(s.cfg.Storage.FileStoreDir, uuid.New().String()+"_"+header.Filename)
 
 	// create the file directory
 	fileDir := filepath.Dir(fileMetas.FilePath)
 	if err := os.MkdirAll(fileDir, os.ModePerm); err != nil {
-		log.Printf("failed to create file directory: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to create file directory", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to create file directory")
 		return
 	}
@@ -70,23 +81,27 @@ func FileUploadHandler(w http.ResponseWriter, r *http.Request) {
 	// save the file to the local disk
 	newFile, err := os.Create(fileMetas.FilePath)
 	if err != nil {
-		log.Printf("failed to create file: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to create file", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to create file")
 		return
 	}
 	defer newFile.Close()
 
+	_, span = tracing.Start(r.Context(), "storage.SaveFile", attribute.String("file.path", fileMetas.FilePath))
 	fileMetas.FileSize, err = io.Copy(newFile, file)
+	tracing.End(span, err)
 	if err != nil {
-		log.Printf("failed to save file: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to save file", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to save file")
 		return
 	}
 
 	// calculate the hash of the file
+	_, span = tracing.Start(r.Context(), "storage.Hash", attribute.Int64("file.size", fileMetas.FileSize))
 	fileMetas.FileHash, err = utils.CalculateSHA256(newFile)
+	tracing.End(span, err)
 	if err != nil {
-		log.Printf("failed to calculate hash: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to calculate hash", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to calculate hash")
 		return
 	}
@@ -94,41 +109,55 @@ func FileUploadHandler(w http.ResponseWriter, r *http.Request) {
 	// check the file hash with the hash from the client
 	if fileMetas.FileHash != fileHash {
 		// delete the file from the local disk
-		go func() {
+		s.goBackground(func() {
 			if err := os.Remove(fileMetas.FilePath); err != nil {
-				log.Printf("failed to delete file: %v", err.Error())
+				slog.ErrorContext(r.Context(), "failed to delete file", "error", err)
 			}
-		}()
-		log.Printf("file hash does not match")
+		})
+		slog.WarnContext(r.Context(), "file hash does not match")
 		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "file hash does not match")
 		return
 	}
 
 	// save the file metadata to the database
-	if err := SaveUserFileDB(fileMetas, userID); err != nil {
-		log.Printf("failed to save file metadata: %v", err.Error())
+	if err := s.saveUploadedFileDB(r.Context(), fileMetas, userID, teamID, folderID); err != nil {
+		if writeQuotaError(w, err) {
+			s.goBackground(func() {
+				if err := os.Remove(fileMetas.FilePath); err != nil {
+					slog.ErrorContext(r.Context(), "failed to delete file", "error", err)
+				}
+			})
+			return
+		}
+		slog.ErrorContext(r.Context(), "failed to save file metadata", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", err.Error())
 		return
 	}
+	logging.AddAttrs(r.Context(), slog.Int("file_id", fileMetas.FileID))
 
 	// send the message to the MQ
-	rabbitMQ := mq.GetRabbitMQ()
+	rabbitMQ := s.transfer
 	fileMsg := &mq.FileTransferMessage{
 		FileID:    fileMetas.FileID,
 		LocalFile: fileMetas.FilePath,
 		ObjectKey: config.BucketDir + fileMetas.FileName,
+		RequestID: logging.RequestID(r.Context()),
 	}
-	if err := rabbitMQ.PublishMessage(fileMsg); err != nil {
-		log.Printf("failed to publish message: %v", err.Error())
+	if err := rabbitMQ.PublishMessage(r.Context(), fileMsg); err != nil {
+		slog.ErrorContext(r.Context(), "failed to publish message", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to publish message")
 		return
 	}
 
+	// index the file content, failures do not affect the upload
+	s.publishIndexMessage(r.Context(), fileMetas)
+
+	metrics.ObserveUpload(metrics.UploadSingle, fileMetas.FileSize, start)
 	utils.WriteJSONResponse(w, http.StatusOK, "success", "file uploaded successfully")
 }
 
 // FileQueryHandler: handles the query request
-func FileQueryHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) FileQueryHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
 		return
@@ -143,27 +172,41 @@ func FileQueryHandler(w http.ResponseWriter, r *http.Request) {
 	// convert the file_id to int
 	fileID, err := strconv.Atoi(fileIDStr)
 	if err != nil {
-		log.Printf("failed to convert file_id to int: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to convert file_id to int", "error", err)
 		http.Error(w, "invalid parameter", http.StatusBadRequest)
 		return
 	}
 
-	fileMeta, err := db.GetFileMeta(fileID)
+	// check if the user can read the file
+	userID, _ := getUserFromContext(r)
+	if !s.checkFileAccess(w, r, userID, fileID, models.PermissionRead) {
+		return
+	}
+
+	fileMeta, err := s.fileStore(r.Context()).GetFileMeta(fileID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get file metadata", "error", err)
+		http.Error(w, "failed to get file metadata", http.StatusInternalServerError)
+		return
+	}
+
+	// attach the tags and metadata of the caller
+	fileMeta.Tags, fileMeta.Metadata, err = s.store(r.Context()).GetUserFileLabels(userID, fileID)
 	if err != nil {
-		log.Printf("failed to get file metadata: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to get file labels", "error", err)
 		http.Error(w, "failed to get file metadata", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(fileMeta); err != nil {
-		log.Printf("failed to encode the file metadata: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to encode the file metadata", "error", err)
 		http.Error(w, "failed to encode the file metadata", http.StatusInternalServerError)
 	}
 }
 
 // FileDownloadHandler: handles the download request
-func FileDownloadHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) FileDownloadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
 		return
@@ -178,15 +221,21 @@ func FileDownloadHandler(w http.ResponseWriter, r *http.Request) {
 	// convert the file_id to int
 	fileID, err := strconv.Atoi(fileIDStr)
 	if err != nil {
-		log.Printf("failed to convert file_id to int: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to convert file_id to int", "error", err)
 		http.Error(w, "invalid parameter", http.StatusBadRequest)
 		return
 	}
 
+	// check if the user can read the file
+	userID, _ := getUserFromContext(r)
+	if !s.checkFileAccess(w, r, userID, fileID, models.PermissionRead) {
+		return
+	}
+
 	// get the file metadata
-	fileMeta, err := db.GetFileMeta(fileID)
+	fileMeta, err := s.fileStore(r.Context()).GetFileMeta(fileID)
 	if err != nil {
-		log.Printf("failed to get file metadata: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to get file metadata", "error", err)
 		http.Error(w, "failed to get file metadata", http.StatusInternalServerError)
 		return
 	}
@@ -194,7 +243,7 @@ func FileDownloadHandler(w http.ResponseWriter, r *http.Request) {
 	// open the file
 	file, err := os.Open(fileMeta.FilePath)
 	if err != nil {
-		log.Printf("failed to open file: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to open file", "error", err)
 		http.Error(w, "failed to open file", http.StatusInternalServerError)
 		return
 	}
@@ -209,7 +258,7 @@ func FileDownloadHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeContent(w, r, fileMeta.FileName, fileMeta.UpdateAt, file)
 }
 
-func FileDownloadURLHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) FileDownloadURLHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
 		return
@@ -224,24 +273,30 @@ func FileDownloadURLHandler(w http.ResponseWriter, r *http.Request) {
 	// convert the file_id to int
 	fileID, err := strconv.Atoi(fileIDStr)
 	if err != nil {
-		log.Printf("failed to convert file_id to int: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to convert file_id to int", "error", err)
 		http.Error(w, "invalid parameter", http.StatusBadRequest)
 		return
 	}
 
+	// check if the user can read the file
+	userID, _ := getUserFromContext(r)
+	if !s.checkFileAccess(w, r, userID, fileID, models.PermissionRead) {
+		return
+	}
+
 	// get the file metadata
-	fileMeta, err := db.GetFileMeta(fileID)
+	fileMeta, err := s.fileStore(r.Context()).GetFileMeta(fileID)
 	if err != nil {
-		log.Printf("failed to get file metadata: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to get file metadata", "error", err)
 		http.Error(w, "failed to get file metadata", http.StatusInternalServerError)
 		return
 	}
 
 	// generate the download URL
 	expiryTime := config.URLExpireTime
-	downloadURL, err := oss.GenerateDownloadURL(config.BucketName, config.BucketDir+fileMeta.FileName, expiryTime)
+	downloadURL, err := s.oss.GenerateDownloadURL(r.Context(), config.BucketDir+fileMeta.FileName, expiryTime)
 	if err != nil {
-		log.Printf("failed to generate download URL: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to generate download URL", "error", err)
 		http.Error(w, "failed to generate download URL", http.StatusInternalServerError)
 		return
 	}
@@ -254,13 +309,13 @@ func FileDownloadURLHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("failed to encode the download URL: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to encode the download URL", "error", err)
 		http.Error(w, "failed to encode the download URL", http.StatusInternalServerError)
 	}
 }
 
 // FileUpdateHandler: handles the update request
-func FileUpdateHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) FileUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPut {
 		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
 		return
@@ -276,22 +331,28 @@ func FileUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	// convert the file_id to int
 	fileID, err := strconv.Atoi(fileIDStr)
 	if err != nil {
-		log.Printf("failed to convert file_id to int: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to convert file_id to int", "error", err)
 		http.Error(w, "invalid parameter", http.StatusBadRequest)
 		return
 	}
 
+	// check if the user can write the file
+	userID, _ := getUserFromContext(r)
+	if !s.checkFileAccess(w, r, userID, fileID, models.PermissionWrite) {
+		return
+	}
+
 	// decode the request body
 	var updateReq models.UpdateFileMetaRequest
 	if err := json.NewDecoder(r.Body).Decode(&updateReq); err != nil {
-		log.Printf("failed to decode the request: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
 		http.Error(w, "failed to decode the request", http.StatusBadRequest)
 		return
 	}
 
-	// update the file metadata
-	if err := db.UpdateFileMeta(fileID, updateReq); err != nil {
-		log.Printf("failed to update file metadata: %v", err.Error())
+	// update the file metadata of the holders, the stored file is shared by the users having the same content
+	if err := s.store(r.Context()).UpdateUserFileMeta(userID, fileID, updateReq); err != nil {
+		slog.ErrorContext(r.Context(), "failed to update file metadata", "error", err)
 		http.Error(w, "failed to update file metadata", http.StatusInternalServerError)
 		return
 	}
@@ -301,7 +362,7 @@ func FileUpdateHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // FileDeleteHandler: handles the delete request
-func FileDeleteHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) FileDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
 		return
@@ -315,93 +376,143 @@ func FileDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	userID, err := strconv.Atoi(parts[3])
 	if err != nil {
-		log.Printf("failed to convert user_id to int: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to convert user_id to int", "error", err)
 		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
 		return
 	}
 	fileID, err := strconv.Atoi(parts[4])
 	if err != nil {
-		log.Printf("failed to convert file_id to int: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to convert file_id to int", "error", err)
 		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
 		return
 	}
 
+	// only the owner or a user granted the write permission by the owner can delete the file
+	callerID, _ := getUserFromContext(r)
+	if callerID != userID {
+		permission, err := s.store(r.Context()).GetSharePermission(userID, callerID, fileID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to get share permission", "error", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to check file access")
+			return
+		}
+		if permission != models.PermissionWrite {
+			utils.WriteJSONResponse(w, http.StatusForbidden, "error", "permission denied")
+			return
+		}
+	}
+
 	// delete the file
-	ok, filePath, err := db.DeleteUserFile(userID, fileID)
+	ok, filePath, err := s.fileStore(r.Context()).DeleteUserFile(userID, fileID)
 	if err != nil {
-		log.Printf("failed to delete file: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to delete file", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", err.Error())
 		return
 	}
 
 	// delete the file from the local disk if the reference count is 0
 	if ok {
-		go func() {
+		s.goBackground(func() {
 			if err := os.Remove(filePath); err != nil {
-				log.Printf("failed to delete file: %v", err.Error())
+				slog.ErrorContext(r.Context(), "failed to delete file", "error", err)
 			}
-		}()
+		})
 	}
 
 	utils.WriteJSONResponse(w, http.StatusOK, "success", "file deleted successfully")
 }
 
 // FileFastUploadHandler: fast upload the file if the file already exists
-func FileFastUploadHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) FileFastUploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
 		return
 	}
+	start := time.Now()
 
-	// get the user_id, file_hash, and file_name from the form
+	// the file is uploaded by the authenticated user, get the file_hash and the file_name from the form
+	userID, _ := getUserFromContext(r)
 	fileHash := r.FormValue("file_hash")
 	fileName := r.FormValue("file_name")
-	userID, err := strconv.Atoi(r.FormValue("user_id"))
-	if err != nil {
-		log.Printf("failed to convert user_id to int: %v", err.Error())
+	if fileHash == "" || fileName == "" {
 		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
 		return
 	}
-	if fileHash == "" || fileName == "" {
-		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+	teamID, folderID, ok := s.checkUploadTarget(w, r, userID)
+	if !ok {
 		return
 	}
 
 	// check if the file exists
-	exist, fileID, err := db.FileExists(fileHash)
+	exist, fileID, err := s.fileStore(r.Context()).FileExists(fileHash)
 	if err != nil {
-		log.Printf("failed to check if the file exists: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to check if the file exists", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to check if the file exists")
 		return
 	}
 
 	// if the file does not exist, return the status of "not_exists"
 	if !exist {
+		metrics.ObserveFastUpload(metrics.FastUploadMiss)
 		utils.WriteJSONResponse(w, http.StatusOK, "not_exists", "file does not exist")
 		return
 	}
+	logging.AddAttrs(r.Context(), slog.Int("file_id", fileID))
 
-	// check if the file exists in the user file table
-	exist, err = db.UserFileExists(userID, fileID)
+	// check if the file exists in the user file table or the team files
+	if teamID > 0 {
+		exist, err = s.store(r.Context()).TeamFileExists(teamID, fileID)
+	} else {
+		exist, err = s.fileStore(r.Context()).UserFileExists(userID, fileID)
+	}
 	if err != nil {
-		log.Printf("failed to check if the file exists: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to check if the file exists", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to check if the file exists")
 		return
 	}
 
 	// if the file exists in the user file table, return the status of "repeat"
 	if exist {
+		metrics.ObserveFastUpload(metrics.FastUploadRepeat)
 		utils.WriteJSONResponse(w, http.StatusOK, "repeat", "file already exists")
 		return
 	}
 
+	// check the quota, the shared content counts against every user holding it
+	fileMeta, err := s.fileStore(r.Context()).GetFileMeta(fileID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get file metadata", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get file metadata")
+		return
+	}
+	if !s.checkQuota(w, r, userID, fileMeta.FileSize) {
+		return
+	}
+
 	// save the file to the user file table
-	if err := db.SaveUserFile(userID, fileID, fileName); err != nil {
-		log.Printf("failed to save user file: %v", err.Error())
+	if teamID > 0 {
+		err = s.store(r.Context()).SaveTeamFile(teamID, userID, fileID, fileName)
+	} else {
+		err = s.fileStore(r.Context()).SaveUserFile(userID, fileID, fileName)
+	}
+	if writeQuotaError(w, err) {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to save user file", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to save user file")
 		return
 	}
+	if folderID > 0 {
+		if err := s.store(r.Context()).MoveFile(userID, teamID, fileID, folderID); err != nil {
+			slog.ErrorContext(r.Context(), "failed to move file", "error", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to move file")
+			return
+		}
+	}
 
 	// return the status of "success"
+	metrics.ObserveFastUpload(metrics.FastUploadHit)
+	metrics.ObserveUpload(metrics.UploadFast, fileMeta.FileSize, start)
 	utils.WriteJSONResponse(w, http.StatusOK, "success", "file fast uploaded successfully")
 }
@@ -3,34 +3,41 @@ package handler
 import (
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/logging"
+	"github.com/bladewaltz9/file-store-server/metrics"
 	"github.com/bladewaltz9/file-store-server/models"
 	"github.com/bladewaltz9/file-store-server/mq"
-	"github.com/bladewaltz9/file-store-server/redis"
+	"github.com/bladewaltz9/file-store-server/tracing"
 	"github.com/bladewaltz9/file-store-server/utils"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // FileChunkedUploadHandler: handles the chunked upload request
-func FileChunkedUploadHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) FileChunkedUploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
 		return
 	}
 
 	// parse the form data
-	if err := r.ParseMultipartForm(config.MaxUploadSize); err != nil {
+	_, span := tracing.Start(r.Context(), "http.ParseMultipartForm")
+	err := r.ParseMultipartForm(s.cfg.Storage.MaxUploadSize)
+	tracing.End(span, err)
+	if err != nil {
 		if err == http.ErrContentLength {
-			log.Printf("uploaded file is too large: %v", err)
+			slog.ErrorContext(r.Context(), "uploaded file is too large", "error", err)
 			utils.WriteJSONResponse(w, http.StatusRequestEntityTooLarge, "error", "uploaded file is too large")
 		} else {
-			log.Printf("failed to parse multipart form: %v", err)
+			slog.ErrorContext(r.Context(), "failed to parse multipart form", "error", err)
 			utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to parse form data")
 		}
 		return
@@ -41,65 +48,80 @@ func FileChunkedUploadHandler(w http.ResponseWriter, r *http.Request) {
 	fileName := r.FormValue("file_name")
 	chunkIndex, err := strconv.Atoi(r.FormValue("chunk_index"))
 	if err != nil {
-		log.Printf("failed to convert chunk_index to int: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to convert chunk_index to int", "error", err)
 		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
 		return
 	}
 	totalChunks, err := strconv.Atoi(r.FormValue("total_chunks"))
 	if err != nil {
-		log.Printf("failed to convert total_chunks to int: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to convert total_chunks to int", "error", err)
 		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
 		return
 	}
 
 	// get the file chunk
-	file, _, err := r.FormFile("file")
+	file, header, err := r.FormFile("file")
 	if err != nil {
-		log.Printf("failed to get data from form: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to get data from form", "error", err)
 		http.Error(w, "failed to get data from form", http.StatusInternalServerError)
 		return
 	}
 	defer file.Close()
 
+	// check the quota before storing the chunk, with the total file size if the client sends it
+	userID, _ := getUserFromContext(r)
+	size := header.Size
+	if fileSize, err := strconv.ParseInt(r.FormValue("file_size"), 10, 64); err == nil && fileSize > size {
+		size = fileSize
+	}
+	if !s.checkQuota(w, r, userID, size) {
+		return
+	}
+
 	// create the file directory
-	if err := os.MkdirAll(filepath.Join(config.FileChunkDir, fileIDStr), os.ModePerm); err != nil {
-		log.Printf("failed to create file directory: %v", err.Error())
+	if err := os.MkdirAll(filepath.Join(s.cfg.Storage.FileChunkDir, fileIDStr), os.ModePerm); err != nil {
+		slog.ErrorContext(r.Context(), "failed to create file directory", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to create file directory")
 		return
 	}
 
 	// save the file chunk to the local disk
-	chunkPath := filepath.Join(config.FileChunkDir, fileIDStr, fmt.Sprintf("chunk-%d", chunkIndex))
+	chunkPath := filepath.Join(s.cfg.Storage.FileChunkDir, fileIDStr, fmt.Sprintf("chunk-%d", chunkIndex))
 	newFile, err := os.Create(chunkPath)
 	if err != nil {
-		log.Printf("failed to create file: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to create file", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to create file")
 		return
 	}
 	defer newFile.Close()
 
-	if _, err = io.Copy(newFile, file); err != nil {
-		log.Printf("failed to save file: %v", err.Error())
+	_, span = tracing.Start(r.Context(), "storage.SaveChunk", attribute.String("file.path", chunkPath))
+	_, err = io.Copy(newFile, file)
+	tracing.End(span, err)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to save file", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to save file")
 		return
 	}
 
 	// calculate the hash of the chunk
+	_, span = tracing.Start(r.Context(), "storage.Hash")
 	chunkHashCalculated, err := utils.CalculateSHA256(newFile)
+	tracing.End(span, err)
 	if err != nil {
-		log.Printf("failed to calculate hash: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to calculate hash", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to calculate hash")
 		return
 	}
 	// check the chunk hash with the hash from the client
 	if chunkHash != chunkHashCalculated {
 		// delete the chunk from the local disk
-		go func() {
+		s.goBackground(func() {
 			if err := os.Remove(chunkPath); err != nil {
-				log.Printf("failed to delete chunk: %v", err.Error())
+				slog.ErrorContext(r.Context(), "failed to delete chunk", "error", err)
 			}
-		}()
-		log.Printf("chunk hash does not match: %v", chunkHashCalculated)
+		})
+		slog.WarnContext(r.Context(), "chunk hash does not match", "chunk_hash", chunkHashCalculated)
 		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "chunk hash does not match")
 		return
 	}
@@ -110,15 +132,15 @@ func FileChunkedUploadHandler(w http.ResponseWriter, r *http.Request) {
 		FileName:    fileName,
 		TotalChunks: totalChunks,
 	}
-	if err := redis.StoreFileChunkInfo(chunkInfo); err != nil {
-		log.Printf("failed to store file info: %v", err.Error())
+	if err := s.rdb(r.Context()).StoreFileChunkInfo(chunkInfo); err != nil {
+		slog.ErrorContext(r.Context(), "failed to store file info", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to store file info")
 		return
 	}
 
 	// store the chunk status
-	if err := redis.StoreChunkStatus(fileIDStr, chunkIndex); err != nil {
-		log.Printf("failed to store chunk status: %v", err.Error())
+	if err := s.rdb(r.Context()).StoreChunkStatus(fileIDStr, chunkIndex); err != nil {
+		slog.ErrorContext(r.Context(), "failed to store chunk status", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to store chunk status")
 		return
 	}
@@ -128,30 +150,30 @@ func FileChunkedUploadHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // FileChunksMergeHandler: handles the merge request
-func FileChunksMergeHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) FileChunksMergeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
 		return
 	}
+	start := time.Now()
 
-	// parse the form data
-	userID, err := strconv.Atoi(r.FormValue("user_id"))
-	if err != nil {
-		log.Printf("failed to convert user_id to int: %v", err.Error())
-		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
-		return
-	}
+	// the file is merged for the authenticated user, parse the form data
+	userID, _ := getUserFromContext(r)
 	fileIDStr := r.FormValue("file_id")
 	fileHash := r.FormValue("file_hash")
+	teamID, folderID, ok := s.checkUploadTarget(w, r, userID)
+	if !ok {
+		return
+	}
 
 	// check if all chunks are received
-	chunkInfo, err := redis.GetFileChunkInfo(fileIDStr)
+	chunkInfo, err := s.rdb(r.Context()).GetFileChunkInfo(fileIDStr)
 	if err != nil || chunkInfo == nil {
 		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "file not found")
 		return
 	}
 	for i := 0; i < chunkInfo.TotalChunks; i++ {
-		received, err := redis.GetChunkStatus(fileIDStr, i)
+		received, err := s.rdb(r.Context()).GetChunkStatus(fileIDStr, i)
 		if err != nil {
 			utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get chunk status")
 			return
@@ -162,17 +184,32 @@ func FileChunksMergeHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// check the quota with the size of all chunks before merging
+	chunkDir := filepath.Join(s.cfg.Storage.FileChunkDir, chunkInfo.FileID)
+	var totalSize int64
+	for i := 0; i < chunkInfo.TotalChunks; i++ {
+		chunkStat, err := os.Stat(filepath.Join(chunkDir, fmt.Sprintf("chunk-%d", i)))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to stat chunk file", "error", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to open chunk file")
+			return
+		}
+		totalSize += chunkStat.Size()
+	}
+	if !s.checkQuota(w, r, userID, totalSize) {
+		return
+	}
+
 	// merge the file chunks
-	chunkDir := filepath.Join(config.FileChunkDir, chunkInfo.FileID)
 	fileMetas := &models.FileMeta{
 		FileName: chunkInfo.FileName,
-		FilePath: config.FileStoreDir + uuid.New().String() + "_" + chunkInfo.FileName,
+		FilePath: filepath.Join(s.cfg.Storage.FileStoreDir, uuid.New().String()+"_"+chunkInfo.FileName),
 		FileSize: 0,
 	}
 
 	// create the file directory
 	if err := os.MkdirAll(filepath.Dir(fileMetas.FilePath), os.ModePerm); err != nil {
-		log.Printf("failed to create file directory: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to create file directory", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to create file directory")
 		return
 	}
@@ -180,18 +217,20 @@ func FileChunksMergeHandler(w http.ResponseWriter, r *http.Request) {
 	// create the new file
 	newFile, err := os.Create(fileMetas.FilePath)
 	if err != nil {
-		log.Printf("failed to create file: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to create file", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to create file")
 		return
 	}
 	defer newFile.Close()
 
 	// merge the file chunks
+	_, span := tracing.Start(r.Context(), "storage.MergeChunks", attribute.Int("chunks", chunkInfo.TotalChunks))
 	for i := 0; i < chunkInfo.TotalChunks; i++ {
 		chunkPath := filepath.Join(chunkDir, fmt.Sprintf("chunk-%d", i))
 		chunkFile, err := os.Open(chunkPath)
 		if err != nil {
-			log.Printf("failed to open chunk file: %v", err.Error())
+			tracing.End(span, err)
+			slog.ErrorContext(r.Context(), "failed to open chunk file", "error", err)
 			utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to open chunk file")
 			return
 		}
@@ -199,24 +238,28 @@ func FileChunksMergeHandler(w http.ResponseWriter, r *http.Request) {
 
 		size, err := io.Copy(newFile, chunkFile)
 		if err != nil {
-			log.Printf("failed to merge chunk file: %v", err.Error())
+			tracing.End(span, err)
+			slog.ErrorContext(r.Context(), "failed to merge chunk file", "error", err)
 			utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to merge chunk file")
 			return
 		}
 		fileMetas.FileSize += size
 	}
+	tracing.End(span, nil)
 
 	// delete the file chunks
-	go func() {
+	s.goBackground(func() {
 		if err := os.RemoveAll(chunkDir); err != nil {
-			log.Printf("failed to delete chunk directory: %v", err.Error())
+			slog.ErrorContext(r.Context(), "failed to delete chunk directory", "error", err)
 		}
-	}()
+	})
 
 	// calculate the hash of the file
+	_, span = tracing.Start(r.Context(), "storage.Hash", attribute.Int64("file.size", fileMetas.FileSize))
 	fileMetas.FileHash, err = utils.CalculateSHA256(newFile)
+	tracing.End(span, err)
 	if err != nil {
-		log.Printf("failed to calculate hash: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to calculate hash", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to calculate hash")
 		return
 	}
@@ -224,35 +267,49 @@ func FileChunksMergeHandler(w http.ResponseWriter, r *http.Request) {
 	// check the file hash with the hash from the client
 	if fileMetas.FileHash != fileHash {
 		// delete the file from the local disk
-		go func() {
+		s.goBackground(func() {
 			if err := os.Remove(fileMetas.FilePath); err != nil {
-				log.Printf("failed to delete file: %v", err.Error())
+				slog.ErrorContext(r.Context(), "failed to delete file", "error", err)
 			}
-		}()
-		log.Printf("file hash does not match: %v", fileMetas.FileHash)
+		})
+		slog.WarnContext(r.Context(), "file hash does not match", "file_hash", fileMetas.FileHash)
 		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "file hash does not match")
 		return
 	}
 
 	// save the file metadata to the database
-	if err := SaveUserFileDB(fileMetas, userID); err != nil {
-		log.Printf("failed to save file metadata: %v", err.Error())
+	if err := s.saveUploadedFileDB(r.Context(), fileMetas, userID, teamID, folderID); err != nil {
+		if writeQuotaError(w, err) {
+			s.goBackground(func() {
+				if err := os.Remove(fileMetas.FilePath); err != nil {
+					slog.ErrorContext(r.Context(), "failed to delete file", "error", err)
+				}
+			})
+			return
+		}
+		slog.ErrorContext(r.Context(), "failed to save file metadata", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", err.Error())
 		return
 	}
+	logging.AddAttrs(r.Context(), slog.Int("file_id", fileMetas.FileID))
 
 	// send the message to the MQ
-	rabbitMQ := mq.GetRabbitMQ()
+	rabbitMQ := s.transfer
 	fileMsg := &mq.FileTransferMessage{
 		FileID:    fileMetas.FileID,
 		LocalFile: fileMetas.FilePath,
 		ObjectKey: config.BucketDir + fileMetas.FileName,
+		RequestID: logging.RequestID(r.Context()),
 	}
-	if err := rabbitMQ.PublishMessage(fileMsg); err != nil {
-		log.Printf("failed to publish message: %v", err.Error())
+	if err := rabbitMQ.PublishMessage(r.Context(), fileMsg); err != nil {
+		slog.ErrorContext(r.Context(), "failed to publish message", "error", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to publish message")
 		return
 	}
 
+	// index the file content, failures do not affect the upload
+	s.publishIndexMessage(r.Context(), fileMetas)
+
+	metrics.ObserveUpload(metrics.UploadChunked, fileMetas.FileSize, start)
 	utils.WriteJSONResponse(w, http.StatusOK, "success", "file uploaded successfully")
 }
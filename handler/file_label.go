@@ -0,0 +1,242 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/db"
+	"github.com/bladewaltz9/file-store-server/logging"
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/utils"
+)
+
+// FileTagsHandler: handles the request to replace the tags of a file
+func (s *Server) FileTagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	fileID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/file/tags/"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to convert file_id to int", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+
+	// decode the request body
+	var tagsReq models.FileTagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&tagsReq); err != nil {
+		slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to decode the request")
+		return
+	}
+	tags, err := normalizeTags(tagsReq.Tags)
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", err.Error())
+		return
+	}
+
+	// check if the user owns the file
+	if !s.checkUserFile(w, r, userID, fileID) {
+		return
+	}
+
+	if err := s.store(r.Context()).SetUserFileTags(userID, fileID, tags); err != nil {
+		slog.ErrorContext(r.Context(), "failed to set file tags", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to set file tags")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "file tags updated successfully")
+}
+
+// FileBulkTagsHandler: handles the request to add and remove tags on several files
+func (s *Server) FileBulkTagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	// decode the request body
+	var bulkReq models.BulkTagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&bulkReq); err != nil {
+		slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to decode the request")
+		return
+	}
+	if len(bulkReq.FileIDs) == 0 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+	add, err := normalizeTags(bulkReq.Add)
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", err.Error())
+		return
+	}
+	remove, err := normalizeTags(bulkReq.Remove)
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", err.Error())
+		return
+	}
+
+	// check if the user owns all the files
+	for _, fileID := range bulkReq.FileIDs {
+		if !s.checkUserFile(w, r, userID, fileID) {
+			return
+		}
+	}
+
+	if err := s.store(r.Context()).BulkEditTags(userID, bulkReq.FileIDs, add, remove); err != nil {
+		if errors.Is(err, db.ErrTooManyTags) {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, "error", fmt.Sprintf("too many tags, at most %d", config.MaxTagsPerFile))
+			return
+		}
+		slog.ErrorContext(r.Context(), "failed to edit file tags", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to edit file tags")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "file tags updated successfully")
+}
+
+// FileMetadataHandler: handles the request to replace the key-value metadata of a file
+func (s *Server) FileMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	fileID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/file/metadata/"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to convert file_id to int", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+
+	// decode the request body
+	var metaReq models.FileMetadataRequest
+	if err := json.NewDecoder(r.Body).Decode(&metaReq); err != nil {
+		slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to decode the request")
+		return
+	}
+	if err := validateMetadata(metaReq.Metadata); err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", err.Error())
+		return
+	}
+
+	// check if the user owns the file
+	if !s.checkUserFile(w, r, userID, fileID) {
+		return
+	}
+
+	if err := s.store(r.Context()).SetUserFileMetadata(userID, fileID, metaReq.Metadata); err != nil {
+		slog.ErrorContext(r.Context(), "failed to set file metadata", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to set file metadata")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "file metadata updated successfully")
+}
+
+// FileListHandler: handles the request to list the user files filtered by tags and metadata,
+// e.g. /file/list?tag=report&tag=2024&meta.project=apollo
+func (s *Server) FileListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	query := r.URL.Query()
+	tags, err := normalizeTags(query["tag"])
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", err.Error())
+		return
+	}
+	metadata := make(map[string]string)
+	for key, values := range query {
+		if strings.HasPrefix(key, "meta.") && len(values) > 0 {
+			metadata[strings.TrimPrefix(key, "meta.")] = values[0]
+		}
+	}
+
+	userFiles, err := s.store(r.Context()).QueryUserFiles(userID, tags, metadata)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to query user files", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to query user files")
+		return
+	}
+	if userFiles == nil {
+		userFiles = []models.FileInfo{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(userFiles); err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode the user files", "error", err)
+		http.Error(w, "failed to encode the user files", http.StatusInternalServerError)
+	}
+}
+
+// checkUserFile: check if the user owns the file, write the error response if not
+func (s *Server) checkUserFile(w http.ResponseWriter, r *http.Request, userID int, fileID int) bool {
+	logging.AddAttrs(r.Context(), slog.Int("file_id", fileID))
+	exist, err := s.fileStore(r.Context()).UserFileExists(userID, fileID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to check if the file exists", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to check if the file exists")
+		return false
+	}
+	if !exist {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "file not found")
+		return false
+	}
+	return true
+}
+
+// normalizeTags: trim, lowercase and deduplicate the tags
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > config.MaxTagLength {
+			return nil, fmt.Errorf("tag is too long: %s", tag)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > config.MaxTagsPerFile {
+		return nil, fmt.Errorf("too many tags, at most %d", config.MaxTagsPerFile)
+	}
+	return normalized, nil
+}
+
+// validateMetadata: check the count and the size of the metadata
+func validateMetadata(metadata map[string]string) error {
+	if len(metadata) > config.MaxMetadataKeys {
+		return fmt.Errorf("too many metadata keys, at most %d", config.MaxMetadataKeys)
+	}
+	for key, value := range metadata {
+		if key == "" || len(key) > config.MaxMetaKeyLength {
+			return fmt.Errorf("invalid metadata key: %s", key)
+		}
+		if len(value) > config.MaxMetaValueLength {
+			return fmt.Errorf("metadata value is too long: %s", key)
+		}
+	}
+	return nil
+}
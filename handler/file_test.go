@@ -0,0 +1,235 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/bladewaltz9/file-store-server/auth"
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/db"
+	"github.com/bladewaltz9/file-store-server/db/memory"
+	"github.com/bladewaltz9/file-store-server/handler"
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/mq"
+)
+
+// recorder: records the published messages instead of sending them to RabbitMQ
+type recorder struct {
+	messages []interface{}
+}
+
+func (p *recorder) PublishMessage(ctx context.Context, msg interface{}) error {
+	p.messages = append(p.messages, msg)
+	return nil
+}
+
+// racingQuota: the files of the store, the quota is used up by a concurrent upload once it is checked
+type racingQuota struct {
+	*memory.Store
+}
+
+func (q racingQuota) CheckUserQuota(userID int, size int64) error {
+	return nil
+}
+
+// testServer: the handlers on the in-memory store, the files are stored in a temporary directory
+type testServer struct {
+	*handler.Server
+	store    *memory.Store
+	transfer *recorder
+	dir      string
+}
+
+// newTestServer: create the handlers and save the users alice and bob
+func newTestServer(t *testing.T) *testServer {
+	cfg := &config.Config{}
+	cfg.Storage.FileStoreDir = t.TempDir()
+	cfg.Storage.MaxUploadSize = 1 << 20
+
+	store, transfer := memory.New(), &recorder{}
+	s := handler.NewServer(cfg, nil, nil, nil, nil, nil).WithRepositories(store, store).WithPublishers(transfer, &recorder{})
+	for _, username := range []string{"alice", "bob"} {
+		if err := store.SaveUserInfo(username, "encoded", username+"@example.com"); err != nil {
+			t.Fatalf("Failed to save the user: %v", err)
+		}
+	}
+	return &testServer{Server: s, store: store, transfer: transfer, dir: cfg.Storage.FileStoreDir}
+}
+
+// serve: serve the request of the user with the handler, like TokenAuthMiddleware once the token is validated
+func (s *testServer) serve(t *testing.T, h http.HandlerFunc, r *http.Request, userID int) *httptest.ResponseRecorder {
+	t.Helper()
+	claims := &auth.Claims{UserID: userID, Username: fmt.Sprintf("user%d", userID)}
+	r = r.WithContext(context.WithValue(r.Context(), models.ContextKey("claims"), claims))
+	w := httptest.NewRecorder()
+	h(w, r)
+	if err := s.Wait(context.Background()); err != nil {
+		t.Fatalf("Failed to wait for the background work: %v", err)
+	}
+	return w
+}
+
+// upload: upload the file of the user, the hash sent by the client is hash if it is set
+func (s *testServer) upload(t *testing.T, userID int, fileName string, content []byte, hash string) *httptest.ResponseRecorder {
+	t.Helper()
+	if hash == "" {
+		hash = fmt.Sprintf("%x", sha256.Sum256(content))
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("file_hash", hash)
+	part, err := form.CreateFormFile("file", fileName)
+	if err != nil {
+		t.Fatalf("Failed to create the form: %v", err)
+	}
+	part.Write(content)
+	form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/file/upload", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return s.serve(t, s.FileUploadHandler, r, userID)
+}
+
+// storedFiles: the names of the files in the storage directory
+func (s *testServer) storedFiles(t *testing.T) []string {
+	t.Helper()
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		t.Fatalf("Failed to read the storage directory: %v", err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestFileUpload(t *testing.T) {
+	s := newTestServer(t)
+	content := []byte("hello world")
+
+	// the files not matching the hash of the client are discarded
+	if w := s.upload(t, 1, "a.bin", content, "bad"); w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d: %s", w.Code, w.Body.String())
+	}
+	if files := s.storedFiles(t); len(files) != 0 {
+		t.Errorf("Expected the file to be removed, got %v", files)
+	}
+
+	if w := s.upload(t, 1, "a.bin", content, ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	files, err := s.store.GetUserFiles(1)
+	if err != nil || len(files) != 1 || files[0].FileName != "a.bin" || files[0].FileSize != int64(len(content)) {
+		t.Fatalf("Expected the uploaded file, got %+v, %v", files, err)
+	}
+	if others, _ := s.store.GetUserFiles(2); len(others) != 0 {
+		t.Errorf("Expected the file to be uploaded only by alice, got %+v", others)
+	}
+	if len(s.transfer.messages) != 1 {
+		t.Fatalf("Expected one transfer message, got %d", len(s.transfer.messages))
+	}
+	if msg, ok := s.transfer.messages[0].(*mq.FileTransferMessage); !ok || msg.FileID != files[0].FileID {
+		t.Errorf("Expected the transfer message of file %d, got %+v", files[0].FileID, s.transfer.messages[0])
+	}
+
+	// the usage of the uploader is charged
+	w := s.serve(t, s.UserUsageHandler, httptest.NewRequest(http.MethodGet, "/user/usage", nil), 1)
+	var quota models.UserQuota
+	if err := json.Unmarshal(w.Body.Bytes(), &quota); err != nil {
+		t.Fatalf("Failed to decode the usage %q: %v", w.Body.String(), err)
+	}
+	if quota.FileCount != 1 || quota.UsedBytes != int64(len(content)) {
+		t.Errorf("Expected the usage of one file, got %+v", quota)
+	}
+}
+
+// TestFileUploadOverQuota: the uploads that do not fit the quota are not kept, the same content
+// can be uploaded again and is not found by the fast uploads
+func TestFileUploadOverQuota(t *testing.T) {
+	s := newTestServer(t)
+	s.WithRepositories(s.store, racingQuota{s.store})
+	content := []byte("hello world")
+	hash := fmt.Sprintf("%x", sha256.Sum256(content))
+
+	s.store.SetUserQuota(1, 5, 10)
+	if w := s.upload(t, 1, "a.bin", content, ""); w.Code != http.StatusInsufficientStorage {
+		t.Fatalf("Expected status 507, got %d: %s", w.Code, w.Body.String())
+	}
+	if files := s.storedFiles(t); len(files) != 0 {
+		t.Errorf("Expected the file to be removed, got %v", files)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/file/fastupload", nil)
+	r.Form = url.Values{"file_hash": {hash}, "file_name": {"a.bin"}}
+	w := s.serve(t, s.FileFastUploadHandler, r, 2)
+	var resp struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Status != "not_exists" {
+		t.Errorf("Expected the rejected file not to exist, got %d: %s", w.Code, w.Body.String())
+	}
+
+	s.store.SetUserQuota(1, 1<<20, 10)
+	if w := s.upload(t, 1, "a.bin", content, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected the file to be uploaded again, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := s.store.GetFileMeta(1); !errors.Is(err, db.ErrFileNotFound) {
+		t.Errorf("Expected the rejected file to be deleted, got %v", err)
+	}
+}
+
+func TestFileDelete(t *testing.T) {
+	s := newTestServer(t)
+	content := []byte("hello world")
+	if w := s.upload(t, 1, "a.bin", content, ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	files, err := s.store.GetUserFiles(1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected the uploaded file, got %+v, %v", files, err)
+	}
+	fileID := files[0].FileID
+	// bob has the same content, like after a fast upload
+	if err := s.store.SaveUserFile(2, fileID, "b.bin"); err != nil {
+		t.Fatalf("Failed to save the file of bob: %v", err)
+	}
+
+	deleteFile := func(userID int) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/file/delete/%d/%d", userID, fileID), nil)
+		return s.serve(t, s.FileDeleteHandler, r, userID)
+	}
+
+	// the stored file is kept while another user references it
+	if w := deleteFile(1); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if files, _ := s.store.GetUserFiles(1); len(files) != 0 {
+		t.Errorf("Expected the file of alice to be deleted, got %+v", files)
+	}
+	if stored := s.storedFiles(t); len(stored) != 1 {
+		t.Errorf("Expected the stored file to be kept, got %v", stored)
+	}
+
+	if w := deleteFile(2); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if stored := s.storedFiles(t); len(stored) != 0 {
+		t.Errorf("Expected the stored file to be removed with its last reference, got %v", stored)
+	}
+
+	r := httptest.NewRequest(http.MethodDelete, "/file/delete/1/abc", nil)
+	if w := s.serve(t, s.FileDeleteHandler, r, 1); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
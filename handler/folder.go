@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/utils"
+)
+
+// checkFolder: check if the folder exists and belongs to the user or the team, folder 0 is the root,
+// write the error response if not
+func (s *Server) checkFolder(w http.ResponseWriter, r *http.Request, userID int, teamID int, folderID int) bool {
+	if folderID == 0 {
+		return true
+	}
+	folder, err := s.store(r.Context()).GetFolder(folderID)
+	if err != nil || folder.TeamID != teamID || (teamID == 0 && folder.UserID != userID) {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "folder not found")
+		return false
+	}
+	return true
+}
+
+// FolderCreateHandler: handles the request to create a personal folder or a team folder
+func (s *Server) FolderCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	var folderReq models.CreateFolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&folderReq); err != nil {
+		slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to decode the request")
+		return
+	}
+	folderReq.Name = strings.TrimSpace(folderReq.Name)
+	if folderReq.Name == "" || len(folderReq.Name) > 256 || strings.Contains(folderReq.Name, "/") {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid folder name")
+		return
+	}
+
+	// the team editors and above can create team folders
+	if folderReq.TeamID > 0 {
+		if _, ok := s.checkTeamRole(w, r, folderReq.TeamID, userID, models.RoleEditor); !ok {
+			return
+		}
+	}
+	if !s.checkFolder(w, r, userID, folderReq.TeamID, folderReq.ParentID) {
+		return
+	}
+
+	folder := &models.Folder{
+		Name:     folderReq.Name,
+		ParentID: folderReq.ParentID,
+		TeamID:   folderReq.TeamID,
+	}
+	if folderReq.TeamID == 0 {
+		folder.UserID = userID
+	}
+	folderID, err := s.store(r.Context()).CreateFolder(folder)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to create folder", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to create folder")
+		return
+	}
+	folder.FolderID = folderID
+
+	writeJSON(w, folder)
+}
+
+// FolderListHandler: handles the request to list the content of a folder,
+// e.g. /folder/list?team_id=1&folder_id=2, the personal root folder if both are omitted
+func (s *Server) FolderListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	teamID, folderID, ok := parseFolderTarget(w, r)
+	if !ok {
+		return
+	}
+	if teamID > 0 {
+		if _, ok := s.checkTeamRole(w, r, teamID, userID, models.RoleViewer); !ok {
+			return
+		}
+	}
+	if !s.checkFolder(w, r, userID, teamID, folderID) {
+		return
+	}
+
+	content, err := s.store(r.Context()).GetFolderContent(userID, teamID, folderID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get folder content", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get folder content")
+		return
+	}
+	writeJSON(w, content)
+}
+
+// FolderDeleteHandler: handles the request to delete a folder, the files inside are moved to the root
+func (s *Server) FolderDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	folderID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/folder/delete/"))
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+	folder, err := s.store(r.Context()).GetFolder(folderID)
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "folder not found")
+		return
+	}
+	if folder.TeamID > 0 {
+		if _, ok := s.checkTeamRole(w, r, folder.TeamID, userID, models.RoleEditor); !ok {
+			return
+		}
+	} else if folder.UserID != userID {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "folder not found")
+		return
+	}
+
+	if err := s.store(r.Context()).DeleteFolder(folderID); err != nil {
+		slog.ErrorContext(r.Context(), "failed to delete folder", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to delete folder")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "folder deleted successfully")
+}
+
+// FileMoveHandler: handles the request to move a personal file or a team file into a folder
+func (s *Server) FileMoveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	var moveReq models.MoveFileRequest
+	if err := json.NewDecoder(r.Body).Decode(&moveReq); err != nil {
+		slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to decode the request")
+		return
+	}
+
+	// check if the file belongs to the user or the team
+	if moveReq.TeamID > 0 {
+		if _, ok := s.checkTeamRole(w, r, moveReq.TeamID, userID, models.RoleEditor); !ok {
+			return
+		}
+		exist, err := s.store(r.Context()).TeamFileExists(moveReq.TeamID, moveReq.FileID)
+		if err != nil || !exist {
+			utils.WriteJSONResponse(w, http.StatusNotFound, "error", "file not found")
+			return
+		}
+	} else if !s.checkUserFile(w, r, userID, moveReq.FileID) {
+		return
+	}
+	if !s.checkFolder(w, r, userID, moveReq.TeamID, moveReq.FolderID) {
+		return
+	}
+
+	if err := s.store(r.Context()).MoveFile(userID, moveReq.TeamID, moveReq.FileID, moveReq.FolderID); err != nil {
+		slog.ErrorContext(r.Context(), "failed to move file", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to move file")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "file moved successfully")
+}
+
+// parseFolderTarget: get the optional team_id and folder_id from the request, 0 if omitted
+func parseFolderTarget(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	ids := make([]int, 2)
+	for i, key := range []string{"team_id", "folder_id"} {
+		value := r.FormValue(key)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.Atoi(value)
+		if err != nil || id < 0 {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+			return 0, 0, false
+		}
+		ids[i] = id
+	}
+	return ids[0], ids[1], true
+}
+
+// checkUploadTarget: get the optional team and folder the file of the uploader is uploaded into,
+// the team editors and above can upload team files. The uploader is the authenticated user, the one
+// checked here is the one saved as the uploader
+func (s *Server) checkUploadTarget(w http.ResponseWriter, r *http.Request, userID int) (int, int, bool) {
+	teamID, folderID, ok := parseFolderTarget(w, r)
+	if !ok {
+		return 0, 0, false
+	}
+	if teamID > 0 {
+		if _, ok := s.checkTeamRole(w, r, teamID, userID, models.RoleEditor); !ok {
+			return 0, 0, false
+		}
+	}
+	if !s.checkFolder(w, r, userID, teamID, folderID) {
+		return 0, 0, false
+	}
+	return teamID, folderID, true
+}
+
+// saveUploadedFileDB: save the uploaded file as a team file if teamID is set, as a user file otherwise,
+// and move it into the folder
+func (s *Server) saveUploadedFileDB(ctx context.Context, fileMetas *models.FileMeta, userID int, teamID int, folderID int) error {
+	var err error
+	if teamID > 0 {
+		err = s.SaveTeamFileDB(ctx, fileMetas, teamID, userID)
+	} else {
+		err = s.SaveUserFileDB(ctx, fileMetas, userID)
+	}
+	if err != nil {
+		return err
+	}
+	if folderID > 0 {
+		return s.store(ctx).MoveFile(userID, teamID, fileMetas.FileID, folderID)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/utils"
+)
+
+// dependencyCheck: a dependency checked by the readiness probe
+type dependencyCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// SetShuttingDown: fail the readiness probe from now on, called when the server starts shutting down
+func (s *Server) SetShuttingDown() {
+	s.shuttingDown.Store(true)
+}
+
+// HealthzHandler: the liveness probe, the process is up and serving
+func (s *Server) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, models.HealthStatusOK, "alive")
+}
+
+// ReadyzHandler: the readiness probe, checks the dependencies concurrently and fails
+// if one of them fails or the server is shutting down
+func (s *Server) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+
+	status := models.ReadinessStatus{
+		Status:       models.HealthStatusOK,
+		Dependencies: s.checkDependencies(r.Context()),
+	}
+	for _, dependency := range status.Dependencies {
+		if dependency.Status != models.HealthStatusOK {
+			status.Status = models.HealthStatusUnavailable
+		}
+	}
+	if s.shuttingDown.Load() {
+		status.Status = models.HealthStatusShuttingDown
+	}
+
+	statusCode := http.StatusOK
+	if status.Status != models.HealthStatusOK {
+		statusCode = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		slog.Error("failed to encode the response", "error", err)
+	}
+}
+
+// dependencies: the dependencies of the server, the services not connected are left out
+func (s *Server) dependencies() []dependencyCheck {
+	var checks []dependencyCheck
+	if s.db != nil {
+		checks = append(checks, dependencyCheck{s.cfg.Database.Driver, s.db.Ping})
+	}
+	if s.redis != nil {
+		checks = append(checks, dependencyCheck{"redis", s.redis.Ping})
+	}
+	if s.mq != nil {
+		checks = append(checks, dependencyCheck{"rabbitmq", func(context.Context) error { return s.mq.Ping() }})
+	}
+	if s.oss != nil {
+		checks = append(checks, dependencyCheck{"oss", func(context.Context) error { return s.oss.Ping() }})
+	}
+	return append(checks,
+		dependencyCheck{"file_store_dir", s.freeSpaceCheck(s.cfg.Storage.FileStoreDir)},
+		dependencyCheck{"file_chunk_dir", s.freeSpaceCheck(s.cfg.Storage.FileChunkDir)},
+	)
+}
+
+// checkDependencies: check the dependencies concurrently, each within the health timeout
+func (s *Server) checkDependencies(ctx context.Context) map[string]models.DependencyStatus {
+	checks := s.dependencies()
+	results := make(map[string]models.DependencyStatus, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, dependency := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := runCheck(ctx, s.cfg.Health.Timeout, dependency.check)
+			result := models.DependencyStatus{Status: models.HealthStatusOK, DurationMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status, result.Error = models.HealthStatusError, err.Error()
+			}
+
+			mu.Lock()
+			results[dependency.name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// runCheck: run the check with the timeout, the checks of the clients without a context
+// are abandoned when it expires
+func runCheck(ctx context.Context, timeout time.Duration, check func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+}
+
+// freeSpaceCheck: check the filesystem of the directory has the minimum free space
+func (s *Server) freeSpaceCheck(dir string) func(ctx context.Context) error {
+	return func(context.Context) error {
+		free, _, err := utils.DiskUsage(dir)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if free < uint64(s.cfg.Health.MinFreeBytes) {
+			return fmt.Errorf("%d bytes free, the minimum is %d", free, s.cfg.Health.MinFreeBytes)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/db"
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// loginFailedMessage: the same message for unknown users, wrong passwords and locked accounts,
+// so that the response does not tell which usernames exist
+const loginFailedMessage = "invalid username or password"
+
+// dummyPasswordHash: compared for the unknown users so that they take as long as the known ones
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// loginFailureKey: the key of the failed attempts of the username, usernames are case-insensitive in the database
+func loginFailureKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+// loginDelay: the wait before the next attempt after the failures, doubled with every failure
+func loginDelay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	delay := config.LoginBaseDelay
+	for i := 1; i < failures && delay < config.LoginMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > config.LoginMaxDelay {
+		delay = config.LoginMaxDelay
+	}
+	return delay
+}
+
+// checkLoginThrottle: check the failed attempts of the ip and the username, returns the time to wait before the next attempt
+func (s *Server) checkLoginThrottle(r *http.Request, username string) (time.Duration, error) {
+	ipFailures, err := s.rdb(r.Context()).GetLoginFailures("ip:" + utils.ClientIP(r))
+	if err != nil {
+		return 0, err
+	}
+	if ipFailures.Count >= config.LoginMaxFailuresPerIP {
+		return time.Until(ipFailures.Last.Add(config.LoginFailureWindow)), nil
+	}
+
+	userFailures, err := s.rdb(r.Context()).GetLoginFailures(loginFailureKey(username))
+	if err != nil {
+		return 0, err
+	}
+	return time.Until(userFailures.Last.Add(loginDelay(userFailures.Count))), nil
+}
+
+// writeLoginThrottled: reject the attempt until the wait is over
+func writeLoginThrottled(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second)/time.Second)+1))
+	http.Error(w, "too many failed attempts, please try again later", http.StatusTooManyRequests)
+}
+
+// recordLoginFailure: count the failed attempt of the ip and the username, and lock the account after too many failures,
+// the attempts on unknown usernames are counted as well so that the throttling does not tell which usernames exist
+func (s *Server) recordLoginFailure(r *http.Request, username string, userInfo *models.UserInfo) {
+	if _, err := s.rdb(r.Context()).RecordLoginFailure("ip:"+utils.ClientIP(r), config.LoginFailureWindow); err != nil {
+		slog.ErrorContext(r.Context(), "failed to record login failure", "error", err)
+	}
+	failures, err := s.rdb(r.Context()).RecordLoginFailure(loginFailureKey(username), config.LoginFailureWindow)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to record login failure", "error", err)
+		return
+	}
+	if userInfo == nil || userInfo.Status != models.UserStatusActive || failures < config.LoginMaxFailures {
+		return
+	}
+
+	until := time.Now().Add(config.LoginLockoutTime)
+	if err := s.userStore(r.Context()).LockUser(userInfo.UserID, &until); err != nil {
+		slog.ErrorContext(r.Context(), "failed to lock user", "error", err)
+		return
+	}
+	slog.WarnContext(r.Context(), "user locked after failed logins", "user_id", userInfo.UserID, "until", until.Format(time.RFC3339), "failures", failures)
+}
+
+// checkAccountStatus: check if the account can login, the temporary lockout is lifted once it is over
+func (s *Server) checkAccountStatus(ctx context.Context, userInfo *models.UserInfo) (bool, error) {
+	switch userInfo.Status {
+	case models.UserStatusActive:
+		return true, nil
+	case models.UserStatusLocked:
+		if userInfo.LockedUntil == nil || time.Now().Before(*userInfo.LockedUntil) {
+			return false, nil
+		}
+		if _, err := s.userStore(ctx).UnlockUser(userInfo.UserID); err != nil {
+			return false, err
+		}
+		userInfo.Status = models.UserStatusActive
+		userInfo.LockedUntil = nil
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// authenticateUser: check the username and the password with brute-force protection,
+// writes the response and returns nil if the login failed
+func (s *Server) authenticateUser(w http.ResponseWriter, r *http.Request, username string, password string) *models.UserInfo {
+	wait, err := s.checkLoginThrottle(r, username)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to check login failures", "error", err)
+		http.Error(w, "failed to login", http.StatusInternalServerError)
+		return nil
+	}
+	if wait > 0 {
+		writeLoginThrottled(w, wait)
+		return nil
+	}
+
+	// get the user information from the database
+	userInfo, err := s.userStore(r.Context()).GetUserInfoByUsername(username)
+	if err != nil && err != db.ErrUserNotFound {
+		slog.ErrorContext(r.Context(), "failed to get user", "error", err)
+		http.Error(w, "failed to get user", http.StatusInternalServerError)
+		return nil
+	}
+
+	// compare the password, against the dummy hash if the user does not exist
+	hash := dummyPasswordHash
+	if userInfo != nil {
+		hash = []byte(userInfo.Password)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || userInfo == nil {
+		s.recordLoginFailure(r, username, userInfo)
+		http.Error(w, loginFailedMessage, http.StatusUnauthorized)
+		return nil
+	}
+
+	allowed, err := s.checkAccountStatus(r.Context(), userInfo)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to unlock user", "error", err)
+		http.Error(w, "failed to login", http.StatusInternalServerError)
+		return nil
+	}
+	if !allowed {
+		http.Error(w, loginFailedMessage, http.StatusUnauthorized)
+		return nil
+	}
+	return userInfo
+}
+
+// resetLoginFailures: clear the failed attempts of the username once the login is complete, after the second factor if any
+func (s *Server) resetLoginFailures(ctx context.Context, username string) {
+	if err := s.rdb(ctx).ResetLoginFailures(loginFailureKey(username)); err != nil {
+		slog.ErrorContext(ctx, "failed to reset login failures", "error", err)
+	}
+}
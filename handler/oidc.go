@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/auth"
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/db"
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/utils"
+)
+
+// oidcExchangeTimeout: the timeout of the discovery and the code exchange with the identity provider
+const oidcExchangeTimeout = time.Second * 10
+
+// maxUsernameCandidates: the suffixes tried when the username of a new identity is taken
+const maxUsernameCandidates = 20
+
+// usernameInvalidChars: the characters removed from the usernames derived from the identities
+var usernameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// OIDCLoginHandler: handles the request to login with the identity provider, redirects to its authorization page
+func (s *Server) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), oidcExchangeTimeout)
+	defer cancel()
+	provider, err := auth.GetOIDCProvider(ctx)
+	if err == auth.ErrOIDCDisabled {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get oidc provider", "error", err)
+		http.Error(w, "identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	state, err := utils.GenerateToken(config.OIDCStateBytes)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to generate state", "error", err)
+		http.Error(w, "failed to login", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := utils.GenerateToken(config.OIDCStateBytes)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to generate nonce", "error", err)
+		http.Error(w, "failed to login", http.StatusInternalServerError)
+		return
+	}
+	oidcState := &models.OIDCState{Nonce: nonce, Verifier: auth.NewPKCEVerifier()}
+	if err := s.rdb(r.Context()).StoreOIDCState(state, oidcState, config.OIDCStateExpireTime); err != nil {
+		slog.ErrorContext(r.Context(), "failed to store oidc state", "error", err)
+		http.Error(w, "failed to login", http.StatusInternalServerError)
+		return
+	}
+
+	// bind the state to the browser, the callback is a cross-site redirect so the cookie must be lax
+	http.SetCookie(w, &http.Cookie{
+		Name:     "oidc_state",
+		Value:    state,
+		HttpOnly: true,
+		Secure:   true,
+		Path:     "/user/login/oidc",
+		MaxAge:   int(config.OIDCStateExpireTime.Seconds()),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, provider.AuthCodeURL(state, oidcState.Nonce, oidcState.Verifier), http.StatusFound)
+}
+
+// OIDCCallbackHandler: handles the redirect of the identity provider, verifies the ID token
+// and logs in the linked user, the user is linked or provisioned on the first login
+func (s *Server) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: "oidc_state", Path: "/user/login/oidc", HttpOnly: true, Secure: true, MaxAge: -1})
+
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		slog.WarnContext(r.Context(), "identity provider returned error", "error", errCode, "error_description", r.URL.Query().Get("error_description"))
+		http.Error(w, "login with the identity provider failed", http.StatusUnauthorized)
+		return
+	}
+
+	// the state must be the one of this browser and of a pending login
+	state := r.URL.Query().Get("state")
+	cookie, err := r.Cookie("oidc_state")
+	if state == "" || err != nil || subtle.ConstantTimeCompare([]byte(state), []byte(cookie.Value)) != 1 {
+		http.Error(w, "invalid login state, please login again", http.StatusBadRequest)
+		return
+	}
+	oidcState, err := s.rdb(r.Context()).ConsumeOIDCState(state)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get oidc state", "error", err)
+		http.Error(w, "failed to login", http.StatusInternalServerError)
+		return
+	}
+	if oidcState == nil {
+		http.Error(w, "login expired, please login again", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), oidcExchangeTimeout)
+	defer cancel()
+	provider, err := auth.GetOIDCProvider(ctx)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get oidc provider", "error", err)
+		http.Error(w, "identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+	identity, err := provider.Exchange(ctx, r.URL.Query().Get("code"), oidcState.Verifier, oidcState.Nonce)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to verify oidc login", "error", err)
+		http.Error(w, "login with the identity provider failed", http.StatusUnauthorized)
+		return
+	}
+
+	userInfo, err := s.resolveIdentityUser(r.Context(), identity)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to resolve oidc identity", "subject", identity.Subject, "issuer", identity.Issuer, "error", err)
+		http.Error(w, "failed to login", http.StatusInternalServerError)
+		return
+	}
+	if userInfo == nil {
+		http.Error(w, "no account is linked to this identity", http.StatusForbidden)
+		return
+	}
+
+	active, err := s.checkAccountStatus(r.Context(), userInfo)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to unlock user", "error", err)
+		http.Error(w, "failed to login", http.StatusInternalServerError)
+		return
+	}
+	if !active {
+		http.Error(w, "account is locked or disabled", http.StatusForbidden)
+		return
+	}
+
+	// the local second factor still applies to the single sign-on
+	userTOTP, err := s.store(r.Context()).GetTOTP(userInfo.UserID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get totp", "error", err)
+		http.Error(w, "failed to login", http.StatusInternalServerError)
+		return
+	}
+	if userTOTP != nil && userTOTP.Enabled {
+		s.startSecondFactor(w, r, userInfo.UserID)
+		return
+	}
+
+	if _, err := s.issueTokens(w, r, userInfo.UserID, userInfo.Username); err != nil {
+		slog.ErrorContext(r.Context(), "failed to generate token", "error", err)
+		http.Error(w, "failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/dashboard", http.StatusFound)
+}
+
+// resolveIdentityUser: get the user linked to the identity, link it to the account with the same verified email
+// or provision a new account if configured, nil if the identity has no account
+func (s *Server) resolveIdentityUser(ctx context.Context, identity *auth.OIDCIdentity) (*models.UserInfo, error) {
+	userInfo, err := s.store(ctx).GetUserByIdentity(identity.Issuer, identity.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if userInfo != nil {
+		if err := s.store(ctx).TouchUserIdentity(identity.Issuer, identity.Subject, identity.Email); err != nil {
+			slog.ErrorContext(ctx, "failed to update identity", "error", err)
+		}
+		return userInfo, nil
+	}
+
+	// both sides must have verified the email, or a local account registered with someone else's email
+	// would take over their identity
+	if s.cfg.OIDC.LinkByEmail && identity.Email != "" && identity.EmailVerified {
+		userInfo, err := s.userStore(ctx).GetUserInfoByEmail(identity.Email)
+		if err != nil && err != db.ErrUserNotFound {
+			return nil, err
+		}
+		if userInfo != nil && userInfo.EmailValidated {
+			if err := s.store(ctx).LinkUserIdentity(userInfo.UserID, identity.Issuer, identity.Subject, identity.Email); err != nil {
+				return nil, err
+			}
+			slog.InfoContext(ctx, "linked oidc identity to user", "subject", identity.Subject, "issuer", identity.Issuer, "target_user_id", userInfo.UserID)
+			return userInfo, nil
+		}
+	}
+
+	if !s.cfg.OIDC.AutoProvision {
+		return nil, nil
+	}
+	username, err := s.identityUsername(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := s.store(ctx).ProvisionIdentityUser(username, identity.Email, identity.EmailVerified, identity.Issuer, identity.Subject)
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "provisioned user for oidc identity", "target_user_id", userID, "subject", identity.Subject, "issuer", identity.Issuer)
+	return s.userStore(ctx).GetUserInfoByID(userID)
+}
+
+// identityUsername: derive a free username from the preferred username or the email of the identity
+func (s *Server) identityUsername(ctx context.Context, identity *auth.OIDCIdentity) (string, error) {
+	base := identity.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+	base = usernameInvalidChars.ReplaceAllString(base, "")
+	if base == "" {
+		base = "user"
+	}
+	if len(base) > 60 {
+		base = base[:60]
+	}
+
+	for i := 1; i <= maxUsernameCandidates; i++ {
+		username := base
+		if i > 1 {
+			username = fmt.Sprintf("%s-%d", base, i)
+		}
+		_, err := s.userStore(ctx).GetUserInfoByUsername(username)
+		if err == db.ErrUserNotFound {
+			return username, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free username for %s", base)
+}
@@ -0,0 +1,328 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/mail"
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// phonePattern: the characters allowed in the phone numbers
+var phonePattern = regexp.MustCompile(`^\+?[0-9 -]*$`)
+
+// getCurrentUser: get the user of the request from the database, writes the response if it fails
+func (s *Server) getCurrentUser(w http.ResponseWriter, r *http.Request) (*models.UserInfo, bool) {
+	userID, _ := getUserFromContext(r)
+	userInfo, err := s.userStore(r.Context()).GetUserInfoByID(userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get user", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get user")
+		return nil, false
+	}
+	return userInfo, true
+}
+
+// checkCurrentPassword: re-authenticate the user with the password before a sensitive change,
+// the wrong passwords count towards the lockout like the failed logins
+func (s *Server) checkCurrentPassword(w http.ResponseWriter, r *http.Request, userInfo *models.UserInfo, password string) bool {
+	// the accounts created by the single sign-on have no password until it is reset
+	if userInfo.Password == "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "the account has no password, please reset it first")
+		return false
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(userInfo.Password), []byte(password)); err != nil {
+		s.recordLoginFailure(r, userInfo.Username, userInfo)
+		utils.WriteJSONResponse(w, http.StatusUnauthorized, "error", "invalid password")
+		return false
+	}
+	return true
+}
+
+// validateProfile: check the lengths and formats of the profile fields
+func validateProfile(profile *models.UserProfile) error {
+	if len(profile.DisplayName) > config.MaxDisplayNameLength || len(profile.Bio) > config.MaxBioLength ||
+		len(profile.AvatarURL) > config.MaxAvatarURLLength || len(profile.Locale) > config.MaxLocaleLength {
+		return errors.New("profile field too long")
+	}
+	if profile.AvatarURL != "" {
+		u, err := url.Parse(profile.AvatarURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return errors.New("the avatar url must be an https url")
+		}
+	}
+	if profile.Timezone != "" {
+		if _, err := time.LoadLocation(profile.Timezone); err != nil {
+			return errors.New("unknown timezone")
+		}
+	}
+	return nil
+}
+
+// UserProfileHandler: handles the request to view (GET) or update (PUT) the profile of the user
+func (s *Server) UserProfileHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		userInfo, ok := s.getCurrentUser(w, r)
+		if !ok {
+			return
+		}
+		writeJSON(w, userInfo)
+	case http.MethodPut:
+		userID, _ := getUserFromContext(r)
+
+		var profileReq models.UpdateProfileRequest
+		if err := json.NewDecoder(r.Body).Decode(&profileReq); err != nil {
+			slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
+			utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to decode the request")
+			return
+		}
+		if profileReq.Phone != nil && (len(*profileReq.Phone) > config.MaxPhoneLength || !phonePattern.MatchString(*profileReq.Phone)) {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid phone")
+			return
+		}
+		if profileReq.Profile != nil {
+			if err := validateProfile(profileReq.Profile); err != nil {
+				utils.WriteJSONResponse(w, http.StatusBadRequest, "error", err.Error())
+				return
+			}
+		}
+
+		if err := s.userStore(r.Context()).UpdateUserProfile(userID, profileReq.Phone, profileReq.Profile); err != nil {
+			slog.ErrorContext(r.Context(), "failed to update profile", "error", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to update profile")
+			return
+		}
+		utils.WriteJSONResponse(w, http.StatusOK, "success", "profile updated successfully")
+	default:
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+	}
+}
+
+// PasswordChangeHandler: handles the request to change the password with the current one,
+// the other sessions of the user are revoked and the current one gets new tokens
+func (s *Server) PasswordChangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+
+	var passwordReq models.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&passwordReq); err != nil {
+		slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to decode the request")
+		return
+	}
+	if len(passwordReq.NewPassword) < config.MinPasswordLength {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", fmt.Sprintf("the password must have at least %d characters", config.MinPasswordLength))
+		return
+	}
+
+	userInfo, ok := s.getCurrentUser(w, r)
+	if !ok || !s.checkCurrentPassword(w, r, userInfo, passwordReq.CurrentPassword) {
+		return
+	}
+
+	encodedPwd, err := bcrypt.GenerateFromPassword([]byte(passwordReq.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode the password", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to change password")
+		return
+	}
+	if err := s.userStore(r.Context()).UpdateUserPassword(userInfo.UserID, string(encodedPwd)); err != nil {
+		slog.ErrorContext(r.Context(), "failed to update the password", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to change password")
+		return
+	}
+
+	if err := s.rdb(r.Context()).RevokeUserSessions(userInfo.UserID); err != nil {
+		slog.ErrorContext(r.Context(), "failed to revoke sessions", "error", err)
+	}
+	if _, err := s.issueTokens(w, r, userInfo.UserID, userInfo.Username); err != nil {
+		slog.ErrorContext(r.Context(), "failed to generate token", "error", err)
+	}
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "password changed successfully")
+}
+
+// EmailChangeHandler: handles the request to change the email, the new email is used once the link mailed to it is opened
+func (s *Server) EmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+
+	var emailReq models.ChangeEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&emailReq); err != nil {
+		slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to decode the request")
+		return
+	}
+	address, err := netmail.ParseAddress(emailReq.Email)
+	if err != nil || address.Address != emailReq.Email || len(emailReq.Email) > 64 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid email")
+		return
+	}
+
+	userInfo, ok := s.getCurrentUser(w, r)
+	if !ok || !s.checkCurrentPassword(w, r, userInfo, emailReq.Password) {
+		return
+	}
+	if emailReq.Email == userInfo.Email {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "the email is not changed")
+		return
+	}
+	inUse, err := s.userStore(r.Context()).EmailInUse(emailReq.Email, userInfo.UserID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to check email", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to change email")
+		return
+	}
+	if inUse {
+		utils.WriteJSONResponse(w, http.StatusConflict, "error", "the email is already in use")
+		return
+	}
+
+	// the confirmation goes to the new email, the token remembers it
+	pending := *userInfo
+	pending.Email = emailReq.Email
+	if err := s.sendUserToken(r, &pending, models.TokenChangeEmail); err != nil {
+		if errors.Is(err, errMailRateLimited) {
+			utils.WriteJSONResponse(w, http.StatusTooManyRequests, "error", err.Error())
+			return
+		}
+		slog.ErrorContext(r.Context(), "failed to send email change mail", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to send confirmation mail")
+		return
+	}
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "confirmation mail sent to the new email")
+}
+
+// EmailConfirmHandler: handles the confirmation link of the new email, e.g. /user/email/confirm?token=xxx,
+// the old email is notified of the change
+func (s *Server) EmailConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, email, err := s.store(r.Context()).ConsumeUserToken(models.TokenChangeEmail, utils.HashToken(r.FormValue("token")))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to verify token", "error", err)
+		http.Error(w, "failed to change email", http.StatusInternalServerError)
+		return
+	}
+	if userID == 0 {
+		http.Error(w, "the link is invalid or expired", http.StatusBadRequest)
+		return
+	}
+	userInfo, err := s.userStore(r.Context()).GetUserInfoByID(userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get user", "error", err)
+		http.Error(w, "failed to change email", http.StatusInternalServerError)
+		return
+	}
+
+	// another account may have taken the email since the mail was sent
+	inUse, err := s.userStore(r.Context()).EmailInUse(email, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to check email", "error", err)
+		http.Error(w, "failed to change email", http.StatusInternalServerError)
+		return
+	}
+	if inUse {
+		http.Error(w, "the email is already in use", http.StatusConflict)
+		return
+	}
+	if err := s.userStore(r.Context()).UpdateUserEmail(userID, email); err != nil {
+		slog.ErrorContext(r.Context(), "failed to update email", "error", err)
+		http.Error(w, "failed to change email", http.StatusInternalServerError)
+		return
+	}
+
+	if userInfo.Email != "" {
+		msg := &mail.Message{
+			To:      userInfo.Email,
+			Subject: "Your email has been changed",
+			Body: fmt.Sprintf("Hi %s,\n\nThe email of your account has been changed to %s.\nIf you did not make this change, please reset your password and contact the administrator.\n",
+				userInfo.Username, email),
+		}
+		if err := s.mailer.Send(msg); err != nil {
+			slog.ErrorContext(r.Context(), "failed to send email change notice", "error", err)
+		}
+	}
+
+	http.Redirect(w, r, "/dashboard", http.StatusFound)
+}
+
+// AccountDeleteHandler: handles the request to delete the account with its files and the teams it owns,
+// the password and the second factor if enabled are required
+func (s *Server) AccountDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+
+	var deleteReq models.DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&deleteReq); err != nil {
+		slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to decode the request")
+		return
+	}
+
+	userInfo, ok := s.getCurrentUser(w, r)
+	if !ok || !s.checkCurrentPassword(w, r, userInfo, deleteReq.Password) {
+		return
+	}
+	userTOTP, err := s.store(r.Context()).GetTOTP(userInfo.UserID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get totp", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to delete account")
+		return
+	}
+	if userTOTP != nil && userTOTP.Enabled {
+		ok, err := s.verifySecondFactor(r.Context(), userTOTP, deleteReq.Code)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to verify totp", "error", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to delete account")
+			return
+		}
+		if !ok {
+			utils.WriteJSONResponse(w, http.StatusUnauthorized, "error", "invalid two-factor code")
+			return
+		}
+	}
+
+	removedPaths, err := s.userStore(r.Context()).DeleteUser(userInfo.UserID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to delete user", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to delete account")
+		return
+	}
+	s.goBackground(func() {
+		for _, filePath := range removedPaths {
+			if err := os.Remove(filePath); err != nil {
+				slog.ErrorContext(r.Context(), "failed to delete file", "error", err)
+			}
+		}
+	})
+
+	if err := s.rdb(r.Context()).RevokeUserSessions(userInfo.UserID); err != nil {
+		slog.ErrorContext(r.Context(), "failed to revoke sessions", "error", err)
+	}
+	s.resetLoginFailures(r.Context(), userInfo.Username)
+	clearTokens(w)
+	slog.InfoContext(r.Context(), "user deleted the account")
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "account deleted successfully")
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/bladewaltz9/file-store-server/db"
+	"github.com/bladewaltz9/file-store-server/utils"
+)
+
+// checkQuota: check if the user can store another file of the size, write the error response if not
+func (s *Server) checkQuota(w http.ResponseWriter, r *http.Request, userID int, size int64) bool {
+	err := s.fileStore(r.Context()).CheckUserQuota(userID, size)
+	if err == nil {
+		return true
+	}
+	if !writeQuotaError(w, err) {
+		slog.ErrorContext(r.Context(), "failed to check the quota", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to check the quota")
+	}
+	return false
+}
+
+// writeQuotaError: write the error response if the error is ErrQuotaExceeded
+func writeQuotaError(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, db.ErrQuotaExceeded) {
+		return false
+	}
+	utils.WriteJSONResponse(w, http.StatusInsufficientStorage, "error", db.ErrQuotaExceeded.Error())
+	return true
+}
+
+// UserUsageHandler: handles the request to get the storage quota and usage of the user
+func (s *Server) UserUsageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	quota, err := s.fileStore(r.Context()).GetUserQuota(userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get the quota", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get the quota")
+		return
+	}
+	writeJSON(w, quota)
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/logging"
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/mq"
+	"github.com/bladewaltz9/file-store-server/search"
+	"github.com/bladewaltz9/file-store-server/utils"
+)
+
+// FileSearchHandler: handles the full-text search request
+func (s *Server) FileSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+
+	userID, _ := getUserFromContext(r)
+
+	keyword := strings.TrimSpace(r.FormValue("q"))
+	if keyword == "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+	limit := config.SearchResultLimit
+	if limitStr := r.FormValue("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+			return
+		}
+		if n < limit {
+			limit = n
+		}
+	}
+
+	// search the files owned by the user
+	hits, err := s.store(r.Context()).SearchUserFiles(userID, keyword, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to search files", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to search files")
+		return
+	}
+	for i := range hits {
+		hits[i].Snippet = search.Snippet(hits[i].Content, keyword, config.SearchSnippetLength)
+	}
+	if hits == nil {
+		hits = []models.SearchHit{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(hits); err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode the search result", "error", err)
+		http.Error(w, "failed to encode the search result", http.StatusInternalServerError)
+	}
+}
+
+// publishIndexMessage: sends the file to the full-text index queue if its type is supported
+func (s *Server) publishIndexMessage(ctx context.Context, fileMetas *models.FileMeta) {
+	if !search.IsSupported(fileMetas.FileName) {
+		return
+	}
+
+	indexMsg := &mq.FileIndexMessage{
+		FileID:    fileMetas.FileID,
+		LocalFile: fileMetas.FilePath,
+		FileName:  fileMetas.FileName,
+		RequestID: logging.RequestID(ctx),
+	}
+	if err := s.index.PublishMessage(ctx, indexMsg); err != nil {
+		slog.ErrorContext(ctx, "failed to publish index message", "error", err)
+	}
+}
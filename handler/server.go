@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/db"
+	"github.com/bladewaltz9/file-store-server/mail"
+	"github.com/bladewaltz9/file-store-server/mq"
+	"github.com/bladewaltz9/file-store-server/oss"
+	"github.com/bladewaltz9/file-store-server/redis"
+	"github.com/bladewaltz9/file-store-server/utils"
+)
+
+// Publisher: publishes the messages of a queue, implemented by mq.RabbitMQ and the recorders of the tests
+type Publisher interface {
+	PublishMessage(ctx context.Context, msg interface{}) error
+}
+
+// Server: the services the handlers work with, the handlers are its methods
+type Server struct {
+	cfg    *config.Config
+	db     *db.DB
+	users  db.UserRepository
+	files  db.FileRepository
+	redis  *redis.Client
+	mq     *mq.Client
+	oss    *oss.Client
+	mailer mail.Mailer
+
+	// transfer and index: the publishers of the OSS transfer and the full-text index queues
+	transfer Publisher
+	index    Publisher
+
+	// background: the work left running by the handlers after their responses
+	background sync.WaitGroup
+	// shuttingDown: the readiness probe fails once the server starts shutting down
+	shuttingDown atomic.Bool
+}
+
+// NewServer: create the handlers of the services
+func NewServer(cfg *config.Config, database *db.DB, rdb *redis.Client, mqClient *mq.Client, ossClient *oss.Client, mailer mail.Mailer) *Server {
+	s := &Server{
+		cfg:    cfg,
+		db:     database,
+		redis:  rdb,
+		mq:     mqClient,
+		oss:    ossClient,
+		mailer: mailer,
+	}
+	if database != nil {
+		s.users, s.files = database, database
+	}
+	if mqClient != nil {
+		s.transfer, s.index = mqClient.Transfer, mqClient.Index
+	}
+	return s
+}
+
+// WithRepositories: use the repositories instead of the database for the users and the files, e.g. an in-memory store in the tests
+func (s *Server) WithRepositories(users db.UserRepository, files db.FileRepository) *Server {
+	s.users, s.files = users, files
+	return s
+}
+
+// WithPublishers: publish the messages of the transfer and the index queues with the publishers instead of RabbitMQ
+func (s *Server) WithPublishers(transfer Publisher, index Publisher) *Server {
+	s.transfer, s.index = transfer, index
+	return s
+}
+
+// store, userStore, fileStore and rdb: the services bound to ctx, the context of the request,
+// their queries and commands are traced in its span
+func (s *Server) store(ctx context.Context) *db.DB {
+	return s.db.WithContext(ctx)
+}
+
+func (s *Server) userStore(ctx context.Context) db.UserRepository {
+	if d, ok := s.users.(*db.DB); ok {
+		return d.WithContext(ctx)
+	}
+	return s.users
+}
+
+func (s *Server) fileStore(ctx context.Context) db.FileRepository {
+	if d, ok := s.files.(*db.DB); ok {
+		return d.WithContext(ctx)
+	}
+	return s.files
+}
+
+func (s *Server) rdb(ctx context.Context) *redis.Client {
+	return s.redis.WithContext(ctx)
+}
+
+// goBackground: run fn after the response, e.g. removing the deleted files from the disk,
+// Wait waits for it on shutdown
+func (s *Server) goBackground(fn func()) {
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		fn()
+	}()
+}
+
+// Wait: wait for the background work of the handlers, or return the error of the context if it is done first
+func (s *Server) Wait(ctx context.Context) error {
+	return utils.WaitContext(ctx, &s.background)
+}
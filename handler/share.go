@@ -0,0 +1,467 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/logging"
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ShareCreateHandler: handles the request to create a public share link of a file
+func (s *Server) ShareCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	// decode the request body
+	var shareReq models.CreateShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&shareReq); err != nil {
+		slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to decode the request")
+		return
+	}
+	expireTime := time.Duration(shareReq.ExpireHours) * time.Hour
+	if shareReq.ExpireHours < 0 || expireTime > config.ShareMaxExpireTime || shareReq.MaxDownloads < 0 ||
+		shareReq.FileID < 0 || shareReq.FolderID < 0 || (shareReq.FileID > 0) == (shareReq.FolderID > 0) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+
+	// check if the user owns the file or can manage the folder
+	if shareReq.FileID > 0 && !s.checkUserFile(w, r, userID, shareReq.FileID) {
+		return
+	}
+	if shareReq.FolderID > 0 && !s.checkShareFolder(w, r, userID, shareReq.FolderID) {
+		return
+	}
+
+	link := &models.ShareLink{
+		UserID:       userID,
+		FileID:       shareReq.FileID,
+		FolderID:     shareReq.FolderID,
+		MaxDownloads: shareReq.MaxDownloads,
+	}
+	if shareReq.ExpireHours > 0 {
+		expireAt := time.Now().Add(expireTime)
+		link.ExpireAt = &expireAt
+	}
+
+	// encode the password
+	if shareReq.Password != "" {
+		encodedPwd, err := bcrypt.GenerateFromPassword([]byte(shareReq.Password), bcrypt.DefaultCost)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to encode the password", "error", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to encode the password")
+			return
+		}
+		link.Password = string(encodedPwd)
+	}
+
+	// generate the random token
+	token, err := utils.GenerateToken(config.ShareTokenBytes)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to generate token", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to generate token")
+		return
+	}
+	link.Token = token
+
+	linkID, err := s.store(r.Context()).SaveShareLink(link)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to save share link", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to save share link")
+		return
+	}
+
+	response := models.CreateShareResponse{
+		LinkID: linkID,
+		Token:  token,
+		URL:    fmt.Sprintf("https://%s/s/%s", r.Host, token),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode the share link", "error", err)
+		http.Error(w, "failed to encode the share link", http.StatusInternalServerError)
+	}
+}
+
+// checkShareFolder: check if the folder is a personal folder of the user or a team folder the user
+// can edit, write the error response if not
+func (s *Server) checkShareFolder(w http.ResponseWriter, r *http.Request, userID int, folderID int) bool {
+	folder, err := s.store(r.Context()).GetFolder(folderID)
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "folder not found")
+		return false
+	}
+	if folder.TeamID > 0 {
+		_, ok := s.checkTeamRole(w, r, folder.TeamID, userID, models.RoleEditor)
+		return ok
+	}
+	if folder.UserID != userID {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "folder not found")
+		return false
+	}
+	return true
+}
+
+// ShareListHandler: handles the request to list the share links of the user
+func (s *Server) ShareListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	links, err := s.store(r.Context()).GetUserShareLinks(userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get share links", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get share links")
+		return
+	}
+	if links == nil {
+		links = []models.ShareLink{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(links); err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode the share links", "error", err)
+		http.Error(w, "failed to encode the share links", http.StatusInternalServerError)
+	}
+}
+
+// ShareRevokeHandler: handles the request to revoke a share link
+func (s *Server) ShareRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	linkID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/share/revoke/"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to convert link_id to int", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+
+	ok, err := s.store(r.Context()).RevokeShareLink(userID, linkID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to revoke share link", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to revoke share link")
+		return
+	}
+	if !ok {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "share link not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "share link revoked successfully")
+}
+
+// ShareLogsHandler: handles the request to get the access logs of a share link
+func (s *Server) ShareLogsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	linkID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/share/logs/"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to convert link_id to int", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+
+	logs, err := s.store(r.Context()).GetShareAccessLogs(userID, linkID, config.ShareLogLimit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get share access logs", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get share access logs")
+		return
+	}
+	if logs == nil {
+		logs = []models.ShareAccessLog{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(logs); err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode the share access logs", "error", err)
+		http.Error(w, "failed to encode the share access logs", http.StatusInternalServerError)
+	}
+}
+
+// SharePageHandler: handles the unauthenticated landing page and download of a share link,
+// /s/{token} shows the landing page and /s/{token}/download downloads the file, or the file
+// named by file_id of a shared folder, the password is only accepted in a POST form
+func (s *Server) SharePageHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/s/")
+	token, action, _ := strings.Cut(path, "/")
+	if token == "" || (action != "" && action != "download") {
+		http.NotFound(w, r)
+		return
+	}
+
+	link, err := s.store(r.Context()).GetShareLinkByToken(token)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if action == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+			return
+		}
+		s.logShareAccess(r, link, "view", shareLinkState(link))
+		s.renderSharePage(w, r, link, shareLinkState(link))
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+	s.shareDownload(w, r, link)
+}
+
+// shareDownload: check the password and the limits of the share link and send the file
+func (s *Server) shareDownload(w http.ResponseWriter, r *http.Request, link *models.ShareLink) {
+	if state := shareLinkState(link); state != "" {
+		s.logShareAccess(r, link, "denied", state)
+		s.renderSharePage(w, r, link, state)
+		return
+	}
+
+	// check the password, the guesses are limited per link and client ip
+	if link.HasPassword {
+		if r.Method != http.MethodPost {
+			s.logShareAccess(r, link, "denied", "password required")
+			s.renderSharePage(w, r, link, "password required")
+			return
+		}
+		key := fmt.Sprintf("share:%s:ip:%s", utils.HashToken(link.Token), utils.ClientIP(r))
+		allowed, err := s.rdb(r.Context()).AllowRate(key, config.SharePasswordMaxAttempts, config.SharePasswordWindow)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to check the share password rate", "error", err)
+			http.Error(w, "failed to download file", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			s.logShareAccess(r, link, "denied", "too many attempts")
+			s.renderSharePage(w, r, link, "too many attempts, please try again later")
+			return
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(link.Password), []byte(r.PostFormValue("password"))); err != nil {
+			s.logShareAccess(r, link, "denied", "invalid password")
+			s.renderSharePage(w, r, link, "invalid password")
+			return
+		}
+	}
+
+	// list the files of the protected folder once the password is accepted
+	if link.FolderID > 0 && link.HasPassword && r.FormValue("file_id") == "" {
+		s.logShareAccess(r, link, "view", "ok")
+		s.renderShareFolder(w, r, link, r.PostFormValue("password"))
+		return
+	}
+
+	// get the shared file, or the requested file of the shared folder
+	fileID, fileName := link.FileID, link.FileName
+	if link.FolderID > 0 {
+		file, ok := s.shareFolderFile(w, r, link)
+		if !ok {
+			return
+		}
+		fileID, fileName = file.FileID, file.FileName
+	}
+	fileMeta, err := s.fileStore(r.Context()).GetFileMeta(fileID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get file metadata", "error", err)
+		http.Error(w, "failed to get file metadata", http.StatusInternalServerError)
+		return
+	}
+
+	// open the file
+	file, err := os.Open(fileMeta.FilePath)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to open file", "error", err)
+		http.Error(w, "failed to open file", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	// count the download once the file can be sent, the link may be used up concurrently
+	ok, err := s.store(r.Context()).ConsumeShareDownload(link.LinkID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to count share download", "error", err)
+		http.Error(w, "failed to download file", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		s.logShareAccess(r, link, "denied", "download limit reached")
+		s.renderSharePage(w, r, link, "download limit reached")
+		return
+	}
+	s.logShareAccess(r, link, "download", "ok")
+
+	// set the response header
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+
+	// send the file content to the client
+	http.ServeContent(w, r, fileName, fileMeta.UpdateAt, file)
+}
+
+// shareFolderFiles: get the files directly inside the shared folder, the sub folders are not shared
+func (s *Server) shareFolderFiles(r *http.Request, link *models.ShareLink) ([]models.FileInfo, error) {
+	folder, err := s.store(r.Context()).GetFolder(link.FolderID)
+	if err != nil {
+		return nil, err
+	}
+	content, err := s.store(r.Context()).GetFolderContent(folder.UserID, folder.TeamID, folder.FolderID)
+	if err != nil {
+		return nil, err
+	}
+	return content.Files, nil
+}
+
+// shareFolderFile: get the file named by file_id from the shared folder, write the error response if
+// it is not inside the folder
+func (s *Server) shareFolderFile(w http.ResponseWriter, r *http.Request, link *models.ShareLink) (*models.FileInfo, bool) {
+	fileID, err := strconv.Atoi(r.FormValue("file_id"))
+	if err != nil {
+		http.Error(w, "invalid parameter", http.StatusBadRequest)
+		return nil, false
+	}
+	files, err := s.shareFolderFiles(r, link)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get the shared folder content", "error", err)
+		http.Error(w, "failed to get the shared folder content", http.StatusInternalServerError)
+		return nil, false
+	}
+	for i := range files {
+		if files[i].FileID == fileID {
+			logging.AddAttrs(r.Context(), slog.Int("file_id", fileID))
+			return &files[i], true
+		}
+	}
+	http.NotFound(w, r)
+	return nil, false
+}
+
+// shareLinkState: get the reason why the share link can not be used, empty if it is usable
+func shareLinkState(link *models.ShareLink) string {
+	if link.Status != "active" {
+		return "share link has been revoked"
+	}
+	if link.ExpireAt != nil && time.Now().After(*link.ExpireAt) {
+		return "share link has expired"
+	}
+	if link.MaxDownloads > 0 && link.DownloadCount >= link.MaxDownloads {
+		return "download limit reached"
+	}
+	return ""
+}
+
+// logShareAccess: save the access log of the share link, failures are only logged
+func (s *Server) logShareAccess(r *http.Request, link *models.ShareLink, action string, result string) {
+	if result == "" {
+		result = "ok"
+	}
+	agent := r.UserAgent()
+	if len(agent) > 256 {
+		agent = agent[:256]
+	}
+	accessLog := &models.ShareAccessLog{
+		LinkID: link.LinkID,
+		IP:     utils.ClientIP(r),
+		Agent:  agent,
+		Action: action,
+		Result: result,
+	}
+	if err := s.store(r.Context()).SaveShareAccessLog(accessLog); err != nil {
+		slog.ErrorContext(r.Context(), "failed to save share access log", "error", err)
+	}
+}
+
+// renderSharePage: render the landing page of the share link, the files of a shared folder are
+// listed only if it has no password
+func (s *Server) renderSharePage(w http.ResponseWriter, r *http.Request, link *models.ShareLink, errMsg string) {
+	data := models.SharePageData{
+		Token:       link.Token,
+		FileName:    link.FileName,
+		HasPassword: link.HasPassword,
+		ExpireAt:    link.ExpireAt,
+		Error:       errMsg,
+	}
+	if link.FolderID > 0 {
+		data.FileName, data.IsFolder = link.FolderName, true
+		if !link.HasPassword {
+			files, err := s.shareFolderFiles(r, link)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "failed to get the shared folder content", "error", err)
+				http.Error(w, "failed to get the shared folder content", http.StatusInternalServerError)
+				return
+			}
+			data.Files = files
+		}
+	} else {
+		fileMeta, err := s.fileStore(r.Context()).GetFileMeta(link.FileID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to get file metadata", "error", err)
+			http.Error(w, "failed to get file metadata", http.StatusInternalServerError)
+			return
+		}
+		data.FileSize = fileMeta.FileSize
+	}
+	s.executeSharePage(w, r, data)
+}
+
+// renderShareFolder: render the files of the shared folder once the password is accepted
+func (s *Server) renderShareFolder(w http.ResponseWriter, r *http.Request, link *models.ShareLink, password string) {
+	files, err := s.shareFolderFiles(r, link)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get the shared folder content", "error", err)
+		http.Error(w, "failed to get the shared folder content", http.StatusInternalServerError)
+		return
+	}
+	// the page holds the password for the downloads
+	w.Header().Set("Cache-Control", "no-store")
+	s.executeSharePage(w, r, models.SharePageData{
+		Token:    link.Token,
+		FileName: link.FolderName,
+		IsFolder: true,
+		Files:    files,
+		Password: password,
+		ExpireAt: link.ExpireAt,
+	})
+}
+
+// executeSharePage: write the share landing page
+func (s *Server) executeSharePage(w http.ResponseWriter, r *http.Request, data models.SharePageData) {
+	tmp, err := template.ParseFiles("static/view/share.html")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to parse the template", "error", err)
+		http.Error(w, "failed to parse the template", http.StatusInternalServerError)
+		return
+	}
+
+	if err = tmp.Execute(w, data); err != nil {
+		slog.ErrorContext(r.Context(), "failed to execute the template", "error", err)
+		http.Error(w, "failed to execute the template", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,409 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bladewaltz9/file-store-server/logging"
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/utils"
+)
+
+// roleRank: the rank of the team roles, owner > admin > editor > viewer
+var roleRank = map[string]int{
+	models.RoleViewer: 1,
+	models.RoleEditor: 2,
+	models.RoleAdmin:  3,
+	models.RoleOwner:  4,
+}
+
+// checkTeamRole: check if the user has at least the required role in the team,
+// write the error response if not
+func (s *Server) checkTeamRole(w http.ResponseWriter, r *http.Request, teamID int, userID int, required string) (string, bool) {
+	role, err := s.store(r.Context()).GetTeamRole(teamID, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get team role", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to check team role")
+		return "", false
+	}
+	if role == "" {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "team not found")
+		return "", false
+	}
+	if roleRank[role] < roleRank[required] {
+		utils.WriteJSONResponse(w, http.StatusForbidden, "error", "permission denied")
+		return "", false
+	}
+	return role, true
+}
+
+// canManageRole: check if a member with the role can invite, change or remove members with the target role,
+// only the owner manages the admins and nobody manages the owner
+func canManageRole(role string, target string) bool {
+	if target == models.RoleOwner {
+		return false
+	}
+	if role == models.RoleOwner {
+		return true
+	}
+	return role == models.RoleAdmin && roleRank[target] < roleRank[models.RoleAdmin]
+}
+
+// TeamCreateHandler: handles the request to create a team, the creator becomes the owner
+func (s *Server) TeamCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	var teamReq models.CreateTeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&teamReq); err != nil {
+		slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to decode the request")
+		return
+	}
+	teamReq.Name = strings.TrimSpace(teamReq.Name)
+	if teamReq.Name == "" || len(teamReq.Name) > 64 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid team name")
+		return
+	}
+
+	teamID, err := s.store(r.Context()).CreateTeam(teamReq.Name, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to create team", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to create team")
+		return
+	}
+
+	writeJSON(w, models.TeamInfo{TeamID: teamID, Name: teamReq.Name, OwnerID: userID, Role: models.RoleOwner})
+}
+
+// TeamListHandler: handles the request to list the teams of the user
+func (s *Server) TeamListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	teams, err := s.store(r.Context()).GetUserTeams(userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get teams", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get teams")
+		return
+	}
+	if teams == nil {
+		teams = []models.TeamInfo{}
+	}
+	writeJSON(w, teams)
+}
+
+// TeamMembersHandler: handles the request to list the members of a team, for any member
+func (s *Server) TeamMembersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	teamID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/team/members/"))
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+	if _, ok := s.checkTeamRole(w, r, teamID, userID, models.RoleViewer); !ok {
+		return
+	}
+
+	members, err := s.store(r.Context()).GetTeamMembers(teamID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get team members", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get team members")
+		return
+	}
+	writeJSON(w, members)
+}
+
+// TeamInviteHandler: handles the request to invite a user to a team, for the admins and the owner
+func (s *Server) TeamInviteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	var inviteReq models.TeamInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&inviteReq); err != nil {
+		slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to decode the request")
+		return
+	}
+	if _, ok := roleRank[inviteReq.Role]; !ok {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid role")
+		return
+	}
+
+	role, ok := s.checkTeamRole(w, r, inviteReq.TeamID, userID, models.RoleAdmin)
+	if !ok {
+		return
+	}
+	if !canManageRole(role, inviteReq.Role) {
+		utils.WriteJSONResponse(w, http.StatusForbidden, "error", "permission denied")
+		return
+	}
+
+	// get the invitee
+	invitee, err := s.userStore(r.Context()).GetUserInfoByUsername(inviteReq.Username)
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "user not found")
+		return
+	}
+	if inviteeRole, err := s.store(r.Context()).GetTeamRole(inviteReq.TeamID, invitee.UserID); err != nil || inviteeRole != "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "user is already a member")
+		return
+	}
+
+	if _, err := s.store(r.Context()).SaveTeamInvite(inviteReq.TeamID, userID, invitee.UserID, inviteReq.Role); err != nil {
+		slog.ErrorContext(r.Context(), "failed to save team invite", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to invite user")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "user invited successfully")
+}
+
+// TeamInvitesHandler: handles the request to list the pending invitations of the user
+func (s *Server) TeamInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	invites, err := s.store(r.Context()).GetPendingInvites(userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get team invites", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get team invites")
+		return
+	}
+	if invites == nil {
+		invites = []models.TeamInvite{}
+	}
+	writeJSON(w, invites)
+}
+
+// TeamInviteRespondHandler: handles the request to accept or decline an invitation,
+// /team/invite/accept/{invite_id} or /team/invite/decline/{invite_id}
+func (s *Server) TeamInviteRespondHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	action, inviteIDStr, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/team/invite/"), "/")
+	inviteID, err := strconv.Atoi(inviteIDStr)
+	if err != nil || (action != "accept" && action != "decline") {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+
+	ok, err := s.store(r.Context()).RespondTeamInvite(inviteID, userID, action == "accept")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to respond team invite", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to respond invite")
+		return
+	}
+	if !ok {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "invite not found")
+		return
+	}
+
+	if action == "accept" {
+		utils.WriteJSONResponse(w, http.StatusOK, "success", "invite accepted successfully")
+	} else {
+		utils.WriteJSONResponse(w, http.StatusOK, "success", "invite declined successfully")
+	}
+}
+
+// TeamMemberRoleHandler: handles the request to change the role of a team member
+func (s *Server) TeamMemberRoleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	var memberReq models.TeamMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&memberReq); err != nil {
+		slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to decode the request")
+		return
+	}
+	if _, ok := roleRank[memberReq.Role]; !ok {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid role")
+		return
+	}
+
+	role, ok := s.checkTeamRole(w, r, memberReq.TeamID, userID, models.RoleAdmin)
+	if !ok {
+		return
+	}
+	targetRole, err := s.store(r.Context()).GetTeamRole(memberReq.TeamID, memberReq.UserID)
+	if err != nil || targetRole == "" {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "member not found")
+		return
+	}
+	if !canManageRole(role, targetRole) || !canManageRole(role, memberReq.Role) {
+		utils.WriteJSONResponse(w, http.StatusForbidden, "error", "permission denied")
+		return
+	}
+
+	if err := s.store(r.Context()).UpdateTeamMemberRole(memberReq.TeamID, memberReq.UserID, memberReq.Role); err != nil {
+		slog.ErrorContext(r.Context(), "failed to update team member role", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to update member role")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "member role updated successfully")
+}
+
+// TeamMemberRemoveHandler: handles the request to remove a member from a team, any member can leave the team
+func (s *Server) TeamMemberRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	var memberReq models.TeamMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&memberReq); err != nil {
+		slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to decode the request")
+		return
+	}
+
+	role, ok := s.checkTeamRole(w, r, memberReq.TeamID, userID, models.RoleViewer)
+	if !ok {
+		return
+	}
+	targetRole, err := s.store(r.Context()).GetTeamRole(memberReq.TeamID, memberReq.UserID)
+	if err != nil || targetRole == "" {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "member not found")
+		return
+	}
+	if targetRole == models.RoleOwner {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "the owner can not leave the team")
+		return
+	}
+	if memberReq.UserID != userID && !canManageRole(role, targetRole) {
+		utils.WriteJSONResponse(w, http.StatusForbidden, "error", "permission denied")
+		return
+	}
+
+	if err := s.store(r.Context()).RemoveTeamMember(memberReq.TeamID, memberReq.UserID); err != nil {
+		slog.ErrorContext(r.Context(), "failed to remove team member", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to remove member")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "member removed successfully")
+}
+
+// TeamDeleteHandler: handles the request to delete a team with its files, for the owner
+func (s *Server) TeamDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	teamID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/team/delete/"))
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+	if _, ok := s.checkTeamRole(w, r, teamID, userID, models.RoleOwner); !ok {
+		return
+	}
+
+	removedPaths, err := s.store(r.Context()).DeleteTeam(teamID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to delete team", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to delete team")
+		return
+	}
+
+	// delete the files from the local disk if the reference count is 0
+	s.goBackground(func() {
+		for _, filePath := range removedPaths {
+			if err := os.Remove(filePath); err != nil {
+				slog.ErrorContext(r.Context(), "failed to delete file", "error", err)
+			}
+		}
+	})
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "team deleted successfully")
+}
+
+// TeamFileDeleteHandler: handles the request to delete a team file, for the editors and above,
+// /team/file/delete/{team_id}/{file_id}
+func (s *Server) TeamFileDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/team/file/delete/"), "/")
+	if len(parts) != 2 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+	teamID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+	fileID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+	if _, ok := s.checkTeamRole(w, r, teamID, userID, models.RoleEditor); !ok {
+		return
+	}
+
+	logging.AddAttrs(r.Context(), slog.Int("file_id", fileID))
+	ok, filePath, err := s.store(r.Context()).DeleteTeamFile(teamID, fileID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to delete team file", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to delete file")
+		return
+	}
+
+	// delete the file from the local disk if the reference count is 0
+	if ok {
+		s.goBackground(func() {
+			if err := os.Remove(filePath); err != nil {
+				slog.ErrorContext(r.Context(), "failed to delete file", "error", err)
+			}
+		})
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "file deleted successfully")
+}
+
+// writeJSON: write the value as the JSON response
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Error("failed to encode the response", "error", err)
+		http.Error(w, "failed to encode the response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/auth"
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/db"
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/utils"
+)
+
+// issueTokens: issue a new access token and refresh token for the user and set them in the cookies
+func (s *Server) issueTokens(w http.ResponseWriter, r *http.Request, userID int, username string) (*models.TokenResponse, error) {
+	// the token version changes when all sessions of the user are revoked
+	version, err := s.rdb(r.Context()).GetTokenVersion(userID)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, _, err := auth.IssueAccessToken(userID, username, version)
+	if err != nil {
+		return nil, err
+	}
+
+	// generate the refresh token, only its hash is kept on the server
+	refreshToken, err := utils.GenerateToken(config.RefreshTokenBytes)
+	if err != nil {
+		return nil, err
+	}
+	session := &models.RefreshSession{UserID: userID, Username: username}
+	if err := s.rdb(r.Context()).StoreRefreshToken(refreshToken, session, config.RefreshTokenExpirationTime); err != nil {
+		return nil, err
+	}
+
+	// set the tokens in the cookies, the access token is sent on the links followed from other sites
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    accessToken,
+		HttpOnly: true, // prevent the client from accessing the cookie
+		Secure:   true,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    refreshToken,
+		HttpOnly: true,
+		Secure:   true,
+		Path:     "/user/",
+		MaxAge:   int(config.RefreshTokenExpirationTime.Seconds()),
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return &models.TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(config.JWTExpirationTime.Seconds()),
+	}, nil
+}
+
+// clearTokens: remove the token cookies from the client
+func clearTokens(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: "token", Path: "/", HttpOnly: true, Secure: true, MaxAge: -1, SameSite: http.SameSiteLaxMode})
+	http.SetCookie(w, &http.Cookie{Name: "refresh_token", Path: "/user/", HttpOnly: true, Secure: true, MaxAge: -1, SameSite: http.SameSiteStrictMode})
+}
+
+// extractRefreshToken: get the refresh token from the form or the cookie
+func extractRefreshToken(r *http.Request) string {
+	if refreshToken := r.FormValue("refresh_token"); refreshToken != "" {
+		return refreshToken
+	}
+	if cookie, err := r.Cookie("refresh_token"); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// TokenRefreshHandler: handles the request to exchange the refresh token for new tokens,
+// the used refresh token is invalidated
+func (s *Server) TokenRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+
+	refreshToken := extractRefreshToken(r)
+	if refreshToken == "" {
+		utils.WriteJSONResponse(w, http.StatusUnauthorized, "error", "refresh token missing")
+		return
+	}
+	session, err := s.rdb(r.Context()).ConsumeRefreshToken(refreshToken)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get refresh token", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to refresh token")
+		return
+	}
+	if session == nil {
+		clearTokens(w)
+		utils.WriteJSONResponse(w, http.StatusUnauthorized, "error", "invalid refresh token")
+		return
+	}
+
+	// the tokens are only issued to the accounts that can still login
+	userInfo, err := s.userStore(r.Context()).GetUserInfoByID(session.UserID)
+	if errors.Is(err, db.ErrUserNotFound) {
+		clearTokens(w)
+		utils.WriteJSONResponse(w, http.StatusUnauthorized, "error", "invalid refresh token")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get user info", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to refresh token")
+		return
+	}
+	active, err := s.checkAccountStatus(r.Context(), userInfo)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to unlock user", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to refresh token")
+		return
+	}
+	if !active {
+		clearTokens(w)
+		utils.WriteJSONResponse(w, http.StatusForbidden, "error", "account is locked or disabled")
+		return
+	}
+
+	tokens, err := s.issueTokens(w, r, userInfo.UserID, userInfo.Username)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to generate token", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to generate token")
+		return
+	}
+	writeJSON(w, tokens)
+}
+
+// UserLogoutHandler: handles the logout request, revokes the access token and the refresh token
+func (s *Server) UserLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	claims := r.Context().Value(models.ContextKey("claims")).(*auth.Claims)
+
+	// revoke the access token until it expires
+	if err := s.rdb(r.Context()).RevokeAccessToken(claims.ID, time.Until(claims.ExpiresAt.Time)); err != nil {
+		slog.ErrorContext(r.Context(), "failed to revoke token", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to logout")
+		return
+	}
+
+	// invalidate the refresh token of the session
+	if refreshToken := extractRefreshToken(r); refreshToken != "" {
+		if _, err := s.rdb(r.Context()).ConsumeRefreshToken(refreshToken); err != nil {
+			slog.ErrorContext(r.Context(), "failed to revoke refresh token", "error", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to logout")
+			return
+		}
+	}
+
+	clearTokens(w)
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "logged out successfully")
+}
+
+// UserRevokeSessionsHandler: handles the request to revoke all sessions of the user on every device
+func (s *Server) UserRevokeSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	if err := s.rdb(r.Context()).RevokeUserSessions(userID); err != nil {
+		slog.ErrorContext(r.Context(), "failed to revoke sessions", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to revoke sessions")
+		return
+	}
+
+	clearTokens(w)
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "all sessions revoked successfully")
+}
+
+// JWKSHandler: handles the request to get the public keys verifying the access tokens
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, auth.GetKeySet().JWKS())
+}
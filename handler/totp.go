@@ -0,0 +1,350 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bladewaltz9/file-store-server/auth"
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/utils"
+)
+
+// verifySecondFactor: check the TOTP code or an unused recovery code of the user, both are accepted once
+func (s *Server) verifySecondFactor(ctx context.Context, userTOTP *models.UserTOTP, code string) (bool, error) {
+	if step, ok := auth.ValidateTOTP(userTOTP.Secret, code, userTOTP.LastStep); ok {
+		return s.store(ctx).UseTOTPStep(userTOTP.UserID, step)
+	}
+	return s.store(ctx).UseRecoveryCode(userTOTP.UserID, utils.HashToken(auth.NormalizeRecoveryCode(code)))
+}
+
+// newRecoveryCodes: generate the recovery codes and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := auth.GenerateRecoveryCodes(config.RecoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashToken(code)
+	}
+	return codes, hashes, nil
+}
+
+// decodeTOTPCode: get the code from the JSON body
+func decodeTOTPCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var codeReq models.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&codeReq); err != nil || codeReq.Code == "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return "", false
+	}
+	return codeReq.Code, true
+}
+
+// checkEnabledTOTP: check the code against the enabled second factor of the user, write the error response if not
+func (s *Server) checkEnabledTOTP(w http.ResponseWriter, r *http.Request, userID int, code string) bool {
+	userTOTP, err := s.store(r.Context()).GetTOTP(userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get totp", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get two-factor authentication")
+		return false
+	}
+	if userTOTP == nil || !userTOTP.Enabled {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "two-factor authentication is not enabled")
+		return false
+	}
+	ok, err := s.verifySecondFactor(r.Context(), userTOTP, code)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to verify code", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to verify code")
+		return false
+	}
+	if !ok {
+		utils.WriteJSONResponse(w, http.StatusUnauthorized, "error", "invalid code")
+		return false
+	}
+	return true
+}
+
+// TOTPEnrollHandler: handles the request to start the TOTP enrollment, returns the secret and the QR code
+func (s *Server) TOTPEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, username := getUserFromContext(r)
+
+	userTOTP, err := s.store(r.Context()).GetTOTP(userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get totp", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get two-factor authentication")
+		return
+	}
+	if userTOTP != nil && userTOTP.Enabled {
+		utils.WriteJSONResponse(w, http.StatusConflict, "error", "two-factor authentication is already enabled")
+		return
+	}
+
+	enrollment, err := auth.GenerateTOTP(config.TOTPIssuer, username)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to generate totp", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to generate two-factor authentication")
+		return
+	}
+	if err := s.store(r.Context()).SaveTOTPSecret(userID, enrollment.Secret); err != nil {
+		slog.ErrorContext(r.Context(), "failed to save totp", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to save two-factor authentication")
+		return
+	}
+
+	writeJSON(w, enrollment)
+}
+
+// TOTPConfirmHandler: handles the request to confirm the enrollment with a code, returns the recovery codes
+func (s *Server) TOTPConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+	code, ok := decodeTOTPCode(w, r)
+	if !ok {
+		return
+	}
+
+	userTOTP, err := s.store(r.Context()).GetTOTP(userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get totp", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get two-factor authentication")
+		return
+	}
+	if userTOTP == nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "two-factor authentication is not enrolled")
+		return
+	}
+	if userTOTP.Enabled {
+		utils.WriteJSONResponse(w, http.StatusConflict, "error", "two-factor authentication is already enabled")
+		return
+	}
+	step, ok := auth.ValidateTOTP(userTOTP.Secret, code, userTOTP.LastStep)
+	if !ok {
+		utils.WriteJSONResponse(w, http.StatusUnauthorized, "error", "invalid code")
+		return
+	}
+	if _, err := s.store(r.Context()).UseTOTPStep(userID, step); err != nil {
+		slog.ErrorContext(r.Context(), "failed to save totp step", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to enable two-factor authentication")
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to generate recovery codes", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to enable two-factor authentication")
+		return
+	}
+	if err := s.store(r.Context()).EnableTOTP(userID, hashes); err != nil {
+		slog.ErrorContext(r.Context(), "failed to enable totp", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to enable two-factor authentication")
+		return
+	}
+
+	writeJSON(w, &models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// TOTPDisableHandler: handles the request to disable the second factor, a code is required
+func (s *Server) TOTPDisableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+	code, ok := decodeTOTPCode(w, r)
+	if !ok || !s.checkEnabledTOTP(w, r, userID, code) {
+		return
+	}
+
+	if err := s.store(r.Context()).DeleteTOTP(userID); err != nil {
+		slog.ErrorContext(r.Context(), "failed to delete totp", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to disable two-factor authentication")
+		return
+	}
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "two-factor authentication disabled")
+}
+
+// TOTPRecoveryCodesHandler: handles the request to replace the recovery codes, a code is required
+func (s *Server) TOTPRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+	code, ok := decodeTOTPCode(w, r)
+	if !ok || !s.checkEnabledTOTP(w, r, userID, code) {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to generate recovery codes", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to generate recovery codes")
+		return
+	}
+	if err := s.store(r.Context()).SaveRecoveryCodes(userID, hashes); err != nil {
+		slog.ErrorContext(r.Context(), "failed to save recovery codes", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to generate recovery codes")
+		return
+	}
+
+	writeJSON(w, &models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// startSecondFactor: hold the login of the user until the second factor is verified on /user/login/2fa
+func (s *Server) startSecondFactor(w http.ResponseWriter, r *http.Request, userID int) {
+	mfaToken, err := utils.GenerateToken(config.MFATokenBytes)
+	if err == nil {
+		err = s.rdb(r.Context()).StoreMFAToken(mfaToken, userID, config.MFATokenExpireTime)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to store mfa token", "error", err)
+		http.Error(w, "failed to login", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "mfa_token",
+		Value:    mfaToken,
+		HttpOnly: true,
+		Path:     "/user/login",
+		MaxAge:   int(config.MFATokenExpireTime.Seconds()),
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/user/login/2fa", http.StatusFound)
+}
+
+// LoginTOTPHandler: handles the second step of the login, checks the TOTP or recovery code of the pending login
+func (s *Server) LoginTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		http.ServeFile(w, r, "static/view/user_login_2fa.html")
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mfaToken := r.FormValue("mfa_token")
+	if cookie, err := r.Cookie("mfa_token"); mfaToken == "" && err == nil {
+		mfaToken = cookie.Value
+	}
+	userID, err := s.rdb(r.Context()).GetMFAToken(mfaToken)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get mfa token", "error", err)
+		http.Error(w, "failed to login", http.StatusInternalServerError)
+		return
+	}
+	if mfaToken == "" || userID == 0 {
+		http.Error(w, "login expired, please login again", http.StatusUnauthorized)
+		return
+	}
+
+	// limit the code attempts of the pending login
+	allowed, err := s.rdb(r.Context()).AllowRate("mfa:"+utils.HashToken(mfaToken), config.MFAMaxAttempts, config.MFATokenExpireTime)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to count mfa attempts", "error", err)
+		http.Error(w, "failed to login", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		if err := s.rdb(r.Context()).DeleteMFAToken(mfaToken); err != nil {
+			slog.ErrorContext(r.Context(), "failed to delete mfa token", "error", err)
+		}
+		http.Error(w, "too many attempts, please login again", http.StatusTooManyRequests)
+		return
+	}
+
+	userInfo, err := s.userStore(r.Context()).GetUserInfoByID(userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get user", "error", err)
+		http.Error(w, "failed to get user", http.StatusInternalServerError)
+		return
+	}
+	userTOTP, err := s.store(r.Context()).GetTOTP(userID)
+	if err != nil || userTOTP == nil {
+		slog.ErrorContext(r.Context(), "failed to get totp of user", "target_user_id", userID, "error", err)
+		http.Error(w, "failed to login", http.StatusInternalServerError)
+		return
+	}
+	ok, err := s.verifySecondFactor(r.Context(), userTOTP, strings.TrimSpace(r.FormValue("code")))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to verify code", "error", err)
+		http.Error(w, "failed to login", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		// the wrong codes count towards the lockout, a new login does not reset them
+		s.recordLoginFailure(r, userInfo.Username, userInfo)
+		http.Error(w, "invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	// the account may have been locked since the password was checked
+	active, err := s.checkAccountStatus(r.Context(), userInfo)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to unlock user", "error", err)
+		http.Error(w, "failed to login", http.StatusInternalServerError)
+		return
+	}
+	if !active {
+		http.Error(w, loginFailedMessage, http.StatusUnauthorized)
+		return
+	}
+	s.resetLoginFailures(r.Context(), userInfo.Username)
+	if err := s.rdb(r.Context()).DeleteMFAToken(mfaToken); err != nil {
+		slog.ErrorContext(r.Context(), "failed to delete mfa token", "error", err)
+	}
+	http.SetCookie(w, &http.Cookie{Name: "mfa_token", Path: "/user/login", HttpOnly: true, MaxAge: -1})
+
+	if _, err := s.issueTokens(w, r, userInfo.UserID, userInfo.Username); err != nil {
+		slog.ErrorContext(r.Context(), "failed to generate token", "error", err)
+		http.Error(w, "failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/dashboard", http.StatusFound)
+}
+
+// AdminTOTPResetHandler: handles the administrator request to remove the second factor of a user who lost it,
+// e.g. /admin/user/2fa/reset/{user_id}, all sessions of the user are revoked
+func (s *Server) AdminTOTPResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	adminID, _ := getUserFromContext(r)
+
+	userID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/admin/user/2fa/reset/"))
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+	if _, err := s.userStore(r.Context()).GetUserInfoByID(userID); err != nil {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "user not found")
+		return
+	}
+
+	if err := s.store(r.Context()).DeleteTOTP(userID); err != nil {
+		slog.ErrorContext(r.Context(), "failed to delete totp", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to reset two-factor authentication")
+		return
+	}
+	if err := s.rdb(r.Context()).RevokeUserSessions(userID); err != nil {
+		slog.ErrorContext(r.Context(), "failed to revoke sessions", "error", err)
+	}
+	slog.InfoContext(r.Context(), "admin reset the two-factor authentication of user", "admin_id", adminID, "target_user_id", userID)
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "two-factor authentication reset successfully")
+}
@@ -1,21 +1,21 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"html/template"
+	"log/slog"
 	"net/http"
-	"text/template"
-	"time"
 
-	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/auth"
 	"github.com/bladewaltz9/file-store-server/db"
 	"github.com/bladewaltz9/file-store-server/models"
-	"github.com/dgrijalva/jwt-go"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // UserRegisterHandler: handles the user register request
-func UserRegisterHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) UserRegisterHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		http.ServeFile(w, r, "static/view/user_register.html")
 	} else if r.Method == http.MethodPost {
@@ -25,7 +25,7 @@ func UserRegisterHandler(w http.ResponseWriter, r *http.Request) {
 		email := r.FormValue("email")
 
 		// check if the user exists
-		if _, err := db.GetUserInfoByUsername(username); err == nil {
+		if _, err := s.userStore(r.Context()).GetUserInfoByUsername(username); err == nil {
 			http.Error(w, "user already exists", http.StatusBadRequest)
 			return
 		}
@@ -33,18 +33,31 @@ func UserRegisterHandler(w http.ResponseWriter, r *http.Request) {
 		// encode the password
 		encodedPwd, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 		if err != nil {
-			log.Printf("failed to encode the password: %v", err.Error())
+			slog.ErrorContext(r.Context(), "failed to encode the password", "error", err)
 			http.Error(w, "failed to encode the password", http.StatusInternalServerError)
 			return
 		}
 
 		// save user to the database
-		if err := db.SaveUserInfo(username, string(encodedPwd), email); err != nil {
-			log.Printf("failed to save user: %v", err.Error())
+		if err := s.userStore(r.Context()).SaveUserInfo(username, string(encodedPwd), email); err == db.ErrUserExists {
+			// registered concurrently since the check
+			http.Error(w, "user already exists", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			slog.ErrorContext(r.Context(), "failed to save user", "error", err)
 			http.Error(w, "failed to save user", http.StatusInternalServerError)
 			return
 		}
 
+		// send the verification mail, the user can ask for it again from the dashboard
+		if email != "" {
+			if userInfo, err := s.userStore(r.Context()).GetUserInfoByUsername(username); err != nil {
+				slog.ErrorContext(r.Context(), "failed to get user", "error", err)
+			} else if err := s.sendUserToken(r, userInfo, models.TokenVerifyEmail); err != nil {
+				slog.ErrorContext(r.Context(), "failed to send verification mail", "error", err)
+			}
+		}
+
 		http.Redirect(w, r, "/user/login", http.StatusFound)
 	} else {
 		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
@@ -52,51 +65,54 @@ func UserRegisterHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // UserLoginHandler: handles the user login request
-func UserLoginHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) UserLoginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		http.ServeFile(w, r, "static/view/user_login.html")
+		data := models.LoginPageData{
+			OIDCEnabled:      s.cfg.OIDC.Enabled(),
+			OIDCProviderName: s.cfg.OIDC.ProviderName,
+		}
+		tmp, err := template.ParseFiles("static/view/user_login.html")
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to parse the template", "error", err)
+			http.Error(w, "failed to parse the template", http.StatusInternalServerError)
+			return
+		}
+		if err = tmp.Execute(w, data); err != nil {
+			slog.ErrorContext(r.Context(), "failed to execute the template", "error", err)
+			http.Error(w, "failed to execute the template", http.StatusInternalServerError)
+		}
 	} else if r.Method == http.MethodPost {
 		// parse the form
 		username := r.FormValue("username")
 		password := r.FormValue("password")
 
-		// get the user information from the database
-		userInfo, err := db.GetUserInfoByUsername(username)
-		if err != nil {
-			log.Printf("failed to get user: %v", err.Error())
-			http.Error(w, "failed to get user", http.StatusInternalServerError)
+		// check the password, throttled per ip and per username
+		userInfo := s.authenticateUser(w, r, username, password)
+		if userInfo == nil {
 			return
 		}
 
-		// compare the password
-		if err := bcrypt.CompareHashAndPassword([]byte(userInfo.Password), []byte(password)); err != nil {
-			log.Printf("invalid password: %v", err.Error())
-			http.Error(w, "invalid password", http.StatusUnauthorized)
+		// the users with two-factor authentication need a TOTP or recovery code
+		userTOTP, err := s.store(r.Context()).GetTOTP(userInfo.UserID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to get totp", "error", err)
+			http.Error(w, "failed to login", http.StatusInternalServerError)
+			return
+		}
+		if userTOTP != nil && userTOTP.Enabled {
+			s.startSecondFactor(w, r, userInfo.UserID)
 			return
 		}
 
-		// generate jwt token
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"user_id":  userInfo.UserID,
-			"username": username,
-			"exp":      time.Now().Add(config.JWTExpirationTime).Unix(), // set expiration time
-		})
+		s.resetLoginFailures(r.Context(), username)
 
-		tokenStr, err := token.SignedString([]byte(config.JWTSecretKey))
-		if err != nil {
-			log.Printf("failed to generate token: %v", err.Error())
+		// generate the access token and the refresh token
+		if _, err := s.issueTokens(w, r, userInfo.UserID, userInfo.Username); err != nil {
+			slog.ErrorContext(r.Context(), "failed to generate token", "error", err)
 			http.Error(w, "failed to generate token", http.StatusInternalServerError)
 			return
 		}
 
-		// set the token in the cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "token",
-			Value:    tokenStr,
-			HttpOnly: true, // prevent the client from accessing the cookie
-			Path:     "/",
-		})
-
 		http.Redirect(w, r, "/dashboard", http.StatusFound)
 	} else {
 		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
@@ -104,52 +120,113 @@ func UserLoginHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // DashboardHandler: handles the dashboard request
-func DashboardHandler(w http.ResponseWriter, r *http.Request) {
-	// get the claims from the context
-	claims := r.Context().Value(models.ContextKey("claims")).(jwt.MapClaims)
-	user_id := int(claims["user_id"].(float64))
-	username := claims["username"].(string)
+func (s *Server) DashboardHandler(w http.ResponseWriter, r *http.Request) {
+	// get the user from the context
+	user_id, username := getUserFromContext(r)
 
 	// get the user files from the database
-	userFiles, err := db.GetUserFiles(user_id)
+	userFiles, err := s.fileStore(r.Context()).GetUserFiles(user_id)
 	if err != nil {
-		log.Printf("failed to get user files: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to get user files", "error", err)
 		http.Error(w, "failed to get user files", http.StatusInternalServerError)
 		return
 	}
+	if err := s.store(r.Context()).LoadUserFileLabels(user_id, userFiles); err != nil {
+		slog.ErrorContext(r.Context(), "failed to get user file labels", "error", err)
+		http.Error(w, "failed to get user files", http.StatusInternalServerError)
+		return
+	}
+	sharedFiles, err := s.store(r.Context()).GetSharedWithUser(user_id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get shared files", "error", err)
+		http.Error(w, "failed to get shared files", http.StatusInternalServerError)
+		return
+	}
+	teams, err := s.store(r.Context()).GetUserTeams(user_id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get user teams", "error", err)
+		http.Error(w, "failed to get user teams", http.StatusInternalServerError)
+		return
+	}
+	quota, err := s.fileStore(r.Context()).GetUserQuota(user_id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get user quota", "error", err)
+		http.Error(w, "failed to get user quota", http.StatusInternalServerError)
+		return
+	}
+	apiKeys, err := s.store(r.Context()).GetUserAPIKeys(user_id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get api keys", "error", err)
+		http.Error(w, "failed to get api keys", http.StatusInternalServerError)
+		return
+	}
+	userInfo, err := s.userStore(r.Context()).GetUserInfoByID(user_id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get user", "error", err)
+		http.Error(w, "failed to get user", http.StatusInternalServerError)
+		return
+	}
 	data := models.DashboardData{
-		UserID:   user_id,
-		Username: username,
-		Files:    userFiles,
+		UserID:      user_id,
+		Username:    username,
+		Files:       userFiles,
+		SharedFiles: sharedFiles,
+		Teams:       teams,
+		Quota:       quota,
+		APIKeys:     apiKeys,
+
+		EmailValidated: userInfo.EmailValidated,
 	}
 
 	tmp, err := template.ParseFiles("static/view/dashboard.html")
 	if err != nil {
-		log.Printf("failed to parse the template: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to parse the template", "error", err)
 		http.Error(w, "failed to parse the template", http.StatusInternalServerError)
 		return
 	}
 
 	if err = tmp.Execute(w, data); err != nil {
-		log.Printf("failed to execute the template: %v", err.Error())
+		slog.ErrorContext(r.Context(), "failed to execute the template", "error", err)
 		http.Error(w, "failed to execute the template", http.StatusInternalServerError)
 		return
 	}
 
 }
 
+// getUserFromContext: get the user id and username from the token claims in the context
+func getUserFromContext(r *http.Request) (int, string) {
+	claims := r.Context().Value(models.ContextKey("claims")).(*auth.Claims)
+	return claims.UserID, claims.Username
+}
+
 // SaveUserFileDB saves the file metadata to the database
-func SaveUserFileDB(fileMetas *models.FileMeta, userID int) error {
-	// save the file metadata to the database
-	fileID, err := db.SaveFileMeta(fileMetas.FileHash, fileMetas.FileName, fileMetas.FileSize, fileMetas.FilePath)
+func (s *Server) SaveUserFileDB(ctx context.Context, fileMetas *models.FileMeta, userID int) error {
+	// save the file metadata and the relationship between the user and the file to the database,
+	// the file is not saved if it does not fit the quota
+	fileID, err := s.fileStore(ctx).SaveNewUserFile(userID, fileMetas.FileHash, fileMetas.FileName, fileMetas.FileSize, fileMetas.FilePath)
 	if err != nil {
-		return fmt.Errorf("failed to save file metadata: %v", err.Error())
+		if errors.Is(err, db.ErrQuotaExceeded) {
+			return err
+		}
+		return fmt.Errorf("failed to save user file: %v", err.Error())
 	}
+	fileMetas.FileID = fileID
 
-	// save the relationship between the user and the file to the database
-	if err := db.SaveUserFile(userID, fileID, fileMetas.FileName); err != nil {
-		return fmt.Errorf("failed to save user file: %v", err.Error())
+	return nil
+}
+
+// SaveTeamFileDB saves the file metadata to the database as a file of the team
+func (s *Server) SaveTeamFileDB(ctx context.Context, fileMetas *models.FileMeta, teamID int, uploaderID int) error {
+	// save the file metadata and the relationship between the team and the file to the database,
+	// the file is not saved if it does not fit the quota of the uploader
+	fileID, err := s.store(ctx).SaveNewTeamFile(teamID, uploaderID, fileMetas.FileHash, fileMetas.FileName, fileMetas.FileSize, fileMetas.FilePath)
+	if err != nil {
+		if errors.Is(err, db.ErrQuotaExceeded) {
+			return err
+		}
+		return fmt.Errorf("failed to save team file: %v", err.Error())
 	}
+	fileMetas.FileID = fileID
 
 	return nil
 }
@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/logging"
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/utils"
+)
+
+// UserShareCreateHandler: handles the request to share a file with a registered user
+func (s *Server) UserShareCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	// decode the request body
+	var shareReq models.CreateUserShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&shareReq); err != nil {
+		slog.ErrorContext(r.Context(), "failed to decode the request", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "failed to decode the request")
+		return
+	}
+	if shareReq.Permission != models.PermissionRead && shareReq.Permission != models.PermissionWrite {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid permission")
+		return
+	}
+	expireTime := time.Duration(shareReq.ExpireHours) * time.Hour
+	if shareReq.ExpireHours < 0 || expireTime > config.ShareMaxExpireTime {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+
+	// check if the user owns the file
+	if !s.checkUserFile(w, r, userID, shareReq.FileID) {
+		return
+	}
+
+	// get the grantee
+	grantee, err := s.userStore(r.Context()).GetUserInfoByUsername(shareReq.Username)
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "user not found")
+		return
+	}
+	if grantee.UserID == userID {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "can not share with yourself")
+		return
+	}
+
+	share := &models.UserShare{
+		OwnerID:    userID,
+		GranteeID:  grantee.UserID,
+		FileID:     shareReq.FileID,
+		Permission: shareReq.Permission,
+	}
+	if shareReq.ExpireHours > 0 {
+		expireAt := time.Now().Add(expireTime)
+		share.ExpireAt = &expireAt
+	}
+	if err := s.store(r.Context()).SaveUserShare(share); err != nil {
+		slog.ErrorContext(r.Context(), "failed to save user share", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to share file")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "file shared successfully")
+}
+
+// UserShareListHandler: handles the request to list the shares,
+// /share/user/with-me lists the files shared with the user and /share/user/by-me the files shared by the user
+func (s *Server) UserShareListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	var shares []models.UserShare
+	var err error
+	switch r.URL.Path {
+	case "/share/user/with-me":
+		shares, err = s.store(r.Context()).GetSharedWithUser(userID)
+	case "/share/user/by-me":
+		shares, err = s.store(r.Context()).GetSharedByUser(userID)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get user shares", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get user shares")
+		return
+	}
+	if shares == nil {
+		shares = []models.UserShare{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(shares); err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode the user shares", "error", err)
+		http.Error(w, "failed to encode the user shares", http.StatusInternalServerError)
+	}
+}
+
+// UserShareRevokeHandler: handles the request to revoke a share, by the owner or the grantee
+func (s *Server) UserShareRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, "error", "invalid method")
+		return
+	}
+	userID, _ := getUserFromContext(r)
+
+	shareID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/share/user/revoke/"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to convert share_id to int", "error", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, "error", "invalid parameter")
+		return
+	}
+
+	ok, err := s.store(r.Context()).DeleteUserShare(userID, shareID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to revoke user share", "error", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to revoke share")
+		return
+	}
+	if !ok {
+		utils.WriteJSONResponse(w, http.StatusNotFound, "error", "share not found")
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, "success", "share revoked successfully")
+}
+
+// checkFileAccess: check if the user owns the file or it is shared with the required permission,
+// write the error response if not
+func (s *Server) checkFileAccess(w http.ResponseWriter, r *http.Request, userID int, fileID int, required string) bool {
+	logging.AddAttrs(r.Context(), slog.Int("file_id", fileID))
+	permission, err := s.store(r.Context()).GetFileAccess(userID, fileID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to get file access", "error", err)
+		http.Error(w, "failed to check file access", http.StatusInternalServerError)
+		return false
+	}
+	if permission == "" {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return false
+	}
+	if !permissionAllows(permission, required) {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// permissionAllows: check if the permission covers the required one, owner > write > read
+func permissionAllows(permission string, required string) bool {
+	rank := map[string]int{
+		models.PermissionRead:  1,
+		models.PermissionWrite: 2,
+		models.PermissionOwner: 3,
+	}
+	return rank[permission] >= rank[required]
+}
@@ -0,0 +1,113 @@
+// Package logging is the structured logging of the server, the log lines written with the context
+// of a request or a message carry its fields, e.g. the request id, the route and the user id
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/models"
+)
+
+// Setup: make the logger of the config the default of slog and of the standard log package
+func Setup(cfg config.LogConfig) error {
+	logger, err := New(cfg, os.Stderr)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// New: create the logger of the config writing to w
+func New(cfg config.LogConfig, w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("failed to parse the log level: %v", err.Error())
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch cfg.Format {
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unsupported log format %q", cfg.Format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// fields: the attributes of a request or a message, added as it is handled, e.g. the user id
+// once the token is validated
+type fields struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// NewContext: start the fields of a request or a message with attrs, the log lines written
+// with the context carry them
+func NewContext(ctx context.Context, attrs ...slog.Attr) context.Context {
+	return context.WithValue(ctx, models.ContextKey("log_fields"), &fields{attrs: attrs})
+}
+
+// AddAttrs: add attrs to the fields of the context, the later log lines and the access log of
+// the request carry them, does nothing if the context has no fields
+func AddAttrs(ctx context.Context, attrs ...slog.Attr) {
+	f, ok := ctx.Value(models.ContextKey("log_fields")).(*fields)
+	if !ok {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attrs = append(f.attrs, attrs...)
+}
+
+// attrs: a copy of the fields of the context
+func attrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	f, ok := ctx.Value(models.ContextKey("log_fields")).(*fields)
+	if !ok {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]slog.Attr(nil), f.attrs...)
+}
+
+// WithRequestID: attach the id of the request to the context, to pass it on with the messages
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, models.ContextKey("request_id"), requestID)
+}
+
+// RequestID: the id of the request of the context, empty outside of a request
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(models.ContextKey("request_id")).(string)
+	return requestID
+}
+
+// contextHandler: adds the fields of the context to the records
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	record.AddAttrs(attrs(ctx)...)
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,45 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/logging"
+)
+
+func TestContextFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(config.LogConfig{Level: "info", Format: "json"}, &buf)
+	if err != nil {
+		t.Fatalf("Failed to create the logger: %v", err)
+	}
+
+	ctx := logging.NewContext(context.Background(), slog.String("request_id", "req-1"))
+	logging.AddAttrs(ctx, slog.Int("user_id", 7))
+	logger.DebugContext(ctx, "filtered by the level")
+	logger.InfoContext(ctx, "uploaded", "file_id", 3)
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Failed to parse the log line %q: %v", buf.String(), err)
+	}
+	if line["msg"] != "uploaded" || line["request_id"] != "req-1" || line["user_id"] != float64(7) || line["file_id"] != float64(3) {
+		t.Errorf("unexpected log line: %s", buf.String())
+	}
+
+	// the contexts without fields are logged as they are
+	buf.Reset()
+	logging.AddAttrs(context.Background(), slog.Int("user_id", 7))
+	logger.InfoContext(context.Background(), "started")
+	if bytes.Contains(buf.Bytes(), []byte("user_id")) {
+		t.Errorf("unexpected fields in the log line: %s", buf.String())
+	}
+
+	if _, err := logging.New(config.LogConfig{Level: "verbose", Format: "json"}, &buf); err == nil {
+		t.Errorf("expected an error for an unknown level")
+	}
+}
@@ -0,0 +1,52 @@
+package mail
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogMailer: writes the mails to a file, or to the log if no file is set, for development and tests
+type LogMailer struct {
+	mu   sync.Mutex
+	path string
+	sent []Message
+}
+
+// NewLogMailer: create the log mailer
+func NewLogMailer(path string) *LogMailer {
+	return &LogMailer{path: path}
+}
+
+// Send: record the mail
+func (m *LogMailer) Send(msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, *msg)
+
+	if m.path == "" {
+		slog.Info("mail", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+		return nil
+	}
+
+	entry := fmt.Sprintf("[%s] To: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+
+	file, err := os.OpenFile(m.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open the mail log: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(entry); err != nil {
+		return fmt.Errorf("failed to write the mail log: %v", err)
+	}
+	return nil
+}
+
+// Sent: get the mails sent so far
+func (m *LogMailer) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.sent...)
+}
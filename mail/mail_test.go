@@ -0,0 +1,32 @@
+package mail_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bladewaltz9/file-store-server/mail"
+)
+
+func TestLogMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	mailer := mail.NewLogMailer(path)
+
+	msg := &mail.Message{To: "user@example.com", Subject: "Verify your email", Body: "token=abc"}
+	if err := mailer.Send(msg); err != nil {
+		t.Fatalf("Failed to send the mail: %v", err)
+	}
+
+	sent := mailer.Sent()
+	if len(sent) != 1 || sent[0].To != msg.To {
+		t.Errorf("Expected the mail to %s, got %v", msg.To, sent)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read the mail log: %v", err)
+	}
+	if !strings.Contains(string(data), "token=abc") {
+		t.Errorf("Expected the mail body in the log, got %s", data)
+	}
+}
@@ -0,0 +1,31 @@
+package mail
+
+import (
+	"fmt"
+
+	"github.com/bladewaltz9/file-store-server/config"
+)
+
+// Message: the mail to send
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer: sends the mails, implemented by SMTPMailer and LogMailer
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// NewMailer: create the mailer of the configured driver
+func NewMailer(cfg config.MailConfig) (Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.From), nil
+	case "log":
+		return NewLogMailer(cfg.LogFile), nil
+	default:
+		return nil, fmt.Errorf("unsupported mail driver: %s", cfg.Driver)
+	}
+}
@@ -1,53 +1,369 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/bladewaltz9/file-store-server/auth"
 	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/db"
 	"github.com/bladewaltz9/file-store-server/handler"
+	"github.com/bladewaltz9/file-store-server/logging"
+	"github.com/bladewaltz9/file-store-server/mail"
+	"github.com/bladewaltz9/file-store-server/metrics"
 	"github.com/bladewaltz9/file-store-server/middleware"
+	"github.com/bladewaltz9/file-store-server/models"
 	"github.com/bladewaltz9/file-store-server/mq"
+	"github.com/bladewaltz9/file-store-server/oss"
+	"github.com/bladewaltz9/file-store-server/redis"
+	"github.com/bladewaltz9/file-store-server/tracing"
+	"github.com/bladewaltz9/file-store-server/utils"
 )
 
-func main() {
+// server: the services of the file store and the handlers using them
+type server struct {
+	cfg    *config.Config
+	db     *db.DB
+	redis  *redis.Client
+	mq     *mq.Client
+	oss    *oss.Client
+	mailer mail.Mailer
+
+	handler    *handler.Server
+	middleware *middleware.Middleware
+
+	// stopTracing: flush the spans on shutdown
+	stopTracing func(context.Context) error
+}
+
+// newServer: connect the services with the settings and create the handlers
+func newServer(cfg *config.Config) (*server, error) {
+	s := &server{cfg: cfg}
+	if err := s.connect(); err != nil {
+		s.close() // the services already connected
+		return nil, err
+	}
+
+	s.handler = handler.NewServer(cfg, s.db, s.redis, s.mq, s.oss, s.mailer)
+	s.middleware = middleware.New(cfg, s.db, s.redis)
+	if cfg.Metrics.Enabled {
+		s.registerMetrics()
+	}
+	return s, nil
+}
+
+// registerMetrics: export the statistics of the database, the storage and the disks with the metrics
+func (s *server) registerMetrics() {
+	metrics.RegisterDB(s.cfg.Database.Driver, s.db.Stats)
+	metrics.RegisterStorage(s.db.GetSystemStats)
+	metrics.RegisterDisks(map[string]string{
+		"file_store_dir": s.cfg.Storage.FileStoreDir,
+		"file_chunk_dir": s.cfg.Storage.FileChunkDir,
+	}, utils.DiskUsage)
+}
+
+// connect: connect the services in the order of their dependencies
+func (s *server) connect() error {
+	var err error
+	if s.db, err = db.Open(s.cfg); err != nil {
+		return err
+	}
+	if err = s.migrate(); err != nil {
+		return err
+	}
+	if s.redis, err = redis.New(s.cfg.Redis); err != nil {
+		return err
+	}
+	if s.oss, err = oss.New(s.cfg.OSS); err != nil {
+		return err
+	}
+	if s.mq, err = mq.New(s.cfg.RabbitMQ); err != nil {
+		return err
+	}
+	s.mailer, err = mail.NewMailer(s.cfg.Mail)
+	return err
+}
+
+// close: close the connections of the services
+func (s *server) close() error {
+	var errs []error
+	if s.mq != nil {
+		errs = append(errs, s.mq.Close())
+	}
+	if s.redis != nil {
+		errs = append(errs, s.redis.Close())
+	}
+	if s.db != nil {
+		errs = append(errs, s.db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// consume: start the RabbitMQ consumers of the transfer and the full-text index queues until the context is done,
+// their failures are sent to errc
+func (s *server) consume(ctx context.Context, errc chan<- error) *sync.WaitGroup {
+	consumer := mq.NewConsumer(s.db, s.oss)
+	queues := map[*mq.RabbitMQ]func(ctx context.Context, message []byte) error{
+		s.mq.Transfer: consumer.ProcessTransferMessage,
+		s.mq.Index:    consumer.ProcessIndexMessage,
+	}
+
+	var wg sync.WaitGroup
+	for rabbitMQ, process := range queues {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := rabbitMQ.Consume(ctx, process); err != nil {
+				errc <- fmt.Errorf("the consumer of %s failed: %v", rabbitMQ.Queue, err)
+			}
+		}()
+	}
+	return &wg
+}
+
+// shutdown: fail the readiness probe, stop accepting the connections and the MQ messages, drain the in-flight
+// requests, the background work of the handlers and the messages being processed within the shutdown timeout,
+// then close the connections
+func (s *server) shutdown(httpServer *http.Server, stopConsumers context.CancelFunc, consumers *sync.WaitGroup) {
+	s.handler.SetShuttingDown()
+	if delay := s.cfg.Health.ShutdownDelay; delay > 0 {
+		slog.Info("failing the readiness probe before shutting down", "delay", delay)
+		time.Sleep(delay)
+	}
+
+	slog.Info("shutting down, draining the in-flight work", "timeout", s.cfg.Server.ShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	stopConsumers()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		slog.Error("failed to drain the http requests, closing their connections", "error", err)
+		httpServer.Close()
+	} else {
+		slog.Info("drained the http requests")
+	}
+	if err := s.handler.Wait(ctx); err != nil {
+		slog.Error("failed to drain the background work of the handlers", "error", err)
+	} else {
+		slog.Info("drained the background work of the handlers")
+	}
+	if err := utils.WaitContext(ctx, consumers); err != nil {
+		slog.Error("failed to drain the MQ consumers, the unacknowledged messages are delivered again", "error", err)
+	} else {
+		slog.Info("stopped the MQ consumers")
+	}
+
+	if err := s.close(); err != nil {
+		slog.Error("failed to close the connections", "error", err)
+	} else {
+		slog.Info("closed the connections")
+	}
+	if err := s.stopTracing(ctx); err != nil {
+		slog.Error("failed to flush the traces", "error", err)
+	}
+}
+
+// routes: the routes of the handlers
+func (s *server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	h, mw := s.handler, s.middleware
+
+	// the routes wrapped by APIKeyScope also accept the personal API keys granted the scope,
+	// the others only accept the login tokens
+
+	// health handler
+	mux.HandleFunc("/healthz", h.HealthzHandler)
+	mux.HandleFunc("/readyz", h.ReadyzHandler)
+	if s.cfg.Metrics.Enabled {
+		mux.Handle("/metrics", metrics.Handler(s.cfg.Metrics.Token))
+	}
+
 	// file handler
-	http.HandleFunc("/file/upload", middleware.TokenAuthMiddleware(handler.FileUploadHandler))
-	http.HandleFunc("/file/query", middleware.TokenAuthMiddleware(handler.FileQueryHandler))
-	http.HandleFunc("/file/download/", middleware.TokenAuthMiddleware(handler.FileDownloadHandler))
-	http.HandleFunc("/file/download/url/", middleware.TokenAuthMiddleware(handler.FileDownloadURLHandler))
-	http.HandleFunc("/file/update/", middleware.TokenAuthMiddleware(handler.FileUpdateHandler))
-	http.HandleFunc("/file/delete/", middleware.TokenAuthMiddleware(handler.FileDeleteHandler))
-	http.HandleFunc("/file/fastupload", middleware.TokenAuthMiddleware(handler.FileFastUploadHandler))
+	mux.HandleFunc("/file/upload", middleware.APIKeyScope(models.APIKeyScopeUpload, mw.TokenAuthMiddleware(mw.VerifiedEmailMiddleware(h.FileUploadHandler))))
+	mux.HandleFunc("/file/query", middleware.APIKeyScope(models.APIKeyScopeRead, mw.TokenAuthMiddleware(h.FileQueryHandler)))
+	mux.HandleFunc("/file/download/", middleware.APIKeyScope(models.APIKeyScopeRead, mw.TokenAuthMiddleware(h.FileDownloadHandler)))
+	mux.HandleFunc("/file/download/url/", middleware.APIKeyScope(models.APIKeyScopeRead, mw.TokenAuthMiddleware(h.FileDownloadURLHandler)))
+	mux.HandleFunc("/file/update/", mw.TokenAuthMiddleware(mw.VerifiedEmailMiddleware(h.FileUpdateHandler)))
+	mux.HandleFunc("/file/delete/", middleware.APIKeyScope(models.APIKeyScopeDelete, mw.TokenAuthMiddleware(h.FileDeleteHandler)))
+	mux.HandleFunc("/file/fastupload", middleware.APIKeyScope(models.APIKeyScopeUpload, mw.TokenAuthMiddleware(mw.VerifiedEmailMiddleware(h.FileFastUploadHandler))))
+	mux.HandleFunc("/file/search", middleware.APIKeyScope(models.APIKeyScopeRead, mw.TokenAuthMiddleware(h.FileSearchHandler)))
+	mux.HandleFunc("/file/list", middleware.APIKeyScope(models.APIKeyScopeRead, mw.TokenAuthMiddleware(h.FileListHandler)))
+	mux.HandleFunc("/file/tags/", mw.TokenAuthMiddleware(h.FileTagsHandler))
+	mux.HandleFunc("/file/tags/bulk", mw.TokenAuthMiddleware(h.FileBulkTagsHandler))
+	mux.HandleFunc("/file/metadata/", mw.TokenAuthMiddleware(h.FileMetadataHandler))
 
 	// file chunked handler
-	http.HandleFunc("/file/upload/chunk", middleware.TokenAuthMiddleware(handler.FileChunkedUploadHandler))
-	http.HandleFunc("/file/merge", middleware.TokenAuthMiddleware(handler.FileChunksMergeHandler))
+	mux.HandleFunc("/file/upload/chunk", middleware.APIKeyScope(models.APIKeyScopeUpload, mw.TokenAuthMiddleware(mw.VerifiedEmailMiddleware(h.FileChunkedUploadHandler))))
+	mux.HandleFunc("/file/merge", middleware.APIKeyScope(models.APIKeyScopeUpload, mw.TokenAuthMiddleware(mw.VerifiedEmailMiddleware(h.FileChunksMergeHandler))))
+
+	// share link handler
+	mux.HandleFunc("/share/create", mw.TokenAuthMiddleware(mw.VerifiedEmailMiddleware(h.ShareCreateHandler)))
+	mux.HandleFunc("/share/list", mw.TokenAuthMiddleware(h.ShareListHandler))
+	mux.HandleFunc("/share/revoke/", mw.TokenAuthMiddleware(h.ShareRevokeHandler))
+	mux.HandleFunc("/share/logs/", mw.TokenAuthMiddleware(h.ShareLogsHandler))
+	mux.HandleFunc("/s/", h.SharePageHandler)
+	mux.HandleFunc("/share/user", mw.TokenAuthMiddleware(mw.VerifiedEmailMiddleware(h.UserShareCreateHandler)))
+	mux.HandleFunc("/share/user/with-me", mw.TokenAuthMiddleware(h.UserShareListHandler))
+	mux.HandleFunc("/share/user/by-me", mw.TokenAuthMiddleware(h.UserShareListHandler))
+	mux.HandleFunc("/share/user/revoke/", mw.TokenAuthMiddleware(h.UserShareRevokeHandler))
+
+	// team and folder handler
+	mux.HandleFunc("/team/create", mw.TokenAuthMiddleware(mw.VerifiedEmailMiddleware(h.TeamCreateHandler)))
+	mux.HandleFunc("/team/list", mw.TokenAuthMiddleware(h.TeamListHandler))
+	mux.HandleFunc("/team/members/", mw.TokenAuthMiddleware(h.TeamMembersHandler))
+	mux.HandleFunc("/team/invite", mw.TokenAuthMiddleware(mw.VerifiedEmailMiddleware(h.TeamInviteHandler)))
+	mux.HandleFunc("/team/invites", mw.TokenAuthMiddleware(h.TeamInvitesHandler))
+	mux.HandleFunc("/team/invite/", mw.TokenAuthMiddleware(h.TeamInviteRespondHandler))
+	mux.HandleFunc("/team/member/role", mw.TokenAuthMiddleware(h.TeamMemberRoleHandler))
+	mux.HandleFunc("/team/member/remove", mw.TokenAuthMiddleware(h.TeamMemberRemoveHandler))
+	mux.HandleFunc("/team/delete/", mw.TokenAuthMiddleware(h.TeamDeleteHandler))
+	mux.HandleFunc("/team/file/delete/", mw.TokenAuthMiddleware(h.TeamFileDeleteHandler))
+	mux.HandleFunc("/folder/create", mw.TokenAuthMiddleware(h.FolderCreateHandler))
+	mux.HandleFunc("/folder/list", middleware.APIKeyScope(models.APIKeyScopeRead, mw.TokenAuthMiddleware(h.FolderListHandler)))
+	mux.HandleFunc("/folder/delete/", mw.TokenAuthMiddleware(h.FolderDeleteHandler))
+	mux.HandleFunc("/file/move", mw.TokenAuthMiddleware(h.FileMoveHandler))
 
 	// user handler
-	http.HandleFunc("/user/register", handler.UserRegisterHandler)
-	http.HandleFunc("/user/login", handler.UserLoginHandler)
+	mux.HandleFunc("/user/register", h.UserRegisterHandler)
+	mux.HandleFunc("/user/login", h.UserLoginHandler)
+	mux.HandleFunc("/user/verify", h.EmailVerifyHandler)
+	mux.HandleFunc("/user/verify/send", mw.TokenAuthMiddleware(h.EmailVerifySendHandler))
+	mux.HandleFunc("/user/password/forgot", h.PasswordForgotHandler)
+	mux.HandleFunc("/user/password/reset", h.PasswordResetHandler)
+	mux.HandleFunc("/user/login/2fa", h.LoginTOTPHandler)
+	mux.HandleFunc("/user/login/oidc", h.OIDCLoginHandler)
+	mux.HandleFunc("/user/login/oidc/callback", h.OIDCCallbackHandler)
+	mux.HandleFunc("/user/2fa/enroll", mw.TokenAuthMiddleware(h.TOTPEnrollHandler))
+	mux.HandleFunc("/user/2fa/confirm", mw.TokenAuthMiddleware(h.TOTPConfirmHandler))
+	mux.HandleFunc("/user/2fa/disable", mw.TokenAuthMiddleware(h.TOTPDisableHandler))
+	mux.HandleFunc("/user/2fa/recovery-codes", mw.TokenAuthMiddleware(h.TOTPRecoveryCodesHandler))
+	mux.HandleFunc("/user/logout", mw.TokenAuthMiddleware(h.UserLogoutHandler))
+	mux.HandleFunc("/user/token/refresh", h.TokenRefreshHandler)
+	mux.HandleFunc("/user/sessions/revoke", mw.TokenAuthMiddleware(h.UserRevokeSessionsHandler))
+	mux.HandleFunc("/user/profile", mw.TokenAuthMiddleware(h.UserProfileHandler))
+	mux.HandleFunc("/user/password/change", mw.TokenAuthMiddleware(h.PasswordChangeHandler))
+	mux.HandleFunc("/user/email/change", mw.TokenAuthMiddleware(h.EmailChangeHandler))
+	mux.HandleFunc("/user/email/confirm", h.EmailConfirmHandler)
+	mux.HandleFunc("/user/delete", mw.TokenAuthMiddleware(h.AccountDeleteHandler))
+	mux.HandleFunc("/.well-known/jwks.json", handler.JWKSHandler)
+	mux.HandleFunc("/user/apikey/create", mw.TokenAuthMiddleware(h.APIKeyCreateHandler))
+	mux.HandleFunc("/user/apikey/list", mw.TokenAuthMiddleware(h.APIKeyListHandler))
+	mux.HandleFunc("/user/apikey/revoke/", mw.TokenAuthMiddleware(h.APIKeyRevokeHandler))
+	mux.HandleFunc("/user/usage", middleware.APIKeyScope(models.APIKeyScopeRead, mw.TokenAuthMiddleware(h.UserUsageHandler)))
+
+	// admin handler
+	mux.HandleFunc("/admin/user/2fa/reset/", mw.TokenAuthMiddleware(mw.AdminMiddleware(h.AdminTOTPResetHandler)))
+	mux.HandleFunc("/admin/user/unlock/", mw.TokenAuthMiddleware(mw.AdminMiddleware(h.AdminUserUnlockHandler)))
+	mux.HandleFunc("/admin/users", mw.TokenAuthMiddleware(mw.AdminMiddleware(h.AdminUserListHandler)))
+	mux.HandleFunc("/admin/user/status/", mw.TokenAuthMiddleware(mw.AdminMiddleware(h.AdminUserStatusHandler)))
+	mux.HandleFunc("/admin/user/role/", mw.TokenAuthMiddleware(mw.AdminMiddleware(h.AdminUserRoleHandler)))
+	mux.HandleFunc("/admin/user/usage/", mw.TokenAuthMiddleware(mw.AdminMiddleware(h.AdminUserUsageHandler)))
+	mux.HandleFunc("/admin/file/delete/", mw.TokenAuthMiddleware(mw.AdminMiddleware(h.AdminFileDeleteHandler)))
+	mux.HandleFunc("/admin/stats", mw.TokenAuthMiddleware(mw.AdminMiddleware(h.AdminStatsHandler)))
 
 	// dashboard handler
-	http.HandleFunc("/dashboard", middleware.TokenAuthMiddleware(handler.DashboardHandler))
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if middleware.IsAuthenticated(r) {
+	mux.HandleFunc("/dashboard", mw.TokenAuthMiddleware(h.DashboardHandler))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if mw.IsAuthenticated(r) {
 			http.Redirect(w, r, "/dashboard", http.StatusFound)
 		} else {
 			http.Redirect(w, r, "/user/login", http.StatusFound)
 		}
 	})
 
-	// start the RabbitMQ consumer
+	return mux
+}
+
+// fatal: log the failure to start and exit
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrateCommand(os.Args[2:]); err != nil {
+			fatal("failed to migrate the database", err)
+		}
+		return
+	}
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		fatal("failed to load the config", err)
+	}
+	if err := logging.Setup(cfg.Log); err != nil {
+		fatal("failed to set up the logging", err)
+	}
+	for _, dir := range []string{cfg.Storage.FileStoreDir, cfg.Storage.FileChunkDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			fatal("failed to create the storage directory", err)
+		}
+	}
+	if err := auth.Init(cfg.JWT, cfg.OIDC); err != nil {
+		fatal("failed to initialize the authentication", err)
+	}
+
+	stopTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("failed to set up the tracing", err)
+	}
+
+	s, err := newServer(cfg)
+	if err != nil {
+		fatal("failed to start the server", err)
+	}
+	s.stopTracing = stopTracing
+
+	// SIGINT and SIGTERM shut the server down, the failure of the http server or of a consumer too
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errc := make(chan error, 3)
+
+	consumeCtx, stopConsumers := context.WithCancel(context.Background())
+	consumers := s.consume(consumeCtx, errc)
+
+	// reload the JWT keys on SIGHUP, to rotate the signing key without a restart
 	go func() {
-		rabbitMQ := mq.GetRabbitMQ()
-		if err := rabbitMQ.ConsumeMessage(); err != nil {
-			panic(err)
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		for range sighup {
+			if err := auth.Reload(); err != nil {
+				slog.Error("failed to reload the JWT keys", "error", err)
+			} else {
+				slog.Info("JWT keys reloaded")
+			}
 		}
 	}()
 
 	// start the server
-	err := http.ListenAndServeTLS(":8080", config.CertFile, config.KeyFile, nil)
-	if err != nil {
-		panic(err)
+	routes := s.routes()
+	httpServer := &http.Server{Addr: cfg.Server.Addr, Handler: middleware.RequestLogger(routes, middleware.Tracing(metrics.Middleware(routes)))}
+	go func() {
+		slog.Info("listening", "addr", cfg.Server.Addr)
+		if err := httpServer.ListenAndServeTLS(cfg.Server.CertFile, cfg.Server.KeyFile); !errors.Is(err, http.ErrServerClosed) {
+			errc <- fmt.Errorf("the http server failed: %v", err)
+		}
+	}()
+
+	var failure error
+	select {
+	case <-ctx.Done():
+		slog.Info("received the signal to stop")
+	case failure = <-errc:
+		slog.Error("stopping on failure", "error", failure)
+	}
+	stop() // a second signal kills the server without waiting
+	s.shutdown(httpServer, stopConsumers, consumers)
+	if failure != nil {
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"database/sql"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// storageStatsTTL: how long the storage statistics are reused, they are aggregated over the whole database
+const storageStatsTTL = time.Minute
+
+// RegisterDB: export the statistics of the connection pool of the metadata database
+func RegisterDB(driver string, stats func() sql.DBStats) {
+	gauge := func(name string, help string, value func(s sql.DBStats) float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: prometheus.Labels{"driver": driver},
+		}, func() float64 { return value(stats()) })
+	}
+	counter := func(name string, help string, value func(s sql.DBStats) float64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        name,
+			Help:        help,
+			ConstLabels: prometheus.Labels{"driver": driver},
+		}, func() float64 { return value(stats()) })
+	}
+
+	Registry.MustRegister(
+		gauge("db_max_open_connections", "The maximum number of open connections of the pool.",
+			func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }),
+		gauge("db_open_connections", "The open connections of the pool.",
+			func(s sql.DBStats) float64 { return float64(s.OpenConnections) }),
+		gauge("db_in_use_connections", "The connections in use.",
+			func(s sql.DBStats) float64 { return float64(s.InUse) }),
+		gauge("db_idle_connections", "The idle connections.",
+			func(s sql.DBStats) float64 { return float64(s.Idle) }),
+		counter("db_wait_count_total", "The connections waited for.",
+			func(s sql.DBStats) float64 { return float64(s.WaitCount) }),
+		counter("db_wait_duration_seconds_total", "The time spent waiting for the connections.",
+			func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }),
+	)
+}
+
+// RegisterStorage: export the stored and the logical bytes and their deduplication ratio
+func RegisterStorage(stats func() (*models.SystemStats, error)) {
+	Registry.MustRegister(&storageCollector{stats: stats})
+}
+
+// RegisterDisks: export the free and the total space of the filesystems of the directories, by name
+func RegisterDisks(dirs map[string]string, usage func(dir string) (uint64, uint64, error)) {
+	Registry.MustRegister(&diskCollector{dirs: dirs, usage: usage})
+}
+
+var (
+	storedBytesDesc = prometheus.NewDesc(namespace+"_storage_stored_bytes",
+		"The size of the stored files after deduplication.", nil, nil)
+	logicalBytesDesc = prometheus.NewDesc(namespace+"_storage_logical_bytes",
+		"The size of the files of the users and the teams.", nil, nil)
+	dedupRatioDesc = prometheus.NewDesc(namespace+"_storage_dedup_ratio",
+		"The logical bytes per stored byte.", nil, nil)
+
+	diskFreeDesc = prometheus.NewDesc(namespace+"_disk_free_bytes",
+		"The space available on the filesystem of the storage directory.", []string{"dir"}, nil)
+	diskTotalDesc = prometheus.NewDesc(namespace+"_disk_total_bytes",
+		"The size of the filesystem of the storage directory.", []string{"dir"}, nil)
+)
+
+// storageCollector: collects the storage statistics, cached for storageStatsTTL
+type storageCollector struct {
+	stats func() (*models.SystemStats, error)
+
+	mu        sync.Mutex
+	cached    *models.SystemStats
+	updatedAt time.Time
+}
+
+func (c *storageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- storedBytesDesc
+	ch <- logicalBytesDesc
+	ch <- dedupRatioDesc
+}
+
+func (c *storageCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	if c.cached == nil || time.Since(c.updatedAt) > storageStatsTTL {
+		stats, err := c.stats()
+		if err != nil {
+			slog.Error("failed to get the storage statistics", "error", err)
+		} else {
+			c.cached, c.updatedAt = stats, time.Now()
+		}
+	}
+	stats := c.cached
+	c.mu.Unlock()
+	if stats == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(storedBytesDesc, prometheus.GaugeValue, float64(stats.StoredBytes))
+	ch <- prometheus.MustNewConstMetric(logicalBytesDesc, prometheus.GaugeValue, float64(stats.LogicalBytes))
+	ratio := 1.0
+	if stats.StoredBytes > 0 {
+		ratio = float64(stats.LogicalBytes) / float64(stats.StoredBytes)
+	}
+	ch <- prometheus.MustNewConstMetric(dedupRatioDesc, prometheus.GaugeValue, ratio)
+}
+
+// diskCollector: collects the space of the filesystems of the storage directories
+type diskCollector struct {
+	dirs  map[string]string
+	usage func(dir string) (uint64, uint64, error)
+}
+
+func (c *diskCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- diskFreeDesc
+	ch <- diskTotalDesc
+}
+
+func (c *diskCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, dir := range c.dirs {
+		free, total, err := c.usage(dir)
+		if err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(diskFreeDesc, prometheus.GaugeValue, float64(free), name)
+		ch <- prometheus.MustNewConstMetric(diskTotalDesc, prometheus.GaugeValue, float64(total), name)
+	}
+}
@@ -0,0 +1,178 @@
+// Package metrics is the Prometheus instrumentation of the server, served at /metrics
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "file_store"
+
+// the kinds of the uploads
+const (
+	UploadSingle  = "single"
+	UploadChunked = "chunked"
+	UploadFast    = "fast"
+)
+
+// the results of the fast upload checks
+const (
+	FastUploadHit    = "hit"    // the content is stored, the file is added without a transfer
+	FastUploadMiss   = "miss"   // the content is not stored, the client uploads it
+	FastUploadRepeat = "repeat" // the user already has the file
+)
+
+// Registry: the metrics served by Handler
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "The HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "The latency of the HTTP requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	uploads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploads_total",
+		Help:      "The completed uploads by kind, single, chunked or fast.",
+	}, []string{"kind"})
+	uploadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_bytes_total",
+		Help:      "The size of the uploaded files by kind, the fast uploads are not transferred.",
+	}, []string{"kind"})
+	uploadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_duration_seconds",
+		Help:      "The duration of the upload requests by kind, the merge request for the chunked uploads.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"kind"})
+	fastUploads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fast_upload_checks_total",
+		Help:      "The fast upload requests by result, hit, miss or repeat.",
+	}, []string{"result"})
+
+	mqPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mq_published_total",
+		Help:      "The messages published by queue and result.",
+	}, []string{"queue", "result"})
+	mqConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mq_consumed_total",
+		Help:      "The messages processed by queue and result.",
+	}, []string{"queue", "result"})
+	mqLag = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mq_queue_lag_seconds",
+		Help:      "The time between the publishing of the messages and the start of their processing.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16),
+	}, []string{"queue"})
+
+	ossTransfer = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "oss_transfer_duration_seconds",
+		Help:      "The duration of the transfers of the files to the OSS by result.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 14),
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration,
+		uploads, uploadBytes, uploadDuration, fastUploads,
+		mqPublished, mqConsumed, mqLag,
+		ossTransfer,
+	)
+}
+
+// Handler: serve the metrics, the scrapers must send the token as a bearer token if it is set
+func Handler(token string) http.Handler {
+	handler := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+	if token == "" {
+		return handler
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// Middleware: count the requests and observe their latency, labeled by the pattern of the route
+// they matched in the mux, so the ids in the paths do not create new series
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := utils.NewStatusRecorder(w)
+		next.ServeHTTP(recorder, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(recorder.Status())).Inc()
+		httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// ObserveUpload: record the completed upload of the kind, started at start
+func ObserveUpload(kind string, size int64, start time.Time) {
+	uploads.WithLabelValues(kind).Inc()
+	uploadBytes.WithLabelValues(kind).Add(float64(size))
+	uploadDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+}
+
+// ObserveFastUpload: record the result of the fast upload check
+func ObserveFastUpload(result string) {
+	fastUploads.WithLabelValues(result).Inc()
+}
+
+// ObservePublish: record the message published to the queue
+func ObservePublish(queue string, err error) {
+	mqPublished.WithLabelValues(queue, result(err)).Inc()
+}
+
+// ObserveQueueLag: record the time the message of the queue waited since published, before it is processed
+func ObserveQueueLag(queue string, published time.Time) {
+	if !published.IsZero() {
+		mqLag.WithLabelValues(queue).Observe(time.Since(published).Seconds())
+	}
+}
+
+// ObserveConsume: record the message of the queue processed
+func ObserveConsume(queue string, err error) {
+	mqConsumed.WithLabelValues(queue, result(err)).Inc()
+}
+
+// ObserveTransfer: record the transfer of a file to the OSS, started at start
+func ObserveTransfer(start time.Time, err error) {
+	ossTransfer.WithLabelValues(result(err)).Observe(time.Since(start).Seconds())
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/bladewaltz9/file-store-server/auth"
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/utils"
+)
+
+// AdminMiddleware: reject the users who are not administrators, must be wrapped by TokenAuthMiddleware
+func (m *Middleware) AdminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := r.Context().Value(models.ContextKey("claims")).(*auth.Claims)
+		user, err := m.db.WithContext(r.Context()).GetUserInfoByID(claims.UserID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to get user", "error", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get user")
+			return
+		}
+		if user.Role != models.UserRoleAdmin {
+			utils.WriteJSONResponse(w, http.StatusForbidden, "error", "permission denied")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
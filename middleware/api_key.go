@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/bladewaltz9/file-store-server/auth"
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/utils"
+)
+
+// errAPIKeyScope: the API key is valid but not allowed on the route
+var errAPIKeyScope = errors.New("api key scope denied")
+
+// APIKeyScope: allow the API keys granted the scope on the route, must wrap TokenAuthMiddleware,
+// the routes without it only accept the login tokens
+func APIKeyScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), models.ContextKey("api_key_scope"), scope)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// isAPIKey: check if the credential of the request is an API key instead of a JWT
+func isAPIKey(tokenStr string) bool {
+	return strings.HasPrefix(tokenStr, config.APIKeyPrefix)
+}
+
+// ValidateAPIKey: validate the API key and check it is granted the scope of the route
+func (m *Middleware) ValidateAPIKey(r *http.Request, keyStr string) (*auth.Claims, error) {
+	store := m.db.WithContext(r.Context())
+	key, err := store.GetAPIKeyByHash(utils.HashToken(keyStr))
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || key.Expired() {
+		return nil, errors.New("invalid api key")
+	}
+
+	scope, _ := r.Context().Value(models.ContextKey("api_key_scope")).(string)
+	if scope == "" || !key.HasScope(scope) {
+		return nil, errAPIKeyScope
+	}
+
+	if err := store.TouchAPIKey(key.KeyID, utils.ClientIP(r), config.APIKeyTouchInterval); err != nil {
+		slog.ErrorContext(r.Context(), "failed to update api key last used", "error", err)
+	}
+	return &auth.Claims{UserID: key.UserID, Username: key.Username}, nil
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/logging"
+	"github.com/bladewaltz9/file-store-server/tracing"
+	"github.com/bladewaltz9/file-store-server/utils"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader: the header of the request ids, kept from the proxies and returned to the clients
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength: the longer request ids of the clients are replaced
+const maxRequestIDLength = 128
+
+// probeRoutes: the routes polled by the orchestrators and the scrapers, logged at the debug level
+var probeRoutes = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+// RequestLogger: give every request an id, attach it and the route matched in routes to the log lines
+// written with the context of the request, and write an access log line once next has served it
+func RequestLogger(routes *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			var err error
+			if requestID, err = utils.GenerateToken(12); err != nil {
+				slog.Error("failed to generate the request id", "error", err)
+			}
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+
+		_, route := routes.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx := logging.NewContext(r.Context(), slog.String("request_id", requestID), slog.String("route", route))
+		ctx = logging.WithRequestID(ctx, requestID)
+		r = r.WithContext(ctx)
+
+		recorder := utils.NewStatusRecorder(w)
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case probeRoutes[route]:
+			level = slog.LevelDebug
+		}
+		slog.Log(ctx, level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("client_ip", utils.ClientIP(r)),
+		)
+	})
+}
+
+// validRequestID: accept the request ids of the clients and the proxies if they are short and printable
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// Tracing: trace the requests, continuing the traces of the callers, the spans are named by the routes
+// and their trace ids are added to the log lines, must be wrapped by RequestLogger
+func Tracing(next http.Handler) http.Handler {
+	traced := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if traceID := tracing.TraceID(r.Context()); traceID != "" {
+			logging.AddAttrs(r.Context(), slog.String("trace_id", traceID))
+		}
+		next.ServeHTTP(w, r)
+		if r.Pattern != "" {
+			trace.SpanFromContext(r.Context()).SetAttributes(semconv.HTTPRoute(r.Pattern))
+		}
+	})
+	return otelhttp.NewHandler(traced, "http",
+		otelhttp.WithFilter(func(r *http.Request) bool { return !probeRoutes[r.URL.Path] }),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			if r.Pattern == "" {
+				return r.Method
+			}
+			return r.Method + " " + r.Pattern
+		}),
+	)
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/db"
+	"github.com/bladewaltz9/file-store-server/redis"
+)
+
+// Middleware: the authentication and the authorization of the requests
+type Middleware struct {
+	db    *db.DB
+	redis *redis.Client
+
+	// requireEmailVerification: restrict the unverified accounts to read-only access
+	requireEmailVerification bool
+}
+
+// New: create the middlewares with the services they check the requests against
+func New(cfg *config.Config, database *db.DB, rdb *redis.Client) *Middleware {
+	return &Middleware{
+		db:                       database,
+		redis:                    rdb,
+		requireEmailVerification: cfg.Mail.RequireVerification,
+	}
+}
@@ -2,12 +2,14 @@ package middleware
 
 import (
 	"context"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 
-	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/auth"
+	"github.com/bladewaltz9/file-store-server/logging"
 	"github.com/bladewaltz9/file-store-server/models"
-	"github.com/dgrijalva/jwt-go"
 )
 
 // extractToken: extract the token from the request
@@ -26,26 +28,29 @@ func extractToken(r *http.Request) string {
 	return tokenStr
 }
 
-// validateToken: validate the token
-func ValidateToken(tokenStr string) (jwt.MapClaims, error) {
-	// parse the token
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.JWTSecretKey), nil
-	})
-	if err != nil || !token.Valid {
+// ValidateToken: validate the token and check if it is revoked
+func (m *Middleware) ValidateToken(ctx context.Context, tokenStr string) (*auth.Claims, error) {
+	claims, err := auth.ParseAccessToken(tokenStr)
+	if err != nil {
 		return nil, err
 	}
 
-	// get the claims from the token
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
+	// check if the token is revoked by logout or by revoking all sessions of the user
+	if claims.ID == "" {
+		return nil, fmt.Errorf("token id missing")
+	}
+	revoked, err := m.redis.WithContext(ctx).IsTokenRevoked(claims.ID, claims.UserID, claims.Version)
+	if err != nil {
 		return nil, err
 	}
+	if revoked {
+		return nil, fmt.Errorf("token revoked")
+	}
 	return claims, nil
 }
 
 // TokenAuthMiddleware: middleware to authenticate the token
-func TokenAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+func (m *Middleware) TokenAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		tokenStr := extractToken(r)
@@ -54,13 +59,25 @@ func TokenAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		claims, err := ValidateToken(tokenStr)
+		// the API keys are only accepted in the Authorization header
+		var claims *auth.Claims
+		var err error
+		if isAPIKey(tokenStr) && r.Header.Get("Authorization") != "" {
+			claims, err = m.ValidateAPIKey(r, tokenStr)
+		} else {
+			claims, err = m.ValidateToken(r.Context(), tokenStr)
+		}
+		if err == errAPIKeyScope {
+			http.Error(w, "api key not allowed on this endpoint", http.StatusForbidden)
+			return
+		}
 		if err != nil {
 			http.Error(w, "invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		// set the claims to the context
+		// set the claims to the context, and the user to the log lines of the request
+		logging.AddAttrs(r.Context(), slog.Int("user_id", claims.UserID))
 		ctx := context.WithValue(r.Context(), models.ContextKey("claims"), claims)
 		r = r.WithContext(ctx)
 
@@ -69,12 +86,12 @@ func TokenAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 }
 
 // IsAuthenticated: check if the user is authenticated
-func IsAuthenticated(r *http.Request) bool {
+func (m *Middleware) IsAuthenticated(r *http.Request) bool {
 	tokenStr := extractToken(r)
 	if tokenStr == "" {
 		return false
 	}
 
-	_, err := ValidateToken(tokenStr)
+	_, err := m.ValidateToken(r.Context(), tokenStr)
 	return err == nil
 }
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/bladewaltz9/file-store-server/auth"
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/utils"
+)
+
+// VerifiedEmailMiddleware: reject the users who did not verify the email if the verification is required,
+// must be wrapped by TokenAuthMiddleware
+func (m *Middleware) VerifiedEmailMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.requireEmailVerification {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims := r.Context().Value(models.ContextKey("claims")).(*auth.Claims)
+		user, err := m.db.WithContext(r.Context()).GetUserInfoByID(claims.UserID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to get user", "error", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, "error", "failed to get user")
+			return
+		}
+		if !user.EmailValidated {
+			utils.WriteJSONResponse(w, http.StatusForbidden, "error", "please verify your email first")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/db"
+	"github.com/bladewaltz9/file-store-server/logging"
+)
+
+const migrateUsage = "usage: file-store-server migrate up|down [steps]|version [flags]"
+
+// migrateCommand: apply or revert the schema migrations, or show the version of the schema,
+// the flags after the action are the flags of the server
+func migrateCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	action, args := args[0], args[1:]
+	steps := 1
+	if action == "down" && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid number of steps %q", args[0])
+		}
+		steps, args = n, args[1:]
+	}
+
+	cfg, err := config.Load(args)
+	if err != nil {
+		return fmt.Errorf("failed to load the config: %v", err)
+	}
+	if err := logging.Setup(cfg.Log); err != nil {
+		return err
+	}
+	store, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch action {
+	case "up":
+		applied, err := store.Migrate()
+		for _, name := range applied {
+			slog.Info("applied the migration", "name", name)
+		}
+		return err
+	case "down":
+		reverted, err := store.MigrateDown(steps)
+		for _, name := range reverted {
+			slog.Info("reverted the migration", "name", name)
+		}
+		return err
+	case "version":
+		current, latest, err := store.SchemaVersion()
+		if err != nil {
+			return err
+		}
+		slog.Info("the schema version", "current", current, "latest", latest)
+		return nil
+	default:
+		return fmt.Errorf("unknown action %q, %s", action, migrateUsage)
+	}
+}
+
+// migrate: apply the pending migrations on startup, or only check there are none
+// if they are applied by the migrate command
+func (s *server) migrate() error {
+	if !s.cfg.Database.AutoMigrate {
+		if err := s.db.CheckSchema(); errors.Is(err, db.ErrSchemaOutdated) {
+			return fmt.Errorf("%v, run `file-store-server migrate up`", err)
+		} else if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	applied, err := s.db.Migrate()
+	for _, name := range applied {
+		slog.Info("applied the migration", "name", name)
+	}
+	return err
+}
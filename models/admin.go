@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// AdminUser: user information listed to the administrators
+type AdminUser struct {
+	UserID         int        `json:"user_id"`
+	Username       string     `json:"username"`
+	Email          string     `json:"email"`
+	EmailValidated bool       `json:"email_validated"`
+	Role           string     `json:"role"`
+	Status         string     `json:"status"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty"`
+	SignupAt       time.Time  `json:"signup_at"`
+	LastActive     time.Time  `json:"last_active"`
+	UsedBytes      int64      `json:"used_bytes"`
+	FileCount      int        `json:"file_count"`
+}
+
+// AdminUserList: a page of the users matching the search
+type AdminUserList struct {
+	Users []AdminUser `json:"users"`
+	Total int         `json:"total"`
+}
+
+// UpdateUserStatusRequest: change the account status request structure
+type UpdateUserStatusRequest struct {
+	Status    string `json:"status"`
+	LockHours int    `json:"lock_hours"` // the lockout of the locked status, 0 until an admin unlocks it
+}
+
+// UpdateUserRoleRequest: change the system role request structure
+type UpdateUserRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// SystemStats: system-wide storage statistics
+type SystemStats struct {
+	Users        map[string]int `json:"users"`         // the number of users by status
+	Files        int            `json:"files"`         // the stored files after deduplication
+	StoredBytes  int64          `json:"stored_bytes"`  // the size of the stored files
+	UserFiles    int            `json:"user_files"`    // the files of the users and teams
+	LogicalBytes int64          `json:"logical_bytes"` // the size charged to the users
+	Teams        int            `json:"teams"`
+	ShareLinks   int            `json:"share_links"` // the active public share links
+}
@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// Scopes of the API keys
+const (
+	APIKeyScopeRead   = "read"
+	APIKeyScopeUpload = "upload"
+	APIKeyScopeDelete = "delete"
+)
+
+// APIKeyScopes: all scopes an API key can be granted
+var APIKeyScopes = []string{APIKeyScopeRead, APIKeyScopeUpload, APIKeyScopeDelete}
+
+// APIKey: personal API key structure, only the hash of the key is stored
+type APIKey struct {
+	KeyID      int        `json:"key_id"`
+	UserID     int        `json:"-"`
+	Username   string     `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpireAt   *time.Time `json:"expire_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	CreateAt   time.Time  `json:"create_at"`
+}
+
+// HasScope: check if the key is granted the scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired: check if the key is expired
+func (k *APIKey) Expired() bool {
+	return k.ExpireAt != nil && time.Now().After(*k.ExpireAt)
+}
+
+// CreateAPIKeyRequest: create API key request structure
+type CreateAPIKeyRequest struct {
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	ExpireDays int      `json:"expire_days"` // 0 for never
+}
+
+// CreateAPIKeyResponse: create API key response structure, the key is only shown once
+type CreateAPIKeyResponse struct {
+	Key    string `json:"key"`
+	APIKey APIKey `json:"api_key"`
+}
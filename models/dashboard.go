@@ -7,6 +7,9 @@ type FileInfo struct {
 	FileSize   int64  `json:"file_size"`
 	UploadTime string `json:"upload_time"`
 	Status     string `json:"status"`
+
+	Tags     []string          `json:"tags,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 // DashboardData: dashboard data structure
@@ -14,6 +17,18 @@ type DashboardData struct {
 	UserID   int        `json:"user_id"`
 	Username string     `json:"username"`
 	Files    []FileInfo `json:"files"`
+
+	EmailValidated bool        `json:"email_validated"`
+	SharedFiles    []UserShare `json:"shared_files"`
+	Teams          []TeamInfo  `json:"teams"`
+	Quota          *UserQuota  `json:"quota"`
+	APIKeys        []APIKey    `json:"api_keys"`
+}
+
+// LoginPageData: login page data structure
+type LoginPageData struct {
+	OIDCEnabled      bool
+	OIDCProviderName string
 }
 
 // DownloadResponse: download response structure
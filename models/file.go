@@ -14,6 +14,9 @@ type FileMeta struct {
 	CreateAt time.Time `json:"create_at"`
 	UpdateAt time.Time `json:"update_at"`
 	Status   string    `json:"status"`
+
+	Tags     []string          `json:"tags,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 // UpdateFileMetaRequest: update file metadata request structure
@@ -22,9 +25,36 @@ type UpdateFileMetaRequest struct {
 	Status   string `json:"status"`
 }
 
+// FileTagsRequest: replace file tags request structure
+type FileTagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// BulkTagsRequest: bulk edit file tags request structure
+type BulkTagsRequest struct {
+	FileIDs []int    `json:"file_ids"`
+	Add     []string `json:"add"`
+	Remove  []string `json:"remove"`
+}
+
+// FileMetadataRequest: replace file metadata request structure
+type FileMetadataRequest struct {
+	Metadata map[string]string `json:"metadata"`
+}
+
 // FileChunkInfo: file chunk information structure
 type FileChunkInfo struct {
 	FileID      string `json:"file_id"`
 	FileName    string `json:"file_name"`
 	TotalChunks int    `json:"total_chunks"`
 }
+
+// SearchHit: full-text search result structure
+type SearchHit struct {
+	FileID   int     `json:"file_id"`
+	FileName string  `json:"file_name"`
+	FileSize int64   `json:"file_size"`
+	Score    float64 `json:"score"`
+	Snippet  string  `json:"snippet"`
+	Content  string  `json:"-"`
+}
@@ -0,0 +1,22 @@
+package models
+
+// Status of the readiness probe and of its dependencies
+const (
+	HealthStatusOK           = "ok"
+	HealthStatusError        = "error"
+	HealthStatusUnavailable  = "unavailable"
+	HealthStatusShuttingDown = "shutting_down"
+)
+
+// DependencyStatus: the result of the readiness check of a dependency
+type DependencyStatus struct {
+	Status     string `json:"status"` // ok or error
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// ReadinessStatus: the response of the readiness probe
+type ReadinessStatus struct {
+	Status       string                      `json:"status"` // ok, unavailable or shutting_down
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
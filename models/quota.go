@@ -0,0 +1,33 @@
+package models
+
+// UserQuota: storage quota and usage of the user, 0 limits mean unlimited
+type UserQuota struct {
+	Plan      string `json:"plan"`
+	MaxBytes  int64  `json:"max_bytes"`
+	MaxFiles  int    `json:"max_files"`
+	UsedBytes int64  `json:"used_bytes"`
+	FileCount int    `json:"file_count"`
+}
+
+// Allows: check if the user can store another file of the size
+func (q *UserQuota) Allows(size int64) bool {
+	if q.MaxBytes > 0 && q.UsedBytes+size > q.MaxBytes {
+		return false
+	}
+	if q.MaxFiles > 0 && q.FileCount+1 > q.MaxFiles {
+		return false
+	}
+	return true
+}
+
+// UsedPercent: the percentage of the used storage space, 0 if unlimited
+func (q *UserQuota) UsedPercent() int {
+	if q.MaxBytes <= 0 {
+		return 0
+	}
+	percent := int(q.UsedBytes * 100 / q.MaxBytes)
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
+}
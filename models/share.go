@@ -0,0 +1,91 @@
+package models
+
+import "time"
+
+// ShareLink: public share link structure, the link shares either a file or a folder
+type ShareLink struct {
+	LinkID        int        `json:"link_id"`
+	UserID        int        `json:"user_id"`
+	FileID        int        `json:"file_id,omitempty"`
+	FileName      string     `json:"file_name,omitempty"`
+	FolderID      int        `json:"folder_id,omitempty"`
+	FolderName    string     `json:"folder_name,omitempty"`
+	Token         string     `json:"token"`
+	Password      string     `json:"-"`
+	HasPassword   bool       `json:"has_password"`
+	ExpireAt      *time.Time `json:"expire_at,omitempty"`
+	MaxDownloads  int        `json:"max_downloads"`
+	DownloadCount int        `json:"download_count"`
+	Status        string     `json:"status"`
+	CreateAt      time.Time  `json:"create_at"`
+}
+
+// ShareAccessLog: access log structure of a share link
+type ShareAccessLog struct {
+	LinkID   int       `json:"link_id"`
+	IP       string    `json:"ip"`
+	Agent    string    `json:"user_agent"`
+	Action   string    `json:"action"`
+	Result   string    `json:"result"`
+	AccessAt time.Time `json:"access_at"`
+}
+
+// CreateShareRequest: create share link request structure, either the file or the folder is set
+type CreateShareRequest struct {
+	FileID       int    `json:"file_id"`
+	FolderID     int    `json:"folder_id"`
+	Password     string `json:"password"`
+	ExpireHours  int    `json:"expire_hours"`
+	MaxDownloads int    `json:"max_downloads"`
+}
+
+// CreateShareResponse: create share link response structure
+type CreateShareResponse struct {
+	LinkID int    `json:"link_id"`
+	Token  string `json:"token"`
+	URL    string `json:"url"`
+}
+
+// SharePageData: public share landing page data structure, Files lists the files of a shared folder
+// once the password is accepted, which is sent again with the downloads
+type SharePageData struct {
+	Token       string
+	FileName    string
+	FileSize    int64
+	IsFolder    bool
+	Files       []FileInfo
+	HasPassword bool
+	Password    string
+	ExpireAt    *time.Time
+	Error       string
+}
+
+// Permissions of the files shared between users
+const (
+	PermissionRead  = "read"
+	PermissionWrite = "write"
+	PermissionOwner = "owner"
+)
+
+// UserShare: file share between registered users structure
+type UserShare struct {
+	ShareID     int        `json:"share_id"`
+	OwnerID     int        `json:"owner_id"`
+	OwnerName   string     `json:"owner_name"`
+	GranteeID   int        `json:"grantee_id"`
+	GranteeName string     `json:"grantee_name"`
+	FileID      int        `json:"file_id"`
+	FileName    string     `json:"file_name"`
+	FileSize    int64      `json:"file_size"`
+	Permission  string     `json:"permission"`
+	ExpireAt    *time.Time `json:"expire_at,omitempty"`
+	CreateAt    time.Time  `json:"create_at"`
+}
+
+// CreateUserShareRequest: share a file with a registered user request structure
+type CreateUserShareRequest struct {
+	FileID      int    `json:"file_id"`
+	Username    string `json:"username"`
+	Permission  string `json:"permission"`
+	ExpireHours int    `json:"expire_hours"`
+}
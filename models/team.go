@@ -0,0 +1,91 @@
+package models
+
+import "time"
+
+// Roles of the team members
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// TeamInfo: team information structure, with the role of the current user
+type TeamInfo struct {
+	TeamID   int       `json:"team_id"`
+	Name     string    `json:"name"`
+	OwnerID  int       `json:"owner_id"`
+	Role     string    `json:"role,omitempty"`
+	CreateAt time.Time `json:"create_at"`
+}
+
+// TeamMember: team member structure
+type TeamMember struct {
+	TeamID   int       `json:"team_id"`
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	JoinAt   time.Time `json:"join_at"`
+}
+
+// TeamInvite: team invitation structure
+type TeamInvite struct {
+	InviteID    int       `json:"invite_id"`
+	TeamID      int       `json:"team_id"`
+	TeamName    string    `json:"team_name"`
+	InviterID   int       `json:"inviter_id"`
+	InviterName string    `json:"inviter_name"`
+	InviteeID   int       `json:"invitee_id"`
+	Role        string    `json:"role"`
+	Status      string    `json:"status"`
+	CreateAt    time.Time `json:"create_at"`
+}
+
+// CreateTeamRequest: create team request structure
+type CreateTeamRequest struct {
+	Name string `json:"name"`
+}
+
+// TeamInviteRequest: invite a user to the team request structure
+type TeamInviteRequest struct {
+	TeamID   int    `json:"team_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// TeamMemberRequest: change the role of or remove a team member request structure
+type TeamMemberRequest struct {
+	TeamID int    `json:"team_id"`
+	UserID int    `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+// Folder: folder structure, owned by a user or a team
+type Folder struct {
+	FolderID int       `json:"folder_id"`
+	Name     string    `json:"name"`
+	ParentID int       `json:"parent_id"`
+	UserID   int       `json:"user_id,omitempty"`
+	TeamID   int       `json:"team_id,omitempty"`
+	CreateAt time.Time `json:"create_at"`
+}
+
+// CreateFolderRequest: create folder request structure
+type CreateFolderRequest struct {
+	Name     string `json:"name"`
+	ParentID int    `json:"parent_id"`
+	TeamID   int    `json:"team_id"`
+}
+
+// MoveFileRequest: move a file into a folder request structure, folder 0 is the root
+type MoveFileRequest struct {
+	FileID   int `json:"file_id"`
+	FolderID int `json:"folder_id"`
+	TeamID   int `json:"team_id"`
+}
+
+// FolderContent: the sub folders and files of a folder structure
+type FolderContent struct {
+	Folders []Folder   `json:"folders"`
+	Files   []FileInfo `json:"files"`
+}
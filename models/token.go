@@ -0,0 +1,20 @@
+package models
+
+// TokenResponse: the issued access token and refresh token
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // seconds until the access token expires
+}
+
+// RefreshSession: the session stored on the server for a refresh token
+type RefreshSession struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// OIDCState: the pending single sign-on login, stored by the hash of its state parameter
+type OIDCState struct {
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"` // the PKCE code verifier
+}
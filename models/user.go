@@ -1,12 +1,97 @@
 package models
 
+import "time"
+
 // UserInfo: user information structure
 type UserInfo struct {
-	UserID   int    `json:"user_id"`
-	Username string `json:"username"`
+	UserID   int        `json:"user_id"`
+	Username string     `json:"username"`
+	Password string     `json:"-"`
+	Email    string     `json:"email"`
+	Teams    []TeamInfo `json:"teams,omitempty"`
+
+	EmailValidated bool   `json:"email_validated"`
+	Phone          string `json:"phone"`
+	PhoneValidated bool   `json:"phone_validated"`
+	Role           string `json:"role"`
+	Status         string `json:"status"`
+	// LockedUntil: the end of the temporary lockout, nil if the account is locked until an admin unlocks it
+	LockedUntil *time.Time  `json:"locked_until,omitempty"`
+	Profile     UserProfile `json:"profile"`
+	SignupAt    time.Time   `json:"signup_at"`
+	LastActive  time.Time   `json:"last_active"`
+}
+
+// UserProfile: the free-form profile of the user, stored in the profile JSON column
+type UserProfile struct {
+	DisplayName string `json:"display_name,omitempty"`
+	Bio         string `json:"bio,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	Locale      string `json:"locale,omitempty"`
+	Timezone    string `json:"timezone,omitempty"`
+}
+
+// UpdateProfileRequest: update profile request structure, the fields left out are unchanged
+type UpdateProfileRequest struct {
+	Phone   *string      `json:"phone"`
+	Profile *UserProfile `json:"profile"`
+}
+
+// ChangePasswordRequest: change password request structure, the current password is required
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangeEmailRequest: change email request structure, the new email is used once it is verified
+type ChangeEmailRequest struct {
 	Password string `json:"password"`
 	Email    string `json:"email"`
-	// TODO: add more fields
+}
+
+// DeleteAccountRequest: delete account request structure, the code is required with two-factor authentication
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
 }
 
 type ContextKey string // ContextKey: context key type
+
+// Status of the user accounts
+const (
+	UserStatusActive   = "active"
+	UserStatusDisabled = "disabled"
+	UserStatusLocked   = "locked"
+	UserStatusDeleted  = "deleted"
+)
+
+// System roles of the users
+const (
+	UserRoleUser  = "user"
+	UserRoleAdmin = "admin"
+)
+
+// UserTOTP: the TOTP second factor of the user
+type UserTOTP struct {
+	UserID   int
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+
+// TOTPCodeRequest: a TOTP code or a recovery code request structure
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodesResponse: the recovery codes, shown to the user once
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Purposes of the single-use tokens sent by mail
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+	TokenChangeEmail   = "change_email"
+)
@@ -1,21 +1,64 @@
 package mq
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"time"
 
 	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/bladewaltz9/file-store-server/db"
+	"github.com/bladewaltz9/file-store-server/logging"
+	"github.com/bladewaltz9/file-store-server/metrics"
 	"github.com/bladewaltz9/file-store-server/oss"
+	"github.com/bladewaltz9/file-store-server/search"
+	"github.com/bladewaltz9/file-store-server/tracing"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// ConsumeMessage: consumes a message from RabbitMQ
-func (r *RabbitMQ) ConsumeMessage() error {
+// Consumer: processes the file transfer and the file index messages
+type Consumer struct {
+	db  *db.DB
+	oss *oss.Client
+}
+
+// NewConsumer: creates the consumer of the messages
+func NewConsumer(database *db.DB, ossClient *oss.Client) *Consumer {
+	return &Consumer{db: database, oss: ossClient}
+}
+
+// publishedAtHeader: the header of the publishing time of the messages in milliseconds,
+// the timestamp of the messages is in seconds
+const publishedAtHeader = "published_at"
+
+// publishedAt: the publishing time of the message, the zero time if it is not set
+func publishedAt(msg amqp.Delivery) time.Time {
+	if ms, ok := msg.Headers[publishedAtHeader].(int64); ok {
+		return time.UnixMilli(ms)
+	}
+	return msg.Timestamp
+}
+
+// Consume: registers a consumer and processes the messages until the context is done or the channel is closed,
+// the message being processed is finished and acknowledged first, the others are left in the queue,
+// process gets the log context of the message, it adds the fields of the message, e.g. the request id
+func (r *RabbitMQ) Consume(ctx context.Context, process func(ctx context.Context, message []byte) error) error {
+	// take one message at a time, the unacknowledged ones go back to the queue if the consumer stops
+	if err := r.channel.Qos(1, 0, false); err != nil {
+		return fmt.Errorf("failed to set the prefetch count: %v", err)
+	}
+
 	// Register a consumer
 	msgs, err := r.channel.Consume(
 		r.Queue,
-		"",
-		true,
+		r.Queue, // the consumer tag, to cancel it
+		false,
 		false,
 		false,
 		false,
@@ -25,29 +68,87 @@ func (r *RabbitMQ) ConsumeMessage() error {
 		return fmt.Errorf("failed to register a consumer: %v", err)
 	}
 
-	forever := make(chan bool)
-	go func() {
-		for msg := range msgs {
-			processMessage(msg.Body)
+	for {
+		select {
+		case <-ctx.Done():
+			if err := r.channel.Cancel(r.Queue, false); err != nil {
+				return fmt.Errorf("failed to cancel the consumer: %v", err)
+			}
+			return nil
+		case msg, ok := <-msgs:
+			if !ok {
+				return errors.New("the channel of the consumer is closed")
+			}
+			metrics.ObserveQueueLag(r.Queue, publishedAt(msg))
+			r.process(ctx, msg, process)
+			if err := msg.Ack(false); err != nil {
+				return fmt.Errorf("failed to acknowledge the message: %v", err)
+			}
 		}
-	}()
-	<-forever
+	}
+}
 
-	return nil
+// process: process the message in a consumer span continuing the trace of the publisher,
+// with the log fields of the queue and the trace
+func (r *RabbitMQ) process(ctx context.Context, msg amqp.Delivery, process func(ctx context.Context, message []byte) error) {
+	ctx = otel.GetTextMapPropagator().Extract(context.WithoutCancel(ctx), headerCarrier(msg.Headers))
+	ctx, span := tracing.StartKind(ctx, "process "+r.Queue, trace.SpanKindConsumer, r.spanAttributes(semconv.MessagingOperationTypeProcess)...)
+	ctx = logging.NewContext(ctx, slog.String("queue", r.Queue))
+	if traceID := tracing.TraceID(ctx); traceID != "" {
+		logging.AddAttrs(ctx, slog.String("trace_id", traceID))
+	}
+
+	err := process(ctx, msg.Body)
+	tracing.End(span, err)
+	metrics.ObserveConsume(r.Queue, err)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to process the message", "error", err)
+	}
 }
 
-// processMessage: processes the message
-func processMessage(message []byte) {
+// ProcessTransferMessage: uploads the file of the transfer message to the OSS
+func (c *Consumer) ProcessTransferMessage(ctx context.Context, message []byte) error {
 	var fileMsg FileTransferMessage
-	err := json.Unmarshal(message, &fileMsg)
-	if err != nil {
-		log.Printf("failed to unmarshal the message: %v\n", err)
-		return
+	if err := json.Unmarshal(message, &fileMsg); err != nil {
+		return fmt.Errorf("failed to unmarshal the message: %v", err)
 	}
+	logging.AddAttrs(ctx, slog.String("request_id", fileMsg.RequestID), slog.Int("file_id", fileMsg.FileID))
 
 	// Upload the file to the OSS
-	if err := oss.UploadFile(config.BucketName, fileMsg.ObjectKey, fileMsg.LocalFile); err != nil {
-		log.Printf("failed to upload the file to the OSS: %v\n", err)
-		return
+	start := time.Now()
+	err := c.oss.UploadFile(ctx, fileMsg.ObjectKey, fileMsg.LocalFile)
+	metrics.ObserveTransfer(start, err)
+	if err != nil {
+		return fmt.Errorf("failed to upload the file to the OSS: %v", err)
+	}
+	slog.InfoContext(ctx, "transferred the file to the OSS", "object_key", fileMsg.ObjectKey, "duration", time.Since(start))
+	return nil
+}
+
+// ProcessIndexMessage: extracts the text of the file and saves it to the full-text index
+func (c *Consumer) ProcessIndexMessage(ctx context.Context, message []byte) error {
+	var indexMsg FileIndexMessage
+	if err := json.Unmarshal(message, &indexMsg); err != nil {
+		return fmt.Errorf("failed to unmarshal the message: %v", err)
 	}
+	logging.AddAttrs(ctx, slog.String("request_id", indexMsg.RequestID), slog.Int("file_id", indexMsg.FileID))
+
+	// Extract the text of the file
+	_, span := tracing.Start(ctx, "search.ExtractText", attribute.String("file.name", indexMsg.FileName))
+	content, err := search.ExtractText(indexMsg.LocalFile, indexMsg.FileName, config.MaxIndexFileSize, config.MaxIndexContentSize)
+	tracing.End(span, err)
+	if errors.Is(err, search.ErrFileTooLarge) {
+		slog.InfoContext(ctx, "skipped indexing the large file")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to extract the text of file %d: %v", indexMsg.FileID, err)
+	}
+
+	// Save the text to the index
+	if err := c.db.WithContext(ctx).SaveFileContent(indexMsg.FileID, content); err != nil {
+		return fmt.Errorf("failed to save the content of file %d: %v", indexMsg.FileID, err)
+	}
+	slog.InfoContext(ctx, "indexed the content of the file", "content_length", len(content))
+	return nil
 }
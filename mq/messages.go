@@ -4,4 +4,12 @@ type FileTransferMessage struct {
 	FileID    int
 	LocalFile string
 	ObjectKey string
+	RequestID string // the upload request, for the logs of the consumer
+}
+
+type FileIndexMessage struct {
+	FileID    int
+	LocalFile string
+	FileName  string
+	RequestID string // the upload request, for the logs of the consumer
 }
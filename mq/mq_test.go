@@ -1,7 +1,10 @@
 package mq_test
 
 import (
+	"context"
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/bladewaltz9/file-store-server/config"
 	"github.com/bladewaltz9/file-store-server/mq"
@@ -15,18 +18,32 @@ func TestRabbitMQ(t *testing.T) {
 		ObjectKey: config.BucketDir + "main.go",
 	}
 
-	rabbitMQ, err := mq.NewRabbitMQ(config.TransExchangeName, config.TransOSSQueueName, config.TransOSSRoutingKey, config.RabbitMQURL)
+	cfg, err := config.Load(nil)
 	if err != nil {
-		t.Errorf("failed to create a new RabbitMQ instance: %v", err)
+		t.Skipf("no config for the RabbitMQ: %v", err)
+	}
+	rabbitMQ, err := mq.NewRabbitMQ(cfg.RabbitMQ.Exchange, cfg.RabbitMQ.OSSQueue, cfg.RabbitMQ.OSSRoutingKey, cfg.RabbitMQ.URL())
+	if err != nil {
+		t.Skipf("the RabbitMQ is not available: %v", err)
 	}
 
 	// Publish a message
-	if err := rabbitMQ.PublishMessage(fileMsg); err != nil {
+	if err := rabbitMQ.PublishMessage(context.Background(), fileMsg); err != nil {
 		t.Errorf("failed to publish a message: %v", err)
 	}
 
-	// Consume a message
-	if err := rabbitMQ.ConsumeMessage(); err != nil {
+	// Consume a message, then stop the consumer
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = rabbitMQ.Consume(ctx, func(ctx context.Context, message []byte) error {
+		var received mq.FileTransferMessage
+		if err := json.Unmarshal(message, &received); err != nil || received.FileID != fileMsg.FileID {
+			t.Errorf("unexpected message: %s", message)
+		}
+		cancel()
+		return nil
+	})
+	if err != nil {
 		t.Errorf("failed to consume a message: %v", err)
 	}
 }
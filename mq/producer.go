@@ -1,21 +1,34 @@
 package mq
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
+	"github.com/bladewaltz9/file-store-server/metrics"
+	"github.com/bladewaltz9/file-store-server/tracing"
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// PublishMessage: publishes a message to RabbitMQ
-func (r *RabbitMQ) PublishMessage(fileMsg *FileTransferMessage) error {
+// PublishMessage: publishes a message to RabbitMQ, with the trace context of ctx for the consumer
+func (r *RabbitMQ) PublishMessage(ctx context.Context, msg interface{}) (err error) {
+	ctx, span := tracing.StartKind(ctx, "publish "+r.Queue, trace.SpanKindProducer, r.spanAttributes(semconv.MessagingOperationTypeSend)...)
+	defer func() { tracing.End(span, err) }()
+
 	// Serialize the message to JSON
-	body, err := json.Marshal(fileMsg)
+	body, err := json.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("failed to marshal the message: %v", err)
 	}
 
-	// Publish the message
+	// Publish the message, with the publishing time for the queue lag and the trace context
+	now := time.Now()
+	headers := amqp.Table{publishedAtHeader: now.UnixMilli()}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
 	err = r.channel.Publish(
 		r.Exchange,
 		r.Key,
@@ -23,9 +36,12 @@ func (r *RabbitMQ) PublishMessage(fileMsg *FileTransferMessage) error {
 		false,
 		amqp.Publishing{
 			ContentType: "application/json",
+			Timestamp:   now,
+			Headers:     headers,
 			Body:        body,
 		},
 	)
+	metrics.ObservePublish(r.Queue, err)
 	if err != nil {
 		return fmt.Errorf("failed to publish the message: %v", err)
 	}
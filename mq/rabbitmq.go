@@ -1,6 +1,7 @@
 package mq
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/bladewaltz9/file-store-server/config"
@@ -18,7 +19,11 @@ type RabbitMQ struct {
 	url      string
 }
 
-var rabbitMQ *RabbitMQ
+// Client: the RabbitMQ instances of the transfer and the full-text index queues
+type Client struct {
+	Transfer *RabbitMQ
+	Index    *RabbitMQ
+}
 
 // NewRabbitMQ: creates a new RabbitMQ instance
 func NewRabbitMQ(exchange, queue, key, url string) (*RabbitMQ, error) {
@@ -35,16 +40,24 @@ func NewRabbitMQ(exchange, queue, key, url string) (*RabbitMQ, error) {
 	return rmq, nil
 }
 
-func init() {
-	var err error
-	rabbitMQ, err = NewRabbitMQ(config.TransExchangeName, config.TransOSSQueueName, config.TransOSSRoutingKey, config.RabbitMQURL)
+// New: connects the RabbitMQ instances of the transfer and the full-text index queues
+func New(cfg config.RabbitMQConfig) (*Client, error) {
+	transfer, err := NewRabbitMQ(cfg.Exchange, cfg.OSSQueue, cfg.OSSRoutingKey, cfg.URL())
 	if err != nil {
-		panic(fmt.Sprintf("failed to create a new RabbitMQ instance: %v", err))
+		return nil, fmt.Errorf("failed to create a new RabbitMQ instance: %v", err)
 	}
+
+	index, err := NewRabbitMQ(cfg.Exchange, cfg.IndexQueue, cfg.IndexRoutingKey, cfg.URL())
+	if err != nil {
+		transfer.Close()
+		return nil, fmt.Errorf("failed to create a new RabbitMQ instance: %v", err)
+	}
+	return &Client{Transfer: transfer, Index: index}, nil
 }
 
-func GetRabbitMQ() *RabbitMQ {
-	return rabbitMQ
+// Close: closes the RabbitMQ instances
+func (c *Client) Close() error {
+	return errors.Join(c.Transfer.Close(), c.Index.Close())
 }
 
 // connect: connects to RabbitMQ
@@ -61,3 +74,22 @@ func (r *RabbitMQ) connect() error {
 	}
 	return nil
 }
+
+// Close: closes the channel and the connection
+func (r *RabbitMQ) Close() error {
+	if err := r.channel.Close(); err != nil {
+		r.conn.Close()
+		return err
+	}
+	return r.conn.Close()
+}
+
+// Ping: check the connections of the RabbitMQ instances are open
+func (c *Client) Ping() error {
+	for _, r := range []*RabbitMQ{c.Transfer, c.Index} {
+		if r.conn.IsClosed() {
+			return fmt.Errorf("the connection of %s is closed", r.Queue)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package mq
+
+import (
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+// headerCarrier: the headers of a message carrying the trace context from the publisher to the consumer
+type headerCarrier amqp.Table
+
+func (h headerCarrier) Get(key string) string {
+	value, _ := h[key].(string)
+	return value
+}
+
+func (h headerCarrier) Set(key, value string) {
+	h[key] = value
+}
+
+func (h headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// spanAttributes: the attributes of the spans of the messages of the queue
+func (r *RabbitMQ) spanAttributes(operation attribute.KeyValue) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.MessagingSystemRabbitMQ,
+		operation,
+		semconv.MessagingDestinationName(r.Exchange),
+		semconv.MessagingRabbitMQDestinationRoutingKey(r.Key),
+		semconv.MessagingConsumerGroupName(r.Queue),
+	}
+}
@@ -3,20 +3,30 @@ package oss
 import "github.com/aliyun/aliyun-oss-go-sdk/oss"
 
 // CreateBucket: create a bucket
-func CreateBucket(bucketName string) error {
-	return ossClient.CreateBucket(bucketName)
+func (c *Client) CreateBucket(bucketName string) error {
+	return c.client.CreateBucket(bucketName)
 }
 
 // DeleteBucket: delete a bucket
-func DeleteBucket(bucketName string) error {
-	return ossClient.DeleteBucket(bucketName)
+func (c *Client) DeleteBucket(bucketName string) error {
+	return c.client.DeleteBucket(bucketName)
 }
 
 // ListBuckets: list all the buckets
-func ListBuckets() ([]oss.BucketProperties, error) {
-	result, err := ossClient.ListBuckets()
+func (c *Client) ListBuckets() ([]oss.BucketProperties, error) {
+	result, err := c.client.ListBuckets()
 	if err != nil {
 		return nil, err
 	}
 	return result.Buckets, nil
 }
+
+// Ping: check the bucket the files are transferred to is reachable
+func (c *Client) Ping() error {
+	bucket, err := c.client.Bucket(c.bucket)
+	if err != nil {
+		return err
+	}
+	_, err = bucket.ListObjects(oss.MaxKeys(1))
+	return err
+}
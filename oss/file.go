@@ -1,15 +1,30 @@
 package oss
 
 import (
+	"context"
 	"time"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/bladewaltz9/file-store-server/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// UploadFile: upload the file to the OSS
-func UploadFile(bucketName, objectKey, localFile string) error {
+// startSpan: trace the operation on the object in the span of ctx, the SDK takes no context
+func (c *Client) startSpan(ctx context.Context, operation, objectKey string) func(err error) {
+	_, span := tracing.Start(ctx, "oss."+operation,
+		attribute.String("oss.bucket", c.bucket),
+		attribute.String("oss.object_key", objectKey),
+	)
+	return func(err error) { tracing.End(span, err) }
+}
+
+// UploadFile: upload the file to the bucket
+func (c *Client) UploadFile(ctx context.Context, objectKey, localFile string) (err error) {
+	end := c.startSpan(ctx, "PutObject", objectKey)
+	defer func() { end(err) }()
+
 	// Get the bucket
-	bucket, err := ossClient.Bucket(bucketName)
+	bucket, err := c.client.Bucket(c.bucket)
 	if err != nil {
 		return err
 	}
@@ -18,10 +33,13 @@ func UploadFile(bucketName, objectKey, localFile string) error {
 	return bucket.PutObjectFromFile(objectKey, localFile)
 }
 
-// GenerateDownloadURL: generate the download URL for the file in the OSS
-func GenerateDownloadURL(bucketName, objectKey string, expiryTime time.Duration) (string, error) {
+// GenerateDownloadURL: generate the download URL for the file in the bucket
+func (c *Client) GenerateDownloadURL(ctx context.Context, objectKey string, expiryTime time.Duration) (_ string, err error) {
+	end := c.startSpan(ctx, "SignURL", objectKey)
+	defer func() { end(err) }()
+
 	// Get the bucket
-	bucket, err := ossClient.Bucket(bucketName)
+	bucket, err := c.client.Bucket(c.bucket)
 	if err != nil {
 		return "", err
 	}
@@ -35,10 +53,13 @@ func GenerateDownloadURL(bucketName, objectKey string, expiryTime time.Duration)
 	return signedURL, nil
 }
 
-// DownloadFile: download the file from the OSS
-func DownloadFile(bucketName, objectKey, downloadPath string) error {
+// DownloadFile: download the file from the bucket
+func (c *Client) DownloadFile(ctx context.Context, objectKey, downloadPath string) (err error) {
+	end := c.startSpan(ctx, "GetObject", objectKey)
+	defer func() { end(err) }()
+
 	// Get the bucket
-	bucket, err := ossClient.Bucket(bucketName)
+	bucket, err := c.client.Bucket(c.bucket)
 	if err != nil {
 		return err
 	}
@@ -47,10 +68,13 @@ func DownloadFile(bucketName, objectKey, downloadPath string) error {
 	return bucket.GetObjectToFile(objectKey, downloadPath)
 }
 
-// DeleteFile: delete the file from the OSS
-func DeleteFile(bucketName, objectKey string) error {
+// DeleteFile: delete the file from the bucket
+func (c *Client) DeleteFile(ctx context.Context, objectKey string) (err error) {
+	end := c.startSpan(ctx, "DeleteObject", objectKey)
+	defer func() { end(err) }()
+
 	// Get the bucket
-	bucket, err := ossClient.Bucket(bucketName)
+	bucket, err := c.client.Bucket(c.bucket)
 	if err != nil {
 		return err
 	}
@@ -7,22 +7,27 @@ import (
 	"github.com/bladewaltz9/file-store-server/config"
 )
 
-var ossClient *oss.Client
+// Client: the object storage the files are transferred to
+type Client struct {
+	client *oss.Client
+	bucket string
+}
 
-// init: initialize the oss connection
-func initOSS(endpoint, accessKeyID, accessKeySecret string) {
-	var err error
-	ossClient, err = oss.New(endpoint, accessKeyID, accessKeySecret)
+// New: create the oss client of the configured endpoint and bucket
+func New(cfg config.OSSConfig) (*Client, error) {
+	client, err := oss.New(cfg.Endpoint, cfg.AccessKeyID, cfg.AccessKeySecret)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to connect to the OSS: %v", err.Error()))
+		return nil, fmt.Errorf("failed to connect to the OSS: %v", err.Error())
 	}
+	return &Client{client: client, bucket: cfg.Bucket}, nil
 }
 
-func init() {
-	initOSS(config.Endpoint, config.AccessKeyID, config.AccessKeySecret)
+// GetOSSClient: get the underlying oss client
+func (c *Client) GetOSSClient() *oss.Client {
+	return c.client
 }
 
-// GetOSSClient: get the oss client
-func GetOSSClient() *oss.Client {
-	return ossClient
+// BucketName: get the bucket the files are transferred to
+func (c *Client) BucketName() string {
+	return c.bucket
 }
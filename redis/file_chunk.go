@@ -8,11 +8,11 @@ import (
 )
 
 // StoreFileInfo: store the file info in the redis
-func StoreFileChunkInfo(fileInfo *models.FileChunkInfo) error {
+func (c *Client) StoreFileChunkInfo(fileInfo *models.FileChunkInfo) error {
 	key := fmt.Sprintf("file_info:%s", fileInfo.FileID)
 
 	// check if the file info exists
-	exists, err := rdb.Exists(ctx, key).Result()
+	exists, err := c.rdb.Exists(c.ctx, key).Result()
 	if err != nil {
 		return fmt.Errorf("failed to check if the file info exists: %v", err)
 	}
@@ -22,7 +22,7 @@ func StoreFileChunkInfo(fileInfo *models.FileChunkInfo) error {
 	}
 
 	// if the file info does not exist, store it
-	_, err = rdb.HMSet(ctx, key, map[string]interface{}{
+	_, err = c.rdb.HMSet(c.ctx, key, map[string]interface{}{
 		"file_id":      fileInfo.FileID,
 		"file_name":    fileInfo.FileName,
 		"total_chunks": fileInfo.TotalChunks,
@@ -35,11 +35,11 @@ func StoreFileChunkInfo(fileInfo *models.FileChunkInfo) error {
 }
 
 // GetFileInfo: get the file info from the redis
-func GetFileChunkInfo(fileID string) (*models.FileChunkInfo, error) {
+func (c *Client) GetFileChunkInfo(fileID string) (*models.FileChunkInfo, error) {
 	key := fmt.Sprintf("file_info:%s", fileID)
 
 	// get the file info
-	fileInfo, err := rdb.HGetAll(ctx, key).Result()
+	fileInfo, err := c.rdb.HGetAll(c.ctx, key).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get the file info: %v", err)
 	}
@@ -63,11 +63,11 @@ func GetFileChunkInfo(fileID string) (*models.FileChunkInfo, error) {
 }
 
 // StoreChunkStatus: store the chunk status in the redis
-func StoreChunkStatus(fileID string, chunkIndex int) error {
+func (c *Client) StoreChunkStatus(fileID string, chunkIndex int) error {
 	key := fmt.Sprintf("file_chunks:%s", fileID)
 
 	// add the chunk index to the set
-	if err := rdb.SAdd(ctx, key, chunkIndex).Err(); err != nil {
+	if err := c.rdb.SAdd(c.ctx, key, chunkIndex).Err(); err != nil {
 		return fmt.Errorf("failed to store the chunk status: %v", err)
 	}
 
@@ -75,11 +75,11 @@ func StoreChunkStatus(fileID string, chunkIndex int) error {
 }
 
 // GetChunkStatus: get the chunk status from the redis
-func GetChunkStatus(fileID string, chunkIndex int) (bool, error) {
+func (c *Client) GetChunkStatus(fileID string, chunkIndex int) (bool, error) {
 	key := fmt.Sprintf("file_chunks:%s", fileID)
 
 	// check if the chunk index exists
-	exists, err := rdb.SIsMember(ctx, key, chunkIndex).Result()
+	exists, err := c.rdb.SIsMember(c.ctx, key, chunkIndex).Result()
 	if err != nil {
 		return false, fmt.Errorf("failed to check if the chunk index exists: %v", err)
 	}
@@ -0,0 +1,52 @@
+package redis
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// LoginFailures: the failed login attempts of a username or an ip in the window
+type LoginFailures struct {
+	Count int
+	Last  time.Time
+}
+
+// GetLoginFailures: get the failed login attempts of the key, zero if there are none
+func (c *Client) GetLoginFailures(key string) (*LoginFailures, error) {
+	values, err := c.rdb.HGetAll(c.ctx, "login_fail:"+key).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get the login failures: %v", err)
+	}
+
+	failures := &LoginFailures{}
+	failures.Count, _ = strconv.Atoi(values["count"])
+	if last, err := strconv.ParseInt(values["last"], 10, 64); err == nil {
+		failures.Last = time.UnixMilli(last)
+	}
+	return failures, nil
+}
+
+// RecordLoginFailure: count a failed login attempt of the key, the counter expires after the window
+func (c *Client) RecordLoginFailure(key string, window time.Duration) (int, error) {
+	key = "login_fail:" + key
+
+	pipe := c.rdb.TxPipeline()
+	count := pipe.HIncrBy(c.ctx, key, "count", 1)
+	pipe.HSet(c.ctx, key, "last", time.Now().UnixMilli())
+	pipe.Expire(c.ctx, key, window)
+	if _, err := pipe.Exec(c.ctx); err != nil {
+		return 0, fmt.Errorf("failed to record the login failure: %v", err)
+	}
+	return int(count.Val()), nil
+}
+
+// ResetLoginFailures: clear the failed login attempts of the key after a successful login or an unlock
+func (c *Client) ResetLoginFailures(key string) error {
+	if err := c.rdb.Del(c.ctx, "login_fail:"+key).Err(); err != nil {
+		return fmt.Errorf("failed to reset the login failures: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package redis
+
+import (
+	"fmt"
+	"time"
+)
+
+// AllowRate: count the action of the key in a fixed window, returns false once the limit is reached
+func (c *Client) AllowRate(key string, limit int, window time.Duration) (bool, error) {
+	key = "rate_limit:" + key
+
+	pipe := c.rdb.TxPipeline()
+	count := pipe.Incr(c.ctx, key)
+	pipe.ExpireNX(c.ctx, key, window)
+	if _, err := pipe.Exec(c.ctx); err != nil {
+		return false, fmt.Errorf("failed to count the rate: %v", err)
+	}
+	return count.Val() <= int64(limit), nil
+}
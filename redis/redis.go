@@ -5,38 +5,53 @@ import (
 	"fmt"
 
 	"github.com/bladewaltz9/file-store-server/config"
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 )
 
-var (
-	// rdb: redis client
+// Client: the redis of the sessions, the rate limits and the upload progress
+type Client struct {
 	rdb *redis.Client
-	ctx = context.Background()
-)
+	ctx context.Context // of the commands, see WithContext
+}
 
-// InitRedis: initialize the redis client
-func initRedis(addr, password string, db int) {
-	rdb = redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       db,
+// New: create the redis client and check the connection
+func New(cfg config.RedisConfig) (*Client, error) {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr(),
+		Password: cfg.Password,
+		DB:       cfg.DB,
 	})
 
-	_, err := rdb.Ping(ctx).Result()
-	if err != nil {
-		panic(fmt.Sprintf("Failed to connect to the redis: %v", err.Error()))
+	if _, err := rdb.Ping(context.Background()).Result(); err != nil {
+		rdb.Close()
+		return nil, fmt.Errorf("failed to connect to the redis: %v", err.Error())
+	}
+	// trace the commands in the spans of their contexts
+	if err := redisotel.InstrumentTracing(rdb); err != nil {
+		rdb.Close()
+		return nil, fmt.Errorf("failed to instrument the redis: %v", err.Error())
 	}
+	return &Client{rdb: rdb, ctx: context.Background()}, nil
 }
 
-func GetRedisClient() *redis.Client {
-	return rdb
+// WithContext: the client running its commands with ctx, they are traced as the children of its span,
+// the commands are not canceled with ctx
+func (c *Client) WithContext(ctx context.Context) *Client {
+	return &Client{rdb: c.rdb, ctx: context.WithoutCancel(ctx)}
 }
 
-func init() {
-	// initialize the redis client
-	addr := config.RedisHost + ":" + fmt.Sprintf("%d", config.RedisPort)
-	password := config.RedisPassword
-	db := config.RedisDB
+// GetRedisClient: get the underlying client
+func (c *Client) GetRedisClient() *redis.Client {
+	return c.rdb
+}
+
+// Close: close the client
+func (c *Client) Close() error {
+	return c.rdb.Close()
+}
 
-	initRedis(addr, password, db)
+// Ping: check the connection to the redis
+func (c *Client) Ping(ctx context.Context) error {
+	return c.rdb.Ping(ctx).Err()
 }
@@ -2,16 +2,35 @@ package redis_test
 
 import (
 	"context"
+	"fmt"
+	"os"
 	"testing"
 	"time"
 
+	"github.com/bladewaltz9/file-store-server/config"
 	"github.com/bladewaltz9/file-store-server/redis"
 )
 
-var ctx = context.Background()
+var (
+	ctx    = context.Background()
+	client *redis.Client
+)
+
+// TestMain: connect to the configured redis, the tests are skipped if it is not available
+func TestMain(m *testing.M) {
+	cfg, err := config.Load(nil)
+	if err == nil {
+		client, err = redis.New(cfg.Redis)
+	}
+	if err != nil {
+		fmt.Printf("skipping the redis tests: %v\n", err)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
 
 func TestRedisSetGet(t *testing.T) {
-	redisClient := redis.GetRedisClient()
+	redisClient := client.GetRedisClient()
 
 	// test set
 	err := redisClient.Set(ctx, "test_key", "test_value", 0).Err()
@@ -35,7 +54,7 @@ func TestRedisSetGet(t *testing.T) {
 }
 
 func TestRedisExpiration(t *testing.T) {
-	redisClient := redis.GetRedisClient()
+	redisClient := client.GetRedisClient()
 
 	// test set with expiration
 	err := redisClient.Set(ctx, "test_key", "test_value", 1*time.Second).Err()
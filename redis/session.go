@@ -0,0 +1,174 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bladewaltz9/file-store-server/models"
+	"github.com/bladewaltz9/file-store-server/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+// StoreRefreshToken: store the refresh token with its session until it expires,
+// the token is stored by its hash so a leaked redis dump can not be replayed
+func (c *Client) StoreRefreshToken(token string, session *models.RefreshSession, ttl time.Duration) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the session: %v", err)
+	}
+
+	tokenHash := utils.HashToken(token)
+	sessionsKey := fmt.Sprintf("user_sessions:%d", session.UserID)
+	pipe := c.rdb.TxPipeline()
+	pipe.Set(c.ctx, "refresh_token:"+tokenHash, data, ttl)
+	pipe.SAdd(c.ctx, sessionsKey, tokenHash)
+	pipe.Expire(c.ctx, sessionsKey, ttl)
+	if _, err := pipe.Exec(c.ctx); err != nil {
+		return fmt.Errorf("failed to store the refresh token: %v", err)
+	}
+	return nil
+}
+
+// ConsumeRefreshToken: get and delete the session of the refresh token, so every refresh token is used once,
+// returns nil if the token is unknown or expired
+func (c *Client) ConsumeRefreshToken(token string) (*models.RefreshSession, error) {
+	tokenHash := utils.HashToken(token)
+	data, err := c.rdb.GetDel(c.ctx, "refresh_token:"+tokenHash).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get the refresh token: %v", err)
+	}
+
+	session := &models.RefreshSession{}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the session: %v", err)
+	}
+	if err := c.rdb.SRem(c.ctx, fmt.Sprintf("user_sessions:%d", session.UserID), tokenHash).Err(); err != nil {
+		return nil, fmt.Errorf("failed to remove the session: %v", err)
+	}
+	return session, nil
+}
+
+// RevokeAccessToken: add the access token id to the revocation list until the token expires
+func (c *Client) RevokeAccessToken(tokenID string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	if err := c.rdb.Set(c.ctx, "revoked_token:"+tokenID, 1, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to revoke the token: %v", err)
+	}
+	return nil
+}
+
+// RevokeUserSessions: delete all refresh tokens of the user and bump the token version,
+// which invalidates all access tokens issued before
+func (c *Client) RevokeUserSessions(userID int) error {
+	sessionsKey := fmt.Sprintf("user_sessions:%d", userID)
+	tokenHashes, err := c.rdb.SMembers(c.ctx, sessionsKey).Result()
+	if err != nil {
+		return fmt.Errorf("failed to get the sessions: %v", err)
+	}
+
+	pipe := c.rdb.TxPipeline()
+	for _, tokenHash := range tokenHashes {
+		pipe.Del(c.ctx, "refresh_token:"+tokenHash)
+	}
+	pipe.Del(c.ctx, sessionsKey)
+	pipe.Incr(c.ctx, fmt.Sprintf("token_version:%d", userID))
+	if _, err := pipe.Exec(c.ctx); err != nil {
+		return fmt.Errorf("failed to revoke the sessions: %v", err)
+	}
+	return nil
+}
+
+// GetTokenVersion: get the current token version of the user, 0 if the sessions were never revoked
+func (c *Client) GetTokenVersion(userID int) (int, error) {
+	version, err := c.rdb.Get(c.ctx, fmt.Sprintf("token_version:%d", userID)).Int()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to get the token version: %v", err)
+	}
+	return version, nil
+}
+
+// IsTokenRevoked: check if the access token is revoked, by its id or by the token version of the user
+func (c *Client) IsTokenRevoked(tokenID string, userID int, version int) (bool, error) {
+	pipe := c.rdb.Pipeline()
+	revoked := pipe.Exists(c.ctx, "revoked_token:"+tokenID)
+	current := pipe.Get(c.ctx, fmt.Sprintf("token_version:%d", userID))
+	if _, err := pipe.Exec(c.ctx); err != nil && err != redis.Nil {
+		return false, fmt.Errorf("failed to check the token: %v", err)
+	}
+
+	if revoked.Val() > 0 {
+		return true, nil
+	}
+	currentVersion, err := current.Int()
+	if err != nil && err != redis.Nil {
+		return false, fmt.Errorf("failed to get the token version: %v", err)
+	}
+	return version != currentVersion, nil
+}
+
+// StoreMFAToken: store the pending login of the user who passed the password check and owes the second factor
+func (c *Client) StoreMFAToken(token string, userID int, ttl time.Duration) error {
+	if err := c.rdb.Set(c.ctx, "mfa_token:"+utils.HashToken(token), userID, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store the mfa token: %v", err)
+	}
+	return nil
+}
+
+// GetMFAToken: get the user of the pending login, 0 if the token is unknown or expired
+func (c *Client) GetMFAToken(token string) (int, error) {
+	userID, err := c.rdb.Get(c.ctx, "mfa_token:"+utils.HashToken(token)).Int()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to get the mfa token: %v", err)
+	}
+	return userID, nil
+}
+
+// DeleteMFAToken: delete the pending login once it is completed or has too many failed attempts
+func (c *Client) DeleteMFAToken(token string) error {
+	if err := c.rdb.Del(c.ctx, "mfa_token:"+utils.HashToken(token)).Err(); err != nil {
+		return fmt.Errorf("failed to delete the mfa token: %v", err)
+	}
+	return nil
+}
+
+// StoreOIDCState: store the pending single sign-on login until the identity provider redirects back
+func (c *Client) StoreOIDCState(state string, oidcState *models.OIDCState, ttl time.Duration) error {
+	data, err := json.Marshal(oidcState)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the oidc state: %v", err)
+	}
+	if err := c.rdb.Set(c.ctx, "oidc_state:"+utils.HashToken(state), data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store the oidc state: %v", err)
+	}
+	return nil
+}
+
+// ConsumeOIDCState: get and delete the pending single sign-on login, so every state is used once,
+// returns nil if the state is unknown or expired
+func (c *Client) ConsumeOIDCState(state string) (*models.OIDCState, error) {
+	data, err := c.rdb.GetDel(c.ctx, "oidc_state:"+utils.HashToken(state)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get the oidc state: %v", err)
+	}
+
+	oidcState := &models.OIDCState{}
+	if err := json.Unmarshal(data, oidcState); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the oidc state: %v", err)
+	}
+	return oidcState, nil
+}
@@ -0,0 +1,221 @@
+package search
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+	"golang.org/x/net/html"
+)
+
+// ErrUnsupportedType: the file type can not be indexed
+var ErrUnsupportedType = errors.New("unsupported file type")
+
+// ErrFileTooLarge: the file is larger than the max file size, it is not indexed
+var ErrFileTooLarge = errors.New("file too large to index")
+
+// IsSupported: check if the text of the file can be extracted
+func IsSupported(fileName string) bool {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".txt", ".md", ".markdown", ".csv", ".json", ".html", ".htm", ".pdf":
+		return true
+	}
+	return false
+}
+
+// ExtractText: extract at most maxSize bytes of the plain text of the local file, the type is decided
+// by the file name, the files larger than maxFileSize are skipped with ErrFileTooLarge
+func ExtractText(localFile, fileName string, maxFileSize int64, maxSize int) (string, error) {
+	if !IsSupported(fileName) {
+		return "", ErrUnsupportedType
+	}
+	info, err := os.Stat(localFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat the file: %v", err)
+	}
+	if info.Size() > maxFileSize {
+		return "", ErrFileTooLarge
+	}
+
+	var text string
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".txt", ".md", ".markdown":
+		text, err = extractPlain(localFile, maxSize)
+	case ".csv":
+		text, err = extractCSV(localFile, maxSize)
+	case ".json":
+		text, err = extractJSON(localFile, maxFileSize)
+	case ".html", ".htm":
+		text, err = extractHTML(localFile, maxSize)
+	case ".pdf":
+		text, err = extractPDF(localFile, maxSize)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return truncate(text, maxSize), nil
+}
+
+// extractPlain: read the text file as it is
+func extractPlain(localFile string, maxSize int) (string, error) {
+	file, err := os.Open(localFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to open the file: %v", err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, int64(maxSize)))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the file: %v", err)
+	}
+	return string(data), nil
+}
+
+// extractCSV: join the fields of the csv file, stopping once maxSize bytes are collected
+func extractCSV(localFile string, maxSize int) (string, error) {
+	file, err := os.Open(localFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to open the file: %v", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var builder strings.Builder
+	for builder.Len() < maxSize {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read the csv: %v", err)
+		}
+		builder.WriteString(strings.Join(record, " "))
+		builder.WriteString("\n")
+	}
+	return builder.String(), nil
+}
+
+// extractJSON: collect the keys and string values of the json file, the whole value is decoded
+// so at most maxFileSize bytes are read
+func extractJSON(localFile string, maxFileSize int64) (string, error) {
+	file, err := os.Open(localFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to open the file: %v", err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxFileSize))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the file: %v", err)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return "", fmt.Errorf("failed to unmarshal the json: %v", err)
+	}
+
+	var words []string
+	collectJSON(value, &words)
+	return strings.Join(words, " "), nil
+}
+
+// collectJSON: walk the json value and collect the text
+func collectJSON(value interface{}, words *[]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			*words = append(*words, key)
+			collectJSON(item, words)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectJSON(item, words)
+		}
+	case string:
+		*words = append(*words, v)
+	case float64, bool:
+		*words = append(*words, fmt.Sprint(v))
+	}
+}
+
+// extractHTML: collect the text nodes of the html file, skipping scripts and styles, stopping once
+// maxSize bytes are collected
+func extractHTML(localFile string, maxSize int) (string, error) {
+	file, err := os.Open(localFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to open the file: %v", err)
+	}
+	defer file.Close()
+
+	var builder strings.Builder
+	skip := 0
+	tokenizer := html.NewTokenizer(file)
+	for builder.Len() < maxSize {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if tokenizer.Err() == io.EOF {
+				return builder.String(), nil
+			}
+			return "", fmt.Errorf("failed to parse the html: %v", tokenizer.Err())
+		case html.StartTagToken:
+			if name, _ := tokenizer.TagName(); isSkippedTag(name) {
+				skip++
+			}
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); isSkippedTag(name) && skip > 0 {
+				skip--
+			}
+		case html.TextToken:
+			if skip == 0 {
+				builder.Write(bytes.TrimSpace(tokenizer.Text()))
+				builder.WriteString(" ")
+			}
+		}
+	}
+	return builder.String(), nil
+}
+
+// isSkippedTag: check if the content of the html tag is not text
+func isSkippedTag(name []byte) bool {
+	tag := string(name)
+	return tag == "script" || tag == "style"
+}
+
+// extractPDF: extract at most maxSize bytes of the plain text of the pdf file
+func extractPDF(localFile string, maxSize int) (string, error) {
+	file, reader, err := pdf.Open(localFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to open the pdf: %v", err)
+	}
+	defer file.Close()
+
+	text, err := reader.GetPlainText()
+	if err != nil {
+		return "", fmt.Errorf("failed to extract the pdf text: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(text, int64(maxSize))); err != nil {
+		return "", fmt.Errorf("failed to read the pdf text: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// truncate: cut the text to the max size without breaking the utf-8 characters
+func truncate(text string, maxSize int) string {
+	if len(text) > maxSize {
+		text = text[:maxSize]
+	}
+	return strings.ToValidUTF8(text, "")
+}
@@ -0,0 +1,117 @@
+package search_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bladewaltz9/file-store-server/search"
+)
+
+// TestExtractText: tests the text extraction of the supported file types
+func TestExtractText(t *testing.T) {
+	cases := []struct {
+		fileName string
+		data     string
+		want     []string
+		notWant  []string
+	}{
+		{"note.md", "# Title\nquarterly report", []string{"quarterly report"}, nil},
+		{"data.csv", "name,city\nalice,\"new york\"", []string{"alice new york"}, nil},
+		{"conf.json", `{"owner": {"name": "bob"}, "tags": ["x", 42]}`, []string{"owner", "bob", "42"}, nil},
+		{"page.html", "<html><style>p{}</style><body><p>hello <b>world</b></p><script>var a;</script></body></html>", []string{"hello", "world"}, []string{"var a", "p{}"}},
+		{"invoice.pdf", minimalPDF("quarterly invoice"), []string{"quarterly invoice"}, nil},
+	}
+
+	dir := t.TempDir()
+	for _, c := range cases {
+		path := filepath.Join(dir, c.fileName)
+		if err := os.WriteFile(path, []byte(c.data), 0644); err != nil {
+			t.Fatalf("failed to write the file: %v", err)
+		}
+
+		text, err := search.ExtractText(path, c.fileName, 1<<20, 1<<20)
+		if err != nil {
+			t.Errorf("failed to extract %s: %v", c.fileName, err)
+			continue
+		}
+		for _, want := range c.want {
+			if !strings.Contains(text, want) {
+				t.Errorf("text of %s does not contain %q: %q", c.fileName, want, text)
+			}
+		}
+		for _, notWant := range c.notWant {
+			if strings.Contains(text, notWant) {
+				t.Errorf("text of %s should not contain %q: %q", c.fileName, notWant, text)
+			}
+		}
+	}
+
+	if _, err := search.ExtractText(filepath.Join(dir, "a.bin"), "a.bin", 1<<20, 1<<20); err != search.ErrUnsupportedType {
+		t.Errorf("expected ErrUnsupportedType, got: %v", err)
+	}
+
+	// the extraction stops at the max size, the larger files are skipped
+	path := filepath.Join(dir, "rows.csv")
+	if err := os.WriteFile(path, []byte(strings.Repeat("a,b\n", 10000)), 0644); err != nil {
+		t.Fatalf("failed to write the file: %v", err)
+	}
+	if text, err := search.ExtractText(path, "rows.csv", 1<<20, 100); err != nil || len(text) != 100 {
+		t.Errorf("expected 100 bytes of text, got %d bytes: %v", len(text), err)
+	}
+	if _, err := search.ExtractText(path, "rows.csv", 1000, 100); err != search.ErrFileTooLarge {
+		t.Errorf("expected ErrFileTooLarge, got: %v", err)
+	}
+}
+
+// minimalPDF: build a one page pdf showing the text
+func minimalPDF(text string) string {
+	content := fmt.Sprintf("BT /F1 12 Tf 72 720 Td (%s) Tj ET", text)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+	}
+
+	var b strings.Builder
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return b.String()
+}
+
+// TestSnippet: tests the snippet is cut around the matched term
+func TestSnippet(t *testing.T) {
+	content := strings.Repeat("lorem ipsum ", 50) + "the secret keyword is here " + strings.Repeat("dolor sit ", 50)
+
+	snippet := search.Snippet(content, "Keyword", 40)
+	if !strings.Contains(snippet, "keyword") {
+		t.Errorf("snippet does not contain the keyword: %q", snippet)
+	}
+	if !strings.HasPrefix(snippet, "...") || !strings.HasSuffix(snippet, "...") {
+		t.Errorf("snippet should be surrounded by ellipses: %q", snippet)
+	}
+
+	// the lower case of some runes is longer, the window must still be around the term
+	content = strings.Repeat("İ", 200) + " keyword " + strings.Repeat("dolor sit ", 50)
+	if snippet := search.Snippet(content, "keyword", 40); !strings.Contains(snippet, "keyword") {
+		t.Errorf("snippet does not contain the keyword: %q", snippet)
+	}
+
+	if snippet := search.Snippet("short text", "text", 40); snippet != "short text" {
+		t.Errorf("short content should be returned as it is: %q", snippet)
+	}
+}
@@ -0,0 +1,75 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Snippet: cut a piece of the content around the first matched query term
+func Snippet(content, query string, length int) string {
+	runes := []rune(strings.Join(strings.Fields(content), " "))
+	if len(runes) <= length {
+		return string(runes)
+	}
+
+	// find the first position of any query term, the runes are lowered one by one so that the
+	// positions match the runes of the content
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	pos := -1
+	for _, term := range strings.FieldsFunc(strings.Map(unicode.ToLower, query), isSeparator) {
+		if i := indexRunes(lower, []rune(term)); i >= 0 && (pos < 0 || i < pos) {
+			pos = i
+		}
+	}
+
+	// center the window on the matched term
+	start := 0
+	if pos > 0 {
+		start = pos - length/4
+		if start < 0 {
+			start = 0
+		}
+	}
+	end := start + length
+	if end > len(runes) {
+		end = len(runes)
+		start = end - length
+	}
+
+	snippet := string(runes[start:end])
+	if start > 0 {
+		snippet = "..." + snippet
+	}
+	if end < len(runes) {
+		snippet = snippet + "..."
+	}
+	return snippet
+}
+
+// isSeparator: check if the rune separates the query terms
+func isSeparator(r rune) bool {
+	return unicode.IsSpace(r) || (unicode.IsPunct(r) && r != '_')
+}
+
+// indexRunes: get the index of the sub slice in the rune slice
+func indexRunes(runes, sub []rune) int {
+	if len(sub) == 0 {
+		return -1
+	}
+	for i := 0; i+len(sub) <= len(runes); i++ {
+		match := true
+		for j := range sub {
+			if runes[i+j] != sub[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
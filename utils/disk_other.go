@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package utils
+
+import "errors"
+
+// DiskUsage: the disk usage is not reported on this platform
+func DiskUsage(dir string) (uint64, uint64, error) {
+	return 0, 0, errors.ErrUnsupported
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin
+
+package utils
+
+import "syscall"
+
+// DiskUsage: the bytes available to the unprivileged users and the size of the filesystem of the directory
+func DiskUsage(dir string) (uint64, uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), stat.Blocks * uint64(stat.Bsize), nil
+}
@@ -20,6 +20,7 @@ func LoadEnv() error {
 	for i := 0; i < maxSubDirCount; i++ {
 		err := godotenv.Load(path)
 		if err == nil {
+			envLoaded = true
 			return nil
 		}
 		path = "../" + path
@@ -0,0 +1,21 @@
+package utils
+
+import (
+	"context"
+	"sync"
+)
+
+// WaitContext: wait for the wait group, or return the error of the context if it is done first
+func WaitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}